@@ -1,12 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/pkg/errors"
 
+	"github.com/irvinlim/apple-health-ingester/pkg/backends"
 	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
 	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/routes"
 	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
 )
 
@@ -31,13 +35,50 @@ func RegisterInfluxDBBackend(ingester *ingester.Ingester, mux *http.ServeMux) er
 	if !enableInfluxDB {
 		return nil
 	}
+	backend, err := newInfluxDBBackend()
+	if err != nil {
+		return err
+	}
+	return RegisterBackend(backend, ingester, mux, pathPrefix+"/influxdb/ingest")
+}
+
+func newInfluxDBBackend() (backends.Backend, error) {
 	client, err := influxdb.NewClient()
 	if err != nil {
-		return errors.Wrapf(err, "cannot initialize client")
+		return nil, errors.Wrapf(err, "cannot initialize client")
+	}
+	return influxdb.NewBackend(client)
+}
+
+// RegisterRoutesBackend registers the Routes backend.
+func RegisterRoutesBackend(ingester *ingester.Ingester, mux *http.ServeMux) error {
+	if !enableRoutes {
+		return nil
 	}
-	backend, err := influxdb.NewBackend(client)
+	backend, err := routes.NewBackend()
 	if err != nil {
 		return err
 	}
-	return RegisterBackend(backend, ingester, mux, pathPrefix+"/influxdb/ingest")
+	return RegisterBackend(backend, ingester, mux, pathPrefix+"/routes/ingest")
+}
+
+// newBackend returns the backend with the given name (e.g. "localfile"), which
+// is configured by its flags regardless of whether it is enabled.
+func newBackend(name string) (backends.Backend, error) {
+	var backend backends.Backend
+	var err error
+	switch strings.ToLower(name) {
+	case "localfile":
+		backend, err = localfile.NewBackend()
+	case "influxdb":
+		backend, err = newInfluxDBBackend()
+	case "routes":
+		backend, err = routes.NewBackend()
+	default:
+		return nil, fmt.Errorf("unknown backend %v, must be one of localfile, influxdb, routes", name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot initialize %v backend", name)
+	}
+	return backend, nil
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"github.com/spf13/pflag"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 )
 
 var (
@@ -10,9 +12,13 @@ var (
 	authorizationToken string
 	enableInfluxDB     bool
 	enableLocalFile    bool
+	enableRoutes       bool
+	enableArchive      bool
 	enableTLS          bool
 	certFile           string
 	keyFile            string
+	strictValidation   bool
+	timeLayouts        []string
 )
 
 func init() {
@@ -22,7 +28,23 @@ func init() {
 		"Optional authorization token that will be used to authenticate incoming requests.")
 	pflag.BoolVar(&enableInfluxDB, "backend.influxdb", false, "Enable the InfluxDB storage backend.")
 	pflag.BoolVar(&enableLocalFile, "backend.localfile", false, "Enable the LocalFile storage backend.")
+	pflag.BoolVar(&enableRoutes, "backend.routes", false, "Enable the Routes backend to export workout routes as files.")
+	pflag.BoolVar(&enableArchive, "http.archive", false,
+		"Archive the raw body of each ingest request before ingesting it, see --archive.* flags.")
 	pflag.BoolVar(&enableTLS, "http.enableTLS", false, "Enable TLS/HTTPS. Requires setting certificate and key files.")
 	pflag.StringVar(&certFile, "http.certFile", "", "Certificate file for TLS support.")
 	pflag.StringVar(&keyFile, "http.keyFile", "", "Key file for TLS support.")
+	pflag.BoolVar(&strictValidation, "http.strictValidation", false,
+		"Reject payloads which fail validation with 422 Unprocessable Entity, instead of ingesting them on a best-effort basis.")
+	pflag.StringSliceVar(&timeLayouts, "http.timeLayouts", nil,
+		"Additional Go time layouts to parse timestamps with, which are attempted before the default layouts.")
+}
+
+// decoderOptions returns the options used to decode incoming payloads.
+func decoderOptions() healthautoexport.DecoderOptions {
+	var opts healthautoexport.DecoderOptions
+	if len(timeLayouts) > 0 {
+		opts.TimeLayouts = append(append([]string(nil), timeLayouts...), healthautoexport.DefaultTimeLayouts()...)
+	}
+	return opts
 }
@@ -2,19 +2,29 @@ package main
 
 import (
 	"io"
+	"mime"
 	"net/http"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/irvinlim/apple-health-ingester/pkg/archive"
 	"github.com/irvinlim/apple-health-ingester/pkg/backends"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
 )
 
 type RegisterBackendFunc func(ingester *ingester.Ingester, mux *http.ServeMux) error
 
+// payloadArchiver archives the raw body of each ingest request if non-nil.
+var payloadArchiver *archive.Archiver
+
 func RegisterBackend(backend backends.Backend, ingester *ingester.Ingester, mux *http.ServeMux, pattern string) error {
-	mux.Handle(pattern, handleIngest(ingester, backend.Name()))
+	var handler http.Handler = handleIngest(ingester, backend.Name())
+	if payloadArchiver != nil {
+		handler = createArchiveHandler(payloadArchiver, backend.Name())(handler)
+	}
+	mux.Handle(pattern, handler)
 	if err := ingester.AddBackend(backend); err != nil {
 		return err
 	}
@@ -33,7 +43,26 @@ func handleIngest(ingester *ingester.Ingester, name string) http.Handler {
 		q := r.URL.Query()
 		target := q.Get("target")
 
-		if err := ingester.Ingest(r.Body, name, target); err != nil {
+		format, err := getRequestFormat(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		// In strict mode, reject the entire payload if there are any validation errors.
+		if strictValidation || q.Get("strict") == "true" {
+			payload, issues := healthautoexport.ValidateWithOptions(r.Body, format, ingester.DecoderOptions)
+			if issues.HasErrors() {
+				writeValidationReport(w, http.StatusUnprocessableEntity, issues)
+				return
+			}
+			err = ingester.IngestPayload(payload, name, target)
+		} else {
+			err = ingester.IngestWithFormat(r.Body, format, name, target)
+		}
+
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			err := errors.Wrapf(err, "ingest error for %v", name)
 			_, _ = w.Write([]byte(err.Error()))
@@ -44,3 +73,19 @@ func handleIngest(ingester *ingester.Ingester, name string) http.Handler {
 		_, _ = w.Write([]byte("ok"))
 	})
 }
+
+// getRequestFormat determines the payload format of the request. The format
+// query parameter takes precedence over the Content-Type header, and JSON is
+// assumed if neither is specified.
+func getRequestFormat(r *http.Request) (healthautoexport.Format, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return healthautoexport.ParseFormat(format)
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		switch mediaType {
+		case "text/csv", "application/csv":
+			return healthautoexport.FormatCSV, nil
+		}
+	}
+	return healthautoexport.FormatJSON, nil
+}
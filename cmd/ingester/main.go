@@ -11,12 +11,12 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 
+	"github.com/irvinlim/apple-health-ingester/pkg/archive"
 	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
 )
 
 func main() {
 	pflag.Parse()
-	mux := http.NewServeMux()
 
 	// Set log level
 	if logLevel != "" {
@@ -28,6 +28,23 @@ func main() {
 		log.SetLevel(level)
 	}
 
+	// Run subcommand if specified
+	switch command := pflag.Arg(0); command {
+	case "":
+		runServer()
+	case "replay":
+		runReplay(pflag.Args()[1:])
+	case "migrate":
+		runMigrate(pflag.Args()[1:])
+	default:
+		log.Fatalf("unknown command %v, see --help", command)
+	}
+}
+
+// runServer runs the http server until interrupted.
+func runServer() {
+	mux := http.NewServeMux()
+
 	// Add middlewares
 	middlewares := []Middleware{
 		createLoggingHandler(log.StandardLogger()),
@@ -51,21 +68,22 @@ func main() {
 		},
 	}
 
-	// Initialize and register backends for ingester
-	ingest := ingester.NewIngester()
-	for _, register := range []RegisterBackendFunc{
-		RegisterDebugBackend,
-		RegisterInfluxDBBackend,
-	} {
-		if err := register(ingest, mux); err != nil {
-			log.WithError(err).Fatal("add backend error")
+	// Initialize archiver before registering backends, so that ingest requests are archived
+	if enableArchive {
+		archiver, err := archive.NewArchiver()
+		if err != nil {
+			log.WithError(err).Fatal("cannot initialize archiver")
 		}
+		payloadArchiver = archiver
 	}
 
-	// Ensure we have at least one backend configured
-	if backends := ingest.ListBackends(); len(backends) == 0 {
-		log.Fatal("no backends configured, see --help")
-	}
+	// Initialize and register backends for ingester
+	ingest := ingester.NewIngester()
+	ingest.DecoderOptions = decoderOptions()
+	registerBackends(ingest, mux)
+
+	// Register validation endpoint
+	mux.Handle(pathPrefix+"/validate", handleValidate())
 
 	// Start ingester
 	log.Info("starting ingester")
@@ -110,4 +128,27 @@ func main() {
 	log.Info("ingester shutting down")
 	ingest.Shutdown()
 	log.Info("ingester shut down")
+
+	if payloadArchiver != nil {
+		_ = payloadArchiver.Close()
+	}
+}
+
+// registerBackends registers all enabled backends, and ensures that at least
+// one backend is enabled.
+func registerBackends(ingest *ingester.Ingester, mux *http.ServeMux) {
+	for _, register := range []RegisterBackendFunc{
+		RegisterDebugBackend,
+		RegisterInfluxDBBackend,
+		RegisterRoutesBackend,
+	} {
+		if err := register(ingest, mux); err != nil {
+			log.WithError(err).Fatal("add backend error")
+		}
+	}
+
+	// Ensure we have at least one backend configured
+	if backends := ingest.ListBackends(); len(backends) == 0 {
+		log.Fatal("no backends configured, see --help")
+	}
 }
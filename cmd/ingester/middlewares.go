@@ -7,7 +7,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/archive"
 )
 
 const (
@@ -82,3 +85,43 @@ func createAuthenticateHandler() func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// createArchiveHandler returns a middleware that will archive the raw body of
+// ingest requests for a backend before they are ingested.
+func createArchiveHandler(archiver *archive.Archiver, backend string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Let the next handler reject requests with an invalid format.
+			format, err := getRequestFormat(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			_ = r.Body.Close()
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(errors.Wrapf(err, "cannot read body").Error()))
+				return
+			}
+
+			metadata := archive.NewMetadata(r, body, backend, string(format))
+			name, err := archiver.Store(body, metadata)
+			if err != nil {
+				log.WithError(err).WithField("backend", backend).Error("could not archive payload")
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(errors.Wrapf(err, "archive error for %v", backend).Error()))
+				return
+			}
+			log.WithFields(log.Fields{
+				"backend": backend,
+				"path":    name,
+				"size":    len(body),
+			}).Debug("archived payload")
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends"
+	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
+	"github.com/irvinlim/apple-health-ingester/pkg/migrate"
+)
+
+// runMigrate migrates all data from the source backend into the destination
+// backend, until done or interrupted.
+func runMigrate(args []string) {
+	if len(args) != 2 {
+		log.Fatal("usage: ingester migrate [flags] <source> <destination>")
+	}
+	if strings.EqualFold(args[0], args[1]) {
+		log.Fatal("source and destination must be different backends")
+	}
+
+	source, err := newBackend(args[0])
+	if err != nil {
+		log.WithError(err).Fatal("cannot initialize source")
+	}
+	reader, ok := source.(backends.Reader)
+	if !ok {
+		log.Fatalf("%v backend does not support reading", source.Name())
+	}
+	destination, err := newBackend(args[1])
+	if err != nil {
+		log.WithError(err).Fatal("cannot initialize destination")
+	}
+
+	ingest := ingester.NewIngester()
+	if err := ingest.AddBackend(destination); err != nil {
+		log.WithError(err).Fatal("add backend error")
+	}
+	migrator := migrate.NewMigrator(ingest, destination.Name())
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	log.WithFields(log.Fields{
+		"source":      source.Name(),
+		"destination": destination.Name(),
+	}).Info("starting migration")
+	ingest.Start()
+	stats, err := migrator.Migrate(ctx, reader)
+	ingest.Shutdown()
+	if closer, ok := source.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	lgr := log.WithFields(log.Fields{
+		"read":    stats.Read,
+		"written": stats.Written,
+	})
+	if err != nil {
+		lgr.WithError(err).Fatal("migration stopped")
+	}
+	lgr.Info("migration finished")
+}
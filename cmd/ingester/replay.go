@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
+	"github.com/irvinlim/apple-health-ingester/pkg/replay"
+)
+
+// runReplay replays payloads found in paths into all enabled backends, until
+// done or interrupted.
+func runReplay(paths []string) {
+	if len(paths) == 0 {
+		log.Fatal("no paths to replay, usage: ingester replay [flags] <path>...")
+	}
+
+	ingest := ingester.NewIngester()
+	ingest.DecoderOptions = decoderOptions()
+	registerBackends(ingest, http.NewServeMux())
+	replayer, err := replay.NewReplayer(ingest)
+	if err != nil {
+		log.WithError(err).Fatal("cannot initialize replayer")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	log.WithField("paths", paths).Info("starting replay")
+	ingest.Start()
+	stats, err := replayer.Replay(ctx, paths...)
+	ingest.Shutdown()
+
+	lgr := log.WithFields(log.Fields{
+		"files":    stats.Files,
+		"replayed": stats.Replayed,
+		"skipped":  stats.Skipped,
+		"failed":   stats.Failed,
+	})
+	if err != nil {
+		lgr.WithError(err).Fatal("replay stopped")
+	}
+	lgr.Info("replay finished")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+// ValidationReport is the response body returned for validation requests.
+type ValidationReport struct {
+	Valid  bool                              `json:"valid"`
+	Issues healthautoexport.ValidationIssues `json:"issues"`
+}
+
+// handleValidate returns a handler that validates the payload without ingesting it.
+func handleValidate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			_, _ = io.Copy(io.Discard, r.Body)
+			_ = r.Body.Close()
+		}()
+
+		format, err := getRequestFormat(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		_, issues := healthautoexport.ValidateWithOptions(r.Body, format, decoderOptions())
+		writeValidationReport(w, http.StatusOK, issues)
+	})
+}
+
+func writeValidationReport(w http.ResponseWriter, statusCode int, issues healthautoexport.ValidationIssues) {
+	if issues == nil {
+		issues = healthautoexport.ValidationIssues{}
+	}
+	report := ValidationReport{
+		Valid:  !issues.HasErrors(),
+		Issues: issues,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := jsoniter.NewEncoder(w).Encode(report); err != nil {
+		log.WithError(err).Error("cannot write validation report")
+	}
+}
@@ -0,0 +1,188 @@
+// Package analytics computes derived statistics from Health Auto Export data,
+// which can be used by any backend.
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	// kilojoulesPerKilocalorie is used to convert energy in kJ to kcal.
+	kilojoulesPerKilocalorie = 4.184
+
+	// defaultMaxHeartRateKey is the key in --analytics.maxHeartRate used for
+	// targets which are not configured explicitly.
+	defaultMaxHeartRateKey = "default"
+)
+
+var (
+	// DefaultHeartRateZones are the lower bounds of the default heart rate
+	// zones, as a percentage of the maximum heart rate.
+	DefaultHeartRateZones = []int{50, 60, 70, 80, 90}
+
+	maxHeartRates  map[string]int
+	heartRateZones []int
+)
+
+// Options configures how statistics are computed.
+type Options struct {
+	// MaxHeartRates is the maximum heart rate in bpm for each target, which is
+	// used to compute heart rate zones.
+	MaxHeartRates map[string]float64
+
+	// DefaultMaxHeartRate is used for targets without a maximum heart rate in
+	// MaxHeartRates. If zero, heart rate zones are not computed for such targets.
+	DefaultMaxHeartRate float64
+
+	// HeartRateZones are the lower bounds of each heart rate zone as a
+	// percentage of the maximum heart rate, in ascending order.
+	HeartRateZones []int
+}
+
+// NewOptionsFromFlags returns Options initialized from flags.
+func NewOptionsFromFlags() (Options, error) {
+	opts := Options{
+		MaxHeartRates:  make(map[string]float64, len(maxHeartRates)),
+		HeartRateZones: heartRateZones,
+	}
+	for target, value := range maxHeartRates {
+		if value <= 0 {
+			return Options{}, fmt.Errorf("invalid --analytics.maxHeartRate for %v: %v", target, value)
+		}
+		if target == defaultMaxHeartRateKey {
+			opts.DefaultMaxHeartRate = float64(value)
+			continue
+		}
+		opts.MaxHeartRates[target] = float64(value)
+	}
+	if !sort.IntsAreSorted(opts.HeartRateZones) {
+		return Options{}, fmt.Errorf("--analytics.heartRateZones must be in ascending order")
+	}
+	return opts, nil
+}
+
+// MaxHeartRate returns the maximum heart rate for the target, or zero if unknown.
+func (o Options) MaxHeartRate(target string) float64 {
+	if value, ok := o.MaxHeartRates[target]; ok {
+		return value
+	}
+	return o.DefaultMaxHeartRate
+}
+
+// HeartRateZoneBounds returns the lower bound of each heart rate zone in bpm
+// for the target, or nil if the maximum heart rate is unknown.
+func (o Options) HeartRateZoneBounds(target string) []float64 {
+	maxHeartRate := o.MaxHeartRate(target)
+	if maxHeartRate <= 0 || len(o.HeartRateZones) == 0 {
+		return nil
+	}
+	bounds := make([]float64, 0, len(o.HeartRateZones))
+	for _, percent := range o.HeartRateZones {
+		bounds = append(bounds, maxHeartRate*float64(percent)/100)
+	}
+	return bounds
+}
+
+// WorkoutStatistics contains statistics derived from a workout. Statistics
+// which cannot be computed from the workout are left as nil.
+type WorkoutStatistics struct {
+	// Duration of the workout.
+	Duration *time.Duration
+
+	// HeartRate contains statistics computed from HeartRateData.
+	HeartRate *HeartRateStatistics
+
+	// RouteDistance is the distance of the route in meters.
+	RouteDistance *float64
+	// AverageSpeed is the average speed over the route in meters per second.
+	AverageSpeed *float64
+
+	// ElevationGain and ElevationLoss are computed from the altitudes of the
+	// route in meters, only if the workout does not contain Elevation.
+	ElevationGain *float64
+	ElevationLoss *float64
+
+	// ActiveEnergyRate is the active energy burned in kcal per minute.
+	ActiveEnergyRate *float64
+}
+
+// ComputeWorkoutStatistics returns statistics derived from the workout of a target.
+func ComputeWorkoutStatistics(workout *healthautoexport.Workout, target string, opts Options) *WorkoutStatistics {
+	stats := &WorkoutStatistics{}
+
+	// Compute duration of the workout.
+	if !workout.End.IsZero() && !workout.Start.IsZero() {
+		duration := workout.End.Sub(workout.Start.Time)
+		stats.Duration = &duration
+	}
+
+	// Compute heart rate statistics.
+	var end time.Time
+	if !workout.End.IsZero() {
+		end = workout.End.Time
+	}
+	stats.HeartRate = ComputeHeartRateStatistics(workout.HeartRateData, end, opts.HeartRateZoneBounds(target))
+
+	// Compute distance, speed and elevation from the route.
+	if route := SortedRoute(workout); len(route) > 1 {
+		distance := RouteDistance(route)
+		stats.RouteDistance = &distance
+		elapsed := route[len(route)-1].Timestamp.Sub(route[0].Timestamp.Time)
+		if elapsed > 0 && distance > 0 {
+			speed := distance / elapsed.Seconds()
+			stats.AverageSpeed = &speed
+		}
+		if workout.Elevation == nil {
+			gain, loss := ElevationChange(route)
+			stats.ElevationGain, stats.ElevationLoss = &gain, &loss
+		}
+	}
+
+	// Compute active energy rate.
+	if energy, ok := ActiveEnergy(workout); ok && stats.Duration != nil && *stats.Duration > 0 {
+		rate := energy / stats.Duration.Minutes()
+		stats.ActiveEnergyRate = &rate
+	}
+
+	return stats
+}
+
+// ActiveEnergy returns the active energy burned during the workout in kcal.
+func ActiveEnergy(workout *healthautoexport.Workout) (float64, bool) {
+	for _, field := range workout.Fields {
+		if field.Key != "activeEnergy" || field.Value == nil {
+			continue
+		}
+		qty := float64(field.Value.Qty)
+		switch field.Value.Units {
+		case "kJ":
+			return qty / kilojoulesPerKilocalorie, true
+		case "kcal", "Cal":
+			return qty, true
+		}
+	}
+	return 0, false
+}
+
+// Pace converts a speed in meters per second to a pace in minutes per kilometer.
+func Pace(speed float64) float64 {
+	if speed <= 0 {
+		return math.Inf(1)
+	}
+	return 1000 / speed / 60
+}
+
+func init() {
+	pflag.StringToIntVar(&maxHeartRates, "analytics.maxHeartRate", nil,
+		"Maximum heart rate in bpm per target used to compute heart rate zones, in target=bpm format. "+
+			"Use \""+defaultMaxHeartRateKey+"\" as the target to set the maximum heart rate for all other targets.")
+	pflag.IntSliceVar(&heartRateZones, "analytics.heartRateZones", DefaultHeartRateZones,
+		"Lower bounds of each heart rate zone as a percentage of the maximum heart rate.")
+}
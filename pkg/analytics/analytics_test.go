@@ -0,0 +1,207 @@
+package analytics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+func TestHaversine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *healthautoexport.RouteDatapoint
+		want float64
+	}{
+		{
+			name: "same point",
+			a:    &healthautoexport.RouteDatapoint{Lat: 1.3, Lon: 103.8},
+			b:    &healthautoexport.RouteDatapoint{Lat: 1.3, Lon: 103.8},
+			want: 0,
+		},
+		{
+			name: "one degree of latitude",
+			a:    &healthautoexport.RouteDatapoint{Lat: 0, Lon: 0},
+			b:    &healthautoexport.RouteDatapoint{Lat: 1, Lon: 0},
+			want: 111195.08,
+		},
+		{
+			name: "one degree of longitude at the equator",
+			a:    &healthautoexport.RouteDatapoint{Lat: 0, Lon: 179.5},
+			b:    &healthautoexport.RouteDatapoint{Lat: 0, Lon: -179.5},
+			want: 111195.08,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, analytics.Haversine(tt.a, tt.b), 0.01)
+		})
+	}
+}
+
+func TestElevationChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		altitudes []float64
+		wantGain  float64
+		wantLoss  float64
+	}{
+		{
+			name: "empty route",
+		},
+		{
+			name:      "noise is ignored",
+			altitudes: []float64{10, 11, 10, 11.5, 10.5},
+		},
+		{
+			name:      "gain and loss",
+			altitudes: []float64{10, 15, 14, 20, 12},
+			wantGain:  10,
+			wantLoss:  8,
+		},
+		{
+			name:      "small changes accumulate",
+			altitudes: []float64{10, 11, 12, 13, 14},
+			wantGain:  4,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			route := make([]*healthautoexport.RouteDatapoint, 0, len(tt.altitudes))
+			for _, altitude := range tt.altitudes {
+				route = append(route, &healthautoexport.RouteDatapoint{Altitude: altitude})
+			}
+			gain, loss := analytics.ElevationChange(route)
+			assert.InDelta(t, tt.wantGain, gain, 1e-9)
+			assert.InDelta(t, tt.wantLoss, loss, 1e-9)
+		})
+	}
+}
+
+func TestComputeHeartRateStatistics(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []*healthautoexport.DatapointWithUnit
+		end   time.Time
+		zones []float64
+		want  *analytics.HeartRateStatistics
+	}{
+		{
+			name: "no samples",
+		},
+		{
+			name: "time-weighted average and zones",
+			data: []*healthautoexport.DatapointWithUnit{
+				// Out of order, will be sorted.
+				makeHeartRate("2021-12-24 08:00:30 +0800", 150),
+				makeHeartRate("2021-12-24 08:00:00 +0800", 100),
+				// Gap of more than 1 minute, only counted for 1 minute.
+				makeHeartRate("2021-12-24 08:05:00 +0800", 170),
+			},
+			end:   mktime("2021-12-24 08:05:30 +0800").Time,
+			zones: []float64{120, 160},
+			want: &analytics.HeartRateStatistics{
+				Average: (100*30 + 150*60 + 170*30) / 120.0,
+				Max:     170,
+				Zones:   []time.Duration{time.Minute, 30 * time.Second},
+			},
+		},
+		{
+			name: "single sample without end time",
+			data: []*healthautoexport.DatapointWithUnit{
+				makeHeartRate("2021-12-24 08:00:00 +0800", 100),
+			},
+			want: &analytics.HeartRateStatistics{
+				Average: 100,
+				Max:     100,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, analytics.ComputeHeartRateStatistics(tt.data, tt.end, tt.zones))
+		})
+	}
+}
+
+func TestComputeWorkoutStatistics(t *testing.T) {
+	opts := analytics.Options{
+		MaxHeartRates:  map[string]float64{"john": 200},
+		HeartRateZones: []int{50, 75},
+	}
+	workout := &healthautoexport.Workout{
+		Name:  "Outdoor Run",
+		Start: mktime("2021-12-24 08:00:00 +0800"),
+		End:   mktime("2021-12-24 08:10:00 +0800"),
+		Route: []*healthautoexport.RouteDatapoint{
+			{Lat: 0, Lon: 0, Altitude: 10, Timestamp: mktime("2021-12-24 08:00:00 +0800")},
+			{Lat: 0.01, Lon: 0, Altitude: 20, Timestamp: mktime("2021-12-24 08:05:00 +0800")},
+		},
+		HeartRateData: []*healthautoexport.DatapointWithUnit{
+			makeHeartRate("2021-12-24 08:00:00 +0800", 120),
+			makeHeartRate("2021-12-24 08:01:00 +0800", 160),
+		},
+		Fields: healthautoexport.WorkoutFields{
+			{Key: "activeEnergy", Value: &healthautoexport.QtyWithUnit{Qty: 418.4, Units: "kJ"}},
+		},
+	}
+
+	t.Run("with zones for target", func(t *testing.T) {
+		stats := analytics.ComputeWorkoutStatistics(workout, "john", opts)
+		if assert.NotNil(t, stats.Duration) {
+			assert.Equal(t, 10*time.Minute, *stats.Duration)
+		}
+		if assert.NotNil(t, stats.HeartRate) {
+			assert.Equal(t, 140.0, stats.HeartRate.Average)
+			assert.Equal(t, 160.0, stats.HeartRate.Max)
+			assert.Equal(t, []time.Duration{time.Minute, time.Minute}, stats.HeartRate.Zones)
+		}
+		if assert.NotNil(t, stats.RouteDistance) {
+			assert.InDelta(t, 1111.95, *stats.RouteDistance, 0.01)
+		}
+		if assert.NotNil(t, stats.AverageSpeed) {
+			assert.InDelta(t, 1111.95/300, *stats.AverageSpeed, 0.01)
+			assert.InDelta(t, 4.497, analytics.Pace(*stats.AverageSpeed), 0.001)
+		}
+		if assert.NotNil(t, stats.ElevationGain) && assert.NotNil(t, stats.ElevationLoss) {
+			assert.Equal(t, 10.0, *stats.ElevationGain)
+			assert.Equal(t, 0.0, *stats.ElevationLoss)
+		}
+		if assert.NotNil(t, stats.ActiveEnergyRate) {
+			assert.InDelta(t, 10, *stats.ActiveEnergyRate, 1e-9)
+		}
+	})
+
+	t.Run("without zones for unknown target", func(t *testing.T) {
+		stats := analytics.ComputeWorkoutStatistics(workout, "jane", opts)
+		if assert.NotNil(t, stats.HeartRate) {
+			assert.Nil(t, stats.HeartRate.Zones)
+		}
+	})
+
+	t.Run("empty workout", func(t *testing.T) {
+		stats := analytics.ComputeWorkoutStatistics(&healthautoexport.Workout{Name: "Yoga"}, "john", opts)
+		assert.Equal(t, &analytics.WorkoutStatistics{}, stats)
+	})
+}
+
+func makeHeartRate(ts string, qty float64) *healthautoexport.DatapointWithUnit {
+	return &healthautoexport.DatapointWithUnit{
+		Date:        mktime(ts),
+		QtyWithUnit: healthautoexport.QtyWithUnit{Qty: healthautoexport.Qty(qty), Units: "bpm"},
+	}
+}
+
+func mktime(ts string) *healthautoexport.Time {
+	t, err := healthautoexport.ParseTime(ts)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
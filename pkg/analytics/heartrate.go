@@ -0,0 +1,111 @@
+package analytics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	// maxHeartRateSampleInterval is the maximum duration that a single heart
+	// rate sample is counted for when computing time in heart rate zones.
+	maxHeartRateSampleInterval = time.Minute
+)
+
+// HeartRateStatistics contains statistics computed from heart rate samples.
+type HeartRateStatistics struct {
+	// Average is the time-weighted average heart rate.
+	Average float64
+	// Max is the maximum heart rate.
+	Max float64
+	// Zones contains the time spent in each heart rate zone, if zones are
+	// configured. Time spent below the first zone is not counted.
+	Zones []time.Duration
+}
+
+// sortedHeartRate returns the heart rate samples which have a date, sorted by time.
+func sortedHeartRate(data []*healthautoexport.DatapointWithUnit) []*healthautoexport.DatapointWithUnit {
+	samples := make([]*healthautoexport.DatapointWithUnit, 0, len(data))
+	for _, datum := range data {
+		if datum != nil && !datum.Date.IsZero() {
+			samples = append(samples, datum)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Date.Before(samples[j].Date.Time)
+	})
+	return samples
+}
+
+// ComputeHeartRateStatistics returns statistics for the heart rate samples of a
+// workout, or nil if there are no samples.
+//
+// Each sample is counted until the next sample, up to a maximum of one minute.
+// zones contains the lower bound of each zone in beats per minute, in
+// ascending order.
+func ComputeHeartRateStatistics(
+	data []*healthautoexport.DatapointWithUnit, end time.Time, zones []float64,
+) *HeartRateStatistics {
+	samples := sortedHeartRate(data)
+	if len(samples) == 0 {
+		return nil
+	}
+
+	stats := &HeartRateStatistics{}
+	if len(zones) > 0 {
+		stats.Zones = make([]time.Duration, len(zones))
+	}
+
+	var sum, total float64
+	for i, sample := range samples {
+		qty := float64(sample.Qty)
+		if qty > stats.Max {
+			stats.Max = qty
+		}
+
+		// Determine how long the sample is counted for.
+		next := end
+		if i+1 < len(samples) {
+			next = samples[i+1].Date.Time
+		}
+		interval := next.Sub(sample.Date.Time)
+		if interval < 0 {
+			interval = 0
+		}
+		if interval > maxHeartRateSampleInterval {
+			interval = maxHeartRateSampleInterval
+		}
+
+		sum += qty * interval.Seconds()
+		total += interval.Seconds()
+		if zone := findZone(qty, zones); zone >= 0 {
+			stats.Zones[zone] += interval
+		}
+	}
+
+	// Fall back to a simple average if no sample could be weighted, e.g. if
+	// there is only a single sample at the end of the workout.
+	if total > 0 {
+		stats.Average = sum / total
+	} else {
+		for _, sample := range samples {
+			stats.Average += float64(sample.Qty)
+		}
+		stats.Average /= float64(len(samples))
+	}
+
+	return stats
+}
+
+// findZone returns the index of the zone that the heart rate falls in, or -1
+// if it is below the first zone.
+func findZone(qty float64, zones []float64) int {
+	zone := -1
+	for i, lower := range zones {
+		if qty >= lower {
+			zone = i
+		}
+	}
+	return zone
+}
@@ -0,0 +1,72 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	// earthRadiusMeters is the mean radius of the Earth.
+	earthRadiusMeters = 6371008.8
+
+	// elevationThresholdMeters is the minimum change in altitude that is
+	// counted towards elevation gain or loss, to avoid accumulating GPS noise.
+	elevationThresholdMeters = 2
+)
+
+// SortedRoute returns the route datapoints of the workout which have a
+// timestamp, sorted by time.
+func SortedRoute(workout *healthautoexport.Workout) []*healthautoexport.RouteDatapoint {
+	route := make([]*healthautoexport.RouteDatapoint, 0, len(workout.Route))
+	for _, datum := range workout.Route {
+		if datum != nil && !datum.Timestamp.IsZero() {
+			route = append(route, datum)
+		}
+	}
+	sort.SliceStable(route, func(i, j int) bool {
+		return route[i].Timestamp.Before(route[j].Timestamp.Time)
+	})
+	return route
+}
+
+// Haversine returns the great-circle distance in meters between two points.
+func Haversine(a, b *healthautoexport.RouteDatapoint) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+}
+
+// RouteDistance returns the total distance in meters of a sorted route.
+func RouteDistance(route []*healthautoexport.RouteDatapoint) float64 {
+	var distance float64
+	for i := 1; i < len(route); i++ {
+		distance += Haversine(route[i-1], route[i])
+	}
+	return distance
+}
+
+// ElevationChange returns the total elevation gain and loss in meters of a
+// sorted route. Changes in altitude smaller than a threshold are accumulated
+// until they exceed it, so that noise in GPS altitudes is not counted.
+func ElevationChange(route []*healthautoexport.RouteDatapoint) (gain, loss float64) {
+	if len(route) == 0 {
+		return 0, 0
+	}
+	reference := route[0].Altitude
+	for _, datum := range route[1:] {
+		delta := datum.Altitude - reference
+		switch {
+		case delta >= elevationThresholdMeters:
+			gain += delta
+			reference = datum.Altitude
+		case delta <= -elevationThresholdMeters:
+			loss -= delta
+			reference = datum.Altitude
+		}
+	}
+	return gain, loss
+}
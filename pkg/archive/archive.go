@@ -0,0 +1,430 @@
+// Package archive stores the raw body of each ingest request exactly as it was
+// received, so that payloads can be audited or replayed into other backends.
+package archive
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// timestampLayout is the layout of the time that a payload was received
+	// at in file names, which sorts in chronological order.
+	timestampLayout = "20060102T150405.000000000Z"
+
+	// dateLayout is the layout of the date directory of each payload.
+	dateLayout = "2006-01-02"
+
+	// metadataExt is the extension of the metadata file of each payload.
+	metadataExt = ".meta.json"
+
+	// payloadExt is the extension of payload files, following the format.
+	payloadExt = ".gz"
+
+	// defaultTarget is the directory of payloads without a target.
+	defaultTarget = "default"
+
+	// hashLength is the number of hex digits of the hash in file names.
+	hashLength = 16
+)
+
+var (
+	archivePath       string
+	maxAge            time.Duration
+	maxSize           int64
+	retentionInterval time.Duration
+
+	// redactedHeaders are not stored in the metadata of payloads.
+	redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+)
+
+// Metadata describes the request of an archived payload.
+type Metadata struct {
+	ReceivedAt time.Time   `json:"receivedAt"`
+	Backend    string      `json:"backend"`
+	Target     string      `json:"target,omitempty"`
+	Format     string      `json:"format"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	RemoteAddr string      `json:"remoteAddr"`
+	Headers    http.Header `json:"headers"`
+	Size       int         `json:"size"`
+	SHA256     string      `json:"sha256"`
+}
+
+// NewMetadata returns the Metadata of a request, excluding any headers used for
+// authentication.
+func NewMetadata(r *http.Request, body []byte, backend, format string) *Metadata {
+	headers := r.Header.Clone()
+	for _, header := range redactedHeaders {
+		headers.Del(header)
+	}
+	hash := sha256.Sum256(body)
+	return &Metadata{
+		ReceivedAt: time.Now().UTC(),
+		Backend:    backend,
+		Target:     r.URL.Query().Get("target"),
+		Format:     format,
+		Method:     r.Method,
+		URL:        r.URL.RequestURI(),
+		RemoteAddr: r.RemoteAddr,
+		Headers:    headers,
+		Size:       len(body),
+		SHA256:     hex.EncodeToString(hash[:]),
+	}
+}
+
+// Options configures an Archiver.
+type Options struct {
+	// Path is the directory that payloads are stored in.
+	Path string
+
+	// MaxAge is the age after which payloads are removed. Payloads are kept
+	// forever if zero.
+	MaxAge time.Duration
+
+	// MaxSize is the total size in bytes of all payloads, after which the
+	// oldest payloads are removed. There is no limit if zero.
+	MaxSize int64
+
+	// RetentionInterval is the interval to remove payloads according to MaxAge
+	// and MaxSize. Payloads are only removed on startup if zero.
+	RetentionInterval time.Duration
+}
+
+// Archiver stores raw payloads in the local filesystem, under
+// {target}/{date}/{timestamp}-{hash}.{format}.gz, together with the request
+// metadata in a file with the same name and a .meta.json extension.
+type Archiver struct {
+	opts Options
+	mtx  sync.Mutex
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewArchiver returns an Archiver configured by command-line flags.
+func NewArchiver() (*Archiver, error) {
+	if archivePath == "" {
+		return nil, errors.New("--archive.path is not set")
+	}
+	return NewArchiverWithOptions(Options{
+		Path:              archivePath,
+		MaxAge:            maxAge,
+		MaxSize:           maxSize,
+		RetentionInterval: retentionInterval,
+	})
+}
+
+// NewArchiverWithOptions returns an Archiver with the given options. Payloads
+// are removed according to the retention options on startup, and periodically
+// in the background until the Archiver is closed.
+func NewArchiverWithOptions(opts Options) (*Archiver, error) {
+	archiver := &Archiver{
+		opts: opts,
+		stop: make(chan struct{}),
+	}
+	if err := os.MkdirAll(opts.Path, 0755); err != nil {
+		return nil, errors.Wrapf(err, "cannot makedirs for %v", opts.Path)
+	}
+	if err := archiver.Retain(); err != nil {
+		return nil, err
+	}
+	if opts.RetentionInterval > 0 && (opts.MaxAge > 0 || opts.MaxSize > 0) {
+		archiver.wg.Add(1)
+		go archiver.retainPeriodically()
+	}
+	return archiver, nil
+}
+
+// Close stops the background retention of the Archiver.
+func (a *Archiver) Close() error {
+	close(a.stop)
+	a.wg.Wait()
+	return nil
+}
+
+// Store compresses and stores the raw body of a request with its metadata, and
+// returns the path of the stored payload.
+func (a *Archiver) Store(body []byte, metadata *Metadata) (string, error) {
+	target := sanitizeName(metadata.Target)
+	if target == "" {
+		target = defaultTarget
+	}
+	receivedAt := metadata.ReceivedAt.UTC()
+	dir := path.Join(a.opts.Path, target, receivedAt.Format(dateLayout))
+	base := receivedAt.Format(timestampLayout) + "-" + metadata.SHA256[:hashLength]
+	name := path.Join(dir, base+"."+sanitizeName(metadata.Format)+payloadExt)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	// The payload is written before its metadata, so that a payload is never
+	// listed without its body.
+	err := writeFile(name, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if _, err := gz.Write(body); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot write payload %v", name)
+	}
+	metadataName := path.Join(dir, base+metadataExt)
+	err = writeFile(metadataName, func(w io.Writer) error {
+		enc := jsoniter.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(metadata)
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot write metadata %v", metadataName)
+	}
+
+	return name, nil
+}
+
+// Entry is a payload stored in an archive.
+type Entry struct {
+	// Path is the path of the compressed payload.
+	Path string
+
+	// MetadataPath is the path of the metadata of the payload.
+	MetadataPath string
+
+	// Size is the total size in bytes of the payload and its metadata.
+	Size int64
+
+	// ModTime is the time that the payload was stored at.
+	ModTime time.Time
+}
+
+// ReadMetadata reads the metadata of the payload.
+func (e *Entry) ReadMetadata() (*Metadata, error) {
+	data, err := os.ReadFile(e.MetadataPath)
+	if err != nil {
+		return nil, err
+	}
+	var metadata Metadata
+	if err := jsoniter.Unmarshal(data, &metadata); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode %v", e.MetadataPath)
+	}
+	return &metadata, nil
+}
+
+// Open returns a reader of the decompressed payload.
+func (e *Entry) Open() (io.ReadCloser, error) {
+	file, err := os.Open(e.Path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, errors.Wrapf(err, "cannot decompress %v", e.Path)
+	}
+	return &entryReader{Reader: gz, file: file}, nil
+}
+
+type entryReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *entryReader) Close() error {
+	err := r.Reader.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// List returns all payloads in the archive at root which have metadata, sorted
+// by the time that they were received.
+func List(root string) ([]*Entry, error) {
+	var entries []*Entry
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(name, payloadExt) {
+			return nil
+		}
+		base := strings.TrimSuffix(name, payloadExt)
+		base = strings.TrimSuffix(base, path.Ext(base))
+		metadataInfo, err := os.Stat(base + metadataExt)
+		if err != nil {
+			// Skip payloads which were partially stored.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &Entry{
+			Path:         name,
+			MetadataPath: base + metadataExt,
+			Size:         info.Size() + metadataInfo.Size(),
+			ModTime:      info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot list %v", root)
+	}
+
+	// File names start with the time that they were received at.
+	sort.Slice(entries, func(i, j int) bool {
+		return path.Base(entries[i].Path) < path.Base(entries[j].Path)
+	})
+	return entries, nil
+}
+
+// Retain removes payloads that are older than MaxAge, and then removes the
+// oldest payloads until the total size is within MaxSize.
+func (a *Archiver) Retain() error {
+	if a.opts.MaxAge <= 0 && a.opts.MaxSize <= 0 {
+		return nil
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	entries, err := List(a.opts.Path)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	var removed int
+	now := time.Now()
+	for _, entry := range entries {
+		expired := a.opts.MaxAge > 0 && now.Sub(entry.ModTime) > a.opts.MaxAge
+		oversized := a.opts.MaxSize > 0 && total > a.opts.MaxSize
+		if !expired && !oversized {
+			break
+		}
+		for _, name := range []string{entry.Path, entry.MetadataPath} {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "cannot remove %v", name)
+			}
+		}
+		removeEmptyDirs(a.opts.Path, path.Dir(entry.Path))
+		total -= entry.Size
+		removed++
+	}
+
+	if removed > 0 {
+		log.WithFields(log.Fields{
+			"path":    a.opts.Path,
+			"removed": removed,
+			"size":    total,
+		}).Info("removed archived payloads")
+	}
+	return nil
+}
+
+// retainPeriodically removes payloads at every RetentionInterval until the
+// Archiver is closed.
+func (a *Archiver) retainPeriodically() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.opts.RetentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			if err := a.Retain(); err != nil {
+				log.WithError(err).Error("could not remove archived payloads")
+			}
+		}
+	}
+}
+
+// removeEmptyDirs removes dir and its parents up to root if they are empty.
+func removeEmptyDirs(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// writeFile writes a file using write, via a temporary file in the same
+// directory which is renamed over the file.
+func writeFile(name string, write func(w io.Writer) error) error {
+	dir := path.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot makedirs for %v", dir)
+	}
+	file, err := os.CreateTemp(dir, path.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := file.Name()
+	defer func() {
+		_ = os.Remove(tmpName)
+	}()
+	if err := write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Chmod(0644); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
+
+// sanitizeName replaces any character that is not safe for use in file names
+// with an underscore.
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '+':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func init() {
+	pflag.StringVar(&archivePath, "archive.path", "", "Output path to store the raw body of each ingest request.")
+	pflag.DurationVar(&maxAge, "archive.maxAge", 0,
+		"Age after which archived payloads are removed. Use 0 to keep payloads forever.")
+	pflag.Int64Var(&maxSize, "archive.maxSize", 0,
+		"Total size in bytes of archived payloads, after which the oldest payloads are removed. Use 0 for no limit.")
+	pflag.DurationVar(&retentionInterval, "archive.retentionInterval", time.Hour,
+		"Interval to remove archived payloads according to --archive.maxAge and --archive.maxSize.")
+}
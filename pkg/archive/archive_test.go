@@ -0,0 +1,154 @@
+package archive_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/archive"
+)
+
+func newRequest(target string) *http.Request {
+	url := "/api/healthautoexport/v1/localfile/ingest"
+	if target != "" {
+		url += "?target=" + target
+	}
+	r := httptest.NewRequest(http.MethodPost, url, strings.NewReader(""))
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Cookie", "session=secret")
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestArchiver_Store(t *testing.T) {
+	dir := t.TempDir()
+	archiver, err := archive.NewArchiverWithOptions(archive.Options{Path: dir})
+	require.NoError(t, err)
+	defer archiver.Close()
+
+	body := []byte(`{"data":{"metrics":[]}}`)
+	metadata := archive.NewMetadata(newRequest("my/device"), body, "LocalFile", "json")
+	metadata.ReceivedAt = time.Date(2021, 12, 24, 10, 30, 0, 0, time.UTC)
+	name, err := archiver.Store(body, metadata)
+	require.NoError(t, err)
+	assert.Equal(t, path.Join(dir, "my_device", "2021-12-24"), path.Dir(name))
+	assert.True(t, strings.HasPrefix(path.Base(name), "20211224T103000.000000000Z-"+metadata.SHA256[:16]))
+	assert.True(t, strings.HasSuffix(name, ".json.gz"))
+
+	entries, err := archive.List(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, name, entries[0].Path)
+
+	reader, err := entries[0].Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, body, data)
+
+	got, err := entries[0].ReadMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, "LocalFile", got.Backend)
+	assert.Equal(t, "my/device", got.Target)
+	assert.Equal(t, "json", got.Format)
+	assert.Equal(t, len(body), got.Size)
+	assert.Equal(t, metadata.SHA256, got.SHA256)
+	assert.Equal(t, "application/json", got.Headers.Get("Content-Type"))
+	assert.Empty(t, got.Headers.Get("Authorization"))
+	assert.Empty(t, got.Headers.Get("Cookie"))
+}
+
+func TestArchiver_Retain(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		opts    archive.Options
+		ages    []time.Duration
+		wantLen int
+	}{
+		{
+			name:    "keep forever",
+			ages:    []time.Duration{72 * time.Hour, time.Hour, 0},
+			wantLen: 3,
+		},
+		{
+			name:    "max age",
+			opts:    archive.Options{MaxAge: 48 * time.Hour},
+			ages:    []time.Duration{72 * time.Hour, time.Hour, 0},
+			wantLen: 2,
+		},
+		{
+			name:    "max size",
+			opts:    archive.Options{MaxSize: 1},
+			ages:    []time.Duration{72 * time.Hour, time.Hour, 0},
+			wantLen: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archiver, err := archive.NewArchiverWithOptions(archive.Options{Path: dir})
+			require.NoError(t, err)
+			for i, age := range tt.ages {
+				body := []byte(strings.Repeat("x", i+1))
+				metadata := archive.NewMetadata(newRequest(""), body, "LocalFile", "json")
+				metadata.ReceivedAt = now.Add(-age)
+				name, err := archiver.Store(body, metadata)
+				require.NoError(t, err)
+				require.NoError(t, os.Chtimes(name, now.Add(-age), now.Add(-age)))
+			}
+			require.NoError(t, archiver.Close())
+
+			tt.opts.Path = dir
+			archiver, err = archive.NewArchiverWithOptions(tt.opts)
+			require.NoError(t, err)
+			defer archiver.Close()
+			entries, err := archive.List(dir)
+			require.NoError(t, err)
+			assert.Len(t, entries, tt.wantLen)
+			if tt.wantLen == 0 {
+				children, err := os.ReadDir(dir)
+				require.NoError(t, err)
+				assert.Empty(t, children)
+			}
+		})
+	}
+}
+
+func TestArchiver_RetainMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	archiver, err := archive.NewArchiverWithOptions(archive.Options{Path: dir})
+	require.NoError(t, err)
+	now := time.Now()
+	var names []string
+	for i := 0; i < 3; i++ {
+		body := []byte(strings.Repeat("x", i+1))
+		metadata := archive.NewMetadata(newRequest(""), body, "LocalFile", "json")
+		metadata.ReceivedAt = now.Add(time.Duration(i) * time.Second)
+		name, err := archiver.Store(body, metadata)
+		require.NoError(t, err)
+		names = append(names, name)
+	}
+	entries, err := archive.List(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.NoError(t, archiver.Close())
+
+	// Keep only the newest payload.
+	archiver, err = archive.NewArchiverWithOptions(archive.Options{Path: dir, MaxSize: entries[2].Size})
+	require.NoError(t, err)
+	defer archiver.Close()
+	entries, err = archive.List(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, names[2], entries[0].Path)
+}
@@ -16,6 +16,14 @@ type Backend interface {
 	Write(payload *healthautoexport.Payload, targetName string) error
 }
 
+// Reader is optionally implemented by backends which can read back the data
+// that they have stored, such as to migrate data into another backend.
+type Reader interface {
+	// Read calls fn with all stored data in chunks, each of which only contains
+	// data of a single target. Reading is stopped if fn returns an error.
+	Read(fn func(payload *healthautoexport.Payload, targetName string) error) error
+}
+
 // BackendQueue is a type that composes a Backend and a workqueue.
 type BackendQueue struct {
 	Backend
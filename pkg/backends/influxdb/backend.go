@@ -3,6 +3,7 @@ package influxdb
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -11,7 +12,9 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
 	"github.com/irvinlim/apple-health-ingester/pkg/backends"
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 	utiltime "github.com/irvinlim/apple-health-ingester/pkg/util/time"
 )
@@ -20,27 +23,108 @@ const (
 	MeasurementSleepAnalysisDetailed   = "sleep_analysis_detailed"
 	MeasurementSleepAnalysisAggregated = "sleep_analysis_aggregated"
 	MeasurementSleepPhases             = "sleep_phases"
+	MeasurementSleepSessions           = "sleep_sessions"
 )
 
 // Backend InfluxDB is used to store ingested metrics into InfluxDB. All metrics
 // will be stored as single Points (i.e. time-series data).
 type Backend struct {
-	ctx        context.Context
-	client     Client
-	staticTags []lp.Tag
+	ctx          context.Context
+	client       Client
+	staticTags   []lp.Tag
+	batchOptions BatchOptions
+	naming       map[DataType]*NamingSchema
+	tags         *tagPromoter
+	fieldTypes   *fieldTypeTracker
+	sleep        *sleepSampleStore
+	analytics    analytics.Options
+	replace      bool
 }
 
 var _ backends.Backend = &Backend{}
 
+// Options configures a Backend.
+type Options struct {
+	// StaticTags are added to every point, in key=value format.
+	StaticTags []string
+
+	// Batch configures how points are batched when writing.
+	Batch BatchOptions
+
+	// Naming configures the naming schema for each data type. Data types which
+	// are not specified use the default naming schema.
+	Naming map[DataType]NamingOptions
+
+	// Tags configures which datapoint fields are promoted to tags.
+	Tags TagOptions
+
+	// FieldTypes overrides the type of datapoint fields by key. Numbers are
+	// written as floats by default.
+	FieldTypes map[string]FieldType
+
+	// Analytics configures how workout statistics are computed.
+	Analytics analytics.Options
+
+	// ReplaceOnReexport deletes existing points of the target in each metric
+	// measurement within the time range of the payload, before writing the new
+	// points. This removes stale points when the same time range is exported
+	// again with different timestamps.
+	//
+	// Any error after deleting is retryable, so that the payload is written
+	// again instead of leaving the deleted time ranges empty.
+	ReplaceOnReexport bool
+}
+
+// NewBackend returns a new Backend initialized from flags.
 func NewBackend(client Client) (backends.Backend, error) {
+	naming, err := namingOptionsFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	types, err := fieldTypesFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	analyticsOptions, err := analytics.NewOptionsFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	return NewBackendWithOptions(client, Options{
+		StaticTags: staticTags,
+		Batch: BatchOptions{
+			MaxPoints:   batchSize,
+			MaxBytes:    batchBytes,
+			Concurrency: writeConcurrency,
+		},
+		Naming: naming,
+		Tags: TagOptions{
+			Keys:      tagKeys,
+			SplitKeys: splitTagKeys,
+			MaxValues: maxTagValues,
+		},
+		FieldTypes:        types,
+		Analytics:         analyticsOptions,
+		ReplaceOnReexport: replaceOnReexport,
+	})
+}
+
+// NewBackendWithOptions returns a new Backend with the given options.
+func NewBackendWithOptions(client Client, opts Options) (backends.Backend, error) {
 	backend := &Backend{
-		ctx:        context.TODO(),
-		client:     client,
-		staticTags: make([]lp.Tag, len(staticTags)),
+		ctx:          context.TODO(),
+		client:       client,
+		staticTags:   make([]lp.Tag, len(opts.StaticTags)),
+		batchOptions: opts.Batch,
+		naming:       make(map[DataType]*NamingSchema, len(AllDataTypes)),
+		tags:         newTagPromoter(opts.Tags),
+		fieldTypes:   newFieldTypeTracker(opts.FieldTypes),
+		sleep:        newSleepSampleStore(),
+		analytics:    opts.Analytics,
+		replace:      opts.ReplaceOnReexport,
 	}
 
 	// Prepare static tags.
-	for i, tag := range staticTags {
+	for i, tag := range opts.StaticTags {
 		tokens := strings.SplitN(tag, "=", 2)
 		if len(tokens) != 2 {
 			return nil, fmt.Errorf("invalid static tag %v", tag)
@@ -51,6 +135,20 @@ func NewBackend(client Client) (backends.Backend, error) {
 		}
 	}
 
+	// Prepare naming schemas.
+	for dataType := range opts.Naming {
+		if !isValidDataType(dataType) {
+			return nil, fmt.Errorf("invalid data type %q for naming schema", dataType)
+		}
+	}
+	for _, dataType := range AllDataTypes {
+		schema, err := NewNamingSchema(opts.Naming[dataType])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid naming schema for %v", dataType)
+		}
+		backend.naming[dataType] = schema
+	}
+
 	return backend, nil
 }
 
@@ -68,31 +166,72 @@ func (b *Backend) Write(payload *healthautoexport.Payload, targetName string) er
 		return nil
 	}
 
+	// Points are accumulated across metrics, workouts and health events, and
+	// written in batches.
+	writer := NewBatchWriter(b.ctx, b.client, b.batchOptions)
+	startTime := time.Now()
+
 	// Write metrics.
+	var replaced bool
 	if len(payload.Data.Metrics) > 0 {
-		if err := b.writeMetrics(payload.Data.Metrics, targetName); err != nil {
-			return errors.Wrapf(err, "write metrics error")
+		var err error
+		replaced, err = b.writeMetrics(writer, payload.Data.Metrics, targetName)
+		if err != nil {
+			_ = writer.Flush()
+			return b.wrapReplacedError(errors.Wrapf(err, "write metrics error"), replaced)
 		}
 	}
 
 	// Write workouts.
 	if len(payload.Data.Workouts) > 0 {
-		if err := b.writeWorkouts(payload.Data.Workouts, targetName); err != nil {
-			return errors.Wrapf(err, "write workouts error")
+		if err := b.writeWorkouts(writer, payload.Data.Workouts, targetName); err != nil {
+			_ = writer.Flush()
+			return b.wrapReplacedError(errors.Wrapf(err, "write workouts error"), replaced)
 		}
 	}
 
+	// Write health events.
+	if err := b.writeHealthEvents(writer, payload.Data, targetName); err != nil {
+		_ = writer.Flush()
+		return b.wrapReplacedError(errors.Wrapf(err, "write health events error"), replaced)
+	}
+
+	// Wait for all batches to be written.
+	if err := writer.Flush(); err != nil {
+		return b.wrapReplacedError(errors.Wrapf(err, "flush error"), replaced)
+	}
+	points, batches := writer.Stats()
+	log.WithFields(log.Fields{
+		"backend": b.Name(),
+		"target":  targetName,
+		"points":  points,
+		"batches": batches,
+		"elapsed": time.Since(startTime),
+	}).Info("write all points success")
+
 	return nil
 }
 
-func (b *Backend) writeMetrics(metrics []*healthautoexport.Metric, targetName string) error {
+// wrapReplacedError returns err as a retryable error if existing points were
+// deleted before it occurred, so that the payload is written again instead of
+// leaving the deleted time ranges empty.
+func (b *Backend) wrapReplacedError(err error, replaced bool) error {
+	if !replaced || apierrors.IsRetryableWrite(err) {
+		return err
+	}
+	return apierrors.WrapfRetryableWrite(err, "write error after deleting existing points")
+}
+
+// writeMetrics queues all metric points to be written. Returns true if existing
+// points were deleted, in which case the new points must be written again if
+// any later write fails.
+func (b *Backend) writeMetrics(writer *BatchWriter, metrics []*healthautoexport.Metric, targetName string) (bool, error) {
 	logger := log.WithFields(log.Fields{
 		"backend":     b.Name(),
 		"target":      targetName,
 		"num_metrics": len(metrics),
 	})
 
-	startTime := time.Now()
 	logger.Info("start writing all metrics")
 
 	tags := []lp.Tag{
@@ -100,39 +239,108 @@ func (b *Backend) writeMetrics(metrics []*healthautoexport.Metric, targetName st
 	}
 	tags = append(tags, b.staticTags...)
 
+	// Process all metrics first, so that existing points can be deleted before
+	// any new points are written.
+	metricPoints := make([][]*write.Point, len(metrics))
+	metricInfos := make([]timeseriesInfo, len(metrics))
+	for i, metric := range metrics {
+		sessions, stale := b.sleep.Merge(targetName, metric.SleepAnalyses)
+		if err := b.deleteSleepSessions(stale, tags, logger); err != nil {
+			return false, err
+		}
+		metricPoints[i], metricInfos[i] = b.processMetricPoints(metric, tags, sessions)
+	}
+	var replaced bool
+	if b.replace {
+		var err error
+		if replaced, err = b.deleteExistingPoints(metricPoints, tags, logger); err != nil {
+			return replaced, err
+		}
+	}
+
 	var info timeseriesInfo
-	for _, metric := range metrics {
-		points, metricInfo := b.processMetricPoints(metric, tags)
+	for i, metric := range metrics {
+		points, metricInfo := metricPoints[i], metricInfos[i]
 		if len(points) > 0 {
 			logger := logger.WithFields(log.Fields{
 				"metric_name": metric.Name,
 				"count":       len(points),
 				"time_range":  utiltime.FormatTimeRange(metricInfo.StartTime, metricInfo.EndTime, time.RFC3339),
 			})
-			startTime := time.Now()
-			logger.Debug("writing metric points")
-			if err := b.client.WriteMetrics(b.ctx, points...); err != nil {
-				return errors.Wrapf(err, "write error for %v", metric.Name)
+			logger.Debug("queueing metric points")
+			if err := writer.AddMetrics(points...); err != nil {
+				return replaced, errors.Wrapf(err, "write error for %v", metric.Name)
 			}
 
 			// Process info before moving on.
 			info.Count += metricInfo.Count
 			info.StartTime = utiltime.MinTimeNonZero(info.StartTime, metricInfo.StartTime)
 			info.EndTime = utiltime.MaxTime(info.EndTime, metricInfo.EndTime)
-
-			logger.WithField("elapsed", time.Since(startTime)).Debug("write metric points success")
 		}
 	}
 
 	logger.WithFields(log.Fields{
 		"points":     info.Count,
 		"time_range": utiltime.FormatTimeRange(info.StartTime, info.EndTime, time.RFC3339),
-		"elapsed":    time.Since(startTime),
-	}).Info("write all metrics success")
+	}).Info("queued all metrics")
+
+	return replaced, nil
+}
 
+// deleteSleepSessions deletes the points of sleep sessions which were replaced
+// by a session with a different date.
+func (b *Backend) deleteSleepSessions(sessions []*healthautoexport.SleepSession, tags []lp.Tag, logger *log.Entry) error {
+	measurement := b.naming[DataTypeMetrics].Measurement(MeasurementSleepSessions, "")
+	for _, session := range sessions {
+		ts := sleepSessionTime(session)
+		predicate := DeletePredicate{
+			Measurement: measurement,
+			Tags:        append(nonEmptyTags(tags), lp.Tag{Key: "source", Value: session.Source}),
+			Start:       ts,
+			End:         ts,
+		}
+		logger.WithFields(log.Fields{
+			"date":   session.Date,
+			"source": session.Source,
+		}).Debug("deleting replaced sleep session")
+		if err := b.client.DeleteMetrics(b.ctx, predicate); err != nil {
+			return errors.Wrapf(err, "delete error for %v", measurement)
+		}
+	}
 	return nil
 }
 
+// deleteExistingPoints deletes existing points with the tags in each
+// measurement, within the time range of the new points in that measurement.
+// Returns true if any points were deleted, in which case the new points must be
+// written again if the deletion or any later write fails.
+func (b *Backend) deleteExistingPoints(metricPoints [][]*write.Point, tags []lp.Tag, logger *log.Entry) (bool, error) {
+	var points []*write.Point
+	for _, p := range metricPoints {
+		points = append(points, p...)
+	}
+	measurements, ranges := measurementRanges(points)
+	var deleted bool
+	for _, measurement := range measurements {
+		info := ranges[measurement]
+		predicate := DeletePredicate{
+			Measurement: measurement,
+			Tags:        nonEmptyTags(tags),
+			Start:       info.StartTime,
+			End:         info.EndTime,
+		}
+		logger.WithFields(log.Fields{
+			"measurement": measurement,
+			"time_range":  utiltime.FormatTimeRange(info.StartTime, info.EndTime, time.RFC3339),
+		}).Debug("deleting existing points")
+		if err := b.client.DeleteMetrics(b.ctx, predicate); err != nil {
+			return deleted, errors.Wrapf(err, "delete error for %v", measurement)
+		}
+		deleted = true
+	}
+	return deleted, nil
+}
+
 type timeseriesInfo struct {
 	// Earliest timestamp that is collected.
 	StartTime time.Time `json:"start_time"`
@@ -142,21 +350,30 @@ type timeseriesInfo struct {
 	Count int `json:"count"`
 }
 
-func (b *Backend) processMetricPoints(metric *healthautoexport.Metric, tags []lp.Tag) ([]*write.Point, timeseriesInfo) {
+func (b *Backend) processMetricPoints(
+	metric *healthautoexport.Metric, tags []lp.Tag, sessions []*healthautoexport.SleepSession,
+) ([]*write.Point, timeseriesInfo) {
 	var info timeseriesInfo
 
+	naming := b.naming[DataTypeMetrics]
 	points := make([]*write.Point, 0, len(metric.Datapoints))
-	datapointMeasurement := GetUnitizedMeasurementName(metric.Name, metric)
+	datapointMeasurement := naming.Measurement(metric.Name, metric.GetUnits())
 	for _, datum := range metric.Datapoints {
 		point := write.NewPointWithMeasurement(datapointMeasurement)
 		addTagsToPoint(point, tags)
+		addTagsToPoint(point, naming.UnitsTags(metric.GetUnits()))
 		// Add qty if set
 		if datum.Qty != 0 {
-			point.AddField("qty", float64(datum.Qty))
+			point.AddField(naming.Field("qty", ""), float64(datum.Qty))
 		}
-		// Add additional fields
-		for name, value := range datum.Fields {
-			point.AddField(name, value)
+		// Add additional fields, promoting some of them to tags
+		datumTags, fields := b.tags.Promote(datapointMeasurement, datum.Fields)
+		addTagsToPoint(point, datumTags)
+		for _, name := range sortedKeys(fields) {
+			fieldName := naming.Field(name, "")
+			if value, ok := b.fieldTypes.Coerce(datapointMeasurement, fieldName, fields[name]); ok {
+				point.AddField(fieldName, value)
+			}
 		}
 		// Skip if there are no fields to write
 		if len(point.FieldList()) == 0 {
@@ -174,53 +391,94 @@ func (b *Backend) processMetricPoints(metric *healthautoexport.Metric, tags []lp
 
 	// Add points for detailed sleep analysis.
 	for _, s := range metric.SleepAnalyses {
-		points = append(points, makeSleepPoint(MeasurementSleepAnalysisDetailed, s.Source, s.Value, 1, nil, s.StartDate, tags))
+		points = append(points, makeSleepPoint(naming, MeasurementSleepAnalysisDetailed, s.Source, s.Value, 1, nil, s.StartDate, tags))
 		// end point has state = 0 (off)
-		points = append(points, makeSleepPoint(MeasurementSleepAnalysisDetailed, s.Source, s.Value, 0, &s.Qty, s.EndDate, tags))
+		points = append(points, makeSleepPoint(naming, MeasurementSleepAnalysisDetailed, s.Source, s.Value, 0, &s.Qty, s.EndDate, tags))
+	}
+
+	// Add one point per night per source for sleep sessions reconstructed from detailed sleep analysis.
+	for _, session := range sessions {
+		points = append(points, makeSleepSessionPoint(naming, MeasurementSleepSessions, session, tags))
 	}
 
 	// Add points for aggregated sleep analysis.
 	for _, a := range metric.AggregatedSleepAnalyses {
 		// Support old aggregated sleep analysis format prior to HAE v6.6.2.
 		if a.SleepSource != "" {
-			points = append(points, makeSleepPoint(MeasurementSleepAnalysisAggregated, a.SleepSource, "asleep", 1, nil, a.SleepStart, tags))
-			points = append(points, makeSleepPoint(MeasurementSleepAnalysisAggregated, a.SleepSource, "asleep", 0, &a.Asleep, a.SleepEnd, tags))
+			points = append(points, makeSleepPoint(naming, MeasurementSleepAnalysisAggregated, a.SleepSource, "asleep", 1, nil, a.SleepStart, tags))
+			points = append(points, makeSleepPoint(naming, MeasurementSleepAnalysisAggregated, a.SleepSource, "asleep", 0, &a.Asleep, a.SleepEnd, tags))
 		}
 		if a.InBedSource != "" {
-			points = append(points, makeSleepPoint(MeasurementSleepAnalysisAggregated, a.InBedSource, "inBed", 1, nil, a.InBedStart, tags))
-			points = append(points, makeSleepPoint(MeasurementSleepAnalysisAggregated, a.InBedSource, "inBed", 0, &a.InBed, a.InBedEnd, tags))
+			points = append(points, makeSleepPoint(naming, MeasurementSleepAnalysisAggregated, a.InBedSource, "inBed", 1, nil, a.InBedStart, tags))
+			points = append(points, makeSleepPoint(naming, MeasurementSleepAnalysisAggregated, a.InBedSource, "inBed", 0, &a.InBed, a.InBedEnd, tags))
 		}
 
 		// Support sleep phase data from HAE v6.6.2 onwards.
 		// All points for sleep phase will use the SleepEnd time.
 		if a.Source != "" {
-			points = append(points, makeSleepPhasePoint(MeasurementSleepPhases, a.Source, "awake", a.Awake, a.SleepEnd, tags))
-			points = append(points, makeSleepPhasePoint(MeasurementSleepPhases, a.Source, "asleep", a.Asleep, a.SleepEnd, tags))
-			points = append(points, makeSleepPhasePoint(MeasurementSleepPhases, a.Source, "inBed", a.InBed, a.SleepEnd, tags))
-			points = append(points, makeSleepPhasePoint(MeasurementSleepPhases, a.Source, "core", a.Core, a.SleepEnd, tags))
-			points = append(points, makeSleepPhasePoint(MeasurementSleepPhases, a.Source, "deep", a.Deep, a.SleepEnd, tags))
-			points = append(points, makeSleepPhasePoint(MeasurementSleepPhases, a.Source, "rem", a.REM, a.SleepEnd, tags))
+			points = append(points, makeSleepPhasePoint(naming, MeasurementSleepPhases, a.Source, "awake", a.Awake, a.SleepEnd, tags))
+			points = append(points, makeSleepPhasePoint(naming, MeasurementSleepPhases, a.Source, "asleep", a.Asleep, a.SleepEnd, tags))
+			points = append(points, makeSleepPhasePoint(naming, MeasurementSleepPhases, a.Source, "inBed", a.InBed, a.SleepEnd, tags))
+			points = append(points, makeSleepPhasePoint(naming, MeasurementSleepPhases, a.Source, "core", a.Core, a.SleepEnd, tags))
+			points = append(points, makeSleepPhasePoint(naming, MeasurementSleepPhases, a.Source, "deep", a.Deep, a.SleepEnd, tags))
+			points = append(points, makeSleepPhasePoint(naming, MeasurementSleepPhases, a.Source, "rem", a.REM, a.SleepEnd, tags))
 		}
 	}
 
 	return points, info
 }
 
-func makeSleepPoint(measurement string, source string, value string,
+func makeSleepPoint(naming *NamingSchema, measurement string, source string, value string,
 	state uint8, qty *healthautoexport.Qty, t *healthautoexport.Time, tags []lp.Tag) *write.Point {
-	point := write.NewPointWithMeasurement(measurement)
+	point := write.NewPointWithMeasurement(naming.Measurement(measurement, ""))
 	addTagsToPoint(point, tags)
 	point.AddTag("source", source)
 	point.AddTag("value", value)
 	if qty != nil {
-		point.AddField("qty", float64(*qty))
+		point.AddField(naming.Field("qty", ""), float64(*qty))
 	}
-	point.AddField("state", state)
+	point.AddField(naming.Field("state", ""), state)
 	point.SetTime(t.Time)
 	return point
 }
 
+// makeSleepSessionPoint creates a point for a SleepSession. The point is
+// timestamped at midnight UTC of the night's date, so that there is only a
+// single point per night and source, which is replaced when the whole night is
+// exported again.
+func makeSleepSessionPoint(
+	naming *NamingSchema,
+	measurement string,
+	session *healthautoexport.SleepSession,
+	tags []lp.Tag,
+) *write.Point {
+	point := write.NewPointWithMeasurement(naming.Measurement(measurement, ""))
+	addTagsToPoint(point, tags)
+	point.AddTag("source", session.Source)
+	point.AddField(naming.Field("inBed", ""), float64(session.InBed))
+	point.AddField(naming.Field("asleep", ""), float64(session.Asleep))
+	point.AddField(naming.Field("awake", ""), float64(session.Awake))
+	point.AddField(naming.Field("core", ""), float64(session.Core))
+	point.AddField(naming.Field("deep", ""), float64(session.Deep))
+	point.AddField(naming.Field("rem", ""), float64(session.REM))
+	point.AddField(naming.Field("efficiency", ""), session.Efficiency)
+	point.AddField(naming.Field("latency", ""), float64(session.Latency))
+	point.AddField(naming.Field("waso", ""), float64(session.WASO))
+	point.SetTime(sleepSessionTime(session))
+	return point
+}
+
+// sleepSessionTime returns the timestamp of a SleepSession point, which is
+// midnight UTC of the night's date, falling back to the start of the session.
+func sleepSessionTime(session *healthautoexport.SleepSession) time.Time {
+	if date, err := time.Parse(healthautoexport.SleepSessionDateLayout, session.Date); err == nil {
+		return date
+	}
+	return session.Start.Time
+}
+
 func makeSleepPhasePoint(
+	naming *NamingSchema,
 	measurement string,
 	source string,
 	value string,
@@ -228,16 +486,16 @@ func makeSleepPhasePoint(
 	t *healthautoexport.Time,
 	tags []lp.Tag,
 ) *write.Point {
-	point := write.NewPointWithMeasurement(measurement)
+	point := write.NewPointWithMeasurement(naming.Measurement(measurement, ""))
 	addTagsToPoint(point, tags)
 	point.AddTag("source", source)
 	point.AddTag("value", value)
-	point.AddField("qty", float64(qty))
+	point.AddField(naming.Field("qty", ""), float64(qty))
 	point.SetTime(t.Time)
 	return point
 }
 
-func (b *Backend) writeWorkouts(workouts []*healthautoexport.Workout, targetName string) error {
+func (b *Backend) writeWorkouts(writer *BatchWriter, workouts []*healthautoexport.Workout, targetName string) error {
 	logger := log.WithFields(log.Fields{
 		"backend":      b.Name(),
 		"target":       targetName,
@@ -245,7 +503,6 @@ func (b *Backend) writeWorkouts(workouts []*healthautoexport.Workout, targetName
 	})
 
 	var count int
-	startTime := time.Now()
 	logger.Info("start writing all workouts")
 
 	for _, workout := range workouts {
@@ -255,11 +512,12 @@ func (b *Backend) writeWorkouts(workouts []*healthautoexport.Workout, targetName
 		tags := []lp.Tag{
 			{Key: "target_name", Value: targetName},
 			{Key: "workout_name", Value: workout.Name},
+			{Key: "workout_id", Value: workout.WorkoutID()},
 		}
 		tags = append(tags, b.staticTags...)
 
 		// Create aggregate workout point
-		point, err := b.createWorkoutAggregatePoint(workout)
+		point, err := b.createWorkoutAggregatePoint(workout, targetName)
 		if err != nil {
 			return errors.Wrapf(err, "conversion error for workout %+v", workout)
 		}
@@ -269,9 +527,9 @@ func (b *Backend) writeWorkouts(workouts []*healthautoexport.Workout, targetName
 		}
 
 		// Create during-workout datapoints
-		points = append(points, b.createRoutePoints("route", tags, workout.Route)...)
-		points = append(points, b.createWorkoutPoints("heart_rate_data", tags, workout.HeartRateData)...)
-		points = append(points, b.createWorkoutPoints("heart_rate_recovery", tags, workout.HeartRateRecovery)...)
+		points = append(points, b.createRoutePoints(workout, "route", tags, workout.Route)...)
+		points = append(points, b.createWorkoutPoints(workout, "heart_rate_data", tags, workout.HeartRateData)...)
+		points = append(points, b.createWorkoutPoints(workout, "heart_rate_recovery", tags, workout.HeartRateRecovery)...)
 
 		if len(points) > 0 {
 			logger := logger.WithFields(log.Fields{
@@ -279,32 +537,28 @@ func (b *Backend) writeWorkouts(workouts []*healthautoexport.Workout, targetName
 				"workout":     workout.Name,
 				"count":       len(points),
 			})
-			startTime := time.Now()
 			count += len(points)
-			logger.Debug("writing workout points")
-			if err := b.client.WriteWorkouts(b.ctx, points...); err != nil {
+			logger.Debug("queueing workout points")
+			if err := writer.AddWorkouts(points...); err != nil {
 				return errors.Wrapf(err, "write error for workout")
 			}
-			logger.WithField("elapsed", time.Since(startTime)).Debug("write workout points success")
 		}
 	}
 
-	logger.WithFields(log.Fields{
-		"points":  count,
-		"elapsed": time.Since(startTime),
-	}).Info("write all workouts success")
+	logger.WithField("points", count).Info("queued all workouts")
 
 	return nil
 }
 
-func (b *Backend) createWorkoutAggregatePoint(workout *healthautoexport.Workout) (*write.Point, error) {
+func (b *Backend) createWorkoutAggregatePoint(workout *healthautoexport.Workout, targetName string) (*write.Point, error) {
 	// Skip if the workout has no start time (probably invalid)
 	if workout.Start.IsZero() {
 		return nil, errors.New("workout has no start time")
 	}
-	point := write.NewPointWithMeasurement("workout")
+	naming := b.naming[DataTypeWorkouts]
+	point := write.NewPointWithMeasurement(naming.WorkoutMeasurement(workout, "workout", ""))
 	// Compute fields from workout
-	workoutFields := CreateWorkoutStatistics(workout)
+	workoutFields := CreateWorkoutStatistics(workout, targetName, b.analytics)
 	// Add elevation fields
 	if workout.Elevation != nil {
 		workoutFields = append(workoutFields, healthautoexport.Field{
@@ -325,7 +579,7 @@ func (b *Backend) createWorkoutAggregatePoint(workout *healthautoexport.Workout)
 	workoutFields = append(workoutFields, workout.Fields...)
 	// Convert to InfluxDB field format
 	for _, field := range workoutFields {
-		fieldName := GetUnitizedMeasurementName(field.Key, field.Value)
+		fieldName := naming.Field(field.Key, field.Value.GetUnits())
 		point.AddField(fieldName, float64(field.Value.Qty))
 	}
 	// Skip if there are no fields to write
@@ -337,13 +591,15 @@ func (b *Backend) createWorkoutAggregatePoint(workout *healthautoexport.Workout)
 }
 
 func (b *Backend) createWorkoutPoints(
-	name string, tags []lp.Tag, data []*healthautoexport.DatapointWithUnit,
+	workout *healthautoexport.Workout, name string, tags []lp.Tag, data []*healthautoexport.DatapointWithUnit,
 ) []*write.Point {
+	naming := b.naming[DataTypeWorkouts]
 	points := make([]*write.Point, 0, len(data))
 	for _, datum := range data {
-		point := write.NewPointWithMeasurement(GetUnitizedMeasurementName(name, datum))
+		point := write.NewPointWithMeasurement(naming.WorkoutMeasurement(workout, name, datum.GetUnits()))
 		addTagsToPoint(point, tags)
-		point.AddField("qty", float64(datum.Qty))
+		addTagsToPoint(point, naming.UnitsTags(datum.GetUnits()))
+		point.AddField(naming.Field("qty", ""), float64(datum.Qty))
 		point.SetTime(datum.Date.Time)
 		points = append(points, point)
 	}
@@ -351,21 +607,33 @@ func (b *Backend) createWorkoutPoints(
 }
 
 func (b *Backend) createRoutePoints(
-	name string, tags []lp.Tag, data []*healthautoexport.RouteDatapoint,
+	workout *healthautoexport.Workout, name string, tags []lp.Tag, data []*healthautoexport.RouteDatapoint,
 ) []*write.Point {
+	naming := b.naming[DataTypeWorkouts]
 	points := make([]*write.Point, 0, len(data))
 	for _, datum := range data {
-		point := write.NewPointWithMeasurement(name)
+		point := write.NewPointWithMeasurement(naming.WorkoutMeasurement(workout, name, ""))
 		addTagsToPoint(point, tags)
-		point.AddField("lat", datum.Lat)
-		point.AddField("lon", datum.Lon)
-		point.AddField("altitude", datum.Altitude)
+		point.AddField(naming.Field("lat", ""), datum.Lat)
+		point.AddField(naming.Field("lon", ""), datum.Lon)
+		point.AddField(naming.Field("altitude", ""), datum.Altitude)
 		point.SetTime(datum.Timestamp.Time)
 		points = append(points, point)
 	}
 	return points
 }
 
+// sortedKeys returns the keys of fields in sorted order, so that fields are
+// always written in the same order.
+func sortedKeys(fields healthautoexport.DatapointFields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func addTagsToPoint(point *write.Point, tags []lp.Tag) {
 	for _, tag := range tags {
 		if tag.Value != "" {
@@ -374,12 +642,27 @@ func addTagsToPoint(point *write.Point, tags []lp.Tag) {
 	}
 }
 
+// nonEmptyTags returns the tags which have a value, similar to addTagsToPoint,
+// since empty tags are never written.
+func nonEmptyTags(tags []lp.Tag) []lp.Tag {
+	result := make([]lp.Tag, 0, len(tags))
+	for _, tag := range tags {
+		if tag.Value != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
 type WithUnits interface {
 	GetUnits() healthautoexport.Units
 }
 
 // GetUnitizedMeasurementName returns the measurement name.
 // It will add a suffix for the unit to the measurement name.
+//
+// Deprecated: Use NamingSchema.Measurement instead, which respects the
+// configured naming schema.
 func GetUnitizedMeasurementName(name string, metric WithUnits) string {
 	return name + "_" + string(metric.GetUnits())
 }
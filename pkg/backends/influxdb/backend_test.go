@@ -2,6 +2,7 @@ package influxdb_test
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -86,6 +87,7 @@ func TestBackend(t *testing.T) {
 			wantMetrics: []string{
 				`sleep_analysis_detailed,target_name=test,source=Irvin's\ Apple\ Watch,value=Core state=1u 1639765266000000000`,
 				`sleep_analysis_detailed,target_name=test,source=Irvin's\ Apple\ Watch,value=Core qty=6.108333333333333,state=0u 1639789026000000000`,
+				`sleep_sessions,target_name=test,source=Irvin's\ Apple\ Watch inBed=6.6,asleep=6.6,awake=0,core=6.6,deep=0,rem=0,efficiency=1,latency=0,waso=0 1639785600000000000`,
 			},
 		},
 		{
@@ -93,12 +95,28 @@ func TestBackend(t *testing.T) {
 			target:  "test",
 			payload: fixtures.PayloadWithWorkouts,
 			wantWorkouts: []string{
-				`workout,target_name=test,workout_name=Walking duration_min=19.166666666666668,elevation_ascent_m=16.36,elevation_descent_m=0,activeEnergy_kJ=226.21122641832523,stepCount_steps=908 1640304163000000000`,
-				`route,target_name=test,workout_name=Walking lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
-				`heart_rate_data_bpm,target_name=test,workout_name=Walking qty=108 1640304167000000000`,
-				`workout,target_name=test,workout_name=Walking duration_min=19.166666666666668,elevation_ascent_m=16.36,elevation_descent_m=0,activeEnergy_kJ=226.21122641832523,stepCount_steps=908 1640304163000000000`,
-				`route,target_name=test,workout_name=Walking lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
-				`heart_rate_data_bpm,target_name=test,workout_name=Walking qty=108 1640304167000000000`,
+				`workout,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 duration_min=19.166666666666668,avg_heart_rate_bpm=108,max_heart_rate_bpm=108,active_energy_rate_kcal/min=2.8208233404895484,elevation_ascent_m=16.36,elevation_descent_m=0,activeEnergy_kJ=226.21122641832523,stepCount_steps=908 1640304163000000000`,
+				`route,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
+				`heart_rate_data_bpm,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 qty=108 1640304167000000000`,
+				`workout,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 duration_min=19.166666666666668,avg_heart_rate_bpm=108,max_heart_rate_bpm=108,active_energy_rate_kcal/min=2.8208233404895484,elevation_ascent_m=16.36,elevation_descent_m=0,activeEnergy_kJ=226.21122641832523,stepCount_steps=908 1640304163000000000`,
+				`route,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
+				`heart_rate_data_bpm,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 qty=108 1640304167000000000`,
+			},
+		},
+		{
+			name:    "write health events",
+			target:  "test",
+			payload: fixtures.PayloadWithHealthEvents,
+			wantMetrics: []string{
+				`symptoms,target_name=test,name=Headache,severity=Mild,source=Irvin's\ iPhone count=1i,duration_min=150 1727744400000000000`,
+				`medications,target_name=test,name=Vitamin\ D,form=Capsule,status=Taken,source=Irvin's\ iPhone count=1i,duration_min=0,dosage=1 1727741100000000000`,
+				`ecg,target_name=test,classification=Sinus\ Rhythm,source=Irvin’s\ Apple\ Watch count=1i,duration_min=0.5,average_heart_rate=72,sampling_frequency=512,number_of_voltage_measurements=2i 1727748000000000000`,
+				`ecg_voltage_uV,target_name=test,classification=Sinus\ Rhythm,source=Irvin’s\ Apple\ Watch qty=-12.5 1727748000000000000`,
+				`ecg_voltage_uV,target_name=test,classification=Sinus\ Rhythm,source=Irvin’s\ Apple\ Watch qty=30.25 1727748001000000000`,
+				`heart_rate_notifications,target_name=test count=1i,duration_min=10,threshold=120 1727762400000000000`,
+				`heart_rate_notifications_hr_bpm,target_name=test qty=125 1727762400000000000`,
+				`state_of_mind,target_name=test,kind=momentaryEmotion,valence_classification=pleasant,labels=Happy|Calm,associations=Family count=1i,duration_min=0,valence=0.5 1727784000000000000`,
+				`cycle_tracking,target_name=test,name=menstrual_flow,value=medium,source=Irvin's\ iPhone count=1i,duration_min=1440 1727712000000000000`,
 			},
 		},
 		{
@@ -118,12 +136,12 @@ func TestBackend(t *testing.T) {
 			target:  "",
 			payload: fixtures.PayloadWithWorkouts,
 			wantWorkouts: []string{
-				`workout,workout_name=Walking duration_min=19.166666666666668,elevation_ascent_m=16.36,elevation_descent_m=0,activeEnergy_kJ=226.21122641832523,stepCount_steps=908 1640304163000000000`,
-				`route,workout_name=Walking lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
-				`heart_rate_data_bpm,workout_name=Walking qty=108 1640304167000000000`,
-				`workout,workout_name=Walking duration_min=19.166666666666668,elevation_ascent_m=16.36,elevation_descent_m=0,activeEnergy_kJ=226.21122641832523,stepCount_steps=908 1640304163000000000`,
-				`route,workout_name=Walking lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
-				`heart_rate_data_bpm,workout_name=Walking qty=108 1640304167000000000`,
+				`workout,workout_name=Walking,workout_id=0d440f6feecd8126 duration_min=19.166666666666668,avg_heart_rate_bpm=108,max_heart_rate_bpm=108,active_energy_rate_kcal/min=2.8208233404895484,elevation_ascent_m=16.36,elevation_descent_m=0,activeEnergy_kJ=226.21122641832523,stepCount_steps=908 1640304163000000000`,
+				`route,workout_name=Walking,workout_id=0d440f6feecd8126 lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
+				`heart_rate_data_bpm,workout_name=Walking,workout_id=0d440f6feecd8126 qty=108 1640304167000000000`,
+				`workout,workout_name=Walking,workout_id=0d440f6feecd8126 duration_min=19.166666666666668,avg_heart_rate_bpm=108,max_heart_rate_bpm=108,active_energy_rate_kcal/min=2.8208233404895484,elevation_ascent_m=16.36,elevation_descent_m=0,activeEnergy_kJ=226.21122641832523,stepCount_steps=908 1640304163000000000`,
+				`route,workout_name=Walking,workout_id=0d440f6feecd8126 lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
+				`heart_rate_data_bpm,workout_name=Walking,workout_id=0d440f6feecd8126 qty=108 1640304167000000000`,
 			},
 		},
 	}
@@ -138,11 +156,217 @@ func TestBackend(t *testing.T) {
 	}
 }
 
+// makeMetricPayload returns a payload with a single metric, with a datapoint for
+// each timestamp in qtys in seconds.
+func makeMetricPayload(name string, units healthautoexport.Units, qtys map[int64]float64) *healthautoexport.Payload {
+	timestamps := make([]int64, 0, len(qtys))
+	for ts := range qtys {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+	metric := &healthautoexport.Metric{Name: name, Units: units}
+	for _, ts := range timestamps {
+		date := healthautoexport.NewTime(time.Unix(ts, 0))
+		metric.Datapoints = append(metric.Datapoints, &healthautoexport.Datapoint{
+			Date: &date,
+			Qty:  healthautoexport.Qty(qtys[ts]),
+		})
+	}
+	return &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{
+			Metrics: []*healthautoexport.Metric{metric},
+		},
+	}
+}
+
+func makeSleepPayload(samples ...*healthautoexport.SleepAnalysis) *healthautoexport.Payload {
+	return &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{
+			Metrics: []*healthautoexport.Metric{
+				{
+					Name:          "sleep_analysis",
+					Units:         "hr",
+					SleepAnalyses: samples,
+				},
+			},
+		},
+	}
+}
+
+func makeSleepSample(start, end time.Time, value string) *healthautoexport.SleepAnalysis {
+	startDate, endDate := healthautoexport.NewTime(start), healthautoexport.NewTime(end)
+	return &healthautoexport.SleepAnalysis{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+		Qty:       healthautoexport.Qty(end.Sub(start).Hours()),
+		Source:    "Apple Watch",
+		Value:     value,
+	}
+}
+
+func TestBackend_SleepSessions(t *testing.T) {
+	midnight := time.Date(2021, 12, 18, 0, 0, 0, 0, time.UTC)
+	previous := makeSleepSample(midnight.Add(-25*time.Hour), midnight.Add(-17*time.Hour), "Core")
+	core := makeSleepSample(midnight.Add(-2*time.Hour), midnight.Add(-30*time.Minute), "Core")
+	deep := makeSleepSample(midnight.Add(-30*time.Minute), midnight.Add(6*time.Hour), "Deep")
+	wantPrevious := `sleep_sessions,target_name=test,source=Apple\ Watch inBed=8,asleep=8,awake=0,core=8,deep=0,rem=0,efficiency=1,latency=0,waso=0 1639699200000000000`
+	wantPartial := `sleep_sessions,target_name=test,source=Apple\ Watch inBed=1.5,asleep=1.5,awake=0,core=1.5,deep=0,rem=0,efficiency=1,latency=0,waso=0 1639699200000000000`
+	wantNight := `sleep_sessions,target_name=test,source=Apple\ Watch inBed=8,asleep=8,awake=0,core=1.5,deep=6.5,rem=0,efficiency=1,latency=0,waso=0 1639785600000000000`
+
+	tests := []struct {
+		name     string
+		payloads []*healthautoexport.Payload
+		want     []string
+	}{
+		{
+			name:     "night within a single payload",
+			payloads: []*healthautoexport.Payload{makeSleepPayload(core, deep)},
+			want:     []string{wantNight},
+		},
+		{
+			name:     "night split across daily payloads",
+			payloads: []*healthautoexport.Payload{makeSleepPayload(core), makeSleepPayload(deep)},
+			want:     []string{wantNight},
+		},
+		{
+			name:     "partial night before midnight",
+			payloads: []*healthautoexport.Payload{makeSleepPayload(core)},
+			want:     []string{wantPartial},
+		},
+		{
+			name: "partial night does not replace previous night with the same date",
+			payloads: []*healthautoexport.Payload{
+				makeSleepPayload(previous),
+				makeSleepPayload(core),
+				makeSleepPayload(deep),
+			},
+			want: []string{wantPrevious, wantNight},
+		},
+		{
+			name: "partial export does not replace the whole night",
+			payloads: []*healthautoexport.Payload{
+				makeSleepPayload(core, deep),
+				makeSleepPayload(deep),
+				makeSleepPayload(core),
+			},
+			want: []string{wantNight},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := influxdb.NewMockClient()
+			backend, err := influxdb.NewBackendWithOptions(client, influxdb.Options{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, payload := range tt.payloads {
+				assert.NoError(t, backend.Write(payload, "test"))
+			}
+			var points []*write.Point
+			for _, point := range latestPoints(client.ReadMetrics()) {
+				if point.Name() == influxdb.MeasurementSleepSessions {
+					points = append(points, point)
+				}
+			}
+			assert.Equal(t, tt.want, formatPoints(points))
+		})
+	}
+}
+
+// latestPoints returns the last point written for each series and time, which
+// replaces the earlier points similar to InfluxDB, sorted by time.
+func latestPoints(points []*write.Point) []*write.Point {
+	keys := make([]string, 0, len(points))
+	latest := make(map[string]*write.Point, len(points))
+	for _, point := range points {
+		key := point.Name()
+		for _, tag := range point.TagList() {
+			key += "," + tag.Key + "=" + tag.Value
+		}
+		key += fmt.Sprintf(" %d", point.Time().UnixNano())
+		if _, ok := latest[key]; !ok {
+			keys = append(keys, key)
+		}
+		latest[key] = point
+	}
+	result := make([]*write.Point, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, latest[key])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time().Before(result[j].Time())
+	})
+	return result
+}
+
 type BackendTest struct {
 	backend backends.Backend
 	client  *influxdb.MockClient
 }
 
+func TestBackend_ECGVoltageMeasurements(t *testing.T) {
+	start := healthautoexport.NewTime(time.Unix(1000, 0))
+	second := healthautoexport.NewTime(time.Unix(1001, 0))
+	makePayload := func(samplingFrequency healthautoexport.Qty, dates ...*healthautoexport.Time) *healthautoexport.Payload {
+		ecg := &healthautoexport.ECG{Start: &start, SamplingFrequency: samplingFrequency}
+		for i, date := range dates {
+			ecg.VoltageMeasurements = append(ecg.VoltageMeasurements, &healthautoexport.VoltageMeasurement{
+				Date:    date,
+				Voltage: healthautoexport.Qty(i + 1),
+				Units:   "uV",
+			})
+		}
+		return &healthautoexport.Payload{Data: &healthautoexport.PayloadData{ECG: []*healthautoexport.ECG{ecg}}}
+	}
+
+	tests := []struct {
+		name    string
+		payload *healthautoexport.Payload
+		want    []string
+	}{
+		{
+			name:    "samples sharing a date are offset at the sampling frequency",
+			payload: makePayload(4, &start, &start, &second, &second, &second),
+			want: []string{
+				`ecg,target_name=test count=1i,sampling_frequency=4 1000000000000`,
+				`ecg_voltage_uV,target_name=test qty=1 1000000000000`,
+				`ecg_voltage_uV,target_name=test qty=2 1000250000000`,
+				`ecg_voltage_uV,target_name=test qty=3 1001000000000`,
+				`ecg_voltage_uV,target_name=test qty=4 1001250000000`,
+				`ecg_voltage_uV,target_name=test qty=5 1001500000000`,
+			},
+		},
+		{
+			name:    "samples without a date are offset from the start",
+			payload: makePayload(4, nil, nil, &second),
+			want: []string{
+				`ecg,target_name=test count=1i,sampling_frequency=4 1000000000000`,
+				`ecg_voltage_uV,target_name=test qty=1 1000000000000`,
+				`ecg_voltage_uV,target_name=test qty=2 1000250000000`,
+				`ecg_voltage_uV,target_name=test qty=3 1001000000000`,
+			},
+		},
+		{
+			name:    "samples without a date are skipped without sampling frequency",
+			payload: makePayload(0, nil, &second),
+			want: []string{
+				`ecg,target_name=test count=1i 1000000000000`,
+				`ecg_voltage_uV,target_name=test qty=2 1001000000000`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			test := NewBackendTest(t)
+			test.AssertWriteMetrics(t, tt.payload, tt.want, "test")
+		})
+	}
+}
+
 func NewBackendTest(t *testing.T) *BackendTest {
 	client := influxdb.NewMockClient()
 	backend, err := influxdb.NewBackend(client)
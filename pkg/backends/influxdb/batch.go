@@ -0,0 +1,178 @@
+package influxdb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+)
+
+// BatchOptions configures how points are batched by a BatchWriter.
+type BatchOptions struct {
+	// MaxPoints is the maximum number of points in a single batch.
+	MaxPoints int
+
+	// MaxBytes is the maximum size of a single batch in line protocol, before
+	// compression. A single point that exceeds MaxBytes is written on its own.
+	MaxBytes int
+
+	// Concurrency is the maximum number of batches that are written concurrently.
+	Concurrency int
+}
+
+// BatchWriter accumulates points across multiple metrics and workouts into
+// batches bounded by the number of points and size, and writes each batch
+// asynchronously with bounded concurrency.
+//
+// A BatchWriter should be used for a single payload, and Flush must be called
+// to write any remaining points and wait for all batches to be written.
+type BatchWriter struct {
+	ctx     context.Context
+	opts    BatchOptions
+	metrics *pointBatch
+	workout *pointBatch
+	sem     chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	errs    []error
+	points  int
+	batches int
+}
+
+// pointBatch is a batch of points that are written to the same bucket.
+type pointBatch struct {
+	name   string
+	write  func(ctx context.Context, point ...*write.Point) error
+	points []*write.Point
+	bytes  int
+}
+
+// NewBatchWriter returns a new BatchWriter that writes to client.
+func NewBatchWriter(ctx context.Context, client Client, opts BatchOptions) *BatchWriter {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	return &BatchWriter{
+		ctx:     ctx,
+		opts:    opts,
+		metrics: &pointBatch{name: "metrics", write: client.WriteMetrics},
+		workout: &pointBatch{name: "workouts", write: client.WriteWorkouts},
+		sem:     make(chan struct{}, opts.Concurrency),
+	}
+}
+
+// AddMetrics adds points to be written to the metrics bucket. Returns an error
+// if any previous batch failed to be written, so that callers can stop early.
+func (w *BatchWriter) AddMetrics(point ...*write.Point) error {
+	return w.add(w.metrics, point...)
+}
+
+// AddWorkouts adds points to be written to the workouts bucket. Returns an
+// error if any previous batch failed to be written, so that callers can stop
+// early.
+func (w *BatchWriter) AddWorkouts(point ...*write.Point) error {
+	return w.add(w.workout, point...)
+}
+
+func (w *BatchWriter) add(batch *pointBatch, points ...*write.Point) error {
+	if err := w.err(); err != nil {
+		return err
+	}
+	for _, point := range points {
+		size := len(write.PointToLineProtocol(point, time.Nanosecond))
+		if len(batch.points) > 0 && w.isFull(batch, size) {
+			w.dispatch(batch)
+		}
+		batch.points = append(batch.points, point)
+		batch.bytes += size
+	}
+	return nil
+}
+
+// isFull returns true if adding a point of the given size would exceed the batch bounds.
+func (w *BatchWriter) isFull(batch *pointBatch, size int) bool {
+	if w.opts.MaxPoints > 0 && len(batch.points)+1 > w.opts.MaxPoints {
+		return true
+	}
+	if w.opts.MaxBytes > 0 && batch.bytes+size > w.opts.MaxBytes {
+		return true
+	}
+	return false
+}
+
+// dispatch writes the current points in the batch asynchronously, and resets
+// the batch. Blocks if the maximum number of concurrent writes is reached.
+func (w *BatchWriter) dispatch(batch *pointBatch) {
+	points, bytes := batch.points, batch.bytes
+	batch.points, batch.bytes = nil, 0
+
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+
+		logger := log.WithFields(log.Fields{
+			"bucket": batch.name,
+			"count":  len(points),
+			"bytes":  bytes,
+		})
+		startTime := time.Now()
+		logger.Debug("writing batch")
+		err := batch.write(w.ctx, points...)
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil {
+			w.errs = append(w.errs, errors.Wrapf(err, "write error for batch of %v points to %v", len(points), batch.name))
+			return
+		}
+		w.points += len(points)
+		w.batches++
+		logger.WithField("elapsed", time.Since(startTime)).Debug("write batch success")
+	}()
+}
+
+// Flush writes all remaining points, and waits for all batches to be written.
+//
+// If any batch failed to be written, the first error which is not retryable is
+// returned. Otherwise, if all errors are retryable, the first retryable error is
+// returned, so that the payload can be retried.
+func (w *BatchWriter) Flush() error {
+	for _, batch := range []*pointBatch{w.metrics, w.workout} {
+		if len(batch.points) > 0 {
+			w.dispatch(batch)
+		}
+	}
+	w.wg.Wait()
+	return w.err()
+}
+
+// Stats returns the number of points and batches that were successfully written.
+func (w *BatchWriter) Stats() (points int, batches int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.points, w.batches
+}
+
+func (w *BatchWriter) err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.errs) == 0 {
+		return nil
+	}
+	for _, err := range w.errs {
+		if !apierrors.IsRetryableWrite(err) {
+			return err
+		}
+	}
+	return w.errs[0]
+}
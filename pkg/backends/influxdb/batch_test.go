@@ -0,0 +1,181 @@
+package influxdb_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+)
+
+// batchClient is a Client that records the size of each batch.
+type batchClient struct {
+	metrics  []int
+	workouts []int
+	err      error
+	delay    time.Duration
+
+	mu          sync.Mutex
+	inflight    int
+	maxInflight int
+}
+
+func (c *batchClient) WriteMetrics(_ context.Context, point ...*write.Point) error {
+	return c.write(&c.metrics, point...)
+}
+
+func (c *batchClient) WriteWorkouts(_ context.Context, point ...*write.Point) error {
+	return c.write(&c.workouts, point...)
+}
+
+func (c *batchClient) DeleteMetrics(_ context.Context, _ influxdb.DeletePredicate) error {
+	return nil
+}
+
+func (c *batchClient) write(batches *[]int, point ...*write.Point) error {
+	c.mu.Lock()
+	c.inflight++
+	if c.inflight > c.maxInflight {
+		c.maxInflight = c.inflight
+	}
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	*batches = append(*batches, len(point))
+	return c.err
+}
+
+func makePoints(n int) []*write.Point {
+	points := make([]*write.Point, 0, n)
+	for i := 0; i < n; i++ {
+		point := write.NewPointWithMeasurement("m")
+		point.AddField("qty", float64(i))
+		point.SetTime(time.Unix(int64(1000+i), 0))
+		points = append(points, point)
+	}
+	return points
+}
+
+func TestBatchWriter(t *testing.T) {
+	// All points have the same size in line protocol.
+	pointSize := len(write.PointToLineProtocol(makePoints(1)[0], time.Nanosecond))
+
+	tests := []struct {
+		name         string
+		opts         influxdb.BatchOptions
+		metrics      []int
+		workouts     []int
+		wantMetrics  []int
+		wantWorkouts []int
+	}{
+		{
+			name:         "unbounded batches",
+			metrics:      []int{3, 4},
+			workouts:     []int{2},
+			wantMetrics:  []int{7},
+			wantWorkouts: []int{2},
+		},
+		{
+			name:         "bounded by points across multiple adds",
+			opts:         influxdb.BatchOptions{MaxPoints: 3},
+			metrics:      []int{2, 2, 2, 1},
+			workouts:     []int{4},
+			wantMetrics:  []int{3, 3, 1},
+			wantWorkouts: []int{3, 1},
+		},
+		{
+			name:        "bounded by bytes",
+			opts:        influxdb.BatchOptions{MaxBytes: pointSize*2 + 1},
+			metrics:     []int{5},
+			wantMetrics: []int{2, 2, 1},
+		},
+		{
+			name:        "point larger than max bytes is written on its own",
+			opts:        influxdb.BatchOptions{MaxBytes: 1},
+			metrics:     []int{2},
+			wantMetrics: []int{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := &batchClient{}
+			writer := influxdb.NewBatchWriter(context.Background(), client, tt.opts)
+			for _, n := range tt.metrics {
+				assert.NoError(t, writer.AddMetrics(makePoints(n)...))
+			}
+			for _, n := range tt.workouts {
+				assert.NoError(t, writer.AddWorkouts(makePoints(n)...))
+			}
+			assert.NoError(t, writer.Flush())
+			assert.ElementsMatch(t, tt.wantMetrics, client.metrics)
+			assert.ElementsMatch(t, tt.wantWorkouts, client.workouts)
+
+			points, batches := writer.Stats()
+			assert.Equal(t, sum(tt.wantMetrics)+sum(tt.wantWorkouts), points)
+			assert.Equal(t, len(tt.wantMetrics)+len(tt.wantWorkouts), batches)
+		})
+	}
+}
+
+func TestBatchWriter_Concurrency(t *testing.T) {
+	client := &batchClient{delay: 10 * time.Millisecond}
+	writer := influxdb.NewBatchWriter(context.Background(), client, influxdb.BatchOptions{
+		MaxPoints:   1,
+		Concurrency: 2,
+	})
+	assert.NoError(t, writer.AddMetrics(makePoints(10)...))
+	assert.NoError(t, writer.Flush())
+	assert.Len(t, client.metrics, 10)
+	assert.LessOrEqual(t, client.maxInflight, 2)
+}
+
+func TestBatchWriter_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetryable bool
+	}{
+		{
+			name:          "retryable errors are preserved",
+			err:           apierrors.WrapRetryableWrite(errors.New("connection refused")),
+			wantRetryable: true,
+		},
+		{
+			name: "non-retryable errors are preserved",
+			err:  errors.New("bad request"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := &batchClient{err: tt.err}
+			writer := influxdb.NewBatchWriter(context.Background(), client, influxdb.BatchOptions{MaxPoints: 1})
+			assert.NoError(t, writer.AddMetrics(makePoints(1)...))
+			err := writer.Flush()
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.wantRetryable, apierrors.IsRetryableWrite(err))
+
+			// Subsequent adds should fail early.
+			assert.Error(t, writer.AddMetrics(makePoints(1)...))
+		})
+	}
+}
+
+func sum(values []int) int {
+	var total int
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
@@ -1,19 +1,27 @@
 package influxdb
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"sync"
+	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
-
-	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+	"github.com/pkg/errors"
 )
 
 // Client knows how to write to an InfluxDB database.
 type Client interface {
 	WriteMetrics(ctx context.Context, point ...*write.Point) error
 	WriteWorkouts(ctx context.Context, point ...*write.Point) error
+	DeleteMetrics(ctx context.Context, predicate DeletePredicate) error
 }
 
 // clientImpl is the real implementation of Client.
@@ -28,36 +36,159 @@ var _ Client = (*clientImpl)(nil)
 
 // NewClient returns a real influxdb Client initialized from flags.
 func NewClient() (Client, error) {
+	switch apiVersion {
+	case 1:
+		return newV1ClientFromFlags()
+	case 2:
+	default:
+		return nil, fmt.Errorf("unsupported --influxdb.apiVersion %v", apiVersion)
+	}
+
 	client, err := NewInfluxDBClient()
 	if err != nil {
 		return nil, err
 	}
-	impl := &clientImpl{
+	return NewV2Client(client, orgName, metricsBucketName, workoutsBucketName), nil
+}
+
+// NewV2Client returns a Client that writes using the InfluxDB 2.x API with the
+// given influxdb2.Client.
+func NewV2Client(client influxdb2.Client, orgName, metricsBucketName, workoutsBucketName string) Client {
+	return &clientImpl{
 		client:             client,
 		orgName:            orgName,
 		metricsBucketName:  metricsBucketName,
 		workoutsBucketName: workoutsBucketName,
 	}
-	return impl, nil
 }
 
 func (c *clientImpl) WriteMetrics(ctx context.Context, point ...*write.Point) error {
-	if err := c.client.WriteAPIBlocking(c.orgName, c.metricsBucketName).WritePoint(ctx, point...); err != nil {
-		return apierrors.WrapRetryableWrite(err)
+	return c.write(ctx, c.metricsBucketName, point...)
+}
+
+func (c *clientImpl) WriteWorkouts(ctx context.Context, point ...*write.Point) error {
+	return c.write(ctx, c.workoutsBucketName, point...)
+}
+
+func (c *clientImpl) DeleteMetrics(ctx context.Context, predicate DeletePredicate) error {
+	return c.delete(ctx, c.metricsBucketName, predicate)
+}
+
+// write writes points to the bucket using the HTTP service of the client
+// directly, instead of the blocking write API which does not preserve the
+// status code of errors. This allows client errors to be distinguished from
+// temporary errors.
+func (c *clientImpl) write(ctx context.Context, bucket string, points ...*write.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	options := c.client.Options()
+	precision := options.Precision()
+	body, err := encodePoints(points, precision, options.UseGZip())
+	if err != nil {
+		return err
+	}
+
+	writeURL, err := c.apiURL("write", url.Values{
+		"org":       {c.orgName},
+		"bucket":    {bucket},
+		"precision": {formatV2Precision(precision)},
+	})
+	if err != nil {
+		return err
 	}
+
+	if perr := c.client.HTTPService().DoPostRequest(ctx, writeURL, body, func(req *http.Request) {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if options.UseGZip() {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+	}, nil); perr != nil {
+		return newWriteError(perr.StatusCode, perr.Error(), points, precision)
+	}
+
 	return nil
 }
 
-func (c *clientImpl) WriteWorkouts(ctx context.Context, point ...*write.Point) error {
-	if err := c.client.WriteAPIBlocking(c.orgName, c.workoutsBucketName).WritePoint(ctx, point...); err != nil {
-		return apierrors.WrapRetryableWrite(err)
+// delete deletes points matching the predicate from the bucket. Similar to
+// write, the HTTP service is used directly to preserve the status code of errors.
+func (c *clientImpl) delete(ctx context.Context, bucket string, predicate DeletePredicate) error {
+	body, err := json.Marshal(map[string]string{
+		"start":     predicate.Start.UTC().Format(time.RFC3339Nano),
+		"stop":      predicate.End.UTC().Format(time.RFC3339Nano),
+		"predicate": predicate.formatV2(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot encode delete request")
 	}
+
+	deleteURL, err := c.apiURL("delete", url.Values{"org": {c.orgName}, "bucket": {bucket}})
+	if err != nil {
+		return err
+	}
+	if perr := c.client.HTTPService().DoPostRequest(ctx, deleteURL, bytes.NewReader(body), func(req *http.Request) {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}, nil); perr != nil {
+		return newDeleteError(perr.StatusCode, perr.Error())
+	}
+
 	return nil
 }
 
+// apiURL returns the URL of an endpoint of the InfluxDB 2.x API with the query.
+func (c *clientImpl) apiURL(endpoint string, query url.Values) (string, error) {
+	u, err := url.Parse(c.client.HTTPService().ServerAPIURL())
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid server URL")
+	}
+	u, err = u.Parse(endpoint)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid server URL")
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// encodePoints encodes points in line protocol, optionally compressed with gzip.
+func encodePoints(points []*write.Point, precision time.Duration, useGzip bool) (*bytes.Buffer, error) {
+	var body bytes.Buffer
+	var w io.Writer = &body
+	var gz *gzip.Writer
+	if useGzip {
+		gz = gzip.NewWriter(&body)
+		w = gz
+	}
+	for _, point := range points {
+		if _, err := io.WriteString(w, write.PointToLineProtocol(point, precision)); err != nil {
+			return nil, errors.Wrapf(err, "cannot encode points")
+		}
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, errors.Wrapf(err, "cannot compress points")
+		}
+	}
+	return &body, nil
+}
+
+// formatV2Precision returns the precision query parameter used by the InfluxDB 2.x API.
+func formatV2Precision(p time.Duration) string {
+	switch p {
+	case time.Microsecond:
+		return "us"
+	case time.Millisecond:
+		return "ms"
+	case time.Second:
+		return "s"
+	}
+	return "ns"
+}
+
 // MockClient is a mock implementation of Client.
 type MockClient struct {
 	buckets map[string][]*write.Point
+	deletes map[string][]DeletePredicate
 	mu      sync.RWMutex
 }
 
@@ -66,6 +197,7 @@ var _ Client = (*MockClient)(nil)
 func NewMockClient() *MockClient {
 	return &MockClient{
 		buckets: make(map[string][]*write.Point),
+		deletes: make(map[string][]DeletePredicate),
 	}
 }
 
@@ -87,6 +219,34 @@ func (m *MockClient) writePoints(bucket string, point ...*write.Point) error {
 	return nil
 }
 
+func (m *MockClient) DeleteMetrics(_ context.Context, predicate DeletePredicate) error {
+	return m.deletePoints("metrics", predicate)
+}
+
+func (m *MockClient) deletePoints(bucket string, predicate DeletePredicate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.deletes == nil {
+		m.deletes = make(map[string][]DeletePredicate)
+	}
+	m.deletes[bucket] = append(m.deletes[bucket], predicate)
+	points := make([]*write.Point, 0, len(m.buckets[bucket]))
+	for _, point := range m.buckets[bucket] {
+		if !predicate.Matches(point) {
+			points = append(points, point)
+		}
+	}
+	m.buckets[bucket] = points
+	return nil
+}
+
+// ReadMetricDeletes returns all predicates used to delete from the metrics bucket.
+func (m *MockClient) ReadMetricDeletes() []DeletePredicate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.deletes["metrics"]
+}
+
 func (m *MockClient) ReadMetrics() []*write.Point {
 	return m.readPoints("metrics")
 }
@@ -105,4 +265,5 @@ func (m *MockClient) Reset() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.buckets = nil
+	m.deletes = nil
 }
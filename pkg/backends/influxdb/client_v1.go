@@ -0,0 +1,264 @@
+package influxdb
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxErrorBodySize is the maximum size of the response body included in errors.
+	maxErrorBodySize = 1024
+)
+
+// V1Target is a database and retention policy to write points to using the
+// InfluxDB 1.x API.
+type V1Target struct {
+	Database        string
+	RetentionPolicy string
+}
+
+// V1Options configures a client for the InfluxDB 1.x write API.
+type V1Options struct {
+	// ServerURL is the base URL of the server, e.g. http://localhost:8086.
+	ServerURL string
+
+	// Username and Password are used for basic authentication, if set.
+	Username string
+	Password string
+
+	// Precision of timestamps, which must be one of time.Nanosecond,
+	// time.Microsecond, time.Millisecond or time.Second.
+	Precision time.Duration
+
+	// UseGzip compresses write requests with gzip.
+	UseGzip bool
+
+	// Metrics and Workouts are the targets to write metrics and workouts to.
+	Metrics  V1Target
+	Workouts V1Target
+
+	// HTTPClient is used to make requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// clientV1 is an implementation of Client for the InfluxDB 1.x write API,
+// which is also supported by other databases such as VictoriaMetrics.
+type clientV1 struct {
+	opts     V1Options
+	writeURL *url.URL
+	queryURL *url.URL
+}
+
+var _ Client = (*clientV1)(nil)
+
+// NewV1Client returns a Client that writes using the InfluxDB 1.x API.
+func NewV1Client(opts V1Options) (Client, error) {
+	if opts.ServerURL == "" {
+		return nil, errors.New("server URL is not set")
+	}
+	writeURL, err := url.Parse(strings.TrimSuffix(opts.ServerURL, "/") + "/write")
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid server URL")
+	}
+	queryURL, err := url.Parse(strings.TrimSuffix(opts.ServerURL, "/") + "/query")
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid server URL")
+	}
+	if opts.Precision == 0 {
+		opts.Precision = time.Nanosecond
+	}
+	if _, err := formatV1Precision(opts.Precision); err != nil {
+		return nil, err
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	return &clientV1{opts: opts, writeURL: writeURL, queryURL: queryURL}, nil
+}
+
+func newV1ClientFromFlags() (Client, error) {
+	if serverURL == "" {
+		return nil, errors.New("--influxdb.serverURL is not set")
+	}
+	if metricsDatabase == "" {
+		return nil, errors.New("--influxdb.metricsDatabase is not set")
+	}
+	if workoutsDatabase == "" {
+		return nil, errors.New("--influxdb.workoutsDatabase is not set")
+	}
+	p, err := parsePrecision(precision)
+	if err != nil {
+		return nil, err
+	}
+	return NewV1Client(V1Options{
+		ServerURL: serverURL,
+		Username:  username,
+		Password:  password,
+		Precision: p,
+		UseGzip:   useGzip,
+		Metrics: V1Target{
+			Database:        metricsDatabase,
+			RetentionPolicy: metricsRetentionPolicy,
+		},
+		Workouts: V1Target{
+			Database:        workoutsDatabase,
+			RetentionPolicy: workoutsRetentionPolicy,
+		},
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: insecureSkipVerify, // nolint:gosec
+				},
+			},
+			Timeout: 20 * time.Second,
+		},
+	})
+}
+
+func (c *clientV1) WriteMetrics(ctx context.Context, point ...*write.Point) error {
+	return c.write(ctx, c.opts.Metrics, point...)
+}
+
+func (c *clientV1) WriteWorkouts(ctx context.Context, point ...*write.Point) error {
+	return c.write(ctx, c.opts.Workouts, point...)
+}
+
+func (c *clientV1) write(ctx context.Context, target V1Target, points ...*write.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	// Encode points in line protocol.
+	body, err := encodePoints(points, c.opts.Precision, c.opts.UseGzip)
+	if err != nil {
+		return err
+	}
+
+	// Prepare request.
+	p, _ := formatV1Precision(c.opts.Precision)
+	query := url.Values{}
+	query.Set("db", target.Database)
+	if target.RetentionPolicy != "" {
+		query.Set("rp", target.RetentionPolicy)
+	}
+	query.Set("precision", p)
+	u := *c.writeURL
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create request")
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.opts.UseGzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.opts.Username != "" || c.opts.Password != "" {
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+
+	// Send request.
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return newWriteError(0, err.Error(), points, c.opts.Precision)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return newWriteError(resp.StatusCode, string(message), points, c.opts.Precision)
+	}
+
+	return nil
+}
+
+func (c *clientV1) DeleteMetrics(ctx context.Context, predicate DeletePredicate) error {
+	return c.delete(ctx, c.opts.Metrics, predicate)
+}
+
+// delete deletes points matching the predicate using an InfluxQL DELETE
+// statement. Note that DELETE applies to all retention policies of the database.
+func (c *clientV1) delete(ctx context.Context, target V1Target, predicate DeletePredicate) error {
+	form := url.Values{}
+	form.Set("db", target.Database)
+	form.Set("q", predicate.formatInfluxQL())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.queryURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrapf(err, "cannot create request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.opts.Username != "" || c.opts.Password != "" {
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return newDeleteError(0, err.Error())
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if resp.StatusCode/100 != 2 {
+		return newDeleteError(resp.StatusCode, string(message))
+	}
+
+	// Errors in statements are returned in the results with a 200 status code.
+	var result struct {
+		Results []struct {
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(message, &result); err == nil {
+		for _, r := range result.Results {
+			if r.Error != "" {
+				return fmt.Errorf("delete statement failed: %v", r.Error)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parsePrecision parses a precision flag value.
+func parsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "ns", "":
+		return time.Nanosecond, nil
+	case "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	}
+	return 0, fmt.Errorf("invalid precision %q, must be one of ns, us, ms, s", s)
+}
+
+// formatV1Precision returns the precision query parameter used by the InfluxDB 1.x API.
+func formatV1Precision(p time.Duration) (string, error) {
+	switch p {
+	case time.Nanosecond:
+		return "ns", nil
+	case time.Microsecond:
+		return "u", nil
+	case time.Millisecond:
+		return "ms", nil
+	case time.Second:
+		return "s", nil
+	}
+	return "", fmt.Errorf("unsupported precision %v", p)
+}
@@ -0,0 +1,130 @@
+package influxdb_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+func TestV1Client(t *testing.T) {
+	point := write.NewPointWithMeasurement("active_energy_kJ")
+	point.AddTag("target_name", "test")
+	point.AddField("qty", 0.5)
+	point.SetTime(time.Unix(1640275440, 123456789))
+
+	tests := []struct {
+		name      string
+		opts      influxdb.V1Options
+		workouts  bool
+		status    int
+		wantQuery url.Values
+		wantBody  string
+		wantAuth  bool
+		wantError assert.ErrorAssertionFunc
+		wantRetry bool
+	}{
+		{
+			name: "write metrics",
+			opts: influxdb.V1Options{
+				Metrics:  influxdb.V1Target{Database: "health", RetentionPolicy: "autogen"},
+				Workouts: influxdb.V1Target{Database: "workouts"},
+			},
+			wantQuery: url.Values{"db": {"health"}, "rp": {"autogen"}, "precision": {"ns"}},
+			wantBody:  "active_energy_kJ,target_name=test qty=0.5 1640275440123456789\n",
+		},
+		{
+			name: "write workouts with precision and basic auth",
+			opts: influxdb.V1Options{
+				Username:  "user",
+				Password:  "pass",
+				Precision: time.Second,
+				Metrics:   influxdb.V1Target{Database: "health"},
+				Workouts:  influxdb.V1Target{Database: "workouts"},
+			},
+			workouts:  true,
+			wantQuery: url.Values{"db": {"workouts"}, "precision": {"s"}},
+			wantBody:  "active_energy_kJ,target_name=test qty=0.5 1640275440\n",
+			wantAuth:  true,
+		},
+		{
+			name: "write with gzip",
+			opts: influxdb.V1Options{
+				Precision: time.Microsecond,
+				UseGzip:   true,
+				Metrics:   influxdb.V1Target{Database: "health"},
+			},
+			wantQuery: url.Values{"db": {"health"}, "precision": {"u"}},
+			wantBody:  "active_energy_kJ,target_name=test qty=0.5 1640275440123456\n",
+		},
+		{
+			name: "server error is retryable",
+			opts: influxdb.V1Options{
+				Metrics: influxdb.V1Target{Database: "health"},
+			},
+			status:    http.StatusServiceUnavailable,
+			wantQuery: url.Values{"db": {"health"}, "precision": {"ns"}},
+			wantBody:  "active_energy_kJ,target_name=test qty=0.5 1640275440123456789\n",
+			wantError: testutils.AssertErrorContains("503 Service Unavailable: database is down"),
+			wantRetry: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/write", r.URL.Path)
+				assert.Equal(t, tt.wantQuery, r.URL.Query())
+				user, pass, ok := r.BasicAuth()
+				assert.Equal(t, tt.wantAuth, ok)
+				if tt.wantAuth {
+					assert.Equal(t, tt.opts.Username, user)
+					assert.Equal(t, tt.opts.Password, pass)
+				}
+				var body io.Reader = r.Body
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					gz, err := gzip.NewReader(r.Body)
+					if !assert.NoError(t, err) {
+						return
+					}
+					body = gz
+				}
+				data, err := io.ReadAll(body)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantBody, string(data))
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					_, _ = w.Write([]byte("database is down"))
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			tt.opts.ServerURL = server.URL
+			client, err := influxdb.NewV1Client(tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if tt.workouts {
+				err = client.WriteWorkouts(context.Background(), point)
+			} else {
+				err = client.WriteMetrics(context.Background(), point)
+			}
+			if testutils.WantError(t, tt.wantError, err) {
+				assert.Equal(t, tt.wantRetry, apierrors.IsRetryableWrite(err))
+			}
+		})
+	}
+}
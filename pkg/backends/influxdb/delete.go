@@ -0,0 +1,106 @@
+package influxdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+	utiltime "github.com/irvinlim/apple-health-ingester/pkg/util/time"
+)
+
+// DeletePredicate selects points to be deleted from a measurement, which have
+// all of the given tags and a timestamp between Start and End inclusive.
+type DeletePredicate struct {
+	Measurement string
+	Tags        []lp.Tag
+	Start       time.Time
+	End         time.Time
+}
+
+// Matches returns true if the point is selected by the predicate.
+func (p DeletePredicate) Matches(point *write.Point) bool {
+	if point.Name() != p.Measurement || point.Time().Before(p.Start) || point.Time().After(p.End) {
+		return false
+	}
+	for _, tag := range p.Tags {
+		if !hasTag(point, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatV2 returns the predicate in the delete predicate syntax of the
+// InfluxDB 2.x API, excluding the time range.
+func (p DeletePredicate) formatV2() string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	conditions := []string{fmt.Sprintf(`_measurement="%v"`, escape.Replace(p.Measurement))}
+	for _, tag := range p.Tags {
+		conditions = append(conditions, fmt.Sprintf(`"%v"="%v"`, escape.Replace(tag.Key), escape.Replace(tag.Value)))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// formatInfluxQL returns the predicate as an InfluxQL DELETE statement, which
+// is used by the InfluxDB 1.x API.
+func (p DeletePredicate) formatInfluxQL() string {
+	identifier := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	literal := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	conditions := make([]string, 0, len(p.Tags)+2)
+	for _, tag := range p.Tags {
+		conditions = append(conditions, fmt.Sprintf(`"%v" = '%v'`, identifier.Replace(tag.Key), literal.Replace(tag.Value)))
+	}
+	conditions = append(conditions,
+		fmt.Sprintf(`time >= %d`, p.Start.UnixNano()),
+		fmt.Sprintf(`time <= %d`, p.End.UnixNano()),
+	)
+	return fmt.Sprintf(`DELETE FROM "%v" WHERE %v`, identifier.Replace(p.Measurement), strings.Join(conditions, " AND "))
+}
+
+// newDeleteError returns an error for a failed delete request. Similar to
+// writes, errors which may succeed after some time are retryable, so that the
+// payload is retried before any points are written.
+func newDeleteError(statusCode int, message string) error {
+	message = strings.TrimSpace(message)
+	if statusCode == 0 {
+		return apierrors.WrapRetryableWrite(fmt.Errorf("delete request error: %v", message))
+	}
+	err := fmt.Errorf("delete request failed with status %v: %v", formatStatus(statusCode), message)
+	if isRetryableStatus(statusCode) {
+		return apierrors.WrapRetryableWrite(err)
+	}
+	return err
+}
+
+// measurementRanges returns the time range of the points in each measurement,
+// sorted by measurement name.
+func measurementRanges(points []*write.Point) ([]string, map[string]timeseriesInfo) {
+	ranges := make(map[string]timeseriesInfo)
+	for _, point := range points {
+		info := ranges[point.Name()]
+		info.StartTime = utiltime.MinTimeNonZero(info.StartTime, point.Time())
+		info.EndTime = utiltime.MaxTime(info.EndTime, point.Time())
+		info.Count++
+		ranges[point.Name()] = info
+	}
+	measurements := make([]string, 0, len(ranges))
+	for measurement := range ranges {
+		measurements = append(measurements, measurement)
+	}
+	sort.Strings(measurements)
+	return measurements, ranges
+}
+
+func hasTag(point *write.Point, tag lp.Tag) bool {
+	for _, t := range point.TagList() {
+		if t.Key == tag.Key && t.Value == tag.Value {
+			return true
+		}
+	}
+	return false
+}
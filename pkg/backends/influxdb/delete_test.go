@@ -0,0 +1,347 @@
+package influxdb_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+func TestBackend_ReplaceOnReexport(t *testing.T) {
+	tests := []struct {
+		name        string
+		replace     bool
+		wantMetrics []string
+		wantDeletes []influxdb.DeletePredicate
+	}{
+		{
+			name: "keep existing points by default",
+			wantMetrics: []string{
+				`step_count_count,target_name=test qty=1 500000000000`,
+				`step_count_count,target_name=other qty=30 1001000000000`,
+				`step_count_count,target_name=test qty=10 1000000000000`,
+				`step_count_count,target_name=test qty=20 1002000000000`,
+				`step_count_count,target_name=test qty=15 1000000000000`,
+				`step_count_count,target_name=test qty=15 1001000000000`,
+				`step_count_count,target_name=test qty=5 1003000000000`,
+			},
+		},
+		{
+			name:    "replace points in time range of target",
+			replace: true,
+			wantMetrics: []string{
+				`step_count_count,target_name=test qty=1 500000000000`,
+				`step_count_count,target_name=other qty=30 1001000000000`,
+				`step_count_count,target_name=test qty=15 1000000000000`,
+				`step_count_count,target_name=test qty=15 1001000000000`,
+				`step_count_count,target_name=test qty=5 1003000000000`,
+			},
+			wantDeletes: []influxdb.DeletePredicate{
+				{
+					Measurement: "step_count_count",
+					Tags:        []lp.Tag{{Key: "target_name", Value: "test"}},
+					Start:       time.Unix(500, 0),
+					End:         time.Unix(500, 0),
+				},
+				{
+					Measurement: "step_count_count",
+					Tags:        []lp.Tag{{Key: "target_name", Value: "other"}},
+					Start:       time.Unix(1001, 0),
+					End:         time.Unix(1001, 0),
+				},
+				{
+					Measurement: "step_count_count",
+					Tags:        []lp.Tag{{Key: "target_name", Value: "test"}},
+					Start:       time.Unix(1000, 0),
+					End:         time.Unix(1002, 0),
+				},
+				{
+					Measurement: "step_count_count",
+					Tags:        []lp.Tag{{Key: "target_name", Value: "test"}},
+					Start:       time.Unix(1000, 0),
+					End:         time.Unix(1003, 0),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := influxdb.NewMockClient()
+			backend, err := influxdb.NewBackendWithOptions(client, influxdb.Options{ReplaceOnReexport: tt.replace})
+			assert.NoError(t, err)
+
+			// Previous day, which is not exported again.
+			assert.NoError(t, backend.Write(makeMetricPayload("step_count", "count", map[int64]float64{500: 1}), "test"))
+			// Points of another target are not deleted.
+			assert.NoError(t, backend.Write(makeMetricPayload("step_count", "count", map[int64]float64{1001: 30}), "other"))
+			// First export of the day.
+			assert.NoError(t, backend.Write(makeMetricPayload("step_count", "count", map[int64]float64{1000: 10, 1002: 20}), "test"))
+			// Re-export of the day with different timestamps.
+			assert.NoError(t, backend.Write(makeMetricPayload("step_count", "count", map[int64]float64{1000: 15, 1001: 15, 1003: 5}), "test"))
+
+			assert.Equal(t, tt.wantMetrics, formatPoints(client.ReadMetrics()))
+			assert.Equal(t, tt.wantDeletes, client.ReadMetricDeletes())
+		})
+	}
+}
+
+func TestBackend_ReplaceOnReexportUntargeted(t *testing.T) {
+	client := influxdb.NewMockClient()
+	backend, err := influxdb.NewBackendWithOptions(client, influxdb.Options{
+		StaticTags:        []string{"env=prod"},
+		ReplaceOnReexport: true,
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, backend.Write(makeMetricPayload("step_count", "count", map[int64]float64{1000: 10, 1002: 20}), ""))
+	assert.NoError(t, backend.Write(makeMetricPayload("step_count", "count", map[int64]float64{1000: 15, 1001: 15}), ""))
+
+	// Empty tags are not written, so they are not included in the predicate.
+	assert.Equal(t, []string{
+		`step_count_count,env=prod qty=20 1002000000000`,
+		`step_count_count,env=prod qty=15 1000000000000`,
+		`step_count_count,env=prod qty=15 1001000000000`,
+	}, formatPoints(client.ReadMetrics()))
+	assert.Equal(t, []influxdb.DeletePredicate{
+		{
+			Measurement: "step_count_count",
+			Tags:        []lp.Tag{{Key: "env", Value: "prod"}},
+			Start:       time.Unix(1000, 0),
+			End:         time.Unix(1002, 0),
+		},
+		{
+			Measurement: "step_count_count",
+			Tags:        []lp.Tag{{Key: "env", Value: "prod"}},
+			Start:       time.Unix(1000, 0),
+			End:         time.Unix(1001, 0),
+		},
+	}, client.ReadMetricDeletes())
+}
+
+// rejectingClient is a MockClient which rejects writes of metrics.
+type rejectingClient struct {
+	*influxdb.MockClient
+
+	// rejectAll rejects all writes.
+	rejectAll bool
+	// rejectAfterDelete rejects the first write after a delete.
+	rejectAfterDelete bool
+
+	deleted bool
+}
+
+func (c *rejectingClient) WriteMetrics(ctx context.Context, point ...*write.Point) error {
+	if c.rejectAll || (c.rejectAfterDelete && c.deleted) {
+		c.rejectAfterDelete = false
+		return &influxdb.WriteError{StatusCode: http.StatusBadRequest, Message: "partial write"}
+	}
+	return c.MockClient.WriteMetrics(ctx, point...)
+}
+
+func (c *rejectingClient) DeleteMetrics(ctx context.Context, predicate influxdb.DeletePredicate) error {
+	c.deleted = true
+	return c.MockClient.DeleteMetrics(ctx, predicate)
+}
+
+func TestBackend_ReplaceOnReexportRejected(t *testing.T) {
+	existing := []string{
+		`step_count_count,target_name=test qty=10 1000000000000`,
+		`step_count_count,target_name=test qty=20 1002000000000`,
+	}
+	tests := []struct {
+		name             string
+		client           *rejectingClient
+		wantRetryable    bool
+		wantMetrics      []string
+		wantRetryMetrics []string
+	}{
+		{
+			name:          "rejected write after delete is retryable",
+			client:        &rejectingClient{rejectAll: true},
+			wantRetryable: true,
+			wantMetrics: []string{
+				`step_count_count,target_name=test qty=20 1002000000000`,
+			},
+		},
+		{
+			name:          "write after delete is retryable",
+			client:        &rejectingClient{rejectAfterDelete: true},
+			wantRetryable: true,
+			wantMetrics: []string{
+				`step_count_count,target_name=test qty=20 1002000000000`,
+			},
+			wantRetryMetrics: []string{
+				`step_count_count,target_name=test qty=20 1002000000000`,
+				`step_count_count,target_name=test qty=15 1000000000000`,
+				`step_count_count,target_name=test qty=15 1001000000000`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mock := influxdb.NewMockClient()
+			backend, err := influxdb.NewBackendWithOptions(mock, influxdb.Options{ReplaceOnReexport: true})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, backend.Write(makeMetricPayload("step_count", "count", map[int64]float64{1000: 10, 1002: 20}), "test"))
+			assert.Equal(t, existing, formatPoints(mock.ReadMetrics()))
+
+			client := tt.client
+			client.MockClient = mock
+			backend, err = influxdb.NewBackendWithOptions(client, influxdb.Options{ReplaceOnReexport: true})
+			if !assert.NoError(t, err) {
+				return
+			}
+			payload := makeMetricPayload("step_count", "count", map[int64]float64{1000: 15, 1001: 15})
+			err = backend.Write(payload, "test")
+			if assert.Error(t, err) {
+				assert.Equal(t, tt.wantRetryable, apierrors.IsRetryableWrite(err))
+			}
+			assert.Equal(t, tt.wantMetrics, formatPoints(mock.ReadMetrics()))
+
+			// Retrying the payload writes the new points again.
+			if tt.wantRetryMetrics != nil {
+				assert.NoError(t, backend.Write(payload, "test"))
+				assert.Equal(t, tt.wantRetryMetrics, formatPoints(mock.ReadMetrics()))
+			}
+		})
+	}
+}
+
+func TestClient_DeleteMetrics(t *testing.T) {
+	predicate := influxdb.DeletePredicate{
+		Measurement: "step_count_count",
+		Tags: []lp.Tag{
+			{Key: "target_name", Value: `it's "me"`},
+			{Key: "env", Value: "prod"},
+		},
+		Start: time.Unix(1000, 0),
+		End:   time.Unix(1002, 500),
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		newClient func(serverURL string) (influxdb.Client, error)
+		wantBody  func(t *testing.T, r *http.Request)
+	}{
+		{
+			name: "v1",
+			path: "/query",
+			newClient: func(serverURL string) (influxdb.Client, error) {
+				return influxdb.NewV1Client(influxdb.V1Options{
+					ServerURL: serverURL,
+					Metrics:   influxdb.V1Target{Database: "health"},
+				})
+			},
+			wantBody: func(t *testing.T, r *http.Request) {
+				assert.NoError(t, r.ParseForm())
+				assert.Equal(t, url.Values{
+					"db": {"health"},
+					"q": {`DELETE FROM "step_count_count" WHERE "target_name" = 'it\'s "me"' AND "env" = 'prod' ` +
+						`AND time >= 1000000000000 AND time <= 1002000000500`},
+				}, r.PostForm)
+			},
+		},
+		{
+			name: "v2",
+			path: "/api/v2/delete",
+			newClient: func(serverURL string) (influxdb.Client, error) {
+				client := influxdb2.NewClient(serverURL, "token")
+				return influxdb.NewV2Client(client, "org", "metrics", "workouts"), nil
+			},
+			wantBody: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, url.Values{"org": {"org"}, "bucket": {"metrics"}}, r.URL.Query())
+				var body map[string]string
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+				assert.Equal(t, map[string]string{
+					"start":     "1970-01-01T00:16:40Z",
+					"stop":      "1970-01-01T00:16:42.0000005Z",
+					"predicate": `_measurement="step_count_count" AND "target_name"="it's \"me\"" AND "env"="prod"`,
+				}, body)
+			},
+		},
+	}
+	statuses := []struct {
+		name      string
+		status    int
+		response  string
+		wantError assert.ErrorAssertionFunc
+		wantRetry bool
+	}{
+		{
+			name:   "success",
+			status: http.StatusNoContent,
+		},
+		{
+			name:      "server error is retryable",
+			status:    http.StatusServiceUnavailable,
+			response:  "database is down",
+			wantError: testutils.AssertErrorContains("503 Service Unavailable"),
+			wantRetry: true,
+		},
+		{
+			name:      "bad request is not retryable",
+			status:    http.StatusBadRequest,
+			response:  "invalid predicate",
+			wantError: testutils.AssertErrorContains("400 Bad Request"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		for _, st := range statuses {
+			st := st
+			t.Run(tt.name+"/"+st.name, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, tt.path, r.URL.Path)
+					tt.wantBody(t, r)
+					w.WriteHeader(st.status)
+					_, _ = w.Write([]byte(st.response))
+				}))
+				defer server.Close()
+
+				client, err := tt.newClient(server.URL)
+				if !assert.NoError(t, err) {
+					return
+				}
+				err = client.DeleteMetrics(context.Background(), predicate)
+				if testutils.WantError(t, st.wantError, err) {
+					assert.Equal(t, st.wantRetry, apierrors.IsRetryableWrite(err))
+				}
+			})
+		}
+	}
+}
+
+func TestV1Client_DeleteStatementError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"results":[{"statement_id":0,"error":"database not found: health"}]}`))
+	}))
+	defer server.Close()
+
+	client, err := influxdb.NewV1Client(influxdb.V1Options{
+		ServerURL: server.URL,
+		Metrics:   influxdb.V1Target{Database: "health"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = client.DeleteMetrics(context.Background(), influxdb.DeletePredicate{Measurement: "m"})
+	testutils.AssertErrorContains("database not found: health")(t, err)
+	assert.False(t, apierrors.IsRetryableWrite(err))
+}
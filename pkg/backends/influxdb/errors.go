@@ -0,0 +1,140 @@
+package influxdb
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	log "github.com/sirupsen/logrus"
+
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+)
+
+const (
+	// maxReportedPoints is the maximum number of offending points included in
+	// the message of a WriteError.
+	maxReportedPoints = 5
+)
+
+var (
+	// fieldConflictRegexp matches field type conflicts in error messages, e.g.
+	// `field type conflict: input field "qty" on measurement "m" is type integer, already exists as type float`.
+	fieldConflictRegexp = regexp.MustCompile(`input field "((?:[^"\\]|\\.)*)" on measurement "((?:[^"\\]|\\.)*)"`)
+
+	// unableToParseRegexp matches lines which could not be parsed in error messages.
+	unableToParseRegexp = regexp.MustCompile(`unable to parse '((?:[^'\\]|\\.)*)'`)
+)
+
+// WriteError is returned when InfluxDB rejects a write due to the points being
+// written, such as a field type conflict or a partial write. Such errors are
+// not retryable, since the same points will be rejected again.
+type WriteError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Message is the error message returned by InfluxDB.
+	Message string
+	// Points contains the offending points in line protocol, if they could be
+	// determined from the error message.
+	Points []string
+}
+
+func (e *WriteError) Error() string {
+	msg := fmt.Sprintf("write rejected with status %v: %v", formatStatus(e.StatusCode), e.Message)
+	if len(e.Points) == 0 {
+		return msg
+	}
+	points := e.Points
+	if len(points) > maxReportedPoints {
+		points = points[:maxReportedPoints]
+	}
+	msg += fmt.Sprintf(" (%v offending points: %v", len(e.Points), strings.Join(points, "; "))
+	if len(e.Points) > len(points) {
+		msg += "; ..."
+	}
+	return msg + ")"
+}
+
+// newWriteError returns an error for a failed write to InfluxDB. Errors without
+// a status code (e.g. network errors), server errors and errors which may
+// succeed after some time or a configuration change are retryable. Other client
+// errors are caused by the points themselves, and are returned as a
+// non-retryable WriteError.
+func newWriteError(statusCode int, message string, points []*write.Point, precision time.Duration) error {
+	message = strings.TrimSpace(message)
+	if isRetryableStatus(statusCode) {
+		if statusCode == 0 {
+			return apierrors.WrapRetryableWrite(fmt.Errorf("write request error: %v", message))
+		}
+		return apierrors.WrapRetryableWrite(fmt.Errorf("write request failed with status %v: %v", formatStatus(statusCode), message))
+	}
+
+	err := &WriteError{
+		StatusCode: statusCode,
+		Message:    message,
+		Points:     findOffendingPoints(message, points, precision),
+	}
+	for _, point := range err.Points {
+		log.WithFields(log.Fields{
+			"status": statusCode,
+			"point":  point,
+		}).Warn("point rejected by InfluxDB")
+	}
+	return err
+}
+
+func formatStatus(statusCode int) string {
+	return fmt.Sprintf("%v %v", statusCode, http.StatusText(statusCode))
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case 0,
+		http.StatusUnauthorized,
+		http.StatusForbidden,
+		http.StatusNotFound,
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests:
+		return true
+	}
+	return statusCode < 400 || statusCode >= 500
+}
+
+// findOffendingPoints returns the points in line protocol which are referenced
+// by the error message.
+func findOffendingPoints(message string, points []*write.Point, precision time.Duration) []string {
+	var offending []string
+
+	// Points with conflicting field types.
+	for _, match := range fieldConflictRegexp.FindAllStringSubmatch(message, -1) {
+		field, measurement := unescapeQuoted(match[1]), unescapeQuoted(match[2])
+		for _, point := range points {
+			if point.Name() != measurement || !hasField(point, field) {
+				continue
+			}
+			offending = append(offending, strings.TrimSuffix(write.PointToLineProtocol(point, precision), "\n"))
+		}
+	}
+
+	// Lines which could not be parsed are already in line protocol.
+	for _, match := range unableToParseRegexp.FindAllStringSubmatch(message, -1) {
+		offending = append(offending, match[1])
+	}
+
+	return offending
+}
+
+func hasField(point *write.Point, key string) bool {
+	for _, field := range point.FieldList() {
+		if field.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func unescapeQuoted(s string) string {
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,140 @@
+package influxdb_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+func TestClient_WriteErrors(t *testing.T) {
+	point1 := write.NewPointWithMeasurement("heart_rate_count/min")
+	point1.AddTag("target_name", "test")
+	point1.AddField("Avg", 60.0)
+	point1.SetTime(time.Unix(1000, 0))
+	point2 := write.NewPointWithMeasurement("heart_rate_count/min")
+	point2.AddTag("target_name", "test")
+	point2.AddField("Avg", int64(61))
+	point2.SetTime(time.Unix(1001, 0))
+	point3 := write.NewPointWithMeasurement("step_count_count")
+	point3.AddTag("target_name", "test")
+	point3.AddField("qty", 10.0)
+	point3.SetTime(time.Unix(1002, 0))
+	points := []*write.Point{point1, point2, point3}
+
+	clients := []struct {
+		name      string
+		path      string
+		newClient func(serverURL string) (influxdb.Client, error)
+	}{
+		{
+			name: "v1",
+			path: "/write",
+			newClient: func(serverURL string) (influxdb.Client, error) {
+				return influxdb.NewV1Client(influxdb.V1Options{
+					ServerURL: serverURL,
+					Metrics:   influxdb.V1Target{Database: "health"},
+				})
+			},
+		},
+		{
+			name: "v2",
+			path: "/api/v2/write",
+			newClient: func(serverURL string) (influxdb.Client, error) {
+				client := influxdb2.NewClient(serverURL, "token")
+				return influxdb.NewV2Client(client, "org", "metrics", "workouts"), nil
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantError  assert.ErrorAssertionFunc
+		wantRetry  bool
+		wantPoints []string
+	}{
+		{
+			name:   "field type conflict",
+			status: http.StatusBadRequest,
+			body: `partial write: field type conflict: input field "Avg" on measurement "heart_rate_count/min" ` +
+				`is type integer, already exists as type float dropped=2`,
+			wantError: testutils.AssertErrorContains("2 offending points"),
+			wantPoints: []string{
+				"heart_rate_count/min,target_name=test Avg=60 1000000000000",
+				"heart_rate_count/min,target_name=test Avg=61i 1001000000000",
+			},
+		},
+		{
+			name:       "unable to parse",
+			status:     http.StatusUnprocessableEntity,
+			body:       `unable to parse 'bad line': missing fields`,
+			wantError:  testutils.AssertErrorContains("422 Unprocessable Entity"),
+			wantPoints: []string{"bad line"},
+		},
+		{
+			name:      "server error is retryable",
+			status:    http.StatusInternalServerError,
+			body:      "internal error",
+			wantError: testutils.AssertErrorContains("500 Internal Server Error"),
+			wantRetry: true,
+		},
+		{
+			name:      "unauthorized is retryable",
+			status:    http.StatusUnauthorized,
+			body:      "unauthorized access",
+			wantError: testutils.AssertErrorContains("401 Unauthorized"),
+			wantRetry: true,
+		},
+		{
+			name:      "rate limit is retryable",
+			status:    http.StatusTooManyRequests,
+			wantError: testutils.AssertErrorContains("429 Too Many Requests"),
+			wantRetry: true,
+		},
+	}
+	for _, client := range clients {
+		client := client
+		for _, tt := range tests {
+			tt := tt
+			t.Run(client.name+"/"+tt.name, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, client.path, r.URL.Path)
+					w.WriteHeader(tt.status)
+					_, _ = w.Write([]byte(tt.body))
+				}))
+				defer server.Close()
+
+				c, err := client.newClient(server.URL)
+				if !assert.NoError(t, err) {
+					return
+				}
+				err = c.WriteMetrics(context.Background(), points...)
+				if !testutils.WantError(t, tt.wantError, err) {
+					return
+				}
+				assert.Equal(t, tt.wantRetry, apierrors.IsRetryableWrite(err))
+
+				var writeErr *influxdb.WriteError
+				if tt.wantRetry {
+					assert.False(t, errors.As(err, &writeErr))
+					return
+				}
+				if assert.True(t, errors.As(err, &writeErr)) {
+					assert.Equal(t, tt.status, writeErr.StatusCode)
+					assert.Equal(t, tt.wantPoints, writeErr.Points)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package influxdb
+
+import (
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	MeasurementSymptoms               = "symptoms"
+	MeasurementMedications            = "medications"
+	MeasurementECG                    = "ecg"
+	MeasurementECGVoltage             = "ecg_voltage"
+	MeasurementHeartRateNotifications = "heart_rate_notifications"
+	MeasurementStateOfMind            = "state_of_mind"
+	MeasurementCycleTracking          = "cycle_tracking"
+)
+
+// writeHealthEvents writes all non-metric and non-workout health data (e.g.
+// symptoms, medications, ECG recordings) into the metrics bucket.
+func (b *Backend) writeHealthEvents(writer *BatchWriter, data *healthautoexport.PayloadData, targetName string) error {
+	tags := []lp.Tag{
+		{Key: "target_name", Value: targetName},
+	}
+	tags = append(tags, b.staticTags...)
+	naming := b.naming[DataTypeEvents]
+
+	// Events without a start time are skipped as they are probably invalid.
+	points := make([]*write.Point, 0)
+	for _, symptom := range data.Symptoms {
+		if symptom.Start.IsZero() {
+			continue
+		}
+		points = append(points, makeEventPoint(naming, MeasurementSymptoms, symptom.Start, symptom.End, tags,
+			lp.Tag{Key: "name", Value: symptom.Name},
+			lp.Tag{Key: "severity", Value: symptom.Severity},
+			lp.Tag{Key: "source", Value: symptom.Source},
+		))
+	}
+	for _, medication := range data.Medications {
+		if medication.Start.IsZero() {
+			continue
+		}
+		point := makeEventPoint(naming, MeasurementMedications, medication.Start, medication.End, tags,
+			lp.Tag{Key: "name", Value: medication.DisplayText},
+			lp.Tag{Key: "form", Value: medication.Form},
+			lp.Tag{Key: "status", Value: medication.Status},
+			lp.Tag{Key: "source", Value: medication.Source},
+		)
+		point.AddField(naming.Field("dosage", ""), float64(medication.Dosage))
+		points = append(points, point)
+	}
+	for _, ecg := range data.ECG {
+		if ecg.Start.IsZero() {
+			continue
+		}
+		points = append(points, b.createECGPoints(naming, ecg, tags)...)
+	}
+	for _, notification := range data.HeartRateNotifications {
+		if notification.Start.IsZero() {
+			continue
+		}
+		points = append(points, b.createHeartRateNotificationPoints(naming, notification, tags)...)
+	}
+	for _, stateOfMind := range data.StateOfMind {
+		if stateOfMind.Start.IsZero() {
+			continue
+		}
+		point := makeEventPoint(naming, MeasurementStateOfMind, stateOfMind.Start, stateOfMind.End, tags,
+			lp.Tag{Key: "kind", Value: stateOfMind.Kind},
+			lp.Tag{Key: "valence_classification", Value: stateOfMind.ValenceClassification},
+			lp.Tag{Key: "labels", Value: strings.Join(stateOfMind.Labels, "|")},
+			lp.Tag{Key: "associations", Value: strings.Join(stateOfMind.Associations, "|")},
+		)
+		point.AddField(naming.Field("valence", ""), float64(stateOfMind.Valence))
+		points = append(points, point)
+	}
+	for _, cycle := range data.CycleTracking {
+		if cycle.Start.IsZero() {
+			continue
+		}
+		point := makeEventPoint(naming, MeasurementCycleTracking, cycle.Start, cycle.End, tags,
+			lp.Tag{Key: "name", Value: cycle.Name},
+			lp.Tag{Key: "value", Value: cycle.Value},
+			lp.Tag{Key: "source", Value: cycle.Source},
+		)
+		if cycle.Qty != 0 {
+			point.AddField(naming.Field("qty", ""), float64(cycle.Qty))
+		}
+		points = append(points, point)
+	}
+
+	if len(points) == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"backend": b.Name(),
+		"target":  targetName,
+		"count":   len(points),
+	}).Info("queued health event points")
+	if err := writer.AddMetrics(points...); err != nil {
+		return errors.Wrapf(err, "write error for health events")
+	}
+
+	return nil
+}
+
+func (b *Backend) createECGPoints(naming *NamingSchema, ecg *healthautoexport.ECG, tags []lp.Tag) []*write.Point {
+	points := make([]*write.Point, 0, len(ecg.VoltageMeasurements)+1)
+	point := makeEventPoint(naming, MeasurementECG, ecg.Start, ecg.End, tags,
+		lp.Tag{Key: "classification", Value: ecg.Classification},
+		lp.Tag{Key: "severity", Value: ecg.Severity},
+		lp.Tag{Key: "source", Value: ecg.Source},
+	)
+	if ecg.AverageHeartRate != 0 {
+		point.AddField(naming.Field("average_heart_rate", ""), float64(ecg.AverageHeartRate))
+	}
+	if ecg.SamplingFrequency != 0 {
+		point.AddField(naming.Field("sampling_frequency", ""), float64(ecg.SamplingFrequency))
+	}
+	if ecg.NumberOfVoltageMeasurements != 0 {
+		point.AddField(naming.Field("number_of_voltage_measurements", ""), ecg.NumberOfVoltageMeasurements)
+	}
+	points = append(points, point)
+
+	// Voltage measurements are written as a high-frequency series, and share
+	// the same tags as the ECG recording itself.
+	times := ecgSampleTimes(ecg)
+	for i, datum := range ecg.VoltageMeasurements {
+		ts := times[i]
+		if ts.IsZero() {
+			continue
+		}
+		point := write.NewPointWithMeasurement(naming.Measurement(MeasurementECGVoltage, datum.GetUnits()))
+		addTagsToPoint(point, tags)
+		addTagsToPoint(point, naming.UnitsTags(datum.GetUnits()))
+		addTagsToPoint(point, []lp.Tag{
+			{Key: "classification", Value: ecg.Classification},
+			{Key: "source", Value: ecg.Source},
+		})
+		point.AddField(naming.Field("qty", ""), float64(datum.Voltage))
+		point.SetTime(ts)
+		points = append(points, point)
+	}
+
+	return points
+}
+
+// ecgSampleTimes returns the time of each voltage measurement in ecg, or the
+// zero time if it cannot be determined.
+//
+// The date of each voltage measurement is used when present. It only has second
+// resolution, so hundreds of samples would otherwise share the same timestamp
+// and overwrite each other; if the sampling frequency is known, samples sharing
+// the same date are offset from it at the sampling frequency. If the date is
+// missing, the time is derived from the start of the recording instead.
+func ecgSampleTimes(ecg *healthautoexport.ECG) []time.Time {
+	offset := func(i int) time.Duration {
+		if ecg.SamplingFrequency <= 0 {
+			return 0
+		}
+		return time.Duration(float64(i) / float64(ecg.SamplingFrequency) * float64(time.Second))
+	}
+
+	times := make([]time.Time, len(ecg.VoltageMeasurements))
+	var date time.Time
+	var n int
+	for i, datum := range ecg.VoltageMeasurements {
+		switch {
+		case datum.Date != nil && !datum.Date.IsZero():
+			if datum.Date.Equal(date) {
+				n++
+			} else {
+				date, n = datum.Date.Time, 0
+			}
+			times[i] = date.Add(offset(n))
+		case ecg.SamplingFrequency > 0 && ecg.Start != nil:
+			times[i] = ecg.Start.Add(offset(i))
+		}
+	}
+	return times
+}
+
+func (b *Backend) createHeartRateNotificationPoints(
+	naming *NamingSchema, notification *healthautoexport.HeartRateNotification, tags []lp.Tag,
+) []*write.Point {
+	points := make([]*write.Point, 0, len(notification.HeartRate)+len(notification.HeartRateVariation)+1)
+	point := makeEventPoint(naming, MeasurementHeartRateNotifications, notification.Start, notification.End, tags)
+	if notification.Threshold != 0 {
+		point.AddField(naming.Field("threshold", ""), float64(notification.Threshold))
+	}
+	points = append(points, point)
+
+	for _, sample := range notification.HeartRate {
+		if sample.Timestamp == nil || sample.Timestamp.Start.IsZero() {
+			continue
+		}
+		point := write.NewPointWithMeasurement(naming.Measurement(MeasurementHeartRateNotifications+"_hr", sample.GetUnits()))
+		addTagsToPoint(point, tags)
+		addTagsToPoint(point, naming.UnitsTags(sample.GetUnits()))
+		point.AddField(naming.Field("qty", ""), float64(sample.HR))
+		point.SetTime(sample.Timestamp.Start.Time)
+		points = append(points, point)
+	}
+	for _, sample := range notification.HeartRateVariation {
+		if sample.Timestamp == nil || sample.Timestamp.Start.IsZero() {
+			continue
+		}
+		point := write.NewPointWithMeasurement(naming.Measurement(MeasurementHeartRateNotifications+"_hrv", sample.GetUnits()))
+		addTagsToPoint(point, tags)
+		addTagsToPoint(point, naming.UnitsTags(sample.GetUnits()))
+		point.AddField(naming.Field("qty", ""), float64(sample.HRV))
+		point.SetTime(sample.Timestamp.Start.Time)
+		points = append(points, point)
+	}
+
+	return points
+}
+
+// makeEventPoint creates a point for an event that spans a time interval. The
+// point will have a count of 1, and the duration of the event if the end time
+// is known.
+func makeEventPoint(
+	naming *NamingSchema,
+	measurement string,
+	start, end *healthautoexport.Time,
+	tags []lp.Tag,
+	eventTags ...lp.Tag,
+) *write.Point {
+	point := write.NewPointWithMeasurement(naming.Measurement(measurement, ""))
+	addTagsToPoint(point, tags)
+	addTagsToPoint(point, eventTags)
+	point.AddField(naming.Field("count", ""), 1)
+	if !start.IsZero() && !end.IsZero() {
+		point.AddField(naming.Field("duration", "min"), end.Sub(start.Time).Minutes())
+	}
+	point.SetTime(start.Time)
+	return point
+}
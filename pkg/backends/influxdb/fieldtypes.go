@@ -0,0 +1,187 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+// FieldType is the type of a field value in InfluxDB.
+type FieldType string
+
+const (
+	FieldTypeFloat   FieldType = "float"
+	FieldTypeInteger FieldType = "integer"
+	FieldTypeString  FieldType = "string"
+	FieldTypeBoolean FieldType = "boolean"
+)
+
+// ParseFieldType parses a FieldType.
+func ParseFieldType(s string) (FieldType, error) {
+	switch t := FieldType(s); t {
+	case FieldTypeFloat, FieldTypeInteger, FieldTypeString, FieldTypeBoolean:
+		return t, nil
+	}
+	return "", fmt.Errorf("invalid field type %q, must be one of float, integer, string, boolean", s)
+}
+
+// fieldTypeTracker coerces the values of dynamically-typed fields so that each
+// field in a measurement is always written with the same type, since InfluxDB
+// rejects the entire batch when a field type conflicts with an existing one.
+//
+// All numbers are written as floats unless overridden for the field key. The
+// first type written for each field in a measurement is tracked, and later
+// values are converted to the tracked type if possible, or dropped otherwise.
+type fieldTypeTracker struct {
+	overrides map[string]FieldType
+
+	mu    sync.Mutex
+	types map[fieldSeriesKey]FieldType
+}
+
+type fieldSeriesKey struct {
+	measurement string
+	key         string
+}
+
+func newFieldTypeTracker(overrides map[string]FieldType) *fieldTypeTracker {
+	return &fieldTypeTracker{
+		overrides: overrides,
+		types:     make(map[fieldSeriesKey]FieldType),
+	}
+}
+
+// Coerce returns the value to write for the field, and false if the field
+// should be dropped.
+func (t *fieldTypeTracker) Coerce(measurement, key string, value interface{}) (interface{}, bool) {
+	logger := log.WithFields(log.Fields{
+		"measurement": measurement,
+		"field":       key,
+		"value":       value,
+	})
+
+	fieldType, ok := t.overrides[key]
+	if !ok {
+		fieldType, ok = defaultFieldType(value)
+		if !ok {
+			logger.Warn("unsupported field value type, dropping field")
+			return nil, false
+		}
+	}
+
+	// Use the tracked type if the field was previously written with another type.
+	series := fieldSeriesKey{measurement: measurement, key: key}
+	t.mu.Lock()
+	if tracked, ok := t.types[series]; ok {
+		fieldType = tracked
+	} else {
+		t.types[series] = fieldType
+	}
+	t.mu.Unlock()
+
+	coerced, ok := convertFieldValue(value, fieldType)
+	if !ok {
+		logger.WithField("type", fieldType).Warn("cannot convert field value to existing field type, dropping field")
+		return nil, false
+	}
+	return coerced, true
+}
+
+// defaultFieldType returns the type that a value is written as by default.
+// Times, which string fields containing a timestamp are decoded as, are written
+// as RFC 3339 strings.
+func defaultFieldType(value interface{}) (FieldType, bool) {
+	switch value.(type) {
+	case float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, json.Number:
+		return FieldTypeFloat, true
+	case string, healthautoexport.Time, *healthautoexport.Time:
+		return FieldTypeString, true
+	case bool:
+		return FieldTypeBoolean, true
+	}
+	return "", false
+}
+
+// convertFieldValue converts a value to the given type.
+func convertFieldValue(value interface{}, fieldType FieldType) (interface{}, bool) {
+	switch fieldType {
+	case FieldTypeFloat:
+		f, ok := toFloat(value)
+		return f, ok && !math.IsNaN(f) && !math.IsInf(f, 0)
+	case FieldTypeInteger:
+		f, ok := toFloat(value)
+		if !ok || f != math.Trunc(f) || f < math.MinInt64 || f > math.MaxInt64 {
+			return nil, false
+		}
+		return int64(f), true
+	case FieldTypeString:
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case bool:
+			return strconv.FormatBool(v), true
+		case healthautoexport.Time:
+			return v.String(), true
+		case *healthautoexport.Time:
+			if v == nil {
+				return nil, false
+			}
+			return v.String(), true
+		}
+		if f, ok := toFloat(value); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64), true
+		}
+	case FieldTypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(v)
+			return b, err == nil
+		}
+	}
+	return nil, false
+}
+
+// toFloat converts a number, or a string containing a number, to a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,105 @@
+package influxdb_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+func TestBackend_FieldTypes(t *testing.T) {
+	// String fields containing a timestamp are decoded as times.
+	var timeDatum healthautoexport.Datapoint
+	assert.NoError(t, json.Unmarshal([]byte(`{"date":"1970-01-01 00:16:40 +0000","Avg":60,"recorded":"2021-12-24 00:04:00 +0800"}`), &timeDatum))
+
+	tests := []struct {
+		name        string
+		fieldTypes  map[string]influxdb.FieldType
+		metrics     []*healthautoexport.Metric
+		wantMetrics []string
+	}{
+		{
+			name: "numbers are written as floats",
+			metrics: []*healthautoexport.Metric{
+				makeHeartRateMetric(
+					healthautoexport.DatapointFields{"Avg": 60, "Min": int64(50), "Max": json.Number("70")},
+				),
+			},
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test Avg=60,Max=70,Min=50 1000000000000`,
+			},
+		},
+		{
+			name: "values are converted to the first type written across payloads",
+			metrics: []*healthautoexport.Metric{
+				makeHeartRateMetric(
+					healthautoexport.DatapointFields{"Avg": 60.5, "context": "resting"},
+				),
+				makeHeartRateMetric(
+					healthautoexport.DatapointFields{"Avg": "61", "context": 1},
+					healthautoexport.DatapointFields{"Avg": "high", "context": true},
+				),
+			},
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test Avg=60.5,context="resting" 1000000000000`,
+				`heart_rate_count/min,target_name=test Avg=61,context="1" 1000000000000`,
+				`heart_rate_count/min,target_name=test context="true" 1001000000000`,
+			},
+		},
+		{
+			name:       "override field type by key",
+			fieldTypes: map[string]influxdb.FieldType{"Count": influxdb.FieldTypeInteger},
+			metrics: []*healthautoexport.Metric{
+				makeHeartRateMetric(
+					healthautoexport.DatapointFields{"Count": 3.0},
+					healthautoexport.DatapointFields{"Count": 3.5},
+				),
+			},
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test Count=3i 1000000000000`,
+			},
+		},
+		{
+			name: "times are written as strings",
+			metrics: []*healthautoexport.Metric{
+				makeHeartRateMetric(
+					timeDatum.Fields,
+				),
+			},
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test Avg=60,recorded="2021-12-24T00:04:00+08:00" 1000000000000`,
+			},
+		},
+		{
+			name: "unsupported values are dropped",
+			metrics: []*healthautoexport.Metric{
+				makeHeartRateMetric(
+					healthautoexport.DatapointFields{"Avg": 60.0, "nested": map[string]interface{}{"a": 1}, "null": nil},
+				),
+			},
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test Avg=60 1000000000000`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := influxdb.NewMockClient()
+			backend, err := influxdb.NewBackendWithOptions(client, influxdb.Options{FieldTypes: tt.fieldTypes})
+			assert.NoError(t, err)
+			for _, metric := range tt.metrics {
+				payload := &healthautoexport.Payload{
+					Data: &healthautoexport.PayloadData{
+						Metrics: []*healthautoexport.Metric{metric},
+					},
+				}
+				assert.NoError(t, backend.Write(payload, "test"))
+			}
+			assert.Equal(t, tt.wantMetrics, formatPoints(client.ReadMetrics()))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package influxdb
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/spf13/pflag"
@@ -16,6 +17,33 @@ var (
 	metricsBucketName  string
 	workoutsBucketName string
 	staticTags         []string
+	useGzip            bool
+	batchSize          int
+	batchBytes         int
+	writeConcurrency   int
+	apiVersion         int
+	precision          string
+	replaceOnReexport  bool
+
+	tagKeys      []string
+	splitTagKeys []string
+	maxTagValues int
+	fieldTypes   map[string]string
+
+	// Naming schema flags, keyed by data type.
+	measurementTemplates map[string]string
+	fieldTemplates       map[string]string
+	unitsTags            map[string]string
+	measurementPrefixes  map[string]string
+	nameCases            map[string]string
+
+	// Only used for InfluxDB 1.x API.
+	username                string
+	password                string
+	metricsDatabase         string
+	metricsRetentionPolicy  string
+	workoutsDatabase        string
+	workoutsRetentionPolicy string
 )
 
 func NewInfluxDBClient() (influxdb2.Client, error) {
@@ -23,17 +51,68 @@ func NewInfluxDBClient() (influxdb2.Client, error) {
 		return nil, errors.New("--influxdb.serverURL is not set")
 	}
 
+	p, err := parsePrecision(precision)
+	if err != nil {
+		return nil, err
+	}
+
 	options := influxdb2.DefaultOptions().
 		SetTLSConfig(&tls.Config{
 			InsecureSkipVerify: insecureSkipVerify, // nolint:gosec
-		})
+		}).
+		SetUseGZip(useGzip).
+		SetPrecision(p)
 
 	client := influxdb2.NewClientWithOptions(serverURL, authToken, options)
 	return client, nil
 }
 
+// namingOptionsFromFlags returns the NamingOptions for each data type from flags.
+func namingOptionsFromFlags() (map[DataType]NamingOptions, error) {
+	naming := make(map[DataType]NamingOptions)
+	for _, flag := range []struct {
+		name   string
+		values map[string]string
+		set    func(opts *NamingOptions, value string)
+	}{
+		{"measurementTemplate", measurementTemplates, func(o *NamingOptions, v string) { o.MeasurementTemplate = v }},
+		{"fieldTemplate", fieldTemplates, func(o *NamingOptions, v string) { o.FieldTemplate = v }},
+		{"unitsTag", unitsTags, func(o *NamingOptions, v string) { o.UnitsTag = v }},
+		{"measurementPrefix", measurementPrefixes, func(o *NamingOptions, v string) { o.Prefix = v }},
+		{"nameCase", nameCases, func(o *NamingOptions, v string) { o.Case = NameCase(v) }},
+	} {
+		for key, value := range flag.values {
+			dataType := DataType(key)
+			if !isValidDataType(dataType) {
+				return nil, fmt.Errorf("invalid data type %q in --influxdb.%v, must be one of %v",
+					key, flag.name, AllDataTypes)
+			}
+			opts := naming[dataType]
+			flag.set(&opts, value)
+			naming[dataType] = opts
+		}
+	}
+	return naming, nil
+}
+
+// fieldTypesFromFlags returns the field type overrides from flags.
+func fieldTypesFromFlags() (map[string]FieldType, error) {
+	types := make(map[string]FieldType, len(fieldTypes))
+	for key, value := range fieldTypes {
+		fieldType, err := ParseFieldType(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --influxdb.fieldTypes for %v: %v", key, err)
+		}
+		types[key] = fieldType
+	}
+	return types, nil
+}
+
 func init() {
 	pflag.StringVar(&serverURL, "influxdb.serverURL", "", "Server URL for InfluxDB.")
+	pflag.IntVar(&apiVersion, "influxdb.apiVersion", 2,
+		"InfluxDB API version to use. Use 1 for InfluxDB 1.x or VictoriaMetrics.")
+	pflag.StringVar(&precision, "influxdb.precision", "ns", "Precision of timestamps to write. One of: ns, us, ms, s.")
 	pflag.BoolVar(&insecureSkipVerify, "influxdb.insecureSkipVerify", false,
 		"Skip TLS verification of the certificate chain and host name for the InfluxDB server.")
 	pflag.StringVar(&authToken, "influxdb.authToken", "", "Auth token to connect to InfluxDB.")
@@ -42,4 +121,46 @@ func init() {
 	pflag.StringVar(&workoutsBucketName, "influxdb.workoutsBucketName", "", "InfluxDB bucket name for workouts.")
 	pflag.StringSliceVar(&staticTags, "influxdb.staticTags", nil,
 		"Additional tags to add to InfluxDB for every single request, in key=value format.")
+	pflag.BoolVar(&useGzip, "influxdb.gzip", false, "Compress write requests to InfluxDB with gzip.")
+	pflag.IntVar(&batchSize, "influxdb.batchSize", 5000, "Maximum number of points in a single write request.")
+	pflag.IntVar(&batchBytes, "influxdb.batchBytes", 1<<20,
+		"Maximum size in bytes of a single write request in line protocol, before compression.")
+	pflag.IntVar(&writeConcurrency, "influxdb.writeConcurrency", 4,
+		"Maximum number of concurrent write requests for a single payload.")
+	pflag.StringVar(&username, "influxdb.username", "", "Username to connect to InfluxDB. Only used for API version 1.")
+	pflag.StringVar(&password, "influxdb.password", "", "Password to connect to InfluxDB. Only used for API version 1.")
+	pflag.StringVar(&metricsDatabase, "influxdb.metricsDatabase", "",
+		"InfluxDB database name for metrics. Only used for API version 1.")
+	pflag.StringVar(&metricsRetentionPolicy, "influxdb.metricsRetentionPolicy", "",
+		"InfluxDB retention policy for metrics. Only used for API version 1.")
+	pflag.StringVar(&workoutsDatabase, "influxdb.workoutsDatabase", "",
+		"InfluxDB database name for workouts. Only used for API version 1.")
+	pflag.StringVar(&workoutsRetentionPolicy, "influxdb.workoutsRetentionPolicy", "",
+		"InfluxDB retention policy for workouts. Only used for API version 1.")
+	pflag.StringToStringVar(&measurementTemplates, "influxdb.measurementTemplate", nil,
+		"Template for measurement names per data type (metrics, workouts, events), in type=template format. "+
+			"Defaults to "+DefaultNameTemplate+".")
+	pflag.StringToStringVar(&fieldTemplates, "influxdb.fieldTemplate", nil,
+		"Template for field names per data type (metrics, workouts, events), in type=template format. "+
+			"Defaults to "+DefaultNameTemplate+".")
+	pflag.StringToStringVar(&unitsTags, "influxdb.unitsTag", nil,
+		"Tag key to write units of measurements to per data type (metrics, workouts, events), in type=key format.")
+	pflag.StringToStringVar(&measurementPrefixes, "influxdb.measurementPrefix", nil,
+		"Prefix for measurement names per data type (metrics, workouts, events), in type=prefix format.")
+	pflag.StringToStringVar(&nameCases, "influxdb.nameCase", nil,
+		"Case to convert names to per data type (metrics, workouts, events), in type=case format. "+
+			"One of: snake, camel.")
+	pflag.StringSliceVar(&tagKeys, "influxdb.tagKeys", DefaultTagKeys,
+		"Keys of string-valued datapoint fields to write as tags instead of fields.")
+	pflag.StringSliceVar(&splitTagKeys, "influxdb.splitTagKeys", DefaultSplitTagKeys,
+		"Keys of tags which may contain multiple values joined with a pipe, which are deduplicated and sorted.")
+	pflag.IntVar(&maxTagValues, "influxdb.maxTagValues", DefaultMaxTagValues,
+		"Maximum number of distinct values for each tag key in a measurement, "+
+			"after which new values are written as fields suffixed with _value instead. Use 0 for no limit.")
+	pflag.BoolVar(&replaceOnReexport, "influxdb.replaceOnReexport", false,
+		"Delete existing metric points of the target within the time range of each measurement in the payload "+
+			"before writing, so that stale points from previous exports of the same time range are removed.")
+	pflag.StringToStringVar(&fieldTypes, "influxdb.fieldTypes", nil,
+		"Types of datapoint fields by field name, in key=type format. All numbers are written as floats by default. "+
+			"One of: float, integer, string, boolean.")
 }
@@ -0,0 +1,298 @@
+package influxdb
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+
+	lp "github.com/influxdata/line-protocol"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	// DefaultNameTemplate is the default template for both measurement and
+	// field names, which appends the units as a suffix if the name has units.
+	DefaultNameTemplate = "{{.Name}}{{with .Units}}_{{.}}{{end}}"
+
+	// NameOnlyTemplate is a template which only contains the name, which is
+	// usually combined with a units tag for measurement names.
+	NameOnlyTemplate = "{{.Name}}"
+)
+
+// DataType is a type of data written to InfluxDB, each of which can be
+// configured with its own NamingSchema.
+type DataType string
+
+const (
+	// DataTypeMetrics are metrics, including sleep analysis.
+	DataTypeMetrics DataType = "metrics"
+	// DataTypeWorkouts are workouts, including routes and heart rate data.
+	DataTypeWorkouts DataType = "workouts"
+	// DataTypeEvents are health events, such as symptoms and medications.
+	DataTypeEvents DataType = "events"
+)
+
+// AllDataTypes is a list of all data types.
+var AllDataTypes = []DataType{DataTypeMetrics, DataTypeWorkouts, DataTypeEvents}
+
+func isValidDataType(dataType DataType) bool {
+	for _, t := range AllDataTypes {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+// NameCase is the case to convert names to.
+type NameCase string
+
+const (
+	// NameCaseDefault keeps names as-is.
+	NameCaseDefault NameCase = ""
+	// NameCaseSnake converts names to snake_case.
+	NameCaseSnake NameCase = "snake"
+	// NameCaseCamel converts names to camelCase.
+	NameCaseCamel NameCase = "camel"
+)
+
+// NamingOptions configures a NamingSchema. The zero value uses the default
+// naming scheme.
+type NamingOptions struct {
+	// MeasurementTemplate is a text/template for measurement names, which is
+	// executed with NameData. Defaults to DefaultNameTemplate.
+	MeasurementTemplate string
+
+	// FieldTemplate is a text/template for field names, which is executed with
+	// NameData. Defaults to DefaultNameTemplate.
+	FieldTemplate string
+
+	// UnitsTag is the key of a tag that the units of a measurement will be
+	// added to, if set. This is usually combined with a MeasurementTemplate
+	// that does not include the units.
+	UnitsTag string
+
+	// Prefix is prepended to all measurement names.
+	Prefix string
+
+	// Case is the case that names are converted to before executing templates.
+	// Units are never converted.
+	Case NameCase
+}
+
+// NameData is passed to measurement and field name templates.
+type NameData struct {
+	// Name of the metric, workout or field, converted to the configured case.
+	Name string
+	// Units of the measurement or field, which may be empty.
+	Units healthautoexport.Units
+	// WorkoutName is the name of the workout, only for workout measurements.
+	WorkoutName string
+	// WorkoutID is the stable ID of the workout, only for workout measurements.
+	WorkoutID string
+}
+
+// NamingSchema determines the names of measurements, fields and tags.
+type NamingSchema struct {
+	measurement *template.Template
+	field       *template.Template
+	unitsTag    string
+	prefix      string
+	nameCase    NameCase
+}
+
+// templateText returns the text of a name template.
+func templateText(tmpl *template.Template) string {
+	return tmpl.Root.String()
+}
+
+// NewNamingSchema returns a NamingSchema from options. Returns an error if
+// any template is invalid.
+func NewNamingSchema(opts NamingOptions) (*NamingSchema, error) {
+	switch opts.Case {
+	case NameCaseDefault, NameCaseSnake, NameCaseCamel:
+	default:
+		return nil, fmt.Errorf("invalid name case %q, must be one of snake, camel", opts.Case)
+	}
+
+	measurement, err := parseNameTemplate("measurement", opts.MeasurementTemplate)
+	if err != nil {
+		return nil, err
+	}
+	field, err := parseNameTemplate("field", opts.FieldTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NamingSchema{
+		measurement: measurement,
+		field:       field,
+		unitsTag:    opts.UnitsTag,
+		prefix:      opts.Prefix,
+		nameCase:    opts.Case,
+	}, nil
+}
+
+func parseNameTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultNameTemplate
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %v template %q", name, text)
+	}
+
+	// Execute once to catch errors that only occur during execution, such as
+	// references to unknown fields.
+	sample := NameData{Name: "name", Units: "units", WorkoutName: "workout", WorkoutID: "id"}
+	if _, err := executeNameTemplate(tmpl, sample); err != nil {
+		return nil, errors.Wrapf(err, "invalid %v template %q", name, text)
+	}
+
+	return tmpl, nil
+}
+
+func executeNameTemplate(tmpl *template.Template, data NameData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Measurement returns the measurement name for the given name and units.
+// Units should be empty if the measurement has no units.
+func (s *NamingSchema) Measurement(name string, units healthautoexport.Units) string {
+	return s.prefix + s.execute(s.measurement, NameData{Name: name, Units: units})
+}
+
+// WorkoutMeasurement returns the measurement name for data of a workout,
+// which allows measurements to be grouped per workout.
+func (s *NamingSchema) WorkoutMeasurement(
+	workout *healthautoexport.Workout, name string, units healthautoexport.Units,
+) string {
+	return s.prefix + s.execute(s.measurement, NameData{
+		Name:        name,
+		Units:       units,
+		WorkoutName: convertCase(workout.Name, s.nameCase),
+		WorkoutID:   workout.WorkoutID(),
+	})
+}
+
+// Field returns the field name for the given name and units. Units should be
+// empty if the field has no units, or if the units are already part of the
+// measurement.
+func (s *NamingSchema) Field(name string, units healthautoexport.Units) string {
+	return s.execute(s.field, NameData{Name: name, Units: units})
+}
+
+// CheckInvertible returns an error if metric names cannot be recovered from
+// measurement names with MetricName. Only the default and name-only measurement
+// templates, and the default field template are supported, without converting
+// the case of names.
+func (s *NamingSchema) CheckInvertible() error {
+	if s.nameCase != NameCaseDefault {
+		return fmt.Errorf("names converted to %v case cannot be recovered", s.nameCase)
+	}
+	if text := templateText(s.field); text != DefaultNameTemplate {
+		return fmt.Errorf("field names cannot be recovered from field template %q", text)
+	}
+	if text := templateText(s.measurement); text != DefaultNameTemplate && text != NameOnlyTemplate {
+		return fmt.Errorf("metric names cannot be recovered from measurement template %q", text)
+	}
+	return nil
+}
+
+// MetricName returns the name of the metric that the measurement was written
+// for, given the units of the measurement. Returns false if the measurement
+// was not written with the NamingSchema. CheckInvertible should be called
+// first to ensure that names can be recovered.
+//
+// If the units are empty, such as when no units tag is configured, any units
+// in the measurement name are kept as part of the metric name.
+func (s *NamingSchema) MetricName(measurement string, units healthautoexport.Units) (string, bool) {
+	if !strings.HasPrefix(measurement, s.prefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(measurement, s.prefix)
+	if units != "" && templateText(s.measurement) == DefaultNameTemplate {
+		suffix := "_" + string(units)
+		if !strings.HasSuffix(name, suffix) {
+			return "", false
+		}
+		name = strings.TrimSuffix(name, suffix)
+	}
+	return name, name != ""
+}
+
+// UnitsTags returns the tags to add to a measurement with the given units.
+func (s *NamingSchema) UnitsTags(units healthautoexport.Units) []lp.Tag {
+	if s.unitsTag == "" || units == "" {
+		return nil
+	}
+	return []lp.Tag{{Key: s.unitsTag, Value: string(units)}}
+}
+
+func (s *NamingSchema) execute(tmpl *template.Template, data NameData) string {
+	name := data.Name
+	data.Name = convertCase(name, s.nameCase)
+	result, err := executeNameTemplate(tmpl, data)
+	if err != nil {
+		// Templates are validated when parsed, so this should not happen.
+		log.WithError(err).WithField("name", name).Error("cannot execute naming template")
+		return data.Name
+	}
+	return result
+}
+
+// convertCase converts a name such as "active_energy" or "activeEnergy" to the given case.
+func convertCase(name string, nameCase NameCase) string {
+	switch nameCase {
+	case NameCaseSnake:
+		return strings.Join(splitWords(name), "_")
+	case NameCaseCamel:
+		words := splitWords(name)
+		for i := 1; i < len(words); i++ {
+			runes := []rune(words[i])
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		}
+		return strings.Join(words, "")
+	}
+	return name
+}
+
+// splitWords splits a name into lowercase words on separators and lowercase to
+// uppercase transitions.
+func splitWords(name string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || unicode.IsSpace(r):
+			flush()
+		case unicode.IsUpper(r):
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+				flush()
+			}
+			word = append(word, unicode.ToLower(r))
+		default:
+			word = append(word, r)
+		}
+	}
+	flush()
+
+	return words
+}
@@ -0,0 +1,195 @@
+package influxdb_test
+
+import (
+	"testing"
+
+	lp "github.com/influxdata/line-protocol"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport/fixtures"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+func TestNamingSchema(t *testing.T) {
+	type name struct {
+		name  string
+		units healthautoexport.Units
+	}
+	tests := []struct {
+		name            string
+		opts            influxdb.NamingOptions
+		input           name
+		wantMeasurement string
+		wantField       string
+		wantTags        []lp.Tag
+		wantError       assert.ErrorAssertionFunc
+	}{
+		{
+			name:            "default with units",
+			input:           name{name: "active_energy", units: "kJ"},
+			wantMeasurement: "active_energy_kJ",
+			wantField:       "active_energy_kJ",
+		},
+		{
+			name:            "default without units",
+			input:           name{name: "route"},
+			wantMeasurement: "route",
+			wantField:       "route",
+		},
+		{
+			name: "units as tag",
+			opts: influxdb.NamingOptions{
+				MeasurementTemplate: "{{.Name}}",
+				UnitsTag:            "units",
+			},
+			input:           name{name: "active_energy", units: "kJ"},
+			wantMeasurement: "active_energy",
+			wantField:       "active_energy_kJ",
+			wantTags:        []lp.Tag{{Key: "units", Value: "kJ"}},
+		},
+		{
+			name: "prefix and camel case",
+			opts: influxdb.NamingOptions{
+				Prefix: "health_",
+				Case:   influxdb.NameCaseCamel,
+			},
+			input:           name{name: "active_energy", units: "kJ"},
+			wantMeasurement: "health_activeEnergy_kJ",
+			wantField:       "activeEnergy_kJ",
+		},
+		{
+			name: "snake case",
+			opts: influxdb.NamingOptions{
+				Case: influxdb.NameCaseSnake,
+			},
+			input:           name{name: "activeEnergy", units: "kJ"},
+			wantMeasurement: "active_energy_kJ",
+			wantField:       "active_energy_kJ",
+		},
+		{
+			name: "custom field template",
+			opts: influxdb.NamingOptions{
+				FieldTemplate: "{{.Name}}",
+			},
+			input:           name{name: "stepCount", units: "steps"},
+			wantMeasurement: "stepCount_steps",
+			wantField:       "stepCount",
+		},
+		{
+			name: "invalid template syntax",
+			opts: influxdb.NamingOptions{
+				MeasurementTemplate: "{{.Name",
+			},
+			wantError: testutils.AssertErrorContains("invalid measurement template"),
+		},
+		{
+			name: "unknown template field",
+			opts: influxdb.NamingOptions{
+				FieldTemplate: "{{.Unit}}",
+			},
+			wantError: testutils.AssertErrorContains("invalid field template"),
+		},
+		{
+			name: "invalid case",
+			opts: influxdb.NamingOptions{
+				Case: "kebab",
+			},
+			wantError: testutils.AssertErrorContains("invalid name case"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := influxdb.NewNamingSchema(tt.opts)
+			if testutils.WantError(t, tt.wantError, err) {
+				return
+			}
+			assert.Equal(t, tt.wantMeasurement, schema.Measurement(tt.input.name, tt.input.units))
+			assert.Equal(t, tt.wantField, schema.Field(tt.input.name, tt.input.units))
+			assert.Equal(t, tt.wantTags, schema.UnitsTags(tt.input.units))
+		})
+	}
+}
+
+func TestBackend_Naming(t *testing.T) {
+	tests := []struct {
+		name         string
+		naming       map[influxdb.DataType]influxdb.NamingOptions
+		payload      *healthautoexport.Payload
+		wantMetrics  []string
+		wantWorkouts []string
+		wantError    assert.ErrorAssertionFunc
+	}{
+		{
+			name: "metrics with units as tag",
+			naming: map[influxdb.DataType]influxdb.NamingOptions{
+				influxdb.DataTypeMetrics: {
+					MeasurementTemplate: "{{.Name}}",
+					UnitsTag:            "units",
+					Prefix:              "hae_",
+				},
+			},
+			payload: &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Metrics: []*healthautoexport.Metric{fixtures.MetricActiveEnergy},
+				},
+			},
+			wantMetrics: []string{
+				"hae_active_energy,target_name=test,units=kJ qty=0.7685677437484512 1640275440000000000",
+				"hae_active_energy,target_name=test,units=kJ qty=0.377848256251549 1640275500000000000",
+			},
+		},
+		{
+			name: "workouts in snake case",
+			naming: map[influxdb.DataType]influxdb.NamingOptions{
+				influxdb.DataTypeWorkouts: {
+					MeasurementTemplate: "workout_{{.Name}}",
+					Case:                influxdb.NameCaseSnake,
+				},
+			},
+			payload: fixtures.PayloadWithWorkouts,
+			wantWorkouts: []string{
+				`workout_workout,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 duration_min=19.166666666666668,avg_heart_rate_bpm=108,max_heart_rate_bpm=108,active_energy_rate_kcal/min=2.8208233404895484,elevation_ascent_m=16.36,elevation_descent_m=0,active_energy_kJ=226.21122641832523,step_count_steps=908 1640304163000000000`,
+				`workout_route,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
+				`workout_heart_rate_data,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 qty=108 1640304167000000000`,
+			},
+		},
+		{
+			name: "workouts grouped per workout",
+			naming: map[influxdb.DataType]influxdb.NamingOptions{
+				influxdb.DataTypeWorkouts: {
+					MeasurementTemplate: "{{.WorkoutName}}_{{.WorkoutID}}_{{.Name}}",
+					Case:                influxdb.NameCaseSnake,
+				},
+			},
+			payload: fixtures.PayloadWithWorkouts,
+			wantWorkouts: []string{
+				`walking_0d440f6feecd8126_workout,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 duration_min=19.166666666666668,avg_heart_rate_bpm=108,max_heart_rate_bpm=108,active_energy_rate_kcal/min=2.8208233404895484,elevation_ascent_m=16.36,elevation_descent_m=0,active_energy_kJ=226.21122641832523,step_count_steps=908 1640304163000000000`,
+				`walking_0d440f6feecd8126_route,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 lat=38.8951,lon=-77.0364,altitude=8.02762222290039 1640304285000000000`,
+				`walking_0d440f6feecd8126_heart_rate_data,target_name=test,workout_name=Walking,workout_id=0d440f6feecd8126 qty=108 1640304167000000000`,
+			},
+		},
+		{
+			name: "invalid data type",
+			naming: map[influxdb.DataType]influxdb.NamingOptions{
+				"sleep": {},
+			},
+			wantError: testutils.AssertErrorContains(`invalid data type "sleep"`),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := influxdb.NewMockClient()
+			backend, err := influxdb.NewBackendWithOptions(client, influxdb.Options{Naming: tt.naming})
+			if testutils.WantError(t, tt.wantError, err) {
+				return
+			}
+			assert.NoError(t, backend.Write(tt.payload, "test"))
+			assert.ElementsMatch(t, tt.wantMetrics, formatPoints(client.ReadMetrics()))
+			assert.ElementsMatch(t, tt.wantWorkouts, formatPoints(client.ReadWorkouts()))
+		})
+	}
+}
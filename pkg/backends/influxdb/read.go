@@ -0,0 +1,336 @@
+package influxdb
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/pkg/errors"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	// readChunkSize is the maximum number of datapoints in each payload read.
+	readChunkSize = 10000
+
+	// readWindow is the time range of points in each query when reading, so
+	// that the results of each query are bounded.
+	readWindow = 7 * 24 * time.Hour
+
+	// targetNameTag is the tag that the target name is written to.
+	targetNameTag = "target_name"
+)
+
+var _ backends.Reader = &Backend{}
+
+// MetricPoint is a point read from the metrics bucket.
+type MetricPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// MetricReader is optionally implemented by a Client which can read points
+// from the metrics bucket.
+type MetricReader interface {
+	// QueryMetricsTimeRange returns the times of the earliest and latest points
+	// in the metrics bucket, or zero times if there are no points.
+	QueryMetricsTimeRange(ctx context.Context) (time.Time, time.Time, error)
+
+	// QueryMetrics calls fn with all points in the metrics bucket with a time
+	// from start inclusive to stop exclusive. Reading is stopped if fn returns
+	// an error.
+	QueryMetrics(ctx context.Context, start, stop time.Time, fn func(point *MetricPoint) error) error
+}
+
+// fluxTime formats a time as a Flux time literal.
+func fluxTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// metricsFluxQuery returns a Flux query for all points in the bucket within the
+// time range, with one row per point.
+func metricsFluxQuery(bucket string, start, stop time.Time) string {
+	return strings.Join([]string{
+		"from(bucket: " + strconv.Quote(bucket) + ")",
+		"  |> range(start: " + fluxTime(start) + ", stop: " + fluxTime(stop) + ")",
+		`  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`,
+	}, "\n")
+}
+
+// metricsTimeFluxQuery returns a Flux query for the earliest or latest point
+// in the bucket.
+func metricsTimeFluxQuery(bucket string, latest bool) string {
+	selector, desc := "first()", "false"
+	if latest {
+		selector, desc = "last()", "true"
+	}
+	return strings.Join([]string{
+		"from(bucket: " + strconv.Quote(bucket) + ")",
+		"  |> range(start: 0)",
+		"  |> " + selector,
+		"  |> group()",
+		`  |> sort(columns: ["_time"], desc: ` + desc + ")",
+		"  |> limit(n: 1)",
+	}, "\n")
+}
+
+func (c *clientImpl) QueryMetricsTimeRange(ctx context.Context) (time.Time, time.Time, error) {
+	var times [2]time.Time
+	for i, latest := range []bool{false, true} {
+		result, err := c.client.QueryAPI(c.orgName).Query(ctx, metricsTimeFluxQuery(c.metricsBucketName, latest))
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.Wrapf(err, "query error")
+		}
+		if result.Next() {
+			times[i] = result.Record().Time()
+		}
+		err = result.Err()
+		_ = result.Close()
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.Wrapf(err, "query error")
+		}
+	}
+	return times[0], times[1], nil
+}
+
+func (c *clientImpl) QueryMetrics(ctx context.Context, start, stop time.Time, fn func(point *MetricPoint) error) error {
+	result, err := c.client.QueryAPI(c.orgName).Query(ctx, metricsFluxQuery(c.metricsBucketName, start, stop))
+	if err != nil {
+		return errors.Wrapf(err, "query error")
+	}
+	defer func() {
+		_ = result.Close()
+	}()
+
+	// Tags are part of the group key of each table, while fields are not.
+	var tags map[string]bool
+	for result.Next() {
+		if result.TableChanged() || tags == nil {
+			tags = make(map[string]bool)
+			for _, column := range result.TableMetadata().Columns() {
+				if column.IsGroup() {
+					tags[column.Name()] = true
+				}
+			}
+		}
+		record := result.Record()
+		point := &MetricPoint{
+			Measurement: record.Measurement(),
+			Tags:        make(map[string]string),
+			Fields:      make(map[string]interface{}),
+			Time:        record.Time(),
+		}
+		for key, value := range record.Values() {
+			if value == nil || strings.HasPrefix(key, "_") || key == "result" || key == "table" {
+				continue
+			}
+			if tags[key] {
+				point.Tags[key], _ = value.(string)
+				continue
+			}
+			point.Fields[key] = value
+		}
+		if err := fn(point); err != nil {
+			return err
+		}
+	}
+	if err := result.Err(); err != nil {
+		return errors.Wrapf(err, "query error")
+	}
+	return nil
+}
+
+func (m *MockClient) QueryMetricsTimeRange(_ context.Context) (time.Time, time.Time, error) {
+	var first, last time.Time
+	for _, p := range m.ReadMetrics() {
+		if first.IsZero() || p.Time().Before(first) {
+			first = p.Time()
+		}
+		if p.Time().After(last) {
+			last = p.Time()
+		}
+	}
+	return first, last, nil
+}
+
+func (m *MockClient) QueryMetrics(_ context.Context, start, stop time.Time, fn func(point *MetricPoint) error) error {
+	// Points are sorted by time within each measurement, similar to the
+	// tables in the result of a Flux query.
+	var points []*write.Point
+	for _, p := range m.ReadMetrics() {
+		if !p.Time().Before(start) && p.Time().Before(stop) {
+			points = append(points, p)
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].Name() != points[j].Name() {
+			return points[i].Name() < points[j].Name()
+		}
+		return points[i].Time().Before(points[j].Time())
+	})
+	for _, p := range points {
+		point := &MetricPoint{
+			Measurement: p.Name(),
+			Tags:        make(map[string]string),
+			Fields:      make(map[string]interface{}),
+			Time:        p.Time(),
+		}
+		for _, tag := range p.TagList() {
+			point.Tags[tag.Key] = tag.Value
+		}
+		for _, field := range p.FieldList() {
+			point.Fields[field.Key] = field.Value
+		}
+		if err := fn(point); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read reads all metric datapoints from the metrics bucket using Flux, which is
+// only supported by the InfluxDB 2.x API. Sleep analysis, workouts and health
+// events are not read, since they cannot be fully converted back from points.
+//
+// Metric names are recovered from measurement names using the naming schema,
+// so the units of a metric are only recovered if a units tag is configured.
+// Tags other than the target name, units and static tags are read as fields of
+// each datapoint. Points are queried in windows of time, so that the results
+// of each query are bounded.
+func (b *Backend) Read(fn func(payload *healthautoexport.Payload, targetName string) error) error {
+	reader, ok := b.client.(MetricReader)
+	if !ok {
+		return errors.New("reading is only supported by the InfluxDB 2.x API")
+	}
+	if err := b.naming[DataTypeMetrics].CheckInvertible(); err != nil {
+		return errors.Wrapf(err, "cannot read metrics")
+	}
+
+	// Sleep analysis and health events are also written to the metrics bucket.
+	// Measurements of health events may have units appended to their names.
+	naming := b.naming[DataTypeMetrics]
+	var skipped []string
+	for _, measurement := range []string{
+		MeasurementSleepAnalysisDetailed,
+		MeasurementSleepAnalysisAggregated,
+		MeasurementSleepPhases,
+		MeasurementSleepSessions,
+	} {
+		skipped = append(skipped, naming.Measurement(measurement, ""))
+	}
+	for _, measurement := range []string{
+		MeasurementSymptoms,
+		MeasurementMedications,
+		MeasurementECG,
+		MeasurementHeartRateNotifications,
+		MeasurementStateOfMind,
+		MeasurementCycleTracking,
+	} {
+		skipped = append(skipped, b.naming[DataTypeEvents].Measurement(measurement, ""))
+	}
+	skip := func(measurement string) bool {
+		for _, name := range skipped {
+			if measurement == name || strings.HasPrefix(measurement, name+"_") {
+				return true
+			}
+		}
+		return false
+	}
+	ignoredTags := map[string]bool{targetNameTag: true}
+	if naming.unitsTag != "" {
+		ignoredTags[naming.unitsTag] = true
+	}
+	for _, tag := range b.staticTags {
+		ignoredTags[tag.Key] = true
+	}
+	qtyField := naming.Field("qty", "")
+
+	var key, target string
+	var metric *healthautoexport.Metric
+	flush := func() error {
+		if metric == nil || len(metric.Datapoints) == 0 {
+			return nil
+		}
+		payload := &healthautoexport.Payload{
+			Data: &healthautoexport.PayloadData{Metrics: []*healthautoexport.Metric{metric}},
+		}
+		metric = nil
+		return fn(payload, target)
+	}
+
+	readPoint := func(point *MetricPoint) error {
+		if skip(point.Measurement) {
+			return nil
+		}
+		var units healthautoexport.Units
+		if naming.unitsTag != "" {
+			units = healthautoexport.Units(point.Tags[naming.unitsTag])
+		}
+		name, ok := naming.MetricName(point.Measurement, units)
+		if !ok {
+			return nil
+		}
+
+		// Start a new chunk for each metric and target.
+		pointKey := strings.Join([]string{point.Measurement, point.Tags[targetNameTag], string(units)}, "\x00")
+		if metric == nil || pointKey != key || len(metric.Datapoints) >= readChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+			key, target = pointKey, point.Tags[targetNameTag]
+			metric = &healthautoexport.Metric{
+				Name:  name,
+				Units: units,
+			}
+		}
+
+		date := healthautoexport.NewTime(point.Time)
+		datum := &healthautoexport.Datapoint{Date: &date}
+		for name, value := range point.Fields {
+			if qty, ok := toFloat(value); ok && name == qtyField {
+				datum.Qty = healthautoexport.Qty(qty)
+				continue
+			}
+			if datum.Fields == nil {
+				datum.Fields = make(healthautoexport.DatapointFields)
+			}
+			datum.Fields[name] = value
+		}
+		for name, value := range point.Tags {
+			if ignoredTags[name] {
+				continue
+			}
+			if datum.Fields == nil {
+				datum.Fields = make(healthautoexport.DatapointFields)
+			}
+			datum.Fields[name] = value
+		}
+		metric.Datapoints = append(metric.Datapoints, datum)
+		return nil
+	}
+
+	first, last, err := reader.QueryMetricsTimeRange(b.ctx)
+	if err != nil {
+		return err
+	}
+	if first.IsZero() {
+		return nil
+	}
+	for start := first; !start.After(last); start = start.Add(readWindow) {
+		stop := start.Add(readWindow)
+		if stop.After(last) {
+			stop = last.Add(time.Nanosecond)
+		}
+		if err := reader.QueryMetrics(b.ctx, start, stop, readPoint); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
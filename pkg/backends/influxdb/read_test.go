@@ -0,0 +1,156 @@
+package influxdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends"
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport/fixtures"
+)
+
+func TestBackend_Read(t *testing.T) {
+	payload := &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{
+			Metrics: []*healthautoexport.Metric{fixtures.MetricActiveEnergy},
+			Symptoms: []*healthautoexport.Symptom{
+				{Start: fixtures.MetricActiveEnergy.Datapoints[0].Date, Name: "Headache"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		opts      influxdb.Options
+		wantName  string
+		wantUnits healthautoexport.Units
+	}{
+		{
+			name:     "default naming",
+			wantName: "active_energy_kJ",
+		},
+		{
+			name: "units as tag",
+			opts: influxdb.Options{
+				StaticTags: []string{"host=test"},
+				Naming: map[influxdb.DataType]influxdb.NamingOptions{
+					influxdb.DataTypeMetrics: {
+						MeasurementTemplate: "{{.Name}}",
+						UnitsTag:            "units",
+						Prefix:              "hae_",
+					},
+				},
+			},
+			wantName:  "active_energy",
+			wantUnits: "kJ",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := influxdb.NewMockClient()
+			backend, err := influxdb.NewBackendWithOptions(client, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, backend.Write(payload, "test"))
+
+			var metrics []*healthautoexport.Metric
+			err = backend.(backends.Reader).Read(func(payload *healthautoexport.Payload, targetName string) error {
+				assert.Equal(t, "test", targetName)
+				metrics = append(metrics, payload.Data.Metrics...)
+				return nil
+			})
+			if !assert.NoError(t, err) || !assert.Len(t, metrics, 1) {
+				return
+			}
+			assert.Equal(t, tt.wantName, metrics[0].Name)
+			assert.Equal(t, tt.wantUnits, metrics[0].Units)
+			if assert.Len(t, metrics[0].Datapoints, 2) {
+				for i, datum := range metrics[0].Datapoints {
+					want := fixtures.MetricActiveEnergy.Datapoints[i]
+					assert.Equal(t, want.Qty, datum.Qty)
+					assert.True(t, want.Date.Equal(datum.Date.Time))
+					assert.Empty(t, datum.Fields)
+				}
+			}
+		})
+	}
+}
+
+func TestBackend_ReadWindows(t *testing.T) {
+	start := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	qtys := make(map[int64]float64, 30)
+	for i := 0; i < 30; i++ {
+		qtys[start.Add(time.Duration(i)*24*time.Hour).Unix()] = float64(i + 1)
+	}
+	payload := makeMetricPayload("step_count", "count", qtys)
+	metric := payload.Data.Metrics[0]
+
+	backend, err := influxdb.NewBackend(influxdb.NewMockClient())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, backend.Write(payload, "test"))
+
+	var datapoints []*healthautoexport.Datapoint
+	err = backend.(backends.Reader).Read(func(payload *healthautoexport.Payload, targetName string) error {
+		for _, metric := range payload.Data.Metrics {
+			assert.Equal(t, "step_count_count", metric.Name)
+			datapoints = append(datapoints, metric.Datapoints...)
+		}
+		return nil
+	})
+	if !assert.NoError(t, err) || !assert.Len(t, datapoints, len(metric.Datapoints)) {
+		return
+	}
+	for i, datum := range datapoints {
+		assert.Equal(t, metric.Datapoints[i].Qty, datum.Qty)
+		assert.True(t, metric.Datapoints[i].Date.Equal(datum.Date.Time))
+	}
+}
+
+func TestBackend_ReadNonInvertibleNaming(t *testing.T) {
+	tests := []struct {
+		name   string
+		naming influxdb.NamingOptions
+		want   string
+	}{
+		{
+			name:   "name case",
+			naming: influxdb.NamingOptions{Case: influxdb.NameCaseCamel},
+			want:   "camel case",
+		},
+		{
+			name:   "measurement template",
+			naming: influxdb.NamingOptions{MeasurementTemplate: "hk_{{.Name}}"},
+			want:   "measurement template",
+		},
+		{
+			name:   "field template",
+			naming: influxdb.NamingOptions{FieldTemplate: "{{.Name}}"},
+			want:   "field template",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := influxdb.NewBackendWithOptions(influxdb.NewMockClient(), influxdb.Options{
+				Naming: map[influxdb.DataType]influxdb.NamingOptions{influxdb.DataTypeMetrics: tt.naming},
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			err = backend.(backends.Reader).Read(func(*healthautoexport.Payload, string) error {
+				t.Error("unexpected payload")
+				return nil
+			})
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.want)
+			}
+		})
+	}
+}
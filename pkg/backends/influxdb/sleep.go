@@ -0,0 +1,171 @@
+package influxdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+// sleepSampleRetention is how long sleep analysis samples are kept before the
+// latest sample of a target, which is much longer than any single night.
+const sleepSampleRetention = 7 * 24 * time.Hour
+
+// sleepSampleStore keeps the non-aggregated sleep analysis samples received for
+// each target, so that sleep sessions are built from all samples received so
+// far, similar to the LocalFile backend. A night which is split across multiple
+// exports (e.g. daily exports starting at midnight) is then still summarized as
+// a single session, and a partial export of a night does not replace the
+// session built from the whole night.
+//
+// Samples are only kept in memory, and are dropped once they are older than
+// sleepSampleRetention.
+type sleepSampleStore struct {
+	mu      sync.Mutex
+	samples map[string][]*healthautoexport.SleepAnalysis
+}
+
+type sleepSampleKey struct {
+	start  int64
+	source string
+}
+
+type sleepSessionKey struct {
+	date   string
+	source string
+}
+
+func newSleepSampleStore() *sleepSampleStore {
+	return &sleepSampleStore{
+		samples: make(map[string][]*healthautoexport.SleepAnalysis),
+	}
+}
+
+// Merge merges the samples into the stored samples of the target, replacing any
+// samples with the same start time and source, and rebuilds the sessions of all
+// nights which contain any of the samples.
+//
+// It returns the sessions to write, with a single session per night and source,
+// and the sessions previously built for these nights whose date has changed
+// (e.g. a night which was cut off before midnight), which should be deleted.
+func (s *sleepSampleStore) Merge(
+	target string, samples []*healthautoexport.SleepAnalysis,
+) (sessions, stale []*healthautoexport.SleepSession) {
+	if len(samples) == 0 {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	builder := healthautoexport.NewSleepSessionBuilder()
+	previous := builder.Build(s.samples[target])
+	s.samples[target] = mergeSleepSamples(s.samples[target], samples)
+	current := builder.Build(s.samples[target])
+
+	// Find the nights which contain any of the samples, including their
+	// previous dates.
+	var keys []sleepSessionKey
+	seen := make(map[sleepSessionKey]bool)
+	addKey := func(session *healthautoexport.SleepSession) {
+		key := sleepSessionKey{date: session.Date, source: session.Source}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, session := range current {
+		if !overlapsSleepSamples(session, samples) {
+			continue
+		}
+		addKey(session)
+		for _, prev := range previous {
+			if prev.Source == session.Source && overlapsSleepSession(prev, session) {
+				addKey(prev)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].date == keys[j].date {
+			return keys[i].source < keys[j].source
+		}
+		return keys[i].date < keys[j].date
+	})
+
+	// Keep the main session of each night and source, which is the longest
+	// one, e.g. if a nap or the start of the next night has the same date.
+	byKey := make(map[sleepSessionKey]*healthautoexport.SleepSession, len(current))
+	for _, session := range current {
+		key := sleepSessionKey{date: session.Date, source: session.Source}
+		if existing, ok := byKey[key]; !ok || session.InBed > existing.InBed {
+			byKey[key] = session
+		}
+	}
+	for _, key := range keys {
+		if session, ok := byKey[key]; ok {
+			sessions = append(sessions, session)
+			continue
+		}
+		for _, prev := range previous {
+			if prev.Date == key.date && prev.Source == key.source {
+				stale = append(stale, prev)
+				break
+			}
+		}
+	}
+
+	return sessions, stale
+}
+
+// mergeSleepSamples merges samples into existing, replacing samples with the
+// same start time and source, and drops samples which are older than
+// sleepSampleRetention before the latest sample.
+func mergeSleepSamples(existing, samples []*healthautoexport.SleepAnalysis) []*healthautoexport.SleepAnalysis {
+	byKey := make(map[sleepSampleKey]int, len(existing)+len(samples))
+	merged := make([]*healthautoexport.SleepAnalysis, 0, len(existing)+len(samples))
+	var latest time.Time
+	for _, list := range [][]*healthautoexport.SleepAnalysis{existing, samples} {
+		for _, sample := range list {
+			if sample == nil || sample.StartDate.IsZero() || sample.EndDate.IsZero() {
+				continue
+			}
+			if sample.EndDate.After(latest) {
+				latest = sample.EndDate.Time
+			}
+			key := sleepSampleKey{start: sample.StartDate.UnixNano(), source: sample.Source}
+			if i, ok := byKey[key]; ok {
+				merged[i] = sample
+				continue
+			}
+			byKey[key] = len(merged)
+			merged = append(merged, sample)
+		}
+	}
+
+	result := merged[:0]
+	for _, sample := range merged {
+		if latest.Sub(sample.EndDate.Time) <= sleepSampleRetention {
+			result = append(result, sample)
+		}
+	}
+	return result
+}
+
+// overlapsSleepSamples returns true if the session overlaps any of the samples.
+func overlapsSleepSamples(session *healthautoexport.SleepSession, samples []*healthautoexport.SleepAnalysis) bool {
+	for _, sample := range samples {
+		if sample == nil || sample.StartDate.IsZero() || sample.EndDate.IsZero() {
+			continue
+		}
+		if !sample.StartDate.After(session.End.Time) && !sample.EndDate.Before(session.Start.Time) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlapsSleepSession returns true if both sessions overlap.
+func overlapsSleepSession(a, b *healthautoexport.SleepSession) bool {
+	return a.Start.Before(b.End.Time) && b.Start.Before(a.End.Time)
+}
@@ -0,0 +1,197 @@
+package influxdb
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	lp "github.com/influxdata/line-protocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+var (
+	// DefaultTagKeys are keys of datapoint fields that are known to have a low
+	// cardinality, and are promoted to tags by default.
+	DefaultTagKeys = []string{"source", "sleepSource", "inBedSource", "mealTime", "reason"}
+
+	// DefaultSplitTagKeys are keys of datapoint fields that may contain
+	// multiple values joined with a pipe, such as "Apple Watch|iPhone".
+	DefaultSplitTagKeys = []string{"source", "sleepSource", "inBedSource"}
+)
+
+const (
+	// DefaultMaxTagValues is the default maximum number of distinct values
+	// for each promoted tag key in a single measurement.
+	DefaultMaxTagValues = 100
+
+	// multiValueSeparator separates multiple values in a single string.
+	multiValueSeparator = "|"
+
+	// fallbackFieldSuffix is appended to the key of a tag whose value is
+	// written as a field instead, so that the field does not share the same
+	// key as the tag.
+	fallbackFieldSuffix = "_value"
+)
+
+// TagOptions configures how datapoint fields are promoted to tags.
+type TagOptions struct {
+	// Keys of datapoint fields that are promoted to tags, if the value is a
+	// string. All other fields are written as fields.
+	Keys []string
+
+	// SplitKeys are keys of datapoint fields that may contain multiple values
+	// joined with a pipe. The values are split, deduplicated and sorted, so
+	// that the same set of values always results in the same tag value.
+	SplitKeys []string
+
+	// MaxValues is the maximum number of distinct values for each tag key in
+	// a single measurement. Once exceeded, new values are written as fields
+	// instead to avoid a cardinality explosion. Zero means no limit.
+	//
+	// The fields are suffixed with "_value" (e.g. source_value), since a field
+	// with the same key as a tag cannot be queried separately from the tag.
+	// Values which were already seen are still written as tags.
+	MaxValues int
+}
+
+// tagPromoter promotes string-valued datapoint fields to tags.
+type tagPromoter struct {
+	keys      map[string]struct{}
+	splitKeys map[string]struct{}
+	maxValues int
+
+	mu sync.Mutex
+	// values contains the set of seen tag values for each measurement and tag key.
+	values map[tagSeriesKey]map[string]struct{}
+	// exceeded contains the measurement and tag keys which have exceeded maxValues.
+	exceeded map[tagSeriesKey]struct{}
+}
+
+type tagSeriesKey struct {
+	measurement string
+	key         string
+}
+
+func newTagPromoter(opts TagOptions) *tagPromoter {
+	p := &tagPromoter{
+		keys:      make(map[string]struct{}, len(opts.Keys)),
+		splitKeys: make(map[string]struct{}, len(opts.SplitKeys)),
+		maxValues: opts.MaxValues,
+		values:    make(map[tagSeriesKey]map[string]struct{}),
+		exceeded:  make(map[tagSeriesKey]struct{}),
+	}
+	for _, key := range opts.Keys {
+		p.keys[key] = struct{}{}
+	}
+	for _, key := range opts.SplitKeys {
+		p.splitKeys[key] = struct{}{}
+	}
+	return p
+}
+
+// Promote returns the fields which should be written as tags for the given
+// measurement, and the remaining fields which should be written as fields.
+// Tags are sorted by key.
+func (p *tagPromoter) Promote(
+	measurement string, fields healthautoexport.DatapointFields,
+) ([]lp.Tag, healthautoexport.DatapointFields) {
+	if len(p.keys) == 0 || len(fields) == 0 {
+		return nil, fields
+	}
+
+	var tags []lp.Tag
+	remaining := make(healthautoexport.DatapointFields, len(fields))
+	for key, value := range fields {
+		tag, promoted, exceeded := p.promote(measurement, key, value)
+		switch {
+		case promoted:
+			tags = append(tags, tag)
+		case exceeded:
+			remaining[key+fallbackFieldSuffix] = value
+		default:
+			remaining[key] = value
+		}
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Key < tags[j].Key
+	})
+
+	return tags, remaining
+}
+
+// promote returns the tag for the field if it should be promoted. Otherwise,
+// exceeded is true if the field could not be promoted because its key has
+// exceeded the maximum number of distinct values.
+func (p *tagPromoter) promote(measurement, key string, value interface{}) (tag lp.Tag, promoted, exceeded bool) {
+	if _, ok := p.keys[key]; !ok {
+		return lp.Tag{}, false, false
+	}
+	str, ok := value.(string)
+	if !ok {
+		return lp.Tag{}, false, false
+	}
+	if _, ok := p.splitKeys[key]; ok {
+		str = normalizeMultiValue(str)
+	}
+	if str == "" {
+		return lp.Tag{}, false, false
+	}
+	if !p.admit(tagSeriesKey{measurement: measurement, key: key}, str) {
+		return lp.Tag{}, false, true
+	}
+	return lp.Tag{Key: key, Value: str}, true, false
+}
+
+// admit returns true if the value can be written as a tag without exceeding
+// the maximum number of distinct values.
+func (p *tagPromoter) admit(series tagSeriesKey, value string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	values, ok := p.values[series]
+	if !ok {
+		values = make(map[string]struct{})
+		p.values[series] = values
+	}
+	if _, ok := values[value]; ok {
+		return true
+	}
+	if p.maxValues > 0 && len(values) >= p.maxValues {
+		if _, ok := p.exceeded[series]; !ok {
+			p.exceeded[series] = struct{}{}
+			log.WithFields(log.Fields{
+				"measurement": series.measurement,
+				"tag":         series.key,
+				"field":       series.key + fallbackFieldSuffix,
+				"max_values":  p.maxValues,
+			}).Warn("too many distinct tag values, writing new values as fields instead")
+		}
+		return false
+	}
+	values[value] = struct{}{}
+	return true
+}
+
+// normalizeMultiValue splits a pipe-joined string into its values, and joins
+// the deduplicated and sorted values back together.
+func normalizeMultiValue(value string) string {
+	tokens := strings.Split(value, multiValueSeparator)
+	seen := make(map[string]struct{}, len(tokens))
+	values := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		if _, ok := seen[token]; ok {
+			continue
+		}
+		seen[token] = struct{}{}
+		values = append(values, token)
+	}
+	sort.Strings(values)
+	return strings.Join(values, multiValueSeparator)
+}
@@ -0,0 +1,95 @@
+package influxdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/influxdb"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+func makeHeartRateMetric(fields ...healthautoexport.DatapointFields) *healthautoexport.Metric {
+	metric := &healthautoexport.Metric{Name: "heart_rate", Units: "count/min"}
+	for i, f := range fields {
+		date := healthautoexport.NewTime(time.Unix(int64(1000+i), 0))
+		metric.Datapoints = append(metric.Datapoints, &healthautoexport.Datapoint{
+			Date:   &date,
+			Fields: f,
+		})
+	}
+	return metric
+}
+
+func TestBackend_Tags(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        influxdb.TagOptions
+		metric      *healthautoexport.Metric
+		wantMetrics []string
+	}{
+		{
+			name: "no tag keys",
+			metric: makeHeartRateMetric(
+				healthautoexport.DatapointFields{"Avg": 60.0, "source": "iPhone"},
+			),
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test Avg=60,source="iPhone" 1000000000000`,
+			},
+		},
+		{
+			name: "promote string fields",
+			opts: influxdb.TagOptions{Keys: []string{"source", "mealTime", "Avg"}},
+			metric: makeHeartRateMetric(
+				healthautoexport.DatapointFields{"Avg": 60.0, "source": "iPhone", "mealTime": "Before Meal"},
+			),
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test,mealTime=Before\ Meal,source=iPhone Avg=60 1000000000000`,
+			},
+		},
+		{
+			name: "split multiple values",
+			opts: influxdb.TagOptions{Keys: []string{"source"}, SplitKeys: []string{"source"}},
+			metric: makeHeartRateMetric(
+				healthautoexport.DatapointFields{"Avg": 60.0, "source": "iPhone|Apple Watch|iPhone"},
+				healthautoexport.DatapointFields{"Avg": 61.0, "source": " Apple Watch | iPhone "},
+			),
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test,source=Apple\ Watch|iPhone Avg=60 1000000000000`,
+				`heart_rate_count/min,target_name=test,source=Apple\ Watch|iPhone Avg=61 1001000000000`,
+			},
+		},
+		{
+			name: "fall back to fields when exceeding max values",
+			opts: influxdb.TagOptions{Keys: []string{"source"}, MaxValues: 2},
+			metric: makeHeartRateMetric(
+				healthautoexport.DatapointFields{"Avg": 60.0, "source": "A"},
+				healthautoexport.DatapointFields{"Avg": 61.0, "source": "B"},
+				healthautoexport.DatapointFields{"Avg": 62.0, "source": "C"},
+				healthautoexport.DatapointFields{"Avg": 63.0, "source": "A"},
+			),
+			wantMetrics: []string{
+				`heart_rate_count/min,target_name=test,source=A Avg=60 1000000000000`,
+				`heart_rate_count/min,target_name=test,source=B Avg=61 1001000000000`,
+				`heart_rate_count/min,target_name=test Avg=62,source_value="C" 1002000000000`,
+				`heart_rate_count/min,target_name=test,source=A Avg=63 1003000000000`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := influxdb.NewMockClient()
+			backend, err := influxdb.NewBackendWithOptions(client, influxdb.Options{Tags: tt.opts})
+			assert.NoError(t, err)
+			payload := &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Metrics: []*healthautoexport.Metric{tt.metric},
+				},
+			}
+			assert.NoError(t, backend.Write(payload, "test"))
+			assert.Equal(t, tt.wantMetrics, formatPoints(client.ReadMetrics()))
+		})
+	}
+}
@@ -1,23 +1,63 @@
 package influxdb
 
 import (
+	"fmt"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 )
 
-// CreateWorkoutStatistics returns additional fields for a Workout.
-func CreateWorkoutStatistics(workout *healthautoexport.Workout) healthautoexport.WorkoutFields {
+// CreateWorkoutStatistics returns additional fields for a Workout, which are
+// derived from the workout of the target using the analytics package.
+func CreateWorkoutStatistics(
+	workout *healthautoexport.Workout, target string, opts analytics.Options,
+) healthautoexport.WorkoutFields {
 	fields := make(healthautoexport.WorkoutFields, 0, 10)
-
-	// Compute duration of the workout.
-	if !workout.End.IsZero() && !workout.Start.IsZero() {
+	addField := func(key string, qty float64, units healthautoexport.Units) {
 		fields = append(fields, healthautoexport.Field{
-			Key: "duration",
+			Key: key,
 			Value: &healthautoexport.QtyWithUnit{
-				Qty:   healthautoexport.Qty(workout.End.Sub(workout.Start.Time).Minutes()),
-				Units: "min",
+				Qty:   healthautoexport.Qty(qty),
+				Units: units,
 			},
 		})
 	}
 
+	stats := analytics.ComputeWorkoutStatistics(workout, target, opts)
+
+	// Duration of the workout.
+	if stats.Duration != nil {
+		addField("duration", stats.Duration.Minutes(), "min")
+	}
+
+	// Heart rate statistics and time spent in each zone.
+	if hr := stats.HeartRate; hr != nil {
+		addField("avg_heart_rate", hr.Average, "bpm")
+		addField("max_heart_rate", hr.Max, "bpm")
+		for i, duration := range hr.Zones {
+			addField(fmt.Sprintf("heart_rate_zone_%v", i+1), duration.Minutes(), "min")
+		}
+	}
+
+	// Distance and speed from the route.
+	if stats.RouteDistance != nil {
+		addField("route_distance", *stats.RouteDistance/1000, "km")
+	}
+	if stats.AverageSpeed != nil {
+		addField("avg_speed", *stats.AverageSpeed*3.6, "km/hr")
+		addField("avg_pace", analytics.Pace(*stats.AverageSpeed), "min/km")
+	}
+
+	// Elevation from the route, which is only computed if not already in the workout.
+	if stats.ElevationGain != nil && stats.ElevationLoss != nil {
+		addField("elevation_ascent", *stats.ElevationGain, "m")
+		addField("elevation_descent", *stats.ElevationLoss, "m")
+	}
+
+	// Active energy burned per minute.
+	if stats.ActiveEnergyRate != nil {
+		addField("active_energy_rate", *stats.ActiveEnergyRate, "kcal/min")
+	}
+
 	return fields
 }
@@ -0,0 +1,106 @@
+package localfile
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	utilfile "github.com/irvinlim/apple-health-ingester/pkg/util/file"
+)
+
+// listFiles returns the names of files with the extension in the directory,
+// including files which are missing but have a backup generation that can be
+// recovered. Backups, sidecar files and temporary files are excluded.
+func listFiles(dirname, ext string) ([]string, error) {
+	entries, err := os.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if original, ok := utilfile.ParseBackupName(name); ok {
+			name = original
+		}
+		if !strings.HasSuffix(name, ext) || isSidecarName(name) || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// recoverFile decodes the file using decode. If the file is missing or cannot
+// be decoded, such as when it was truncated, each backup generation is tried
+// in order, and the file is restored from the first backup that can be decoded.
+func recoverFile(name string, decode func(name string) error) error {
+	err := decode(name)
+	if err == nil {
+		return nil
+	}
+
+	for gen := 1; ; gen++ {
+		backup := utilfile.BackupName(name, gen)
+		if _, statErr := os.Stat(backup); statErr != nil {
+			break
+		}
+		if decodeErr := decode(backup); decodeErr != nil {
+			log.WithError(decodeErr).Warnf("could not read backup %v", backup)
+			continue
+		}
+
+		// Restore the file from the backup, keeping the backup itself.
+		if restoreErr := restoreFile(name, backup); restoreErr != nil {
+			return errors.Wrapf(restoreErr, "cannot restore %v from %v", name, backup)
+		}
+		log.WithError(err).WithFields(log.Fields{
+			"file":   name,
+			"backup": backup,
+		}).Warn("restored file from backup")
+		return nil
+	}
+
+	return err
+}
+
+// restoreFile atomically replaces the file with a copy of the backup.
+func restoreFile(name, backup string) error {
+	src, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	return utilfile.WriteAtomic(name, 0, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+}
+
+// removeWithBackups removes the file and all of its backup generations.
+func removeWithBackups(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for gen := 1; fileExists(utilfile.BackupName(name, gen)); gen++ {
+		if err := os.Remove(utilfile.BackupName(name, gen)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -1,49 +1,181 @@
 package localfile
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"path"
+	"reflect"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
 	"github.com/irvinlim/apple-health-ingester/pkg/backends"
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	utilfile "github.com/irvinlim/apple-health-ingester/pkg/util/file"
+)
+
+const (
+	// eventsDir is the subdirectory of metricsPath that health events are written to.
+	eventsDir = "events"
+
+	// sleepDir is the subdirectory of metricsPath that sleep sessions are written to.
+	sleepDir = "sleep"
+
+	// workoutsDir is the subdirectory of metricsPath that workouts are written to.
+	workoutsDir = "workouts"
+)
+
+// Format is the storage format of metrics.
+type Format string
+
+const (
+	// FormatJSON stores each metric as a single JSON file, which is rewritten
+	// on every write.
+	FormatJSON Format = "json"
+
+	// FormatJSONL stores each metric as a directory of append-only segments in
+	// JSON Lines format (see JSONLStore).
+	FormatJSONL Format = "jsonl"
+
+	// FormatCSV stores each metric as a single CSV file, which is rewritten on
+	// every write.
+	FormatCSV Format = "csv"
+
+	// FormatParquet stores each metric as a single Apache Parquet file, which is
+	// rewritten on every write.
+	FormatParquet Format = "parquet"
 )
 
 var (
-	metricsPath string
+	metricsPath        string
+	format             string
+	segmentSize        int64
+	compactionInterval time.Duration
+	backups            int
+	partitionLayout    string
+	mergeKey           string
+	conflictPolicy     string
 )
 
 // Backend LocalFile is used to store ingested metrics in the local filesystem
-// as JSON files. It is not very performant as it would process all data at once
-// to produce a sorted JSON output file. As such, it should only be used for
-// debugging purposes.
-//
-// TODO(irvinlim): Handle workout data
+// as JSON files. In the default JSON format, it is not very performant as it
+// would process all data at once to produce a sorted JSON output file. The
+// JSONL format should be used instead to store large amounts of data, where
+// each write only appends the incoming data.
 type Backend struct {
-	metrics map[string]*MetricFile
-	mtx     sync.RWMutex
+	format    Format
+	encoder   Encoder
+	layout    *PartitionLayout
+	merge     MergeOptions
+	analytics analytics.Options
+	metrics   map[string]*MetricFile
+	stores    map[string]*JSONLStore
+	events    map[string]*EventFile
+	sleep     map[string]*SleepSessionFile
+	workouts  map[string]*WorkoutFile
+	mtx       sync.RWMutex
+
+	stop chan struct{}
+	wg   sync.WaitGroup
 }
 
 var _ backends.Backend = &Backend{}
 
 func NewBackend() (*Backend, error) {
-	backend := &Backend{}
+	backend := &Backend{
+		format: Format(format),
+		merge: MergeOptions{
+			Key:    MergeKey(mergeKey),
+			Policy: ConflictPolicy(conflictPolicy),
+		},
+		stop: make(chan struct{}),
+	}
 
 	// Load metrics
 	if metricsPath == "" {
 		return nil, errors.New("--localfile.metricsPath is not set")
 	}
-	metrics, err := backend.loadMetrics()
+	if err := backend.merge.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid --localfile.mergeKey or --localfile.conflictPolicy")
+	}
+	analyticsOptions, err := analytics.NewOptionsFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	backend.analytics = analyticsOptions
+	switch backend.format {
+	case FormatJSON, FormatCSV, FormatParquet:
+		backend.encoder, _ = NewEncoder(backend.format)
+		if partitionLayout != "" {
+			layout, err := ParsePartitionLayout(partitionLayout, backend.encoder.Extension())
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid --localfile.partitionLayout")
+			}
+			backend.layout = layout
+
+			// Partitions are read from disk when they are written to.
+			backend.metrics = make(map[string]*MetricFile)
+			break
+		}
+		metrics, err := backend.loadMetrics()
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load metrics from %v", metricsPath)
+		}
+		backend.metrics = metrics
+	case FormatJSONL:
+		if partitionLayout != "" {
+			return nil, fmt.Errorf("--localfile.partitionLayout is not supported by the %v format", FormatJSONL)
+		}
+		if !backend.merge.IsDefault() {
+			return nil, fmt.Errorf("--localfile.mergeKey and --localfile.conflictPolicy are not supported by the %v format",
+				FormatJSONL)
+		}
+		if segmentSize <= 0 {
+			return nil, errors.New("--localfile.segmentSize must be positive")
+		}
+		stores, err := backend.loadStores()
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load metrics from %v", metricsPath)
+		}
+		backend.stores = stores
+		if compactionInterval > 0 {
+			backend.wg.Add(1)
+			go backend.compactPeriodically(compactionInterval)
+		}
+	default:
+		return nil, fmt.Errorf("invalid --localfile.format %q, must be one of %v, %v, %v, %v",
+			format, FormatJSON, FormatJSONL, FormatCSV, FormatParquet)
+	}
+
+	// Load events
+	events, err := backend.loadEvents()
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot load metrics from %v", metricsPath)
+		return nil, errors.Wrapf(err, "cannot load events from %v", path.Join(metricsPath, eventsDir))
 	}
-	backend.metrics = metrics
+	backend.events = events
+
+	// Load sleep sessions
+	sleep, err := backend.loadSleepSessions()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load sleep sessions from %v", path.Join(metricsPath, sleepDir))
+	}
+	backend.sleep = sleep
+
+	// Load workouts
+	workouts, err := backend.loadWorkouts()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load workouts from %v", path.Join(metricsPath, workoutsDir))
+	}
+	backend.workouts = workouts
 
 	return backend, nil
 }
@@ -52,17 +184,62 @@ func (b *Backend) Name() string {
 	return "LocalFile"
 }
 
-// Write will take the incoming payload and merge the metrics with existing
-// metric data, before writing it back to the filesystem.
+// Close stops background compaction of the backend.
+func (b *Backend) Close() error {
+	close(b.stop)
+	b.wg.Wait()
+	return nil
+}
+
+// Write will take the incoming payload and merge the metrics and workouts with
+// existing data, before writing it back to the filesystem.
 func (b *Backend) Write(payload *healthautoexport.Payload, target string) error {
 	b.mtx.Lock()
 	defer b.mtx.Unlock()
 
+	// Properly handle nil data.
+	if payload == nil || payload.Data == nil {
+		log.WithFields(log.Fields{
+			"backend": b.Name(),
+			"target":  target,
+		}).Warn("empty payload data received, skipping")
+		return nil
+	}
+
 	// Handle metrics.
 	for _, metric := range payload.Data.Metrics {
 		if err := b.handleMetric(metric, target); err != nil {
 			return errors.Wrapf(err, "handle metric error for %v", metric.Name)
 		}
+		if len(metric.SleepAnalyses) > 0 {
+			if err := b.handleSleepSessions(metric.SleepAnalyses, target); err != nil {
+				return errors.Wrapf(err, "handle sleep sessions error for %v", metric.Name)
+			}
+		}
+	}
+
+	// Handle workouts.
+	for _, workout := range payload.Data.Workouts {
+		if err := b.handleWorkout(workout, target); err != nil {
+			return errors.Wrapf(err, "handle workout error for %v", workout.Name)
+		}
+	}
+
+	// Handle health events.
+	for _, events := range []struct {
+		Type string
+		Data interface{}
+	}{
+		{Type: "symptoms", Data: payload.Data.Symptoms},
+		{Type: "medications", Data: payload.Data.Medications},
+		{Type: "ecg", Data: payload.Data.ECG},
+		{Type: "heartRateNotifications", Data: payload.Data.HeartRateNotifications},
+		{Type: "stateOfMind", Data: payload.Data.StateOfMind},
+		{Type: "cycleTracking", Data: payload.Data.CycleTracking},
+	} {
+		if err := b.handleEvents(events.Type, events.Data, target); err != nil {
+			return errors.Wrapf(err, "handle events error for %v", events.Type)
+		}
 	}
 
 	return nil
@@ -72,48 +249,295 @@ func (b *Backend) handleMetric(metric *healthautoexport.Metric, target string) e
 	var metricFile MetricFile
 	metricFile.FromMetric(metric, target)
 	fileName := metricFile.GetFileName()
-	updatedData := metricFile.Data
+	if b.format == FormatJSONL {
+		return b.appendMetric(&metricFile)
+	}
+	if b.layout != nil {
+		return b.writePartitions(&metricFile)
+	}
 
-	// Merge with existing data if present
+	// Merge with existing data if present. Existing JSON files are migrated if
+	// metrics are stored in another format.
 	existing, ok := b.metrics[fileName]
-	if ok {
-		// Merge data points by timestamp
-		dataByTimestamp := make(map[healthautoexport.Time]*healthautoexport.Datapoint, len(existing.Data))
-		for _, datum := range existing.Data {
-			dataByTimestamp[*datum.Date] = datum
+	var legacyPath string
+	if !ok && b.format != FormatJSON {
+		legacyPath = path.Join(metricsPath, fileName)
+		legacy, err := b.loadLegacyMetricFile(legacyPath, jsonEncoder{})
+		if err != nil {
+			return err
+		}
+		existing, ok = legacy, legacy != nil
+	}
+	if !ok {
+		existing = &MetricFile{}
+	}
+	updated := existing.Merge(&metricFile, b.merge)
+
+	// Write back
+	metricFilePath := b.metricFilePath(fileName)
+	if err := b.writeMetric(metricFilePath, updated); err != nil {
+		return errors.Wrapf(err, "cannot write metrics to %v", metricFilePath)
+	}
+	b.metrics[fileName] = updated
+
+	if ok && legacyPath != "" {
+		return b.removeLegacyMetricFile(legacyPath, 1)
+	}
+	return nil
+}
+
+// metricFilePath returns the path of a metric file in the format of the backend.
+func (b *Backend) metricFilePath(fileName string) string {
+	return path.Join(metricsPath, strings.TrimSuffix(fileName, ".json")+b.encoder.Extension())
+}
+
+// loadLegacyMetricFile loads a metric file which is migrated to another layout
+// or format, or returns nil if it does not exist.
+func (b *Backend) loadLegacyMetricFile(name string, encoder Encoder) (*MetricFile, error) {
+	if !fileExists(name) && !fileExists(utilfile.BackupName(name, 1)) {
+		return nil, nil
+	}
+	metricFile, err := b.loadMetricFile(name, encoder)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load %v for migration", name)
+	}
+	return metricFile, nil
+}
+
+// removeLegacyMetricFile removes a metric file and its backups after it was
+// migrated to the given number of files.
+func (b *Backend) removeLegacyMetricFile(name string, files int) error {
+	for _, name := range []string{name, sidecarName(name)} {
+		if err := removeWithBackups(name); err != nil {
+			return errors.Wrapf(err, "cannot remove %v after migration", name)
+		}
+	}
+	log.WithFields(log.Fields{
+		"backend": b.Name(),
+		"file":    name,
+		"files":   files,
+	}).Info("migrated metric file")
+	return nil
+}
+
+// writePartitions merges the MetricFile with the partitions that it touches,
+// which are read from disk, and writes them back. If the metric was previously
+// written to a single file, the file is migrated to partitions and removed.
+func (b *Backend) writePartitions(metricFile *MetricFile) error {
+	legacyPath := b.metricFilePath(metricFile.GetFileName())
+	legacy, err := b.loadLegacyMetricFile(legacyPath, b.encoder)
+	if err != nil {
+		return err
+	}
+	if legacy != nil {
+		metricFile = legacy.Merge(metricFile, b.merge)
+	}
+
+	partitions := b.layout.Partition(metricFile)
+	names := make([]string, 0, len(partitions))
+	for name := range partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		partition := partitions[name]
+		partitionPath := path.Join(metricsPath, name)
+		existing, err := b.loadLegacyMetricFile(partitionPath, b.encoder)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			existing = &MetricFile{}
 		}
-		for _, datum := range metricFile.Data {
-			dataByTimestamp[*datum.Date] = datum
+		partition = existing.Merge(partition, b.merge)
+		if err := b.writeMetric(partitionPath, partition); err != nil {
+			return errors.Wrapf(err, "cannot write metrics to %v", partitionPath)
 		}
+	}
 
-		// Convert back to slice and sort
-		newData := make([]*healthautoexport.Datapoint, 0, len(dataByTimestamp))
-		for _, datapoint := range dataByTimestamp {
-			newData = append(newData, datapoint)
+	if legacy != nil {
+		return b.removeLegacyMetricFile(legacyPath, len(partitions))
+	}
+	return nil
+}
+
+// appendMetric appends the MetricFile to its JSONLStore, which is created if it
+// does not exist yet.
+func (b *Backend) appendMetric(metricFile *MetricFile) error {
+	fileName := metricFile.GetFileName()
+	store, ok := b.stores[fileName]
+	if !ok {
+		var err error
+		store, err = b.createStore(fileName)
+		if err != nil {
+			return err
 		}
-		sort.Slice(newData, func(i, j int) bool {
-			return newData[i].Date.Before(newData[j].Date.Time)
-		})
+		b.stores[fileName] = store
+	}
+	if err := store.Append(metricFile); err != nil {
+		return errors.Wrapf(err, "cannot append metrics to %v", store.dir)
+	}
+	return nil
+}
+
+// createStore creates a JSONLStore for the metric file name. Any existing data
+// in the JSON format is migrated to the JSONLStore.
+func (b *Backend) createStore(fileName string) (*JSONLStore, error) {
+	store, err := OpenJSONLStore(path.Join(metricsPath, strings.TrimSuffix(fileName, ".json")), segmentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	legacyPath := path.Join(metricsPath, fileName)
+	legacy, err := b.loadLegacyMetricFile(legacyPath, jsonEncoder{})
+	if err != nil || legacy == nil {
+		return store, err
+	}
+	if err := store.Append(legacy); err != nil {
+		return nil, errors.Wrapf(err, "cannot migrate %v", legacyPath)
+	}
+	if err := b.removeLegacyMetricFile(legacyPath, 1); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// compactPeriodically compacts all JSONLStores at every interval until the
+// backend is closed.
+func (b *Backend) compactPeriodically(interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.compact()
+		}
+	}
+}
+
+// compact compacts all JSONLStores. Each store is locked separately, so that
+// writes to other stores are not blocked.
+func (b *Backend) compact() {
+	b.mtx.RLock()
+	stores := make([]*JSONLStore, 0, len(b.stores))
+	for _, store := range b.stores {
+		stores = append(stores, store)
+	}
+	b.mtx.RUnlock()
+
+	startTime := time.Now()
+	for _, store := range stores {
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+		if err := store.Compact(); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"backend": b.Name(),
+				"store":   store.dir,
+			}).Error("compaction error")
+		}
+	}
+	log.WithFields(log.Fields{
+		"backend": b.Name(),
+		"stores":  len(stores),
+		"elapsed": time.Since(startTime),
+	}).Debug("compacted all stores")
+}
+
+func (b *Backend) handleEvents(eventType string, events interface{}, target string) error {
+	var eventFile EventFile
+	if err := eventFile.FromEvents(eventType, events, target); err != nil {
+		return errors.Wrapf(err, "cannot convert events")
+	}
+	if len(eventFile.Data) == 0 {
+		return nil
+	}
+	fileName := eventFile.GetFileName()
+
+	// Merge with existing data if present
+	if existing, ok := b.events[fileName]; ok {
+		incoming := eventFile.Data
+		eventFile.Data = existing.Data
+		if err := eventFile.MergeEvents(incoming); err != nil {
+			return errors.Wrapf(err, "cannot merge events")
+		}
+	}
 
-		// Store merged data
-		updatedData = newData
+	// Write back
+	eventFilePath := path.Join(metricsPath, eventsDir, fileName)
+	if err := b.writeMetricFile(eventFilePath, &eventFile); err != nil {
+		return errors.Wrapf(err, "cannot write events to %v", eventFilePath)
 	}
+	b.events[fileName] = &eventFile
 
-	// Update data
-	metricFile.Data = updatedData
+	return nil
+}
+
+// handleSleepSessions merges sleep analysis samples with existing samples, and
+// rebuilds the sleep sessions from them, so that nights which are split across
+// multiple exports are summarized as a single session.
+func (b *Backend) handleSleepSessions(samples []*healthautoexport.SleepAnalysis, target string) error {
+	sessionFile := SleepSessionFile{Target: target}
+	fileName := sessionFile.GetFileName()
+
+	// Merge with existing data if present
+	if existing, ok := b.sleep[fileName]; ok {
+		sessionFile.Data = existing.Data
+		sessionFile.Samples = existing.Samples
+	}
+	sessionFile.MergeSamples(samples)
 
 	// Write back
-	metricFilePath := path.Join(metricsPath, fileName)
-	if err := b.writeMetricFile(metricFilePath, &metricFile); err != nil {
-		return errors.Wrapf(err, "cannot write metrics to %v", metricFilePath)
+	sessionFilePath := path.Join(metricsPath, sleepDir, fileName)
+	if err := b.writeMetricFile(sessionFilePath, &sessionFile); err != nil {
+		return errors.Wrapf(err, "cannot write sleep sessions to %v", sessionFilePath)
+	}
+	b.sleep[fileName] = &sessionFile
+
+	return nil
+}
+
+func (b *Backend) handleWorkout(workout *healthautoexport.Workout, target string) error {
+	if workout.Start.IsZero() {
+		log.WithFields(log.Fields{
+			"backend": b.Name(),
+			"target":  target,
+			"workout": workout.Name,
+		}).Warn("workout has no start time, skipping")
+		return nil
+	}
+
+	workoutFile := WorkoutFile{Target: target, Workout: workout}
+	fileName := workoutFile.GetFileName()
+
+	// Merge with existing data if present
+	if existing, ok := b.workouts[fileName]; ok {
+		workoutFile.Workout = existing.Workout
+		workoutFile.MergeWorkout(workout)
 	}
+	workoutFile.ComputeStatistics(b.analytics)
+
+	// Write back
+	workoutFilePath := path.Join(metricsPath, workoutsDir, fileName)
+	if err := b.writeMetricFile(workoutFilePath, &workoutFile); err != nil {
+		return errors.Wrapf(err, "cannot write workout to %v", workoutFilePath)
+	}
+	b.workouts[fileName] = &workoutFile
 
 	return nil
 }
 
 func (b *Backend) loadMetrics() (map[string]*MetricFile, error) {
 	output := make(map[string]*MetricFile)
-	files, err := os.ReadDir(metricsPath)
+	ext := b.encoder.Extension()
+	files, err := listFiles(metricsPath, ext)
 	if err != nil {
 		// Directory doesn't exist, simply return empty map.
 		if os.IsNotExist(err) {
@@ -124,45 +548,170 @@ func (b *Backend) loadMetrics() (map[string]*MetricFile, error) {
 	}
 
 	for _, file := range files {
-		metricFilePath := path.Join(metricsPath, file.Name())
-		metricFile, err := b.loadMetricFile(metricFilePath)
+		metricFilePath := path.Join(metricsPath, file)
+		metricFile, err := b.loadMetricFile(metricFilePath, b.encoder)
 		if err != nil {
 			log.WithError(err).Warnf("could not read %v as metric file", metricFilePath)
 			continue
 		}
-		output[metricFile.GetFileName()] = metricFile
+		output[strings.TrimSuffix(file, ext)+".json"] = metricFile
 	}
 
 	return output, nil
 }
 
-func (b *Backend) loadMetricFile(name string) (*MetricFile, error) {
-	file, err := os.Open(name)
+// loadStores opens all JSONLStores in metricsPath, which only reads the index
+// of each store.
+func (b *Backend) loadStores() (map[string]*JSONLStore, error) {
+	output := make(map[string]*JSONLStore)
+	files, err := os.ReadDir(metricsPath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot open %v", name)
+		// Directory doesn't exist, simply return empty map.
+		if os.IsNotExist(err) {
+			return output, nil
+		}
+
+		return nil, errors.Wrapf(err, "cannot read dir")
 	}
-	defer func() {
-		_ = file.Close()
-	}()
 
-	var metricFile MetricFile
-	dec := jsoniter.NewDecoder(file)
-	if err := dec.Decode(&metricFile); err != nil {
-		return nil, err
+	for _, file := range files {
+		switch file.Name() {
+		case eventsDir, sleepDir, workoutsDir:
+			continue
+		}
+		if !file.IsDir() {
+			continue
+		}
+		storePath := path.Join(metricsPath, file.Name())
+		store, err := OpenJSONLStore(storePath, segmentSize)
+		if err != nil {
+			log.WithError(err).Warnf("could not open %v as jsonl store", storePath)
+			continue
+		}
+		if len(store.index.Segments) == 0 {
+			continue
+		}
+		output[file.Name()+".json"] = store
+	}
+
+	return output, nil
+}
+
+func (b *Backend) loadEvents() (map[string]*EventFile, error) {
+	output := make(map[string]*EventFile)
+	eventsPath := path.Join(metricsPath, eventsDir)
+	files, err := listFiles(eventsPath, ".json")
+	if err != nil {
+		// Directory doesn't exist, simply return empty map.
+		if os.IsNotExist(err) {
+			return output, nil
+		}
+
+		return nil, errors.Wrapf(err, "cannot read dir")
+	}
+
+	for _, file := range files {
+		eventFilePath := path.Join(eventsPath, file)
+		var eventFile EventFile
+		if err := b.loadFile(eventFilePath, &eventFile); err != nil {
+			log.WithError(err).Warnf("could not read %v as event file", eventFilePath)
+			continue
+		}
+		output[eventFile.GetFileName()] = &eventFile
 	}
 
-	return &metricFile, nil
+	return output, nil
 }
 
-func (b *Backend) writeMetricFile(name string, metricFile *MetricFile) error {
-	// Ensure directories exist
-	dirname := path.Dir(name)
-	if err := os.MkdirAll(dirname, 0755); err != nil {
-		return errors.Wrapf(err, "cannot makedirs for %v", dirname)
+func (b *Backend) loadSleepSessions() (map[string]*SleepSessionFile, error) {
+	output := make(map[string]*SleepSessionFile)
+	sleepPath := path.Join(metricsPath, sleepDir)
+	files, err := listFiles(sleepPath, ".json")
+	if err != nil {
+		// Directory doesn't exist, simply return empty map.
+		if os.IsNotExist(err) {
+			return output, nil
+		}
+
+		return nil, errors.Wrapf(err, "cannot read dir")
+	}
+
+	for _, file := range files {
+		sessionFilePath := path.Join(sleepPath, file)
+		var sessionFile SleepSessionFile
+		if err := b.loadFile(sessionFilePath, &sessionFile); err != nil {
+			log.WithError(err).Warnf("could not read %v as sleep session file", sessionFilePath)
+			continue
+		}
+		output[sessionFile.GetFileName()] = &sessionFile
 	}
 
-	// Write file
-	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	return output, nil
+}
+
+func (b *Backend) loadWorkouts() (map[string]*WorkoutFile, error) {
+	output := make(map[string]*WorkoutFile)
+	workoutsPath := path.Join(metricsPath, workoutsDir)
+	files, err := listFiles(workoutsPath, ".json")
+	if err != nil {
+		// Directory doesn't exist, simply return empty map.
+		if os.IsNotExist(err) {
+			return output, nil
+		}
+
+		return nil, errors.Wrapf(err, "cannot read dir")
+	}
+
+	for _, file := range files {
+		workoutFilePath := path.Join(workoutsPath, file)
+		var workoutFile WorkoutFile
+		if err := b.loadFile(workoutFilePath, &workoutFile); err != nil || workoutFile.Workout == nil {
+			log.WithError(err).Warnf("could not read %v as workout file", workoutFilePath)
+			continue
+		}
+		output[workoutFile.GetFileName()] = &workoutFile
+	}
+
+	return output, nil
+}
+
+// loadMetricFile decodes the metric file with the encoder, which is restored
+// from a backup if it cannot be decoded. The sidecar file is restored from the
+// same backup generation.
+func (b *Backend) loadMetricFile(name string, encoder Encoder) (*MetricFile, error) {
+	var metricFile *MetricFile
+	decoded := name
+	err := recoverFile(name, func(candidate string) error {
+		var err error
+		metricFile, err = decodeFile(encoder, candidate)
+		decoded = candidate
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := encoder.(SidecarEncoder); ok && decoded != name && fileExists(sidecarName(decoded)) {
+		if err := restoreFile(sidecarName(name), sidecarName(decoded)); err != nil {
+			return nil, errors.Wrapf(err, "cannot restore %v", sidecarName(name))
+		}
+	}
+	return metricFile, nil
+}
+
+// loadFile decodes the JSON file into v, which is restored from a backup if it
+// cannot be decoded.
+func (b *Backend) loadFile(name string, v interface{}) error {
+	return recoverFile(name, func(name string) error {
+		// Reset v in case a previous attempt partially decoded into it.
+		rv := reflect.ValueOf(v).Elem()
+		rv.Set(reflect.Zero(rv.Type()))
+		return b.decodeFile(name, v)
+	})
+}
+
+// decodeFile decodes the JSON file into v.
+func (b *Backend) decodeFile(name string, v interface{}) error {
+	file, err := os.Open(name)
 	if err != nil {
 		return errors.Wrapf(err, "cannot open %v", name)
 	}
@@ -170,14 +719,65 @@ func (b *Backend) writeMetricFile(name string, metricFile *MetricFile) error {
 		_ = file.Close()
 	}()
 
-	// Encode as JSON
-	enc := jsoniter.NewEncoder(file)
-	enc.SetIndent("", "  ")
-	return enc.Encode(metricFile)
+	dec := jsoniter.NewDecoder(file)
+	return dec.Decode(v)
+}
+
+// writeMetric encodes the MetricFile in the format of the backend and
+// atomically writes it to the file, keeping the previous version as a backup.
+// The sidecar file is written after the file, if the format uses one.
+func (b *Backend) writeMetric(name string, f *MetricFile) error {
+	encoder, ok := b.encoder.(SidecarEncoder)
+	if !ok {
+		return utilfile.WriteAtomic(name, backups, func(w io.Writer) error {
+			return b.encoder.Encode(w, f)
+		})
+	}
+	var sidecar bytes.Buffer
+	if err := utilfile.WriteAtomic(name, backups, func(w io.Writer) error {
+		return encoder.EncodeWithSidecar(w, &sidecar, f)
+	}); err != nil {
+		return err
+	}
+	return utilfile.WriteAtomic(sidecarName(name), backups, func(w io.Writer) error {
+		_, err := w.Write(sidecar.Bytes())
+		return err
+	})
+}
+
+// writeMetricFile encodes v as indented JSON and atomically writes it to the
+// file, keeping the previous version as a backup.
+func (b *Backend) writeMetricFile(name string, v interface{}) error {
+	return utilfile.WriteAtomic(name, backups, func(w io.Writer) error {
+		enc := jsoniter.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	})
 }
 
 func init() {
 	pflag.StringVar(&metricsPath, "localfile.metricsPath", "",
 		"Output path to write metrics, with one metric per file. All data will be aggregated by timestamp. "+
 			"Any existing data will be merged together.")
+	pflag.IntVar(&backups, "localfile.backups", 1,
+		"Number of previous versions of each file to keep as backups, "+
+			"which are used to recover files that cannot be read on startup. Use 0 to disable.")
+	pflag.StringVar(&partitionLayout, "localfile.partitionLayout", "",
+		"Layout of metric file paths relative to metricsPath, which partitions each metric by date, "+
+			"e.g. {target}/{metric}/{yyyy}/{mm}/{dd}.json. Supported placeholders: {target}, {metric}, {yyyy}, {mm}, {dd}, "+
+			"of which {target}, {metric} and {yyyy} are required. Dates are in UTC. The extension must match the format. Not supported by the jsonl format. "+
+			"By default, each metric is written to a single file.")
+	pflag.StringVar(&mergeKey, "localfile.mergeKey", string(MergeKeyTimestamp),
+		"Key of datapoints which are merged together. One of: timestamp, timestamp+source, timestamp+tags. "+
+			"Not supported by the jsonl format.")
+	pflag.StringVar(&conflictPolicy, "localfile.conflictPolicy", string(ConflictLastWriteWins),
+		"Policy to resolve datapoints with the same merge key in a single payload. "+
+			"One of: last-write-wins, keep-both, max, sum. Not supported by the jsonl format.")
+	pflag.StringVar(&format, "localfile.format", string(FormatJSON),
+		"Storage format of metrics. One of: json, jsonl, csv, parquet. "+
+			"The jsonl format appends incoming data to segments, which are compacted periodically.")
+	pflag.Int64Var(&segmentSize, "localfile.segmentSize", 64<<20,
+		"Maximum size in bytes of each segment in the jsonl format, after which a new segment is started.")
+	pflag.DurationVar(&compactionInterval, "localfile.compactionInterval", time.Hour,
+		"Interval to compact segments in the jsonl format, which removes duplicate data. Use 0 to disable.")
 }
@@ -0,0 +1,297 @@
+package localfile_test
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+// makeMetricFile returns a heart rate MetricFile, with a datapoint for each
+// timestamp in qtys in seconds.
+func makeMetricFile(qtys map[int64]float64) *localfile.MetricFile {
+	timestamps := make([]int64, 0, len(qtys))
+	for ts := range qtys {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+	f := &localfile.MetricFile{Name: "heart_rate", Target: "test", Units: "count/min"}
+	for _, ts := range timestamps {
+		date := healthautoexport.NewTime(time.Unix(ts, 0))
+		f.Data = append(f.Data, &healthautoexport.Datapoint{Date: &date, Qty: healthautoexport.Qty(qtys[ts])})
+	}
+	return f
+}
+
+func makeHeartRatePayload(qtys map[int64]float64) *healthautoexport.Payload {
+	f := makeMetricFile(qtys)
+	return &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{
+			Metrics: []*healthautoexport.Metric{
+				{Name: f.Name, Units: f.Units, Datapoints: f.Data},
+			},
+		},
+	}
+}
+
+func TestBackend_RecoverFromBackup(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, pflag.Set("localfile.metricsPath", dir))
+	assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+	assert.NoError(t, pflag.Set("localfile.backups", "2"))
+	assert.NoError(t, pflag.Set("localfile.partitionLayout", ""))
+
+	backend, err := localfile.NewBackend()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{1000: 60}), "test"))
+	assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{1001: 61}), "test"))
+	assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{1002: 62}), "test"))
+	assert.NoError(t, backend.Close())
+
+	// Previous versions are kept as backups, up to the configured number.
+	name := path.Join(dir, "test_heart_rate_count_min.json")
+	assert.FileExists(t, name+".bak.1")
+	assert.FileExists(t, name+".bak.2")
+	assert.NoFileExists(t, name+".bak.3")
+	want, err := os.ReadFile(name + ".bak.1")
+	assert.NoError(t, err)
+
+	// Simulate a truncated file.
+	assert.NoError(t, os.WriteFile(name, []byte(`{"name":"heart_rate","data":[{"da`), 0644))
+
+	// The file is restored from the most recent backup on startup.
+	backend, err = localfile.NewBackend()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		_ = backend.Close()
+	}()
+	got, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+
+	// Data from the backup is merged with subsequent writes.
+	assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{1003: 63}), "test"))
+	var metricFile localfile.MetricFile
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.NoError(t, jsoniter.Unmarshal(data, &metricFile))
+	qtys := make(map[int64]float64, len(metricFile.Data))
+	for _, datum := range metricFile.Data {
+		qtys[datum.Date.Unix()] = float64(datum.Qty)
+	}
+	assert.Equal(t, map[int64]float64{1000: 60, 1001: 61, 1003: 63}, qtys)
+
+	// Temporary files are not left behind.
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+}
+
+func TestBackend_Formats(t *testing.T) {
+	tests := []struct {
+		format  localfile.Format
+		ext     string
+		sidecar bool
+	}{
+		{format: localfile.FormatCSV, ext: ".csv", sidecar: true},
+		{format: localfile.FormatParquet, ext: ".parquet"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.format), func(t *testing.T) {
+			dir := t.TempDir()
+			assert.NoError(t, pflag.Set("localfile.metricsPath", dir))
+			assert.NoError(t, pflag.Set("localfile.backups", "0"))
+			assert.NoError(t, pflag.Set("localfile.partitionLayout", ""))
+			defer func() {
+				assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+			}()
+
+			// Write a JSON file before the format is changed.
+			assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+			backend, err := localfile.NewBackend()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{1000: 60, 1001: 61}), "test"))
+			assert.NoError(t, backend.Close())
+
+			// The JSON file is migrated on the next write.
+			assert.NoError(t, pflag.Set("localfile.format", string(tt.format)))
+			backend, err = localfile.NewBackend()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{1001: 71, 1002: 72}), "test"))
+			assert.NoError(t, backend.Close())
+			name := path.Join(dir, "test_heart_rate_count_min"+tt.ext)
+			assert.FileExists(t, name)
+			assert.NoFileExists(t, path.Join(dir, "test_heart_rate_count_min.json"))
+
+			// Data is merged by timestamp with existing data after a restart.
+			backend, err = localfile.NewBackend()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{1002: 82, 1003: 83}), "test"))
+			assert.NoError(t, backend.Close())
+
+			// Metadata is stored in a sidecar file, which is not loaded as a metric file.
+			if tt.sidecar {
+				assert.FileExists(t, name+".meta.json")
+				data, err := os.ReadFile(name)
+				if assert.NoError(t, err) {
+					assert.True(t, strings.HasPrefix(string(data), "date,qty\n"), string(data))
+				}
+			} else {
+				assert.NoFileExists(t, name+".meta.json")
+			}
+
+			metricFile, err := localfile.ReadMetricFile(name)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assertMetricFileEqual(t, makeMetricFile(map[int64]float64{1000: 60, 1001: 71, 1002: 82, 1003: 83}), metricFile)
+		})
+	}
+}
+
+func TestBackend_ReloadWorkouts(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, pflag.Set("localfile.metricsPath", dir))
+	assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+	assert.NoError(t, pflag.Set("localfile.backups", "0"))
+	assert.NoError(t, pflag.Set("localfile.partitionLayout", ""))
+
+	makePayload := func(route ...*healthautoexport.RouteDatapoint) *healthautoexport.Payload {
+		return &healthautoexport.Payload{
+			Data: &healthautoexport.PayloadData{
+				Workouts: []*healthautoexport.Workout{
+					{Name: "Outdoor Run", Start: mktime("2021-12-24 08:00:00 +0800"), Route: route},
+				},
+			},
+		}
+	}
+
+	backend, err := localfile.NewBackend()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, backend.Write(makePayload(makeRouteDatapoint("2021-12-24 08:00:00 +0800", 1.0)), "john"))
+	assert.NoError(t, backend.Close())
+
+	// Existing workouts are loaded on startup, and merged with re-exports.
+	backend, err = localfile.NewBackend()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, backend.Write(makePayload(makeRouteDatapoint("2021-12-24 08:00:01 +0800", 1.1)), "john"))
+	assert.NoError(t, backend.Close())
+
+	name := path.Join(dir, "workouts", "john_Outdoor_Run_20211224T000000Z.json")
+	data, err := os.ReadFile(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var workoutFile localfile.WorkoutFile
+	assert.NoError(t, jsoniter.Unmarshal(data, &workoutFile))
+	assert.Equal(t, "john", workoutFile.Target)
+	if assert.NotNil(t, workoutFile.Workout) && assert.Len(t, workoutFile.Workout.Route, 2) {
+		assert.Equal(t, 1.0, workoutFile.Workout.Route[0].Lat)
+		assert.Equal(t, 1.1, workoutFile.Workout.Route[1].Lat)
+	}
+}
+
+func TestBackend_ConflictPolicy(t *testing.T) {
+	makePayload := func(data ...*healthautoexport.Datapoint) *healthautoexport.Payload {
+		return &healthautoexport.Payload{
+			Data: &healthautoexport.PayloadData{
+				Metrics: []*healthautoexport.Metric{
+					{Name: "step_count", Units: "count", Datapoints: data},
+				},
+			},
+		}
+	}
+	payload := makePayload(
+		makeStepCount(1000, 10, healthautoexport.DatapointFields{"source": "iPhone"}),
+		makeStepCount(1000, 20, healthautoexport.DatapointFields{"source": "Watch"}),
+	)
+	revised := makePayload(makeStepCount(1000, 25, healthautoexport.DatapointFields{"source": "Watch"}))
+
+	tests := []struct {
+		policy localfile.ConflictPolicy
+		want   []string
+	}{
+		{
+			policy: localfile.ConflictLastWriteWins,
+			want:   []string{"1000 20 map[source:Watch]"},
+		},
+		{
+			policy: localfile.ConflictKeepBoth,
+			want:   []string{"1000 10 map[source:iPhone]", "1000 20 map[source:Watch]"},
+		},
+		{
+			policy: localfile.ConflictMax,
+			want:   []string{"1000 20 map[source:Watch]"},
+		},
+		{
+			policy: localfile.ConflictSum,
+			want:   []string{"1000 30 map[source:Watch]"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			assert.NoError(t, pflag.Set("localfile.metricsPath", dir))
+			assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+			assert.NoError(t, pflag.Set("localfile.backups", "0"))
+			assert.NoError(t, pflag.Set("localfile.partitionLayout", ""))
+			assert.NoError(t, pflag.Set("localfile.conflictPolicy", string(tt.policy)))
+			defer func() {
+				assert.NoError(t, pflag.Set("localfile.conflictPolicy", string(localfile.ConflictLastWriteWins)))
+			}()
+
+			backend, err := localfile.NewBackend()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer backend.Close()
+			read := func() []string {
+				var metricFile localfile.MetricFile
+				data, err := os.ReadFile(path.Join(dir, "test_step_count_count.json"))
+				if !assert.NoError(t, err) {
+					return nil
+				}
+				assert.NoError(t, jsoniter.Unmarshal(data, &metricFile))
+				return formatDatapoints(metricFile.Data)
+			}
+
+			// Conflicts are resolved within the payload, so that exporting the
+			// same payload again does not change the result.
+			assert.NoError(t, backend.Write(payload, "test"))
+			assert.Equal(t, tt.want, read())
+			assert.NoError(t, backend.Write(payload, "test"))
+			assert.Equal(t, tt.want, read())
+
+			// Revised datapoints replace existing datapoints.
+			assert.NoError(t, backend.Write(revised, "test"))
+			assert.Equal(t, []string{"1000 25 map[source:Watch]"}, read())
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package localfile
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+// csvMetadata is the metadata of a CSV file, which is stored in a sidecar file.
+type csvMetadata struct {
+	Name   string                 `json:"name"`
+	Target string                 `json:"target"`
+	Units  healthautoexport.Units `json:"units"`
+
+	// Strings are the columns which only contain strings.
+	Strings []string `json:"strings"`
+}
+
+// csvEncoder encodes a MetricFile as CSV, with a header row followed by one row
+// per datapoint or sleep analysis. Times are formatted as RFC 3339, and nested
+// fields as JSON.
+//
+// The name, target and units are stored in a sidecar file as JSON, together
+// with the columns which only contain strings, so that the CSV file can be
+// loaded directly by other tools. Values of string columns are written as-is,
+// while strings in columns of mixed types are quoted as JSON, so that they are
+// not parsed as other types.
+type csvEncoder struct{}
+
+var _ SidecarEncoder = csvEncoder{}
+
+func (csvEncoder) Extension() string {
+	return ".csv"
+}
+
+func (e csvEncoder) Encode(w io.Writer, f *MetricFile) error {
+	return e.EncodeWithSidecar(w, io.Discard, f)
+}
+
+func (csvEncoder) EncodeWithSidecar(w, sidecar io.Writer, f *MetricFile) error {
+	table, err := newMetricTable(f)
+	if err != nil {
+		return err
+	}
+	stringColumns := table.stringColumns()
+
+	isString := make(map[string]bool, len(stringColumns))
+	for _, column := range stringColumns {
+		isString[column] = true
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(table.columns); err != nil {
+		return err
+	}
+	record := make([]string, len(table.columns))
+	for _, row := range table.rows {
+		for i, column := range table.columns {
+			if isString[column] {
+				record[i], _ = row[column].(string)
+				continue
+			}
+			record[i], err = formatCSVValue(row[column])
+			if err != nil {
+				return errors.Wrapf(err, "cannot format %v", column)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	enc := jsoniter.NewEncoder(sidecar)
+	enc.SetIndent("", "  ")
+	return enc.Encode(csvMetadata{
+		Name:    f.Name,
+		Target:  f.Target,
+		Units:   f.Units,
+		Strings: stringColumns,
+	})
+}
+
+func (e csvEncoder) Decode(data []byte) (*MetricFile, error) {
+	return e.DecodeWithSidecar(data, nil)
+}
+
+func (csvEncoder) DecodeWithSidecar(data, sidecar []byte) (*MetricFile, error) {
+	// Files written by older versions do not have a sidecar file, in which
+	// case the type of each value is inferred from the value.
+	var metadata csvMetadata
+	if sidecar != nil {
+		if err := jsoniter.Unmarshal(sidecar, &metadata); err != nil {
+			return nil, errors.Wrapf(err, "cannot parse sidecar")
+		}
+	}
+	isString := make(map[string]bool, len(metadata.Strings))
+	for _, column := range metadata.Strings {
+		isString[column] = true
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing header")
+	}
+
+	table := &metricTable{columns: records[0]}
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(record))
+		for i, value := range record {
+			if value == "" {
+				continue
+			}
+			column := table.columns[i]
+			switch {
+			case isString[column]:
+				row[column] = value
+			case timeColumns[column]:
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "cannot parse %v", column)
+				}
+				row[column] = t
+			default:
+				row[column] = parseCSVValue(value)
+			}
+		}
+		table.rows = append(table.rows, row)
+	}
+	metricFile, err := table.metricFile()
+	if err != nil {
+		return nil, err
+	}
+	metricFile.Name, metricFile.Target, metricFile.Units = metadata.Name, metadata.Target, metadata.Units
+	return metricFile, nil
+}
+
+func formatCSVValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return jsoniter.MarshalToString(value)
+}
+
+// parseCSVValue parses a value formatted by formatCSVValue, where the type is
+// inferred from the value.
+func parseCSVValue(value string) interface{} {
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	}
+	if b, err := strconv.ParseBool(value); err == nil && (value == "true" || value == "false") {
+		return b
+	}
+	if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") || strings.HasPrefix(value, `"`) {
+		var v interface{}
+		if err := jsoniter.UnmarshalFromString(value, &v); err == nil {
+			return v
+		}
+	}
+	return value
+}
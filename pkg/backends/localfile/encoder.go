@@ -0,0 +1,313 @@
+package localfile
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	utilfile "github.com/irvinlim/apple-health-ingester/pkg/util/file"
+)
+
+// Encoder encodes and decodes a MetricFile in a file format.
+type Encoder interface {
+	// Extension returns the file extension of the format, e.g. ".json".
+	Extension() string
+
+	// Encode writes the MetricFile to w.
+	Encode(w io.Writer, f *MetricFile) error
+
+	// Decode reads a MetricFile from data. The name, target and units may be
+	// empty if they are not stored by the format.
+	Decode(data []byte) (*MetricFile, error)
+}
+
+// SidecarEncoder is implemented by an Encoder which stores the metadata of a
+// MetricFile, such as the name, in a sidecar file next to each file, so that
+// the file itself only contains data.
+type SidecarEncoder interface {
+	Encoder
+
+	// EncodeWithSidecar writes the data of the MetricFile to w, and its
+	// metadata to sidecar.
+	EncodeWithSidecar(w, sidecar io.Writer, f *MetricFile) error
+
+	// DecodeWithSidecar reads a MetricFile from data and the metadata in
+	// sidecar, which is nil if there is no sidecar file.
+	DecodeWithSidecar(data, sidecar []byte) (*MetricFile, error)
+}
+
+// sidecarExt is the extension of sidecar files, which is appended to the name
+// of the file that it belongs to, e.g. heart_rate_count_min.csv.meta.json.
+const sidecarExt = ".meta.json"
+
+// sidecarName returns the name of the sidecar file of a file. The sidecar of a
+// backup generation is the same generation of the sidecar file.
+func sidecarName(name string) string {
+	if original, ok := utilfile.ParseBackupName(name); ok {
+		return original + sidecarExt + name[len(original):]
+	}
+	return name + sidecarExt
+}
+
+// isSidecarName returns true if the name is of a sidecar file.
+func isSidecarName(name string) bool {
+	if original, ok := utilfile.ParseBackupName(name); ok {
+		name = original
+	}
+	return strings.HasSuffix(name, sidecarExt)
+}
+
+// decodeFile decodes the file with the encoder, including its sidecar file if
+// the encoder uses one.
+func decodeFile(encoder Encoder, name string) (*MetricFile, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open %v", name)
+	}
+	sidecarEncoder, ok := encoder.(SidecarEncoder)
+	if !ok {
+		return encoder.Decode(data)
+	}
+	sidecar, err := os.ReadFile(sidecarName(name))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "cannot open %v", sidecarName(name))
+	}
+	return sidecarEncoder.DecodeWithSidecar(data, sidecar)
+}
+
+// NewEncoder returns the Encoder of a format which stores each metric as a
+// single file, or false if there is none.
+func NewEncoder(format Format) (Encoder, bool) {
+	switch format {
+	case FormatJSON:
+		return jsonEncoder{}, true
+	case FormatCSV:
+		return csvEncoder{}, true
+	case FormatParquet:
+		return parquetEncoder{}, true
+	}
+	return nil, false
+}
+
+// jsonEncoder encodes a MetricFile as indented JSON.
+type jsonEncoder struct{}
+
+var _ Encoder = jsonEncoder{}
+
+func (jsonEncoder) Extension() string {
+	return ".json"
+}
+
+func (jsonEncoder) Encode(w io.Writer, f *MetricFile) error {
+	enc := jsoniter.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+func (jsonEncoder) Decode(data []byte) (*MetricFile, error) {
+	var metricFile MetricFile
+	if err := jsoniter.Unmarshal(data, &metricFile); err != nil {
+		return nil, err
+	}
+
+	// Sleep analyses may be stored in data with the same shape as exported by
+	// Health Auto Export, which is parsed the same way as the metric itself.
+	if metricFile.Name == healthautoexport.SleepAnalysisName && len(metricFile.Data) > 0 &&
+		len(metricFile.SleepAnalyses) == 0 && len(metricFile.AggregatedSleepAnalyses) == 0 {
+		var metric healthautoexport.Metric
+		if err := jsoniter.Unmarshal(data, &metric); err != nil {
+			return nil, err
+		}
+		if len(metric.SleepAnalyses) > 0 || len(metric.AggregatedSleepAnalyses) > 0 {
+			metricFile.FromMetric(&metric, metricFile.Target)
+		}
+	}
+
+	return &metricFile, nil
+}
+
+const (
+	dateColumn       = "date"
+	startDateColumn  = "startDate"
+	sleepStartColumn = "sleepStart"
+	inBedStartColumn = "inBedStart"
+)
+
+var (
+	// leadingColumns are ordered before other columns of a metricTable, which
+	// are sorted by name.
+	leadingColumns = []string{
+		dateColumn, startDateColumn, "endDate", sleepStartColumn, "sleepEnd", inBedStartColumn, "inBedEnd", "qty",
+	}
+
+	// timeColumns contain a time.Time in each row of a metricTable.
+	timeColumns = map[string]bool{
+		dateColumn: true, startDateColumn: true, "endDate": true,
+		sleepStartColumn: true, "sleepEnd": true, inBedStartColumn: true, "inBedEnd": true,
+	}
+)
+
+// metricTable is a tabular representation of a MetricFile for columnar formats,
+// with one row per datapoint or sleep analysis and one column per field seen.
+// Values are either a time.Time for time columns, or have the same type as when
+// decoded from JSON.
+type metricTable struct {
+	columns []string
+	rows    []map[string]interface{}
+}
+
+// newMetricTable converts the MetricFile to a metricTable.
+func newMetricTable(f *MetricFile) (*metricTable, error) {
+	table := &metricTable{}
+	addRow := func(entry interface{}, times map[string]*healthautoexport.Time) error {
+		data, err := jsoniter.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		row := make(map[string]interface{})
+		if err := jsoniter.Unmarshal(data, &row); err != nil {
+			return err
+		}
+		for column, t := range times {
+			if t.IsZero() {
+				delete(row, column)
+				continue
+			}
+			row[column] = t.Time
+		}
+		for column, value := range row {
+			if value == nil {
+				delete(row, column)
+			}
+		}
+		table.rows = append(table.rows, row)
+		return nil
+	}
+
+	for _, datum := range f.Data {
+		if err := addRow(datum, map[string]*healthautoexport.Time{dateColumn: datum.Date}); err != nil {
+			return nil, errors.Wrapf(err, "cannot convert datapoint")
+		}
+	}
+	for _, a := range f.SleepAnalyses {
+		times := map[string]*healthautoexport.Time{startDateColumn: a.StartDate, "endDate": a.EndDate}
+		if err := addRow(a, times); err != nil {
+			return nil, errors.Wrapf(err, "cannot convert sleep analysis")
+		}
+	}
+	for _, a := range f.AggregatedSleepAnalyses {
+		times := map[string]*healthautoexport.Time{
+			sleepStartColumn: a.SleepStart, "sleepEnd": a.SleepEnd, inBedStartColumn: a.InBedStart, "inBedEnd": a.InBedEnd,
+		}
+		if err := addRow(a, times); err != nil {
+			return nil, errors.Wrapf(err, "cannot convert aggregated sleep analysis")
+		}
+	}
+
+	// Determine columns from the fields seen.
+	seen := make(map[string]bool)
+	for _, row := range table.rows {
+		for column := range row {
+			seen[column] = true
+		}
+	}
+	var others []string
+	for column := range seen {
+		others = append(others, column)
+	}
+	sort.Strings(others)
+	for _, column := range leadingColumns {
+		if seen[column] || (column == dateColumn && len(seen) == 0) {
+			table.columns = append(table.columns, column)
+		}
+	}
+	for _, column := range others {
+		if !isLeadingColumn(column) {
+			table.columns = append(table.columns, column)
+		}
+	}
+
+	return table, nil
+}
+
+// metricFile converts the metricTable back to a MetricFile. Each row is decoded
+// as a datapoint, sleep analysis or aggregated sleep analysis depending on its
+// time columns.
+func (t *metricTable) metricFile() (*MetricFile, error) {
+	f := &MetricFile{}
+	for i, row := range t.rows {
+		entry := make(map[string]interface{}, len(row))
+		for column, value := range row {
+			if ts, ok := value.(time.Time); ok {
+				value = healthautoexport.NewTime(ts)
+			}
+			entry[column] = value
+		}
+		data, err := jsoniter.Marshal(entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot convert row %v", i)
+		}
+
+		switch {
+		case entry[dateColumn] != nil:
+			var datum healthautoexport.Datapoint
+			err = jsoniter.Unmarshal(data, &datum)
+			f.Data = append(f.Data, &datum)
+		case entry[startDateColumn] != nil:
+			var analysis healthautoexport.SleepAnalysis
+			err = jsoniter.Unmarshal(data, &analysis)
+			f.SleepAnalyses = append(f.SleepAnalyses, &analysis)
+		case entry[sleepStartColumn] != nil || entry[inBedStartColumn] != nil:
+			var analysis healthautoexport.AggregatedSleepAnalysis
+			err = jsoniter.Unmarshal(data, &analysis)
+			f.AggregatedSleepAnalyses = append(f.AggregatedSleepAnalyses, &analysis)
+		default:
+			err = errors.New("missing time")
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot convert row %v", i)
+		}
+	}
+	return f, nil
+}
+
+// stringColumns returns the columns which only contain strings.
+func (t *metricTable) stringColumns() []string {
+	columns := []string{}
+	for _, column := range t.columns {
+		if timeColumns[column] {
+			continue
+		}
+		isString, seen := true, false
+		for _, row := range t.rows {
+			value, ok := row[column]
+			if !ok {
+				continue
+			}
+			seen = true
+			if _, ok := value.(string); !ok {
+				isString = false
+				break
+			}
+		}
+		if seen && isString {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+func isLeadingColumn(column string) bool {
+	for _, c := range leadingColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
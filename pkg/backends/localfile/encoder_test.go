@@ -0,0 +1,280 @@
+package localfile_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+func newTime(ts int64) *healthautoexport.Time {
+	t := healthautoexport.NewTime(time.Unix(ts, 0).In(time.FixedZone("SGT", 8*60*60)))
+	return &t
+}
+
+func TestEncoder(t *testing.T) {
+	heartRate := &localfile.MetricFile{
+		Name:   "heart_rate",
+		Target: "test",
+		Units:  "count/min",
+		Data: []*healthautoexport.Datapoint{
+			{
+				Date:   newTime(1000),
+				Fields: healthautoexport.DatapointFields{"Min": 60.0, "Avg": 65.5, "Max": 71.0, "source": "Watch"},
+			},
+			{
+				Date:   newTime(1060),
+				Fields: healthautoexport.DatapointFields{"Min": 62.0, "Avg": 64.0, "Max": 66.0},
+			},
+		},
+	}
+	stepCount := &localfile.MetricFile{
+		Name:  "step_count",
+		Units: "count",
+		Data: []*healthautoexport.Datapoint{
+			{Date: newTime(1000), Qty: 100},
+			{Date: newTime(1060), Qty: 25.5, Fields: healthautoexport.DatapointFields{"extra": map[string]interface{}{"a": 1.0}}},
+		},
+	}
+	numericStrings := &localfile.MetricFile{
+		Name:   "heart_rate",
+		Target: "test",
+		Units:  "count/min",
+		Data: []*healthautoexport.Datapoint{
+			{Date: newTime(1000), Qty: 60, Fields: healthautoexport.DatapointFields{"source": "1", "context": "true"}},
+			{Date: newTime(1060), Qty: 62, Fields: healthautoexport.DatapointFields{"source": "2", "context": 1.5}},
+		},
+	}
+	sleepAnalysis := &localfile.MetricFile{
+		Name:  healthautoexport.SleepAnalysisName,
+		Units: "hr",
+		SleepAnalyses: []*healthautoexport.SleepAnalysis{
+			{StartDate: newTime(1000), EndDate: newTime(2800), Qty: 0.5, Source: "Watch", Value: "Core"},
+		},
+		AggregatedSleepAnalyses: []*healthautoexport.AggregatedSleepAnalysis{
+			{SleepStart: newTime(1000), SleepEnd: newTime(4600), Asleep: 1, InBed: 1.25, Source: "Watch"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		format      localfile.Format
+		ext         string
+		file        *localfile.MetricFile
+		want        string
+		wantSidecar string
+		wantErr     assert.ErrorAssertionFunc
+	}{
+		{
+			name:   "csv",
+			format: localfile.FormatCSV,
+			ext:    ".csv",
+			file:   heartRate,
+			want: "date,Avg,Max,Min,source\n" +
+				"1970-01-01T08:16:40+08:00,65.5,71,60,Watch\n" +
+				"1970-01-01T08:17:40+08:00,64,66,62,\n",
+			wantSidecar: `{
+  "name": "heart_rate",
+  "target": "test",
+  "units": "count/min",
+  "strings": [
+    "source"
+  ]
+}
+`,
+		},
+		{
+			name:   "csv with qty and nested fields",
+			format: localfile.FormatCSV,
+			ext:    ".csv",
+			file:   stepCount,
+			want: "date,qty,extra\n" +
+				"1970-01-01T08:16:40+08:00,100,\n" +
+				`1970-01-01T08:17:40+08:00,25.5,"{""a"":1}"` + "\n",
+		},
+		{
+			name:   "csv with strings that look like other types",
+			format: localfile.FormatCSV,
+			ext:    ".csv",
+			file:   numericStrings,
+			want: "date,qty,context,source\n" +
+				`1970-01-01T08:16:40+08:00,60,"""true""",1` + "\n" +
+				"1970-01-01T08:17:40+08:00,62,1.5,2\n",
+		},
+		{
+			name:   "csv with sleep analyses",
+			format: localfile.FormatCSV,
+			ext:    ".csv",
+			file:   sleepAnalysis,
+		},
+		{
+			name:   "parquet",
+			format: localfile.FormatParquet,
+			ext:    ".parquet",
+			file:   heartRate,
+		},
+		{
+			name:   "parquet with qty and nested fields",
+			format: localfile.FormatParquet,
+			ext:    ".parquet",
+			file:   stepCount,
+		},
+		{
+			name:   "parquet with sleep analyses",
+			format: localfile.FormatParquet,
+			ext:    ".parquet",
+			file:   sleepAnalysis,
+		},
+		{
+			name:   "parquet without data",
+			format: localfile.FormatParquet,
+			ext:    ".parquet",
+			file:   &localfile.MetricFile{Name: "step_count", Units: "count"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			encoder, ok := localfile.NewEncoder(tt.format)
+			if !assert.True(t, ok) {
+				return
+			}
+			assert.Equal(t, tt.ext, encoder.Extension())
+
+			// The metadata of some formats is stored in a sidecar file.
+			var buf, sidecar bytes.Buffer
+			decode := encoder.Decode
+			if sidecarEncoder, ok := encoder.(localfile.SidecarEncoder); ok {
+				if !assert.NoError(t, sidecarEncoder.EncodeWithSidecar(&buf, &sidecar, tt.file)) {
+					return
+				}
+				decode = func(data []byte) (*localfile.MetricFile, error) {
+					return sidecarEncoder.DecodeWithSidecar(data, sidecar.Bytes())
+				}
+			} else if !assert.NoError(t, encoder.Encode(&buf, tt.file)) {
+				return
+			}
+			if tt.want != "" {
+				assert.Equal(t, tt.want, buf.String())
+			}
+			if tt.wantSidecar != "" {
+				assert.Equal(t, tt.wantSidecar, sidecar.String())
+			}
+
+			got, err := decode(buf.Bytes())
+			if !assert.NoError(t, err) {
+				return
+			}
+			assertMetricFileEqual(t, tt.file, got)
+		})
+	}
+}
+
+// assertMetricFileEqual asserts that the data of both MetricFiles are equal.
+// Times are compared as instants, since the time zone is not stored by all
+// formats.
+func assertMetricFileEqual(t *testing.T, want, got *localfile.MetricFile) {
+	assert.Equal(t, want.Name, got.Name)
+	assert.Equal(t, want.Target, got.Target)
+	assert.Equal(t, want.Units, got.Units)
+	if assert.Len(t, got.Data, len(want.Data)) {
+		for i, datum := range want.Data {
+			assert.True(t, datum.Date.Equal(got.Data[i].Date.Time))
+			assert.Equal(t, datum.Qty, got.Data[i].Qty)
+			assert.Equal(t, len(datum.Fields), len(got.Data[i].Fields))
+			for key, value := range datum.Fields {
+				assert.Equal(t, value, got.Data[i].Fields[key], key)
+			}
+		}
+	}
+	if assert.Len(t, got.SleepAnalyses, len(want.SleepAnalyses)) {
+		for i, a := range want.SleepAnalyses {
+			b := *got.SleepAnalyses[i]
+			assert.True(t, a.StartDate.Equal(b.StartDate.Time))
+			assert.True(t, a.EndDate.Equal(b.EndDate.Time))
+			b.StartDate, b.EndDate = a.StartDate, a.EndDate
+			assert.Equal(t, *a, b)
+		}
+	}
+	if assert.Len(t, got.AggregatedSleepAnalyses, len(want.AggregatedSleepAnalyses)) {
+		for i, a := range want.AggregatedSleepAnalyses {
+			b := *got.AggregatedSleepAnalyses[i]
+			assert.True(t, a.SleepStart.Equal(b.SleepStart.Time))
+			assert.True(t, a.SleepEnd.Equal(b.SleepEnd.Time))
+			b.SleepStart, b.SleepEnd = a.SleepStart, a.SleepEnd
+			assert.Equal(t, *a, b)
+		}
+	}
+}
+
+func TestJSONEncoder_DecodeLegacySleepAnalysis(t *testing.T) {
+	// Older versions stored sleep analyses in data, in the same shape as
+	// exported by Health Auto Export.
+	data := []byte(`{
+  "name": "sleep_analysis",
+  "target": "test",
+  "units": "hr",
+  "data": [
+    {
+      "startDate": "2021-12-17 23:00:00 +0800",
+      "endDate": "2021-12-18 03:00:00 +0800",
+      "qty": 4,
+      "source": "Watch",
+      "value": "Core"
+    },
+    {
+      "startDate": "2021-12-18 03:00:00 +0800",
+      "endDate": "2021-12-18 07:00:00 +0800",
+      "qty": 4,
+      "source": "Watch",
+      "value": "Deep"
+    }
+  ]
+}`)
+	encoder, _ := localfile.NewEncoder(localfile.FormatJSON)
+	metricFile, err := encoder.Decode(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, healthautoexport.SleepAnalysisName, metricFile.Name)
+	assert.Equal(t, "test", metricFile.Target)
+	assert.Empty(t, metricFile.Data)
+	assert.Empty(t, metricFile.AggregatedSleepAnalyses)
+	if assert.Len(t, metricFile.SleepAnalyses, 2) {
+		assert.Equal(t, "Core", metricFile.SleepAnalyses[0].Value)
+		assert.Equal(t, "Deep", metricFile.SleepAnalyses[1].Value)
+		assert.Equal(t, "Watch", metricFile.SleepAnalyses[1].Source)
+		assert.True(t, metricFile.SleepAnalyses[1].EndDate.Equal(time.Date(2021, 12, 17, 23, 0, 0, 0, time.UTC)))
+	}
+
+	// Decoding the re-encoded file does not use the fallback.
+	var buf bytes.Buffer
+	assert.NoError(t, encoder.Encode(&buf, metricFile))
+	decoded, err := encoder.Decode(buf.Bytes())
+	if assert.NoError(t, err) {
+		assert.Len(t, decoded.SleepAnalyses, 2)
+		assert.Empty(t, decoded.Data)
+	}
+}
+
+func TestCSVEncoder_DecodeWithoutMetadata(t *testing.T) {
+	// Older versions did not write a sidecar file, so the type of each value is
+	// inferred from the value.
+	data := []byte("date,qty,source\n" +
+		"1970-01-01T08:16:40+08:00,60,Watch\n" +
+		"1970-01-01T08:17:40+08:00,62,1\n")
+	encoder, _ := localfile.NewEncoder(localfile.FormatCSV)
+	metricFile, err := encoder.Decode(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, metricFile.Name)
+	if assert.Len(t, metricFile.Data, 2) {
+		assert.Equal(t, "Watch", metricFile.Data[0].Fields["source"])
+		assert.Equal(t, 1.0, metricFile.Data[1].Fields["source"])
+	}
+}
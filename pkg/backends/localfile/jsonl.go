@@ -0,0 +1,543 @@
+package localfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	utilfile "github.com/irvinlim/apple-health-ingester/pkg/util/file"
+)
+
+const (
+	// indexFileName is the name of the index file in each JSONLStore directory.
+	indexFileName = "index.json"
+
+	// segmentExt is the file extension of segments.
+	segmentExt = ".jsonl"
+
+	// maxLineSize is the maximum size of a single line in a segment.
+	maxLineSize = 16 << 20
+)
+
+// Streams of a JSONLStore, which correspond to the fields of a MetricFile.
+// Each segment contains entries of a single stream.
+const (
+	streamData                    = "data"
+	streamSleepAnalyses           = "sleepAnalyses"
+	streamAggregatedSleepAnalyses = "aggregatedSleepAnalyses"
+)
+
+// SegmentInfo describes a single segment in a JSONLStore.
+type SegmentInfo struct {
+	// File is the file name of the segment, relative to the store directory.
+	File string `json:"file"`
+	// Stream is the type of entries in the segment.
+	Stream string `json:"stream"`
+	// Sequence is used to order segments, where entries in later segments
+	// replace entries with the same key in earlier segments.
+	Sequence int `json:"sequence"`
+	// Count is the number of entries in the segment.
+	Count int `json:"count"`
+	// Bytes is the size of the segment.
+	Bytes int64 `json:"bytes"`
+	// Start and End are the earliest and latest timestamps of entries.
+	Start *healthautoexport.Time `json:"start,omitempty"`
+	End   *healthautoexport.Time `json:"end,omitempty"`
+}
+
+// SegmentIndex is stored on disk alongside the segments of a JSONLStore, so
+// that segments do not have to be read to append to a store.
+type SegmentIndex struct {
+	Name     string                 `json:"name"`
+	Target   string                 `json:"target,omitempty"`
+	Units    healthautoexport.Units `json:"units"`
+	Segments []*SegmentInfo         `json:"segments"`
+	// Compacted is true if no segments were appended since the last compaction.
+	Compacted bool `json:"compacted"`
+}
+
+// JSONLStore stores the data of a single metric as append-only segments in
+// JSON Lines format, with one entry per line. Writes only append the incoming
+// entries to the latest segment, while duplicate entries are removed by
+// compacting the store periodically.
+type JSONLStore struct {
+	dir         string
+	segmentSize int64
+	index       SegmentIndex
+	mu          sync.Mutex
+}
+
+// OpenJSONLStore opens the JSONLStore in dir, which is created on the first
+// write if it does not exist. Segments which are larger than segmentSize are
+// not appended to.
+func OpenJSONLStore(dir string, segmentSize int64) (*JSONLStore, error) {
+	s := &JSONLStore{dir: dir, segmentSize: segmentSize}
+
+	indexPath := path.Join(dir, indexFileName)
+	if data, err := os.ReadFile(indexPath); err == nil {
+		if err := jsoniter.Unmarshal(data, &s.index); err != nil {
+			log.WithError(err).Warnf("could not read %v as segment index, rebuilding", indexPath)
+			s.index = SegmentIndex{}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "cannot read %v", indexPath)
+	}
+
+	if err := s.reconcile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Index returns a copy of the index of the store.
+func (s *JSONLStore) Index() SegmentIndex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.index
+	index.Segments = make([]*SegmentInfo, 0, len(s.index.Segments))
+	for _, segment := range s.index.Segments {
+		info := *segment
+		index.Segments = append(index.Segments, &info)
+	}
+	return index
+}
+
+// reconcile updates the index with the segments in the directory, in case the
+// index was not written after a segment was created or removed.
+func (s *JSONLStore) reconcile() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.index.Segments = nil
+			return nil
+		}
+		return errors.Wrapf(err, "cannot read dir %v", s.dir)
+	}
+
+	known := make(map[string]*SegmentInfo, len(s.index.Segments))
+	for _, segment := range s.index.Segments {
+		known[segment.File] = segment
+	}
+
+	segments := make([]*SegmentInfo, 0, len(files))
+	for _, file := range files {
+		stream, sequence, ok := parseSegmentName(file.Name())
+		if file.IsDir() || !ok {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return errors.Wrapf(err, "cannot stat %v", file.Name())
+		}
+		segment, ok := known[file.Name()]
+		if !ok {
+			// Segments which are not in the index may contain duplicates.
+			segment = &SegmentInfo{File: file.Name(), Stream: stream, Sequence: sequence}
+			s.index.Compacted = false
+		}
+		segment.Bytes = info.Size()
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Sequence < segments[j].Sequence
+	})
+	s.index.Segments = segments
+	return nil
+}
+
+// Append appends the entries of the MetricFile to the store.
+func (s *JSONLStore) Append(f *MetricFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot makedirs for %v", s.dir)
+	}
+
+	s.index.Name = f.Name
+	s.index.Target = f.Target
+	s.index.Units = f.Units
+	for _, stream := range metricFileStreams(f) {
+		if len(stream.entries) == 0 {
+			continue
+		}
+		if err := s.appendStream(stream); err != nil {
+			return err
+		}
+		s.index.Compacted = false
+	}
+
+	return s.writeIndex()
+}
+
+// appendStream appends the entries of the stream to its latest segment, or to
+// a new segment if the latest segment is full. The index is only updated after
+// the entries are written and synced, so that it never refers to segments or
+// entries which do not exist on disk.
+func (s *JSONLStore) appendStream(stream streamEntries) error {
+	var buf bytes.Buffer
+	existing := s.latestSegment(stream.name)
+	var segment SegmentInfo
+	if existing == nil || existing.Bytes >= s.segmentSize {
+		segment = *newSegment(stream.name, s.nextSequence())
+		existing = nil
+	} else {
+		segment = *existing
+	}
+	for i, entry := range stream.entries {
+		line, err := jsoniter.Marshal(entry)
+		if err != nil {
+			return errors.Wrapf(err, "cannot encode entry")
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		segment.Count++
+		segment.Start = minTime(segment.Start, stream.times[i])
+		segment.End = maxTime(segment.End, stream.times[i])
+	}
+
+	name := path.Join(s.dir, segment.File)
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %v", name)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// Terminate a partially written line, e.g. if the process was killed during
+	// a previous append, so that it does not corrupt the first appended entry.
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "cannot stat %v", name)
+	}
+	segment.Bytes = info.Size()
+	if size := info.Size(); size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err != nil {
+			return errors.Wrapf(err, "cannot read %v", name)
+		}
+		if last[0] != '\n' {
+			if _, err := file.Write([]byte{'\n'}); err != nil {
+				return errors.Wrapf(err, "cannot append to %v", name)
+			}
+			segment.Bytes++
+		}
+	}
+
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return errors.Wrapf(err, "cannot append to %v", name)
+	}
+	if err := file.Sync(); err != nil {
+		return errors.Wrapf(err, "cannot sync %v", name)
+	}
+
+	// Commit the segment to the index.
+	segment.Bytes += int64(buf.Len())
+	if existing == nil {
+		s.index.Segments = append(s.index.Segments, &segment)
+	} else {
+		*existing = segment
+	}
+	return nil
+}
+
+// ReadAll reads all entries in the store, where entries with the same key in
+// later segments replace earlier ones. The result is sorted by time.
+func (s *JSONLStore) ReadAll() (*MetricFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readAll()
+}
+
+func (s *JSONLStore) readAll() (*MetricFile, error) {
+	metricFile := &MetricFile{
+		Name:   s.index.Name,
+		Target: s.index.Target,
+		Units:  s.index.Units,
+	}
+	datapoints := make(map[int64]*healthautoexport.Datapoint)
+	for _, segment := range s.index.Segments {
+		var analyses []*healthautoexport.SleepAnalysis
+		var aggregated []*healthautoexport.AggregatedSleepAnalysis
+		err := s.readSegment(segment, func(line []byte) error {
+			switch segment.Stream {
+			case streamData:
+				var datum healthautoexport.Datapoint
+				if err := jsoniter.Unmarshal(line, &datum); err != nil {
+					return err
+				}
+				if datum.Date != nil {
+					datapoints[datum.Date.UnixNano()] = &datum
+				}
+			case streamSleepAnalyses:
+				var analysis healthautoexport.SleepAnalysis
+				if err := jsoniter.Unmarshal(line, &analysis); err != nil {
+					return err
+				}
+				analyses = append(analyses, &analysis)
+			case streamAggregatedSleepAnalyses:
+				var analysis healthautoexport.AggregatedSleepAnalysis
+				if err := jsoniter.Unmarshal(line, &analysis); err != nil {
+					return err
+				}
+				aggregated = append(aggregated, &analysis)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		metricFile.MergeSleepAnalyses(analyses, aggregated)
+	}
+
+	metricFile.Data = make([]*healthautoexport.Datapoint, 0, len(datapoints))
+	for _, datum := range datapoints {
+		metricFile.Data = append(metricFile.Data, datum)
+	}
+	sort.Slice(metricFile.Data, func(i, j int) bool {
+		return metricFile.Data[i].Date.Before(metricFile.Data[j].Date.Time)
+	})
+	return metricFile, nil
+}
+
+// readSegment calls fn for each line in the segment. Lines which cannot be
+// decoded, such as a partially written line at the end of a segment, are
+// skipped with a warning.
+func (s *JSONLStore) readSegment(segment *SegmentInfo, fn func(line []byte) error) error {
+	name := path.Join(s.dir, segment.File)
+	file, err := os.Open(name)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %v", name)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"segment": name,
+				"line":    lineNum,
+			}).Warn("could not decode line in segment, skipping")
+		}
+	}
+	return errors.Wrapf(scanner.Err(), "cannot read %v", name)
+}
+
+// Compact rewrites all segments of the store into new segments without
+// duplicate entries, sorted by time. Compaction is skipped if the store was
+// not appended to since the last compaction.
+func (s *JSONLStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.index.Compacted || len(s.index.Segments) == 0 {
+		return nil
+	}
+
+	metricFile, err := s.readAll()
+	if err != nil {
+		return err
+	}
+
+	// Write compacted entries into new segments, which are only added to the
+	// index once all of them are written.
+	old := s.index.Segments
+	sequence := s.nextSequence()
+	var segments []*SegmentInfo
+	for _, stream := range metricFileStreams(metricFile) {
+		var segment *SegmentInfo
+		var buf bytes.Buffer
+		for i, entry := range stream.entries {
+			if segment == nil {
+				segment = newSegment(stream.name, sequence)
+				segments = append(segments, segment)
+				sequence++
+			}
+			line, err := jsoniter.Marshal(entry)
+			if err != nil {
+				return errors.Wrapf(err, "cannot encode entry")
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+			segment.Count++
+			segment.Start = minTime(segment.Start, stream.times[i])
+			segment.End = maxTime(segment.End, stream.times[i])
+
+			// Start a new segment once the segment size is reached.
+			if int64(buf.Len()) >= s.segmentSize || i == len(stream.entries)-1 {
+				if err := s.writeSegment(segment, buf.Bytes()); err != nil {
+					return err
+				}
+				buf.Reset()
+				segment = nil
+			}
+		}
+	}
+
+	// Replace old segments in the index before removing them.
+	s.index.Segments = segments
+	s.index.Compacted = true
+	if err := s.writeIndex(); err != nil {
+		return err
+	}
+	for _, segment := range old {
+		name := path.Join(s.dir, segment.File)
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("could not remove compacted segment %v", name)
+		}
+	}
+
+	var before int
+	for _, segment := range old {
+		before += segment.Count
+	}
+	log.WithFields(log.Fields{
+		"store":    s.dir,
+		"segments": len(segments),
+		"before":   before,
+		"after":    len(metricFile.Data) + len(metricFile.SleepAnalyses) + len(metricFile.AggregatedSleepAnalyses),
+	}).Debug("compacted store")
+
+	return nil
+}
+
+// writeSegment writes the contents of a new segment.
+func (s *JSONLStore) writeSegment(segment *SegmentInfo, data []byte) error {
+	name := path.Join(s.dir, segment.File)
+	err := utilfile.WriteAtomic(name, 0, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot write %v", name)
+	}
+	segment.Bytes = int64(len(data))
+	return nil
+}
+
+// writeIndex atomically writes the index of the store, so that it is never
+// partially written.
+func (s *JSONLStore) writeIndex() error {
+	data, err := jsoniter.MarshalIndent(&s.index, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "cannot encode index")
+	}
+	name := path.Join(s.dir, indexFileName)
+	return utilfile.WriteAtomic(name, 0, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// latestSegment returns the segment with the largest sequence in the stream,
+// or nil if there are no segments in the stream.
+func (s *JSONLStore) latestSegment(stream string) *SegmentInfo {
+	var latest *SegmentInfo
+	for _, segment := range s.index.Segments {
+		if segment.Stream == stream {
+			latest = segment
+		}
+	}
+	return latest
+}
+
+// nextSequence returns a sequence larger than all existing segments.
+func (s *JSONLStore) nextSequence() int {
+	var sequence int
+	for _, segment := range s.index.Segments {
+		if segment.Sequence > sequence {
+			sequence = segment.Sequence
+		}
+	}
+	return sequence + 1
+}
+
+// newSegment returns a new empty segment in the stream.
+func newSegment(stream string, sequence int) *SegmentInfo {
+	return &SegmentInfo{
+		File:     fmt.Sprintf("%v-%06d%v", stream, sequence, segmentExt),
+		Stream:   stream,
+		Sequence: sequence,
+	}
+}
+
+// parseSegmentName returns the stream and sequence of a segment file name.
+func parseSegmentName(name string) (string, int, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return "", 0, false
+	}
+	tokens := strings.SplitN(strings.TrimSuffix(name, segmentExt), "-", 2)
+	if len(tokens) != 2 {
+		return "", 0, false
+	}
+	sequence, err := strconv.Atoi(tokens[1])
+	if err != nil {
+		return "", 0, false
+	}
+	switch tokens[0] {
+	case streamData, streamSleepAnalyses, streamAggregatedSleepAnalyses:
+		return tokens[0], sequence, true
+	}
+	return "", 0, false
+}
+
+// streamEntries contains the entries of a single stream, and their times.
+type streamEntries struct {
+	name    string
+	entries []interface{}
+	times   []*healthautoexport.Time
+}
+
+// metricFileStreams returns the entries of the MetricFile in each stream.
+func metricFileStreams(f *MetricFile) []streamEntries {
+	data := streamEntries{name: streamData}
+	for _, datum := range f.Data {
+		data.entries = append(data.entries, datum)
+		data.times = append(data.times, datum.Date)
+	}
+	analyses := streamEntries{name: streamSleepAnalyses}
+	for _, analysis := range f.SleepAnalyses {
+		analyses.entries = append(analyses.entries, analysis)
+		analyses.times = append(analyses.times, analysis.StartDate)
+	}
+	aggregated := streamEntries{name: streamAggregatedSleepAnalyses}
+	for _, analysis := range f.AggregatedSleepAnalyses {
+		aggregated.entries = append(aggregated.entries, analysis)
+		aggregated.times = append(aggregated.times, aggregatedSleepStart(analysis))
+	}
+	return []streamEntries{data, analyses, aggregated}
+}
+
+func minTime(a, b *healthautoexport.Time) *healthautoexport.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a.Time)) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b *healthautoexport.Time) *healthautoexport.Time {
+	if a.IsZero() || (!b.IsZero() && b.After(a.Time)) {
+		return b
+	}
+	return a
+}
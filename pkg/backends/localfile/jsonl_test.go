@@ -0,0 +1,179 @@
+package localfile_test
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+func readQtys(t *testing.T, store *localfile.JSONLStore) map[int64]float64 {
+	f, err := store.ReadAll()
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	assert.Equal(t, "heart_rate", f.Name)
+	assert.Equal(t, "test", f.Target)
+	assert.Equal(t, healthautoexport.Units("count/min"), f.Units)
+	qtys := make(map[int64]float64, len(f.Data))
+	for i, datum := range f.Data {
+		if i > 0 {
+			assert.True(t, f.Data[i-1].Date.Before(datum.Date.Time), "data should be sorted")
+		}
+		qtys[datum.Date.Unix()] = float64(datum.Qty)
+	}
+	return qtys
+}
+
+func TestJSONLStore(t *testing.T) {
+	dir := path.Join(t.TempDir(), "test_heart_rate_count_min")
+	store, err := localfile.OpenJSONLStore(dir, 150)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Append overlapping data, where later data replaces earlier data.
+	assert.NoError(t, store.Append(makeMetricFile(map[int64]float64{1000: 60, 1001: 61, 1002: 62})))
+	assert.NoError(t, store.Append(makeMetricFile(map[int64]float64{1002: 72, 1003: 73})))
+	assert.NoError(t, store.Append(makeMetricFile(map[int64]float64{1001: 81, 1004: 84})))
+	want := map[int64]float64{1000: 60, 1001: 81, 1002: 72, 1003: 73, 1004: 84}
+	assert.Equal(t, want, readQtys(t, store))
+
+	// Segments are rolled over once they exceed the segment size.
+	index := store.Index()
+	assert.False(t, index.Compacted)
+	if assert.Len(t, index.Segments, 2) {
+		assert.Equal(t, 5, index.Segments[0].Count)
+		assert.Equal(t, 2, index.Segments[1].Count)
+		assert.Equal(t, int64(1000), index.Segments[0].Start.Unix())
+		assert.Equal(t, int64(1003), index.Segments[0].End.Unix())
+	}
+
+	// Reopening the store reads the index from disk.
+	store, err = localfile.OpenJSONLStore(dir, 150)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, index, store.Index())
+	assert.Equal(t, want, readQtys(t, store))
+
+	// Compaction removes duplicates.
+	assert.NoError(t, store.Compact())
+	index = store.Index()
+	assert.True(t, index.Compacted)
+	var count int
+	for _, segment := range index.Segments {
+		count += segment.Count
+		assert.FileExists(t, path.Join(dir, segment.File))
+	}
+	assert.Equal(t, 5, count)
+	assert.Equal(t, want, readQtys(t, store))
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, len(index.Segments)+1)
+
+	// Data is appended after compaction.
+	assert.NoError(t, store.Append(makeMetricFile(map[int64]float64{1004: 94, 1005: 95})))
+	want[1004], want[1005] = 94, 95
+	assert.False(t, store.Index().Compacted)
+	assert.Equal(t, want, readQtys(t, store))
+}
+
+func TestJSONLStore_AppendFailed(t *testing.T) {
+	dir := path.Join(t.TempDir(), "test_heart_rate_count_min")
+	store, err := localfile.OpenJSONLStore(dir, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, store.Append(makeMetricFile(map[int64]float64{1000: 60, 1001: 61})))
+	index := store.Index()
+
+	// Block the next segment from being created.
+	next := path.Join(dir, "data-000002.jsonl")
+	assert.NoError(t, os.Mkdir(next, 0755))
+	assert.Error(t, store.Append(makeMetricFile(map[int64]float64{1002: 62})))
+	assert.Equal(t, index, store.Index())
+
+	// The index does not refer to the segment that could not be written.
+	store, err = localfile.OpenJSONLStore(dir, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, index, store.Index())
+	assert.NoError(t, os.Remove(next))
+	assert.NoError(t, store.Append(makeMetricFile(map[int64]float64{1002: 62})))
+	assert.Len(t, store.Index().Segments, 2)
+	assert.Equal(t, map[int64]float64{1000: 60, 1001: 61, 1002: 62}, readQtys(t, store))
+}
+
+func TestJSONLStore_Recovery(t *testing.T) {
+	dir := path.Join(t.TempDir(), "test_heart_rate_count_min")
+	store, err := localfile.OpenJSONLStore(dir, 1<<20)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, store.Append(makeMetricFile(map[int64]float64{1000: 60, 1001: 61})))
+
+	// Simulate a partially written line.
+	segment := path.Join(dir, store.Index().Segments[0].File)
+	file, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0644)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = file.WriteString(`{"date":"1970-01-01 00:16:4`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	// Simulate a segment which was not added to the index.
+	assert.NoError(t, os.WriteFile(path.Join(dir, "data-000009.jsonl"),
+		[]byte(`{"date":"1970-01-01 00:16:43 +0000","qty":63}`+"\n"), 0644))
+
+	store, err = localfile.OpenJSONLStore(dir, 1<<20)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, store.Index().Segments, 2)
+	assert.NoError(t, store.Append(makeMetricFile(map[int64]float64{1002: 62})))
+	assert.Equal(t, map[int64]float64{1000: 60, 1001: 61, 1002: 62, 1003: 63}, readQtys(t, store))
+}
+
+func TestJSONLStore_SleepAnalyses(t *testing.T) {
+	dir := path.Join(t.TempDir(), "test_sleep_analysis_hr")
+	store, err := localfile.OpenJSONLStore(dir, 1<<20)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	start := healthautoexport.NewTime(time.Unix(1000, 0))
+	end := healthautoexport.NewTime(time.Unix(2000, 0))
+	f := &localfile.MetricFile{
+		Name:  healthautoexport.SleepAnalysisName,
+		Units: "hr",
+		SleepAnalyses: []*healthautoexport.SleepAnalysis{
+			{StartDate: &start, EndDate: &end, Qty: 0.25, Source: "Watch", Value: "Core"},
+		},
+		AggregatedSleepAnalyses: []*healthautoexport.AggregatedSleepAnalysis{
+			{SleepStart: &start, SleepEnd: &end, Asleep: 0.25, Source: "Watch"},
+		},
+	}
+	assert.NoError(t, store.Append(f))
+	f.SleepAnalyses[0].Value = "Deep"
+	assert.NoError(t, store.Append(f))
+	assert.NoError(t, store.Compact())
+
+	got, err := store.ReadAll()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, got.Data)
+	if assert.Len(t, got.SleepAnalyses, 1) {
+		assert.Equal(t, "Deep", got.SleepAnalyses[0].Value)
+	}
+	assert.Len(t, got.AggregatedSleepAnalyses, 1)
+	assert.Len(t, store.Index().Segments, 2)
+}
@@ -0,0 +1,221 @@
+package localfile
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+// MergeKey determines which datapoints of a metric are considered the same
+// when merging data.
+type MergeKey string
+
+const (
+	// MergeKeyTimestamp merges datapoints with the same timestamp.
+	MergeKeyTimestamp MergeKey = "timestamp"
+
+	// MergeKeySource merges datapoints with the same timestamp and source, so
+	// that datapoints of different devices are kept separately.
+	MergeKeySource MergeKey = "timestamp+source"
+
+	// MergeKeyTags merges datapoints with the same timestamp and values of all
+	// string fields (e.g. source).
+	MergeKeyTags MergeKey = "timestamp+tags"
+)
+
+// ConflictPolicy determines how datapoints with the same MergeKey in a single
+// write are resolved. Existing datapoints with the same MergeKey are replaced by
+// the resolved datapoints, so that exporting the same data again does not
+// change the result.
+type ConflictPolicy string
+
+const (
+	// ConflictLastWriteWins keeps the last datapoint.
+	ConflictLastWriteWins ConflictPolicy = "last-write-wins"
+
+	// ConflictKeepBoth keeps all distinct datapoints.
+	ConflictKeepBoth ConflictPolicy = "keep-both"
+
+	// ConflictMax keeps the datapoint with the largest quantity.
+	ConflictMax ConflictPolicy = "max"
+
+	// ConflictSum keeps the last datapoint, with the sum of all quantities.
+	ConflictSum ConflictPolicy = "sum"
+)
+
+// sourceField is the field of a datapoint that contains its data source.
+const sourceField = "source"
+
+// MergeOptions configures how datapoints of a metric are merged. The zero value
+// merges datapoints by timestamp, where the last datapoint wins.
+type MergeOptions struct {
+	Key    MergeKey
+	Policy ConflictPolicy
+}
+
+// Validate returns an error if the MergeOptions are invalid.
+func (o MergeOptions) Validate() error {
+	switch o.Key {
+	case "", MergeKeyTimestamp, MergeKeySource, MergeKeyTags:
+	default:
+		return fmt.Errorf("invalid merge key %q, must be one of %v, %v, %v",
+			o.Key, MergeKeyTimestamp, MergeKeySource, MergeKeyTags)
+	}
+	switch o.Policy {
+	case "", ConflictLastWriteWins, ConflictKeepBoth, ConflictMax, ConflictSum:
+	default:
+		return fmt.Errorf("invalid conflict policy %q, must be one of %v, %v, %v, %v",
+			o.Policy, ConflictLastWriteWins, ConflictKeepBoth, ConflictMax, ConflictSum)
+	}
+	return nil
+}
+
+// IsDefault returns true if datapoints are merged by timestamp, where the last
+// datapoint wins.
+func (o MergeOptions) IsDefault() bool {
+	return (o.Key == "" || o.Key == MergeKeyTimestamp) && (o.Policy == "" || o.Policy == ConflictLastWriteWins)
+}
+
+// key returns the merge key of the datapoint.
+func (o MergeOptions) key(datum *healthautoexport.Datapoint) string {
+	key := fmt.Sprintf("%d", datum.Date.UnixNano())
+	switch o.Key {
+	case MergeKeySource:
+		if source, ok := datum.Fields[sourceField].(string); ok {
+			key += "|" + source
+		}
+	case MergeKeyTags:
+		var tags []string
+		for name, value := range datum.Fields {
+			if s, ok := value.(string); ok {
+				tags = append(tags, name+"="+s)
+			}
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			key += "|" + tag
+		}
+	}
+	return key
+}
+
+// resolve resolves datapoints with the same merge key, which are ordered from
+// the oldest to the newest.
+func (o MergeOptions) resolve(data []*healthautoexport.Datapoint) []*healthautoexport.Datapoint {
+	if len(data) <= 1 {
+		return data
+	}
+	last := data[len(data)-1]
+
+	switch o.Policy {
+	case ConflictKeepBoth:
+		var distinct []*healthautoexport.Datapoint
+		for _, datum := range data {
+			duplicate := false
+			for _, other := range distinct {
+				if datapointsEqual(datum, other) {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				distinct = append(distinct, datum)
+			}
+		}
+		return distinct
+
+	case ConflictMax:
+		largest := last
+		for _, datum := range data {
+			if datum.Qty > largest.Qty {
+				largest = datum
+			}
+		}
+		return []*healthautoexport.Datapoint{largest}
+
+	case ConflictSum:
+		sum := *last
+		sum.Qty = 0
+		for _, datum := range data {
+			sum.Qty += datum.Qty
+		}
+		return []*healthautoexport.Datapoint{&sum}
+	}
+
+	return []*healthautoexport.Datapoint{last}
+}
+
+// Merge returns a MetricFile containing the data of both MetricFiles, where
+// datapoints in incoming with the same merge key are resolved with the conflict
+// policy, and replace datapoints in f with the same merge key. Sleep analyses are always merged by start time and source.
+func (f MetricFile) Merge(incoming *MetricFile, opts MergeOptions) *MetricFile {
+	merged := *incoming
+	merged.Data = mergeDatapoints(f.Data, incoming.Data, opts)
+	merged.SleepAnalyses = f.SleepAnalyses
+	merged.AggregatedSleepAnalyses = f.AggregatedSleepAnalyses
+	merged.MergeSleepAnalyses(incoming.SleepAnalyses, incoming.AggregatedSleepAnalyses)
+	return &merged
+}
+
+// mergeDatapoints merges datapoints by merge key, where each group of incoming
+// datapoints with the same merge key is resolved with the conflict policy, and
+// replaces the existing datapoints with the same merge key. The result is
+// sorted by timestamp.
+func mergeDatapoints(existing, incoming []*healthautoexport.Datapoint, opts MergeOptions) []*healthautoexport.Datapoint {
+	groups := make(map[string][]*healthautoexport.Datapoint, len(existing)+len(incoming))
+	for _, datum := range existing {
+		if datum.Date.IsZero() {
+			continue
+		}
+		key := opts.key(datum)
+		groups[key] = append(groups[key], datum)
+	}
+
+	incomingGroups := make(map[string][]*healthautoexport.Datapoint, len(incoming))
+	for _, datum := range incoming {
+		if datum.Date.IsZero() {
+			continue
+		}
+		key := opts.key(datum)
+		incomingGroups[key] = append(incomingGroups[key], datum)
+	}
+	for key, data := range incomingGroups {
+		groups[key] = opts.resolve(data)
+	}
+
+	// Sort by timestamp, and then by key and order within each group so that
+	// the result is deterministic.
+	type entry struct {
+		key   string
+		index int
+		datum *healthautoexport.Datapoint
+	}
+	entries := make([]entry, 0, len(groups))
+	for key, data := range groups {
+		for i, datum := range data {
+			entries = append(entries, entry{key: key, index: i, datum: datum})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !a.datum.Date.Equal(b.datum.Date.Time) {
+			return a.datum.Date.Before(b.datum.Date.Time)
+		}
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		return a.index < b.index
+	})
+
+	merged := make([]*healthautoexport.Datapoint, 0, len(entries))
+	for _, e := range entries {
+		merged = append(merged, e.datum)
+	}
+	return merged
+}
+
+func datapointsEqual(a, b *healthautoexport.Datapoint) bool {
+	return a.Date.Equal(b.Date.Time) && a.Qty == b.Qty && reflect.DeepEqual(a.Fields, b.Fields)
+}
@@ -0,0 +1,164 @@
+package localfile_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+func makeStepCount(ts int64, qty float64, fields healthautoexport.DatapointFields) *healthautoexport.Datapoint {
+	return &healthautoexport.Datapoint{Date: newTime(ts), Qty: healthautoexport.Qty(qty), Fields: fields}
+}
+
+func formatDatapoints(data []*healthautoexport.Datapoint) []string {
+	output := make([]string, 0, len(data))
+	for _, datum := range data {
+		output = append(output, fmt.Sprintf("%v %v %v", datum.Date.Unix(), datum.Qty, datum.Fields))
+	}
+	return output
+}
+
+func TestMetricFile_Merge(t *testing.T) {
+	iPhone := healthautoexport.DatapointFields{"source": "iPhone"}
+	watch := healthautoexport.DatapointFields{"source": "Watch"}
+	watchDevice := healthautoexport.DatapointFields{"source": "Watch", "device": "Series 7"}
+	existing := &localfile.MetricFile{
+		Name: "step_count",
+		Data: []*healthautoexport.Datapoint{
+			makeStepCount(1000, 10, iPhone),
+			makeStepCount(1060, 1, iPhone),
+		},
+	}
+	incoming := &localfile.MetricFile{
+		Name: "step_count",
+		Data: []*healthautoexport.Datapoint{
+			makeStepCount(1000, 20, iPhone),
+			makeStepCount(1000, 30, watch),
+			makeStepCount(1000, 40, watchDevice),
+			makeStepCount(1000, 30, watch),
+			makeStepCount(1120, 5, watch),
+		},
+	}
+
+	tests := []struct {
+		name string
+		opts localfile.MergeOptions
+		want []string
+	}{
+		{
+			name: "default",
+			want: []string{
+				"1000 30 map[source:Watch]",
+				"1060 1 map[source:iPhone]",
+				"1120 5 map[source:Watch]",
+			},
+		},
+		{
+			name: "timestamp with keep both",
+			opts: localfile.MergeOptions{Key: localfile.MergeKeyTimestamp, Policy: localfile.ConflictKeepBoth},
+			want: []string{
+				"1000 20 map[source:iPhone]",
+				"1000 30 map[source:Watch]",
+				"1000 40 map[device:Series 7 source:Watch]",
+				"1060 1 map[source:iPhone]",
+				"1120 5 map[source:Watch]",
+			},
+		},
+		{
+			name: "timestamp with max",
+			opts: localfile.MergeOptions{Key: localfile.MergeKeyTimestamp, Policy: localfile.ConflictMax},
+			want: []string{
+				"1000 40 map[device:Series 7 source:Watch]",
+				"1060 1 map[source:iPhone]",
+				"1120 5 map[source:Watch]",
+			},
+		},
+		{
+			name: "timestamp with sum",
+			opts: localfile.MergeOptions{Key: localfile.MergeKeyTimestamp, Policy: localfile.ConflictSum},
+			want: []string{
+				"1000 120 map[source:Watch]",
+				"1060 1 map[source:iPhone]",
+				"1120 5 map[source:Watch]",
+			},
+		},
+		{
+			name: "timestamp and source",
+			opts: localfile.MergeOptions{Key: localfile.MergeKeySource},
+			want: []string{
+				"1000 30 map[source:Watch]",
+				"1000 20 map[source:iPhone]",
+				"1060 1 map[source:iPhone]",
+				"1120 5 map[source:Watch]",
+			},
+		},
+		{
+			name: "timestamp and source with sum",
+			opts: localfile.MergeOptions{Key: localfile.MergeKeySource, Policy: localfile.ConflictSum},
+			want: []string{
+				"1000 100 map[source:Watch]",
+				"1000 20 map[source:iPhone]",
+				"1060 1 map[source:iPhone]",
+				"1120 5 map[source:Watch]",
+			},
+		},
+		{
+			name: "timestamp and tags",
+			opts: localfile.MergeOptions{Key: localfile.MergeKeyTags},
+			want: []string{
+				"1000 40 map[device:Series 7 source:Watch]",
+				"1000 30 map[source:Watch]",
+				"1000 20 map[source:iPhone]",
+				"1060 1 map[source:iPhone]",
+				"1120 5 map[source:Watch]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			merged := existing.Merge(incoming, tt.opts)
+			assert.Equal(t, tt.want, formatDatapoints(merged.Data))
+
+			// Merging the same data again is idempotent.
+			assert.Equal(t, tt.want, formatDatapoints(merged.Merge(incoming, tt.opts).Data))
+		})
+	}
+}
+
+func TestMergeOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    localfile.MergeOptions
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name: "default",
+		},
+		{
+			name: "valid",
+			opts: localfile.MergeOptions{Key: localfile.MergeKeyTags, Policy: localfile.ConflictKeepBoth},
+		},
+		{
+			name:    "invalid key",
+			opts:    localfile.MergeOptions{Key: "source"},
+			wantErr: testutils.AssertErrorContains(`invalid merge key "source"`),
+		},
+		{
+			name:    "invalid policy",
+			opts:    localfile.MergeOptions{Policy: "min"},
+			wantErr: testutils.AssertErrorContains(`invalid conflict policy "min"`),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			testutils.WantError(t, tt.wantErr, tt.opts.Validate())
+		})
+	}
+}
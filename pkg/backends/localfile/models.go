@@ -1,16 +1,32 @@
 package localfile
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strings"
 
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 )
 
+const (
+	// workoutFileTimeLayout is the layout of the start time in workout file names.
+	workoutFileTimeLayout = "20060102T150405Z"
+)
+
 type MetricFile struct {
 	Name   string                        `json:"name"`
 	Target string                        `json:"target,omitempty"`
 	Units  healthautoexport.Units        `json:"units"`
 	Data   []*healthautoexport.Datapoint `json:"data"`
+
+	// SleepAnalyses and AggregatedSleepAnalyses contain sleep analysis data,
+	// which is stored separately from Data as it has a different shape.
+	SleepAnalyses           []*healthautoexport.SleepAnalysis           `json:"sleepAnalyses,omitempty"`
+	AggregatedSleepAnalyses []*healthautoexport.AggregatedSleepAnalysis `json:"aggregatedSleepAnalyses,omitempty"`
 }
 
 func (f MetricFile) GetFileName() string {
@@ -26,5 +42,425 @@ func (f *MetricFile) FromMetric(metric *healthautoexport.Metric, target string)
 	f.Name = metric.Name
 	f.Units = metric.Units
 	f.Data = metric.Datapoints
+	f.SleepAnalyses = metric.SleepAnalyses
+	f.AggregatedSleepAnalyses = metric.AggregatedSleepAnalyses
 	f.Target = target
 }
+
+// MergeSleepAnalyses merges sleep analysis data into the MetricFile, replacing
+// any existing intervals with the same start time and source. The result is
+// sorted by start time.
+func (f *MetricFile) MergeSleepAnalyses(
+	analyses []*healthautoexport.SleepAnalysis, aggregated []*healthautoexport.AggregatedSleepAnalysis,
+) {
+	if len(f.SleepAnalyses)+len(analyses) > 0 {
+		byKey := make(map[string]*healthautoexport.SleepAnalysis, len(f.SleepAnalyses)+len(analyses))
+		for _, data := range [][]*healthautoexport.SleepAnalysis{f.SleepAnalyses, analyses} {
+			for _, a := range data {
+				byKey[sleepIntervalKey(a.StartDate, a.Source)] = a
+			}
+		}
+		f.SleepAnalyses = make([]*healthautoexport.SleepAnalysis, 0, len(byKey))
+		for _, a := range byKey {
+			f.SleepAnalyses = append(f.SleepAnalyses, a)
+		}
+		sort.Slice(f.SleepAnalyses, func(i, j int) bool {
+			a, b := f.SleepAnalyses[i], f.SleepAnalyses[j]
+			return sleepIntervalLess(a.StartDate, a.Source, b.StartDate, b.Source)
+		})
+	}
+
+	if len(f.AggregatedSleepAnalyses)+len(aggregated) > 0 {
+		byKey := make(map[string]*healthautoexport.AggregatedSleepAnalysis, len(f.AggregatedSleepAnalyses)+len(aggregated))
+		for _, data := range [][]*healthautoexport.AggregatedSleepAnalysis{f.AggregatedSleepAnalyses, aggregated} {
+			for _, a := range data {
+				byKey[sleepIntervalKey(aggregatedSleepStart(a), aggregatedSleepSource(a))] = a
+			}
+		}
+		f.AggregatedSleepAnalyses = make([]*healthautoexport.AggregatedSleepAnalysis, 0, len(byKey))
+		for _, a := range byKey {
+			f.AggregatedSleepAnalyses = append(f.AggregatedSleepAnalyses, a)
+		}
+		sort.Slice(f.AggregatedSleepAnalyses, func(i, j int) bool {
+			a, b := f.AggregatedSleepAnalyses[i], f.AggregatedSleepAnalyses[j]
+			return sleepIntervalLess(aggregatedSleepStart(a), aggregatedSleepSource(a),
+				aggregatedSleepStart(b), aggregatedSleepSource(b))
+		})
+	}
+}
+
+// aggregatedSleepStart returns the start time of an aggregated sleep analysis.
+// Prior to HAE v6.6.2, the sleep and inBed phases have separate start times.
+func aggregatedSleepStart(a *healthautoexport.AggregatedSleepAnalysis) *healthautoexport.Time {
+	if a.SleepStart.IsZero() {
+		return a.InBedStart
+	}
+	return a.SleepStart
+}
+
+// aggregatedSleepSource returns the source of an aggregated sleep analysis.
+// Prior to HAE v6.6.2, the sleep and inBed phases have separate sources.
+func aggregatedSleepSource(a *healthautoexport.AggregatedSleepAnalysis) string {
+	if a.Source != "" {
+		return a.Source
+	}
+	return a.SleepSource + "|" + a.InBedSource
+}
+
+// sleepIntervalKey returns the key of a sleep interval. The start time is
+// compared as an instant, since the time zone may differ between exports.
+func sleepIntervalKey(start *healthautoexport.Time, source string) string {
+	var ts int64
+	if !start.IsZero() {
+		ts = start.UnixNano()
+	}
+	return fmt.Sprintf("%d|%v", ts, source)
+}
+
+func sleepIntervalLess(startA *healthautoexport.Time, sourceA string, startB *healthautoexport.Time, sourceB string) bool {
+	if startA.IsZero() || startB.IsZero() || startA.Equal(startB.Time) {
+		return sourceA < sourceB
+	}
+	return startA.Before(startB.Time)
+}
+
+// EventFile stores all health events of a single type (e.g. symptoms) for a target.
+type EventFile struct {
+	Type   string            `json:"type"`
+	Target string            `json:"target,omitempty"`
+	Data   []json.RawMessage `json:"data"`
+}
+
+func (f EventFile) GetFileName() string {
+	filename := f.Type
+	if f.Target != "" {
+		filename = f.Target + "_" + filename
+	}
+	return filename + ".json"
+}
+
+// FromEvents populates the EventFile from a slice of events of a single type.
+func (f *EventFile) FromEvents(eventType string, events interface{}, target string) error {
+	bytes, err := jsoniter.Marshal(events)
+	if err != nil {
+		return err
+	}
+	var data []json.RawMessage
+	if err := jsoniter.Unmarshal(bytes, &data); err != nil {
+		return err
+	}
+	f.Type = eventType
+	f.Target = target
+	f.Data = data
+	return nil
+}
+
+// eventKey contains the fields used to uniquely identify a single event.
+type eventKey struct {
+	ID          string                 `json:"id,omitempty"`
+	Start       *healthautoexport.Time `json:"start"`
+	Name        string                 `json:"name,omitempty"`
+	DisplayText string                 `json:"displayText,omitempty"`
+	Kind        string                 `json:"kind,omitempty"`
+}
+
+func (k eventKey) String() string {
+	if k.ID != "" {
+		return k.ID
+	}
+	var start string
+	if !k.Start.IsZero() {
+		start = k.Start.String()
+	}
+	return strings.Join([]string{start, k.Name, k.DisplayText, k.Kind}, "|")
+}
+
+// MergeEvents merges events into the EventFile, replacing any existing events
+// with the same key. The result is sorted by start time.
+func (f *EventFile) MergeEvents(events []json.RawMessage) error {
+	keys := make(map[string]eventKey, len(f.Data)+len(events))
+	byKey := make(map[string]json.RawMessage, len(f.Data)+len(events))
+	for _, data := range [][]json.RawMessage{f.Data, events} {
+		for _, event := range data {
+			var key eventKey
+			if err := jsoniter.Unmarshal(event, &key); err != nil {
+				return err
+			}
+			keys[key.String()] = key
+			byKey[key.String()] = event
+		}
+	}
+
+	merged := make([]string, 0, len(byKey))
+	for key := range byKey {
+		merged = append(merged, key)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		a, b := keys[merged[i]].Start, keys[merged[j]].Start
+		if a.IsZero() || b.IsZero() || a.Equal(b.Time) {
+			return merged[i] < merged[j]
+		}
+		return a.Before(b.Time)
+	})
+
+	f.Data = make([]json.RawMessage, 0, len(merged))
+	for _, key := range merged {
+		f.Data = append(f.Data, byKey[key])
+	}
+	return nil
+}
+
+// SleepSessionFile stores all sleep sessions for a target, which are
+// reconstructed from non-aggregated sleep analysis data.
+type SleepSessionFile struct {
+	Target string                           `json:"target,omitempty"`
+	Data   []*healthautoexport.SleepSession `json:"data"`
+
+	// Samples contains all sleep analysis samples that sessions are built from,
+	// so that a night which is split across multiple exports is still
+	// summarized as a single session.
+	Samples []*healthautoexport.SleepAnalysis `json:"samples,omitempty"`
+}
+
+func (f SleepSessionFile) GetFileName() string {
+	filename := "sleep_sessions"
+	if f.Target != "" {
+		filename = f.Target + "_" + filename
+	}
+	return filename + ".json"
+}
+
+// MergeSamples merges sleep analysis samples into the SleepSessionFile,
+// replacing any existing samples with the same start time and source, and
+// rebuilds the sessions from all samples.
+func (f *SleepSessionFile) MergeSamples(samples []*healthautoexport.SleepAnalysis) {
+	merged := MetricFile{SleepAnalyses: f.Samples}
+	merged.MergeSleepAnalyses(samples, nil)
+	f.Samples = merged.SleepAnalyses
+	f.MergeSessions(healthautoexport.NewSleepSessionBuilder().Build(f.Samples))
+}
+
+// MergeSessions merges sessions into the SleepSessionFile, keeping a single
+// session per night and source. Existing sessions are replaced by sessions
+// with the same date and source, or by sessions from the same source which
+// overlap them (e.g. if part of the night was previously dated differently).
+// The result is sorted by date and source.
+func (f *SleepSessionFile) MergeSessions(sessions []*healthautoexport.SleepSession) {
+	byKey := make(map[string]*healthautoexport.SleepSession, len(f.Data)+len(sessions))
+	for _, existing := range f.Data {
+		if !overlapsSleepSession(existing, sessions) {
+			byKey[sleepSessionKey(existing)] = existing
+		}
+	}
+	for _, session := range sessions {
+		byKey[sleepSessionKey(session)] = session
+	}
+
+	f.Data = make([]*healthautoexport.SleepSession, 0, len(byKey))
+	for _, session := range byKey {
+		f.Data = append(f.Data, session)
+	}
+	sort.Slice(f.Data, func(i, j int) bool {
+		a, b := f.Data[i], f.Data[j]
+		if a.Date == b.Date {
+			return a.Source < b.Source
+		}
+		return a.Date < b.Date
+	})
+}
+
+// sleepSessionKey returns the key of a sleep session, which is its night and source.
+func sleepSessionKey(session *healthautoexport.SleepSession) string {
+	return session.Date + "|" + session.Source
+}
+
+// overlapsSleepSession returns true if session overlaps any of sessions from the same source.
+func overlapsSleepSession(session *healthautoexport.SleepSession, sessions []*healthautoexport.SleepSession) bool {
+	if session.Start.IsZero() || session.End.IsZero() {
+		return false
+	}
+	for _, other := range sessions {
+		if other.Source != session.Source || other.Start.IsZero() || other.End.IsZero() {
+			continue
+		}
+		if session.Start.Before(other.End.Time) && other.Start.Before(session.End.Time) {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkoutFile stores a single workout for a target, including its route and
+// heart rate data. Each workout is identified by its name and start time.
+type WorkoutFile struct {
+	Target  string                    `json:"target,omitempty"`
+	Workout *healthautoexport.Workout `json:"workout"`
+
+	// Statistics are derived from Workout, and are recomputed whenever the
+	// workout is merged.
+	Statistics *WorkoutStatistics `json:"statistics,omitempty"`
+}
+
+// WorkoutStatistics contains statistics derived from a workout, in the same
+// units as the corresponding fields written by the InfluxDB backend. Statistics
+// which cannot be computed from the workout are omitted.
+type WorkoutStatistics struct {
+	DurationMin             *float64  `json:"durationMin,omitempty"`
+	AvgHeartRateBpm         *float64  `json:"avgHeartRateBpm,omitempty"`
+	MaxHeartRateBpm         *float64  `json:"maxHeartRateBpm,omitempty"`
+	HeartRateZonesMin       []float64 `json:"heartRateZonesMin,omitempty"`
+	RouteDistanceKm         *float64  `json:"routeDistanceKm,omitempty"`
+	AvgSpeedKmHr            *float64  `json:"avgSpeedKmHr,omitempty"`
+	AvgPaceMinKm            *float64  `json:"avgPaceMinKm,omitempty"`
+	ElevationAscentM        *float64  `json:"elevationAscentM,omitempty"`
+	ElevationDescentM       *float64  `json:"elevationDescentM,omitempty"`
+	ActiveEnergyRateKcalMin *float64  `json:"activeEnergyRateKcalMin,omitempty"`
+}
+
+// ComputeStatistics sets Statistics from the workout of the WorkoutFile.
+func (f *WorkoutFile) ComputeStatistics(opts analytics.Options) {
+	if f.Workout == nil {
+		f.Statistics = nil
+		return
+	}
+	stats := analytics.ComputeWorkoutStatistics(f.Workout, f.Target, opts)
+	float := func(value float64) *float64 { return &value }
+
+	result := &WorkoutStatistics{}
+	if stats.Duration != nil {
+		result.DurationMin = float(stats.Duration.Minutes())
+	}
+	if hr := stats.HeartRate; hr != nil {
+		result.AvgHeartRateBpm = float(hr.Average)
+		result.MaxHeartRateBpm = float(hr.Max)
+		for _, duration := range hr.Zones {
+			result.HeartRateZonesMin = append(result.HeartRateZonesMin, duration.Minutes())
+		}
+	}
+	if stats.RouteDistance != nil {
+		result.RouteDistanceKm = float(*stats.RouteDistance / 1000)
+	}
+	if stats.AverageSpeed != nil {
+		result.AvgSpeedKmHr = float(*stats.AverageSpeed * 3.6)
+		result.AvgPaceMinKm = float(analytics.Pace(*stats.AverageSpeed))
+	}
+	if stats.ElevationGain != nil && stats.ElevationLoss != nil {
+		result.ElevationAscentM = stats.ElevationGain
+		result.ElevationDescentM = stats.ElevationLoss
+	}
+	result.ActiveEnergyRateKcalMin = stats.ActiveEnergyRate
+	f.Statistics = result
+}
+
+func (f WorkoutFile) GetFileName() string {
+	filename := f.Workout.Name
+	if !f.Workout.Start.IsZero() {
+		filename += "_" + f.Workout.Start.UTC().Format(workoutFileTimeLayout)
+	}
+	filename = strings.NewReplacer("/", "_", " ", "_").Replace(filename)
+	if f.Target != "" {
+		filename = f.Target + "_" + filename
+	}
+	return filename + ".json"
+}
+
+// MergeWorkout merges a re-export of the same workout into the WorkoutFile.
+// Fields of the incoming workout replace existing ones, while route and heart
+// rate data are merged by timestamp, since they may be omitted in some exports.
+func (f *WorkoutFile) MergeWorkout(workout *healthautoexport.Workout) {
+	existing := f.Workout
+	if existing == nil {
+		f.Workout = workout
+		return
+	}
+
+	merged := *workout
+	if merged.ID == "" {
+		merged.ID = existing.ID
+	}
+	if merged.End.IsZero() {
+		merged.End = existing.End
+	}
+	if merged.Elevation == nil {
+		merged.Elevation = existing.Elevation
+	}
+	merged.Fields = mergeWorkoutFields(existing.Fields, workout.Fields)
+	merged.Unknown = make(healthautoexport.UnknownFields, len(existing.Unknown)+len(workout.Unknown))
+	for _, unknown := range []healthautoexport.UnknownFields{existing.Unknown, workout.Unknown} {
+		for key, value := range unknown {
+			merged.Unknown[key] = value
+		}
+	}
+	merged.Route = mergeRoute(existing.Route, workout.Route)
+	merged.HeartRateData = mergeDatapointsWithUnit(existing.HeartRateData, workout.HeartRateData)
+	merged.HeartRateRecovery = mergeDatapointsWithUnit(existing.HeartRateRecovery, workout.HeartRateRecovery)
+	f.Workout = &merged
+}
+
+// mergeWorkoutFields merges fields by key, replacing existing fields with the
+// same key. The order of existing fields is preserved.
+func mergeWorkoutFields(existing, incoming healthautoexport.WorkoutFields) healthautoexport.WorkoutFields {
+	index := make(map[string]int, len(existing)+len(incoming))
+	merged := make(healthautoexport.WorkoutFields, 0, len(existing)+len(incoming))
+	for _, fields := range []healthautoexport.WorkoutFields{existing, incoming} {
+		for _, field := range fields {
+			if i, ok := index[field.Key]; ok {
+				merged[i] = field
+				continue
+			}
+			index[field.Key] = len(merged)
+			merged = append(merged, field)
+		}
+	}
+	return merged
+}
+
+// mergeRoute merges route datapoints by timestamp, sorted by timestamp.
+func mergeRoute(existing, incoming []*healthautoexport.RouteDatapoint) []*healthautoexport.RouteDatapoint {
+	byTimestamp := make(map[int64]*healthautoexport.RouteDatapoint, len(existing)+len(incoming))
+	for _, route := range [][]*healthautoexport.RouteDatapoint{existing, incoming} {
+		for _, datum := range route {
+			if datum.Timestamp == nil {
+				continue
+			}
+			byTimestamp[datum.Timestamp.UnixNano()] = datum
+		}
+	}
+	if len(byTimestamp) == 0 {
+		return nil
+	}
+
+	merged := make([]*healthautoexport.RouteDatapoint, 0, len(byTimestamp))
+	for _, datum := range byTimestamp {
+		merged = append(merged, datum)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Timestamp.Before(merged[j].Timestamp.Time)
+	})
+	return merged
+}
+
+// mergeDatapointsWithUnit merges datapoints by date, sorted by date.
+func mergeDatapointsWithUnit(existing, incoming []*healthautoexport.DatapointWithUnit) []*healthautoexport.DatapointWithUnit {
+	byDate := make(map[int64]*healthautoexport.DatapointWithUnit, len(existing)+len(incoming))
+	for _, data := range [][]*healthautoexport.DatapointWithUnit{existing, incoming} {
+		for _, datum := range data {
+			if datum.Date == nil {
+				continue
+			}
+			byDate[datum.Date.UnixNano()] = datum
+		}
+	}
+	if len(byDate) == 0 {
+		return nil
+	}
+
+	merged := make([]*healthautoexport.DatapointWithUnit, 0, len(byDate))
+	for _, datum := range byDate {
+		merged = append(merged, datum)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Date.Before(merged[j].Date.Time)
+	})
+	return merged
+}
@@ -0,0 +1,305 @@
+package localfile_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+func mktime(ts string) *healthautoexport.Time {
+	t, err := healthautoexport.ParseTime(ts)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func makeSleepSample(start, end, value string) *healthautoexport.SleepAnalysis {
+	return &healthautoexport.SleepAnalysis{
+		StartDate: mktime(start),
+		EndDate:   mktime(end),
+		Source:    "Watch",
+		Value:     value,
+	}
+}
+
+func TestSleepSessionFile_MergeSamples(t *testing.T) {
+	tests := []struct {
+		name       string
+		exports    [][]*healthautoexport.SleepAnalysis
+		wantDates  []string
+		wantInBed  []healthautoexport.Qty
+		wantAsleep []healthautoexport.Qty
+	}{
+		{
+			name: "night split across exports after midnight",
+			exports: [][]*healthautoexport.SleepAnalysis{
+				{makeSleepSample("2021-12-17 23:00:00 +0800", "2021-12-18 03:00:00 +0800", "Core")},
+				{makeSleepSample("2021-12-18 03:00:00 +0800", "2021-12-18 07:00:00 +0800", "Deep")},
+			},
+			wantDates:  []string{"2021-12-18"},
+			wantInBed:  []healthautoexport.Qty{8},
+			wantAsleep: []healthautoexport.Qty{8},
+		},
+		{
+			name: "night split across exports before midnight",
+			exports: [][]*healthautoexport.SleepAnalysis{
+				{makeSleepSample("2021-12-17 22:00:00 +0800", "2021-12-17 23:30:00 +0800", "In Bed")},
+				{makeSleepSample("2021-12-17 23:30:00 +0800", "2021-12-18 06:00:00 +0800", "Core")},
+			},
+			wantDates:  []string{"2021-12-18"},
+			wantInBed:  []healthautoexport.Qty{8},
+			wantAsleep: []healthautoexport.Qty{6.5},
+		},
+		{
+			name: "re-export with different time zone",
+			exports: [][]*healthautoexport.SleepAnalysis{
+				{makeSleepSample("2021-12-17 23:00:00 +0800", "2021-12-18 07:00:00 +0800", "Core")},
+				{makeSleepSample("2021-12-17 16:00:00 +0100", "2021-12-18 00:00:00 +0100", "Core")},
+			},
+			wantDates:  []string{"2021-12-18"},
+			wantInBed:  []healthautoexport.Qty{8},
+			wantAsleep: []healthautoexport.Qty{8},
+		},
+		{
+			name: "separate nights",
+			exports: [][]*healthautoexport.SleepAnalysis{
+				{makeSleepSample("2021-12-17 23:00:00 +0800", "2021-12-18 07:00:00 +0800", "Core")},
+				{makeSleepSample("2021-12-18 23:00:00 +0800", "2021-12-19 06:00:00 +0800", "Core")},
+			},
+			wantDates:  []string{"2021-12-18", "2021-12-19"},
+			wantInBed:  []healthautoexport.Qty{8, 7},
+			wantAsleep: []healthautoexport.Qty{8, 7},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f localfile.SleepSessionFile
+			for _, samples := range tt.exports {
+				f.MergeSamples(samples)
+			}
+			var dates []string
+			var inBed, asleep []healthautoexport.Qty
+			for _, session := range f.Data {
+				assert.Equal(t, "Watch", session.Source)
+				dates = append(dates, session.Date)
+				inBed = append(inBed, session.InBed)
+				asleep = append(asleep, session.Asleep)
+			}
+			assert.Equal(t, tt.wantDates, dates)
+			assert.Equal(t, tt.wantInBed, inBed)
+			assert.Equal(t, tt.wantAsleep, asleep)
+		})
+	}
+}
+
+func TestSleepSessionFile_MergeSessions(t *testing.T) {
+	// Sessions written before samples were stored are replaced by sessions for
+	// the same night and source.
+	f := localfile.SleepSessionFile{
+		Data: []*healthautoexport.SleepSession{
+			{Date: "2021-12-17", Source: "Watch", InBed: 6},
+			{Date: "2021-12-18", Source: "Watch", InBed: 4},
+			{Date: "2021-12-18", Source: "iPhone", InBed: 5},
+		},
+	}
+	start := healthautoexport.NewTime(time.Date(2021, 12, 17, 23, 0, 0, 0, time.UTC))
+	f.MergeSessions([]*healthautoexport.SleepSession{
+		{Date: "2021-12-18", Source: "Watch", InBed: 8, Start: &start},
+	})
+	assert.Len(t, f.Data, 3)
+	assert.Equal(t, "2021-12-17", f.Data[0].Date)
+	assert.Equal(t, healthautoexport.Qty(8), f.Data[1].InBed)
+	assert.Equal(t, "iPhone", f.Data[2].Source)
+}
+
+func TestWorkoutFile_ComputeStatistics(t *testing.T) {
+	f := localfile.WorkoutFile{
+		Target: "john",
+		Workout: &healthautoexport.Workout{
+			Name:  "Outdoor Run",
+			Start: mktime("2021-12-24 08:00:00 +0800"),
+			End:   mktime("2021-12-24 08:30:00 +0800"),
+			HeartRateData: []*healthautoexport.DatapointWithUnit{
+				{Date: mktime("2021-12-24 08:00:00 +0800"), QtyWithUnit: healthautoexport.QtyWithUnit{Qty: 150, Units: "count/min"}},
+				{Date: mktime("2021-12-24 08:01:00 +0800"), QtyWithUnit: healthautoexport.QtyWithUnit{Qty: 170, Units: "count/min"}},
+			},
+		},
+	}
+	f.ComputeStatistics(analytics.Options{
+		MaxHeartRates:  map[string]float64{"john": 200},
+		HeartRateZones: []int{50, 80},
+	})
+	if assert.NotNil(t, f.Statistics) {
+		assert.Equal(t, 30.0, *f.Statistics.DurationMin)
+		assert.Equal(t, 170.0, *f.Statistics.MaxHeartRateBpm)
+		assert.Equal(t, []float64{1, 1}, f.Statistics.HeartRateZonesMin)
+		assert.Nil(t, f.Statistics.RouteDistanceKm)
+		assert.Nil(t, f.Statistics.ActiveEnergyRateKcalMin)
+	}
+}
+
+func makeRouteDatapoint(ts string, lat float64) *healthautoexport.RouteDatapoint {
+	return &healthautoexport.RouteDatapoint{Lat: lat, Lon: 103.8, Timestamp: mktime(ts)}
+}
+
+func makeHeartRateDatapoint(ts string, qty healthautoexport.Qty) *healthautoexport.DatapointWithUnit {
+	return &healthautoexport.DatapointWithUnit{
+		Date:        mktime(ts),
+		QtyWithUnit: healthautoexport.QtyWithUnit{Qty: qty, Units: "count/min"},
+	}
+}
+
+func TestWorkoutFile_GetFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		file localfile.WorkoutFile
+		want string
+	}{
+		{
+			name: "start time in UTC",
+			file: localfile.WorkoutFile{
+				Target:  "john",
+				Workout: &healthautoexport.Workout{Name: "Outdoor Run", Start: mktime("2021-12-24 08:02:43 +0800")},
+			},
+			want: "john_Outdoor_Run_20211224T000243Z.json",
+		},
+		{
+			name: "without target",
+			file: localfile.WorkoutFile{
+				Workout: &healthautoexport.Workout{Name: "Outdoor Run", Start: mktime("2021-12-24 00:02:43 +0000")},
+			},
+			want: "Outdoor_Run_20211224T000243Z.json",
+		},
+		{
+			name: "slashes are replaced",
+			file: localfile.WorkoutFile{
+				Target:  "john",
+				Workout: &healthautoexport.Workout{Name: "Walk/Run", Start: mktime("2021-12-24 08:02:43 +0800")},
+			},
+			want: "john_Walk_Run_20211224T000243Z.json",
+		},
+		{
+			name: "without start time",
+			file: localfile.WorkoutFile{
+				Target:  "john",
+				Workout: &healthautoexport.Workout{Name: "Outdoor Run"},
+			},
+			want: "john_Outdoor_Run.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.file.GetFileName())
+		})
+	}
+}
+
+func TestWorkoutFile_MergeWorkout(t *testing.T) {
+	elevation := &healthautoexport.Elevation{Units: "m", Ascent: 10, Descent: 5}
+	f := localfile.WorkoutFile{
+		Target: "john",
+		Workout: &healthautoexport.Workout{
+			ID:        "abc",
+			Name:      "Outdoor Run",
+			Start:     mktime("2021-12-24 08:00:00 +0800"),
+			End:       mktime("2021-12-24 08:30:00 +0800"),
+			Elevation: elevation,
+			Route: []*healthautoexport.RouteDatapoint{
+				makeRouteDatapoint("2021-12-24 08:00:00 +0800", 1.0),
+				makeRouteDatapoint("2021-12-24 08:00:02 +0800", 1.2),
+			},
+			HeartRateData: []*healthautoexport.DatapointWithUnit{
+				makeHeartRateDatapoint("2021-12-24 08:00:00 +0800", 100),
+				makeHeartRateDatapoint("2021-12-24 08:01:00 +0800", 110),
+			},
+			Fields: healthautoexport.WorkoutFields{
+				{Key: "activeEnergy", Value: &healthautoexport.QtyWithUnit{Qty: 100, Units: "kcal"}},
+				{Key: "distance", Value: &healthautoexport.QtyWithUnit{Qty: 4, Units: "km"}},
+			},
+		},
+	}
+
+	// Re-export which omits End, Elevation and part of the route, with
+	// timestamps in a different time zone.
+	f.MergeWorkout(&healthautoexport.Workout{
+		Name:  "Outdoor Run",
+		Start: mktime("2021-12-24 01:00:00 +0100"),
+		Route: []*healthautoexport.RouteDatapoint{
+			makeRouteDatapoint("2021-12-24 01:00:01 +0100", 1.1),
+			makeRouteDatapoint("2021-12-24 01:00:02 +0100", 1.25),
+		},
+		HeartRateData: []*healthautoexport.DatapointWithUnit{
+			makeHeartRateDatapoint("2021-12-24 01:01:00 +0100", 115),
+			makeHeartRateDatapoint("2021-12-24 01:02:00 +0100", 120),
+		},
+		Fields: healthautoexport.WorkoutFields{
+			{Key: "distance", Value: &healthautoexport.QtyWithUnit{Qty: 5, Units: "km"}},
+			{Key: "stepCount", Value: &healthautoexport.QtyWithUnit{Qty: 6000, Units: "count"}},
+		},
+	})
+
+	workout := f.Workout
+	assert.Equal(t, "abc", workout.ID)
+	assert.True(t, workout.End.Equal(mktime("2021-12-24 08:30:00 +0800").Time))
+	assert.Same(t, elevation, workout.Elevation)
+
+	var lats []float64
+	for _, datum := range workout.Route {
+		lats = append(lats, datum.Lat)
+	}
+	assert.Equal(t, []float64{1.0, 1.1, 1.25}, lats)
+
+	var qtys []healthautoexport.Qty
+	for _, datum := range workout.HeartRateData {
+		qtys = append(qtys, datum.Qty)
+	}
+	assert.Equal(t, []healthautoexport.Qty{100, 115, 120}, qtys)
+
+	assert.Equal(t, healthautoexport.WorkoutFields{
+		{Key: "activeEnergy", Value: &healthautoexport.QtyWithUnit{Qty: 100, Units: "kcal"}},
+		{Key: "distance", Value: &healthautoexport.QtyWithUnit{Qty: 5, Units: "km"}},
+		{Key: "stepCount", Value: &healthautoexport.QtyWithUnit{Qty: 6000, Units: "count"}},
+	}, workout.Fields)
+}
+
+func TestMetricFile_MergeSleepAnalyses(t *testing.T) {
+	f := localfile.MetricFile{Name: healthautoexport.SleepAnalysisName}
+	f.MergeSleepAnalyses([]*healthautoexport.SleepAnalysis{
+		makeSleepSample("2021-12-17 23:00:00 +0800", "2021-12-18 01:00:00 +0800", "Core"),
+		makeSleepSample("2021-12-18 01:00:00 +0800", "2021-12-18 02:00:00 +0800", "Deep"),
+	}, []*healthautoexport.AggregatedSleepAnalysis{
+		{SleepStart: mktime("2021-12-17 23:00:00 +0800"), Source: "Watch", Asleep: 7},
+	})
+
+	// Re-export in a different time zone, with an interval from another source
+	// at the same instant.
+	iphone := makeSleepSample("2021-12-17 16:00:00 +0100", "2021-12-17 22:00:00 +0100", "In Bed")
+	iphone.Source = "iPhone"
+	f.MergeSleepAnalyses([]*healthautoexport.SleepAnalysis{
+		makeSleepSample("2021-12-17 18:00:00 +0100", "2021-12-17 19:30:00 +0100", "REM"),
+		makeSleepSample("2021-12-17 19:30:00 +0100", "2021-12-17 20:00:00 +0100", "Awake"),
+		iphone,
+	}, []*healthautoexport.AggregatedSleepAnalysis{
+		{SleepStart: mktime("2021-12-17 16:00:00 +0100"), Source: "Watch", Asleep: 7.5},
+	})
+
+	var got []string
+	for _, a := range f.SleepAnalyses {
+		got = append(got, a.StartDate.UTC().Format(time.RFC3339)+" "+a.Source+" "+a.Value)
+	}
+	assert.Equal(t, []string{
+		"2021-12-17T15:00:00Z Watch Core",
+		"2021-12-17T15:00:00Z iPhone In Bed",
+		"2021-12-17T17:00:00Z Watch REM",
+		"2021-12-17T18:30:00Z Watch Awake",
+	}, got)
+	if assert.Len(t, f.AggregatedSleepAnalyses, 1) {
+		assert.Equal(t, healthautoexport.Qty(7.5), f.AggregatedSleepAnalyses[0].Asleep)
+	}
+}
@@ -0,0 +1,265 @@
+package localfile
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	parquetNameKey   = "name"
+	parquetTargetKey = "target"
+	parquetUnitsKey  = "units"
+)
+
+// parquetColumnType is the type of a column in a Parquet file.
+type parquetColumnType int
+
+const (
+	parquetTimestamp parquetColumnType = iota
+	parquetDouble
+	parquetBoolean
+	parquetString
+	parquetJSON
+)
+
+// parquetEncoder encodes a MetricFile as Apache Parquet, with one optional
+// column per field. Times are stored as timestamps in UTC, and columns that
+// contain values of mixed or nested types are stored as JSON. The name, target
+// and units are stored in the key-value metadata of the file.
+type parquetEncoder struct{}
+
+var _ Encoder = parquetEncoder{}
+
+func (parquetEncoder) Extension() string {
+	return ".parquet"
+}
+
+func (parquetEncoder) Encode(w io.Writer, f *MetricFile) error {
+	table, err := newMetricTable(f)
+	if err != nil {
+		return err
+	}
+
+	// Build schema from the types of values in each column.
+	group := make(parquet.Group, len(table.columns))
+	types := make(map[string]parquetColumnType, len(table.columns))
+	for _, column := range table.columns {
+		typ := inferParquetColumnType(table, column)
+		types[column] = typ
+		group[column] = parquet.Optional(parquetColumnNode(typ))
+	}
+	schema := parquet.NewSchema(parquetSchemaName(f), group)
+
+	// Columns of the schema are ordered by name.
+	columns := schema.Columns()
+	rows := make([]parquet.Row, 0, len(table.rows))
+	for _, row := range table.rows {
+		values := make(parquet.Row, len(columns))
+		for i, path := range columns {
+			value, err := parquetValue(types[path[0]], row[path[0]])
+			if err != nil {
+				return errors.Wrapf(err, "cannot convert %v", path[0])
+			}
+			if value.IsNull() {
+				values[i] = value.Level(0, 0, i)
+			} else {
+				values[i] = value.Level(0, 1, i)
+			}
+		}
+		rows = append(rows, values)
+	}
+
+	writer := parquet.NewWriter(w, schema,
+		parquet.KeyValueMetadata(parquetNameKey, f.Name),
+		parquet.KeyValueMetadata(parquetTargetKey, f.Target),
+		parquet.KeyValueMetadata(parquetUnitsKey, string(f.Units)),
+	)
+	if _, err := writer.WriteRows(rows); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func (parquetEncoder) Decode(data []byte) (*MetricFile, error) {
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	columns := file.Schema().Columns()
+	types := make([]parquetColumnType, len(columns))
+	for i, path := range columns {
+		leaf, ok := file.Schema().Lookup(path...)
+		if !ok || len(path) != 1 {
+			return nil, errors.Errorf("unsupported column %v", path)
+		}
+		types[i], err = parquetNodeColumnType(leaf.Node)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unsupported column %v", path[0])
+		}
+	}
+
+	reader := parquet.NewReader(file)
+	defer func() {
+		_ = reader.Close()
+	}()
+	table := &metricTable{}
+	for _, path := range columns {
+		table.columns = append(table.columns, path[0])
+	}
+	rows := make([]parquet.Row, 64)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, values := range rows[:n] {
+			row := make(map[string]interface{}, len(values))
+			for _, value := range values {
+				if value.IsNull() {
+					continue
+				}
+				i := value.Column()
+				v, err := parquetGoValue(types[i], value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "cannot convert %v", columns[i][0])
+				}
+				row[columns[i][0]] = v
+			}
+			table.rows = append(table.rows, row)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	metricFile, err := table.metricFile()
+	if err != nil {
+		return nil, err
+	}
+	metricFile.Name, _ = file.Lookup(parquetNameKey)
+	metricFile.Target, _ = file.Lookup(parquetTargetKey)
+	units, _ := file.Lookup(parquetUnitsKey)
+	metricFile.Units = healthautoexport.Units(units)
+	return metricFile, nil
+}
+
+func parquetSchemaName(f *MetricFile) string {
+	if f.Name == "" {
+		return "metric"
+	}
+	return f.Name
+}
+
+// inferParquetColumnType returns the type of a column from its values.
+func inferParquetColumnType(table *metricTable, column string) parquetColumnType {
+	if timeColumns[column] {
+		return parquetTimestamp
+	}
+	typ, seen := parquetJSON, false
+	for _, row := range table.rows {
+		var valueType parquetColumnType
+		switch row[column].(type) {
+		case nil:
+			continue
+		case float64:
+			valueType = parquetDouble
+		case bool:
+			valueType = parquetBoolean
+		case string:
+			valueType = parquetString
+		default:
+			return parquetJSON
+		}
+		if seen && typ != valueType {
+			return parquetJSON
+		}
+		typ, seen = valueType, true
+	}
+	return typ
+}
+
+func parquetColumnNode(typ parquetColumnType) parquet.Node {
+	switch typ {
+	case parquetTimestamp:
+		return parquet.Timestamp(parquet.Microsecond)
+	case parquetDouble:
+		return parquet.Leaf(parquet.DoubleType)
+	case parquetBoolean:
+		return parquet.Leaf(parquet.BooleanType)
+	case parquetString:
+		return parquet.String()
+	}
+	return parquet.JSON()
+}
+
+func parquetNodeColumnType(node parquet.Node) (parquetColumnType, error) {
+	if logicalType := node.Type().LogicalType(); logicalType != nil {
+		switch {
+		case logicalType.Timestamp != nil:
+			if logicalType.Timestamp.Unit.Micros == nil {
+				return 0, errors.Errorf("unsupported timestamp unit %v", &logicalType.Timestamp.Unit)
+			}
+			return parquetTimestamp, nil
+		case logicalType.UTF8 != nil:
+			return parquetString, nil
+		case logicalType.Json != nil:
+			return parquetJSON, nil
+		}
+	}
+	switch node.Type().Kind() {
+	case parquet.Double:
+		return parquetDouble, nil
+	case parquet.Boolean:
+		return parquetBoolean, nil
+	}
+	return 0, errors.Errorf("unsupported type %v", node.Type())
+}
+
+// parquetValue converts a value of a metricTable to a Parquet value.
+func parquetValue(typ parquetColumnType, value interface{}) (parquet.Value, error) {
+	if value == nil {
+		return parquet.NullValue(), nil
+	}
+	switch typ {
+	case parquetTimestamp:
+		return parquet.Int64Value(value.(time.Time).UnixMicro()), nil
+	case parquetDouble:
+		return parquet.DoubleValue(value.(float64)), nil
+	case parquetBoolean:
+		return parquet.BooleanValue(value.(bool)), nil
+	case parquetString:
+		return parquet.ByteArrayValue([]byte(value.(string))), nil
+	}
+	data, err := jsoniter.Marshal(value)
+	if err != nil {
+		return parquet.Value{}, err
+	}
+	return parquet.ByteArrayValue(data), nil
+}
+
+// parquetGoValue converts a Parquet value to a value of a metricTable.
+func parquetGoValue(typ parquetColumnType, value parquet.Value) (interface{}, error) {
+	switch typ {
+	case parquetTimestamp:
+		return time.UnixMicro(value.Int64()).UTC(), nil
+	case parquetDouble:
+		return value.Double(), nil
+	case parquetBoolean:
+		return value.Boolean(), nil
+	case parquetString:
+		return string(value.ByteArray()), nil
+	}
+	var v interface{}
+	if err := jsoniter.Unmarshal(value.ByteArray(), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,136 @@
+package localfile
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	// defaultPartitionTarget replaces the {target} placeholder if there is no target.
+	defaultPartitionTarget = "default"
+)
+
+var (
+	partitionPlaceholderRegexp = regexp.MustCompile(`{[^{}]*}`)
+
+	partitionPlaceholders = map[string]bool{
+		"{target}": true,
+		"{metric}": true,
+		"{yyyy}":   true,
+		"{mm}":     true,
+		"{dd}":     true,
+	}
+)
+
+// PartitionLayout is a template of the paths of metric files relative to the
+// metrics path, which partitions each metric by the date of its data, e.g.
+// {target}/{metric}/{yyyy}/{mm}/{dd}.json.
+type PartitionLayout struct {
+	layout string
+}
+
+// ParsePartitionLayout parses and validates a PartitionLayout of files with the
+// extension. The layout must contain the {target}, {metric} and {yyyy}
+// placeholders, so that each metric of each target is written to separate
+// files, and may contain {mm} and {dd}.
+func ParsePartitionLayout(layout, ext string) (*PartitionLayout, error) {
+	if !strings.HasSuffix(layout, ext) {
+		return nil, fmt.Errorf("partition layout %q must end with %v", layout, ext)
+	}
+	if path.IsAbs(layout) || strings.HasPrefix(path.Clean(layout), "..") {
+		return nil, fmt.Errorf("partition layout %q must be relative to the metrics path", layout)
+	}
+	for _, placeholder := range partitionPlaceholderRegexp.FindAllString(layout, -1) {
+		if !partitionPlaceholders[placeholder] {
+			return nil, fmt.Errorf("partition layout %q contains unknown placeholder %v", layout, placeholder)
+		}
+	}
+	for _, placeholder := range []string{"{target}", "{metric}", "{yyyy}"} {
+		if !strings.Contains(layout, placeholder) {
+			return nil, fmt.Errorf("partition layout %q must contain %v", layout, placeholder)
+		}
+	}
+	if strings.Contains(layout, "{dd}") && !strings.Contains(layout, "{mm}") {
+		return nil, fmt.Errorf("partition layout %q must contain {mm} if it contains {dd}", layout)
+	}
+	return &PartitionLayout{layout: layout}, nil
+}
+
+func (l *PartitionLayout) String() string {
+	return l.layout
+}
+
+// Path returns the path of the partition containing data of the metric file at
+// time t, relative to the metrics path. The date is taken in UTC, so that data
+// exported from different time zones is partitioned consistently.
+func (l *PartitionLayout) Path(f *MetricFile, t time.Time) string {
+	t = t.UTC()
+	replacer := strings.NewReplacer(
+		"{target}", partitionTarget(f.Target),
+		"{metric}", strings.TrimSuffix(MetricFile{Name: f.Name, Units: f.Units}.GetFileName(), ".json"),
+		"{yyyy}", fmt.Sprintf("%04d", t.Year()),
+		"{mm}", fmt.Sprintf("%02d", t.Month()),
+		"{dd}", fmt.Sprintf("%02d", t.Day()),
+	)
+	return path.Clean(replacer.Replace(l.layout))
+}
+
+// partitionTarget returns the value of the {target} placeholder, which must not
+// escape the metrics path. Separators are replaced, and targets which only
+// consist of dots (e.g. "..") are escaped.
+func partitionTarget(target string) string {
+	if target == "" {
+		return defaultPartitionTarget
+	}
+	target = strings.ReplaceAll(target, "/", "_")
+	if strings.Trim(target, ".") == "" {
+		target = strings.ReplaceAll(target, ".", "_")
+	}
+	return target
+}
+
+// Partition splits the data of the metric file into partitions, keyed by the
+// path of each partition. Sleep analyses are partitioned by their start time,
+// and data without a time is dropped.
+func (l *PartitionLayout) Partition(f *MetricFile) map[string]*MetricFile {
+	partitions := make(map[string]*MetricFile)
+	get := func(t *healthautoexport.Time) *MetricFile {
+		name := l.Path(f, t.Time)
+		partition, ok := partitions[name]
+		if !ok {
+			partition = &MetricFile{Name: f.Name, Target: f.Target, Units: f.Units}
+			partitions[name] = partition
+		}
+		return partition
+	}
+
+	for _, datum := range f.Data {
+		if datum.Date.IsZero() {
+			continue
+		}
+		partition := get(datum.Date)
+		partition.Data = append(partition.Data, datum)
+	}
+	for _, analysis := range f.SleepAnalyses {
+		if analysis.StartDate.IsZero() {
+			continue
+		}
+		partition := get(analysis.StartDate)
+		partition.SleepAnalyses = append(partition.SleepAnalyses, analysis)
+	}
+	for _, analysis := range f.AggregatedSleepAnalyses {
+		start := aggregatedSleepStart(analysis)
+		if start.IsZero() {
+			continue
+		}
+		partition := get(start)
+		partition.AggregatedSleepAnalyses = append(partition.AggregatedSleepAnalyses, analysis)
+	}
+
+	return partitions
+}
@@ -0,0 +1,182 @@
+package localfile_test
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+func TestParsePartitionLayout(t *testing.T) {
+	sgt := time.FixedZone("SGT", 8*60*60)
+	tests := []struct {
+		name    string
+		layout  string
+		target  string
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:   "daily",
+			layout: "{target}/{metric}/{yyyy}/{mm}/{dd}.json",
+			target: "john",
+			want:   "john/heart_rate_count_min/2021/12/24.json",
+		},
+		{
+			name:   "monthly",
+			layout: "{metric}/{target}-{yyyy}-{mm}.json",
+			target: "john",
+			want:   "heart_rate_count_min/john-2021-12.json",
+		},
+		{
+			name:   "default target",
+			layout: "{target}/{metric}/{yyyy}.json",
+			want:   "default/heart_rate_count_min/2021.json",
+		},
+		{
+			name:   "target with separators",
+			layout: "{target}/{metric}/{yyyy}.json",
+			target: "john/../doe",
+			want:   "john_.._doe/heart_rate_count_min/2021.json",
+		},
+		{
+			name:   "target outside metrics path",
+			layout: "{target}/{metric}/{yyyy}.json",
+			target: "..",
+			want:   "__/heart_rate_count_min/2021.json",
+		},
+		{
+			name:   "current directory target",
+			layout: "{target}/{metric}/{yyyy}.json",
+			target: ".",
+			want:   "_/heart_rate_count_min/2021.json",
+		},
+		{
+			name:    "missing json extension",
+			layout:  "{target}/{metric}/{yyyy}",
+			wantErr: testutils.AssertErrorContains("must end with .json"),
+		},
+		{
+			name:    "missing metric",
+			layout:  "{target}/{yyyy}.json",
+			wantErr: testutils.AssertErrorContains("must contain {metric}"),
+		},
+		{
+			name:    "missing target",
+			layout:  "{metric}/{yyyy}.json",
+			wantErr: testutils.AssertErrorContains("must contain {target}"),
+		},
+		{
+			name:    "missing year",
+			layout:  "{target}/{metric}/{mm}/{dd}.json",
+			wantErr: testutils.AssertErrorContains("must contain {yyyy}"),
+		},
+		{
+			name:    "day without month",
+			layout:  "{target}/{metric}/{yyyy}/{dd}.json",
+			wantErr: testutils.AssertErrorContains("must contain {mm}"),
+		},
+		{
+			name:    "unknown placeholder",
+			layout:  "{target}/{metric}/{yyyy}/{hh}.json",
+			wantErr: testutils.AssertErrorContains("unknown placeholder {hh}"),
+		},
+		{
+			name:    "outside metrics path",
+			layout:  "../{target}/{metric}/{yyyy}.json",
+			wantErr: testutils.AssertErrorContains("must be relative"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			layout, err := localfile.ParsePartitionLayout(tt.layout, ".json")
+			if testutils.WantError(t, tt.wantErr, err) {
+				return
+			}
+			f := &localfile.MetricFile{Name: "heart_rate", Target: tt.target, Units: "count/min"}
+			// The date is taken in UTC.
+			assert.Equal(t, tt.want, layout.Path(f, time.Date(2021, 12, 25, 1, 0, 0, 0, sgt)))
+		})
+	}
+}
+
+func readPartition(t *testing.T, name string) map[int64]float64 {
+	data, err := os.ReadFile(name)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	var metricFile localfile.MetricFile
+	assert.NoError(t, jsoniter.Unmarshal(data, &metricFile))
+	qtys := make(map[int64]float64, len(metricFile.Data))
+	for _, datum := range metricFile.Data {
+		qtys[datum.Date.Unix()] = float64(datum.Qty)
+	}
+	return qtys
+}
+
+func TestBackend_PartitionLayout(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, pflag.Set("localfile.metricsPath", dir))
+	assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+	assert.NoError(t, pflag.Set("localfile.backups", "0"))
+	defer func() {
+		assert.NoError(t, pflag.Set("localfile.partitionLayout", ""))
+	}()
+
+	// Write a single file before partitioning is enabled.
+	assert.NoError(t, pflag.Set("localfile.partitionLayout", ""))
+	backend, err := localfile.NewBackend()
+	if !assert.NoError(t, err) {
+		return
+	}
+	day := int64(24 * 60 * 60)
+	assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{0: 60, day: 61}), "test"))
+	assert.NoError(t, backend.Close())
+	assert.FileExists(t, path.Join(dir, "test_heart_rate_count_min.json"))
+
+	assert.NoError(t, pflag.Set("localfile.partitionLayout", "{target}/{metric}/{yyyy}/{mm}/{dd}.json"))
+	backend, err = localfile.NewBackend()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		_ = backend.Close()
+	}()
+
+	// The single file is migrated to partitions on the next write.
+	assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{day + 1: 71}), "test"))
+	assert.NoFileExists(t, path.Join(dir, "test_heart_rate_count_min.json"))
+	partitionDir := path.Join(dir, "test", "heart_rate_count_min", "1970", "01")
+	day1 := path.Join(partitionDir, "01.json")
+	day2 := path.Join(partitionDir, "02.json")
+	assert.Equal(t, map[int64]float64{0: 60}, readPartition(t, day1))
+	assert.Equal(t, map[int64]float64{day: 61, day + 1: 71}, readPartition(t, day2))
+
+	// Only partitions touched by the payload are rewritten.
+	info, err := os.Stat(day1)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chtimes(day1, info.ModTime(), time.Unix(0, 0)))
+	assert.NoError(t, backend.Write(makeHeartRatePayload(map[int64]float64{day: 81, 2 * day: 82}), "test"))
+	info, err = os.Stat(day1)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(0, 0), info.ModTime())
+	assert.Equal(t, map[int64]float64{0: 60}, readPartition(t, day1))
+	assert.Equal(t, map[int64]float64{day: 81, day + 1: 71}, readPartition(t, day2))
+	assert.Equal(t, map[int64]float64{2 * day: 82}, readPartition(t, path.Join(partitionDir, "03.json")))
+
+	// Partitions are not supported by the jsonl format.
+	assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSONL)))
+	defer func() {
+		assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+	}()
+	_, err = localfile.NewBackend()
+	testutils.AssertErrorContains("not supported by the jsonl format")(t, err)
+}
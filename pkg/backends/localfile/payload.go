@@ -0,0 +1,220 @@
+package localfile
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+var _ backends.Reader = &Backend{}
+
+// ToMetric converts the MetricFile back to a Metric.
+func (f *MetricFile) ToMetric() *healthautoexport.Metric {
+	return &healthautoexport.Metric{
+		Name:                    f.Name,
+		Units:                   f.Units,
+		Datapoints:              f.Data,
+		SleepAnalyses:           f.SleepAnalyses,
+		AggregatedSleepAnalyses: f.AggregatedSleepAnalyses,
+	}
+}
+
+// AddToPayload adds the events in the EventFile to the payload data.
+func (f *EventFile) AddToPayload(data *healthautoexport.PayloadData) error {
+	var events interface{}
+	switch f.Type {
+	case "symptoms":
+		events = &data.Symptoms
+	case "medications":
+		events = &data.Medications
+	case "ecg":
+		events = &data.ECG
+	case "heartRateNotifications":
+		events = &data.HeartRateNotifications
+	case "stateOfMind":
+		events = &data.StateOfMind
+	case "cycleTracking":
+		events = &data.CycleTracking
+	default:
+		return errors.Errorf("unknown event type %v", f.Type)
+	}
+	bytes, err := jsoniter.Marshal(f.Data)
+	if err != nil {
+		return err
+	}
+	return jsoniter.Unmarshal(bytes, events)
+}
+
+// ReadPayload reads a file written by the LocalFile backend as a payload,
+// together with the target that it was ingested with. Metric files in the JSON,
+// CSV and Parquet formats, workout and event files, and JSON Lines stores (given as
+// a directory) are supported. A nil payload is returned for files which only
+// contain data derived from other files, such as sleep sessions.
+func ReadPayload(name string) (*healthautoexport.Payload, string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if info.IsDir() {
+		store, err := OpenJSONLStore(name, segmentSize)
+		if err != nil {
+			return nil, "", err
+		}
+		metricFile, err := store.ReadAll()
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "cannot read %v", name)
+		}
+		return metricPayload(metricFile), metricFile.Target, nil
+	}
+
+	if isSidecarName(name) {
+		return nil, "", errors.Errorf("unsupported sidecar file %v", name)
+	}
+	switch path.Ext(name) {
+	case csvEncoder{}.Extension(), parquetEncoder{}.Extension():
+		metricFile, err := ReadMetricFile(name)
+		if err != nil {
+			return nil, "", err
+		}
+		if metricFile.Name == "" {
+			return nil, "", errors.Errorf("missing metric name in %v", name)
+		}
+		return metricPayload(metricFile), metricFile.Target, nil
+	case jsonEncoder{}.Extension():
+	default:
+		return nil, "", errors.Errorf("unsupported file %v", name)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Determine the type of file from its fields.
+	var fields map[string]jsoniter.RawMessage
+	if err := jsoniter.Unmarshal(data, &fields); err != nil {
+		return nil, "", errors.Wrapf(err, "cannot decode %v", name)
+	}
+	var target string
+	if raw, ok := fields["target"]; ok {
+		if err := jsoniter.Unmarshal(raw, &target); err != nil {
+			return nil, "", errors.Wrapf(err, "cannot decode %v", name)
+		}
+	}
+	payload := &healthautoexport.Payload{Data: &healthautoexport.PayloadData{}}
+	switch {
+	case fields["workout"] != nil:
+		var workoutFile WorkoutFile
+		if err := jsoniter.Unmarshal(data, &workoutFile); err != nil {
+			return nil, "", errors.Wrapf(err, "cannot decode %v", name)
+		}
+		payload.Data.Workouts = []*healthautoexport.Workout{workoutFile.Workout}
+	case fields["type"] != nil:
+		var eventFile EventFile
+		if err := jsoniter.Unmarshal(data, &eventFile); err != nil {
+			return nil, "", errors.Wrapf(err, "cannot decode %v", name)
+		}
+		if err := eventFile.AddToPayload(payload.Data); err != nil {
+			return nil, "", errors.Wrapf(err, "cannot convert %v", name)
+		}
+	case fields["name"] != nil:
+		metricFile, err := jsonEncoder{}.Decode(data)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "cannot decode %v", name)
+		}
+		payload = metricPayload(metricFile)
+	case strings.HasSuffix(path.Base(name), SleepSessionFile{}.GetFileName()):
+		// Sleep sessions are reconstructed from sleep analysis metrics.
+		return nil, target, nil
+	default:
+		return nil, "", errors.Errorf("unknown file %v", name)
+	}
+
+	return payload, target, nil
+}
+
+// ReadMetricFile reads a metric file in the JSON, CSV or Parquet format, which
+// is determined by its extension, together with its sidecar file if the format
+// uses one.
+func ReadMetricFile(name string) (*MetricFile, error) {
+	var encoder Encoder
+	for _, format := range []Format{FormatJSON, FormatCSV, FormatParquet} {
+		if e, _ := NewEncoder(format); path.Ext(name) == e.Extension() {
+			encoder = e
+		}
+	}
+	if encoder == nil {
+		return nil, errors.Errorf("unsupported file %v", name)
+	}
+	metricFile, err := decodeFile(encoder, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode %v", name)
+	}
+	return metricFile, nil
+}
+
+func metricPayload(f *MetricFile) *healthautoexport.Payload {
+	return &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{
+			Metrics: []*healthautoexport.Metric{f.ToMetric()},
+		},
+	}
+}
+
+// Read reads all metrics, workouts and health events in metricsPath, with one
+// payload per metric file, JSON Lines store, partition, workout or event type.
+func (b *Backend) Read(fn func(payload *healthautoexport.Payload, targetName string) error) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	err := filepath.WalkDir(metricsPath, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Sleep sessions are reconstructed from sleep analysis metrics.
+			if name == path.Join(metricsPath, sleepDir) {
+				return filepath.SkipDir
+			}
+			if !fileExists(path.Join(name, indexFileName)) {
+				return nil
+			}
+		} else {
+			switch path.Ext(name) {
+			case jsonEncoder{}.Extension(), csvEncoder{}.Extension(), parquetEncoder{}.Extension():
+			default:
+				// Skip backups, temporary files and files of other formats.
+				return nil
+			}
+			if isSidecarName(name) {
+				return nil
+			}
+		}
+
+		payload, target, err := ReadPayload(name)
+		if err != nil {
+			return err
+		}
+		if payload != nil {
+			if err := fn(payload, target); err != nil {
+				return err
+			}
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
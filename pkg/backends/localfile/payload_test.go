@@ -0,0 +1,140 @@
+package localfile_test
+
+import (
+	"path"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+func TestReadPayload(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, pflag.Set("localfile.metricsPath", dir))
+	assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+	assert.NoError(t, pflag.Set("localfile.backups", "0"))
+	assert.NoError(t, pflag.Set("localfile.partitionLayout", ""))
+
+	backend, err := localfile.NewBackend()
+	if !assert.NoError(t, err) {
+		return
+	}
+	payload := makeHeartRatePayload(map[int64]float64{1000: 60, 1001: 61})
+	start := newTime(1000)
+	payload.Data.Workouts = []*healthautoexport.Workout{{Name: "Walking", Start: start}}
+	payload.Data.Symptoms = []*healthautoexport.Symptom{{Start: start, Name: "Headache"}}
+	assert.NoError(t, backend.Write(payload, "test"))
+	assert.NoError(t, backend.Close())
+
+	tests := []struct {
+		name    string
+		file    string
+		check   func(t *testing.T, data *healthautoexport.PayloadData)
+		wantErr bool
+	}{
+		{
+			name: "metric",
+			file: "test_heart_rate_count_min.json",
+			check: func(t *testing.T, data *healthautoexport.PayloadData) {
+				if assert.Len(t, data.Metrics, 1) {
+					assert.Equal(t, "heart_rate", data.Metrics[0].Name)
+					assert.Len(t, data.Metrics[0].Datapoints, 2)
+				}
+			},
+		},
+		{
+			name: "workout",
+			file: "workouts/test_Walking_" + newTime(1000).UTC().Format("20060102T150405Z") + ".json",
+			check: func(t *testing.T, data *healthautoexport.PayloadData) {
+				if assert.Len(t, data.Workouts, 1) {
+					assert.Equal(t, "Walking", data.Workouts[0].Name)
+				}
+			},
+		},
+		{
+			name: "events",
+			file: "events/test_symptoms.json",
+			check: func(t *testing.T, data *healthautoexport.PayloadData) {
+				if assert.Len(t, data.Symptoms, 1) {
+					assert.Equal(t, "Headache", data.Symptoms[0].Name)
+				}
+			},
+		},
+		{
+			name:    "missing file",
+			file:    "missing.json",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, target, err := localfile.ReadPayload(path.Join(dir, tt.file))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, "test", target)
+			tt.check(t, payload.Data)
+		})
+	}
+}
+
+func TestBackend_Read(t *testing.T) {
+	tests := []struct {
+		format localfile.Format
+	}{
+		{format: localfile.FormatJSON},
+		{format: localfile.FormatJSONL},
+		{format: localfile.FormatCSV},
+		{format: localfile.FormatParquet},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.format), func(t *testing.T) {
+			dir := t.TempDir()
+			assert.NoError(t, pflag.Set("localfile.metricsPath", dir))
+			assert.NoError(t, pflag.Set("localfile.format", string(tt.format)))
+			assert.NoError(t, pflag.Set("localfile.backups", "1"))
+			assert.NoError(t, pflag.Set("localfile.partitionLayout", ""))
+			defer func() {
+				assert.NoError(t, pflag.Set("localfile.format", string(localfile.FormatJSON)))
+			}()
+
+			backend, err := localfile.NewBackend()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer backend.Close()
+			payload := makeHeartRatePayload(map[int64]float64{1000: 60, 1001: 61})
+			start := newTime(1000)
+			payload.Data.Workouts = []*healthautoexport.Workout{{Name: "Walking", Start: start}}
+			payload.Data.Symptoms = []*healthautoexport.Symptom{{Start: start, Name: "Headache"}}
+			assert.NoError(t, backend.Write(payload, "test"))
+			assert.NoError(t, backend.Write(payload, "test"))
+
+			data := &healthautoexport.PayloadData{}
+			err = backend.Read(func(payload *healthautoexport.Payload, targetName string) error {
+				assert.Equal(t, "test", targetName)
+				data.Metrics = append(data.Metrics, payload.Data.Metrics...)
+				data.Workouts = append(data.Workouts, payload.Data.Workouts...)
+				data.Symptoms = append(data.Symptoms, payload.Data.Symptoms...)
+				return nil
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			if assert.Len(t, data.Metrics, 1) {
+				assert.Equal(t, "heart_rate", data.Metrics[0].Name)
+				assert.Len(t, data.Metrics[0].Datapoints, 2)
+			}
+			assert.Len(t, data.Workouts, 1)
+			assert.Len(t, data.Symptoms, 1)
+		})
+	}
+}
@@ -10,6 +10,7 @@ type Backend struct {
 	Writes      []*healthautoexport.Payload
 	ShouldError bool
 	ShouldPanic bool
+	Closed      bool
 }
 
 var _ backends.Backend = &Backend{}
@@ -32,3 +33,8 @@ func (b *Backend) Write(payload *healthautoexport.Payload, _ string) error {
 	b.Writes = append(b.Writes, payload)
 	return nil
 }
+
+func (b *Backend) Close() error {
+	b.Closed = true
+	return nil
+}
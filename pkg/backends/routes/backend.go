@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"io"
+	"path"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/routes"
+	utilfile "github.com/irvinlim/apple-health-ingester/pkg/util/file"
+)
+
+var (
+	outputPath string
+	formats    []string
+)
+
+// Backend Routes is used to export workout routes as files in the local
+// filesystem, which can be imported into mapping tools. Each workout with a
+// route is written once in each of the configured formats, and any existing
+// file for the same workout is overwritten.
+type Backend struct {
+	formats []routes.Format
+}
+
+var _ backends.Backend = &Backend{}
+
+func NewBackend() (*Backend, error) {
+	if outputPath == "" {
+		return nil, errors.New("--routes.outputPath is not set")
+	}
+
+	backend := &Backend{}
+	for _, f := range formats {
+		format, err := routes.ParseFormat(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid --routes.formats")
+		}
+		backend.formats = append(backend.formats, format)
+	}
+	if len(backend.formats) == 0 {
+		return nil, errors.New("--routes.formats is empty")
+	}
+
+	return backend, nil
+}
+
+func (b *Backend) Name() string {
+	return "Routes"
+}
+
+// Write will export the route of each workout in the payload.
+func (b *Backend) Write(payload *healthautoexport.Payload, target string) error {
+	// Properly handle nil data.
+	if payload == nil || payload.Data == nil {
+		log.WithFields(log.Fields{
+			"backend": b.Name(),
+			"target":  target,
+		}).Warn("empty payload data received, skipping")
+		return nil
+	}
+
+	var count int
+	for _, workout := range payload.Data.Workouts {
+		if !routes.HasRoute(workout) {
+			continue
+		}
+		for _, format := range b.formats {
+			name := path.Join(outputPath, routes.FileName(target, workout, format))
+			if err := b.writeRouteFile(name, workout, format); err != nil {
+				return errors.Wrapf(err, "cannot write route to %v", name)
+			}
+			count++
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"backend": b.Name(),
+		"target":  target,
+		"files":   count,
+	}).Info("write all routes success")
+
+	return nil
+}
+
+// writeRouteFile encodes the route of the workout and writes it to the file.
+// The file is written atomically, so that a crash does not leave a truncated
+// file behind.
+func (b *Backend) writeRouteFile(name string, workout *healthautoexport.Workout, format routes.Format) error {
+	return utilfile.WriteAtomic(name, 0, func(w io.Writer) error {
+		return routes.Encode(w, workout, format)
+	})
+}
+
+func init() {
+	pflag.StringVar(&outputPath, "routes.outputPath", "",
+		"Output path to write workout routes, with one file per workout per format.")
+	pflag.StringSliceVar(&formats, "routes.formats", []string{"gpx", "tcx", "geojson"},
+		"Formats to export workout routes in. Supported formats: gpx, tcx, geojson.")
+}
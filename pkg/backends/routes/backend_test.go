@@ -0,0 +1,98 @@
+package routes_test
+
+import (
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/routes"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+func mktime(ts string) *healthautoexport.Time {
+	t, err := healthautoexport.ParseTime(ts)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBackend_Write(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, pflag.Set("routes.outputPath", dir))
+	assert.NoError(t, pflag.Set("routes.formats", "gpx,geojson"))
+
+	backend, err := routes.NewBackend()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	workout := &healthautoexport.Workout{
+		Name:  "Outdoor Run",
+		Start: mktime("2021-12-24 08:00:00 +0800"),
+		End:   mktime("2021-12-24 08:10:00 +0800"),
+		Route: []*healthautoexport.RouteDatapoint{
+			{Lat: 1.3, Lon: 103.8, Altitude: 10, Timestamp: mktime("2021-12-24 08:00:30 +0800")},
+		},
+	}
+	payload := &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{
+			Workouts: []*healthautoexport.Workout{
+				workout,
+				// Workouts without a route are skipped.
+				{Name: "Indoor Walk", Start: mktime("2021-12-24 09:00:00 +0800")},
+			},
+		},
+	}
+	assert.NoError(t, backend.Write(payload, "test"))
+	assert.Equal(t, []string{
+		"test_Outdoor_Run_20211224T080000+0800.geojson",
+		"test_Outdoor_Run_20211224T080000+0800.gpx",
+	}, listFiles(t, dir))
+	geojson, err := os.ReadFile(path.Join(dir, "test_Outdoor_Run_20211224T080000+0800.geojson"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(geojson), `"Point"`)
+
+	// Re-exports replace existing files without leaving temporary files behind.
+	workout.Route = append(workout.Route,
+		&healthautoexport.RouteDatapoint{Lat: 1.301, Lon: 103.8, Altitude: 12.5, Timestamp: mktime("2021-12-24 08:05:00 +0800")})
+	assert.NoError(t, backend.Write(payload, "test"))
+	assert.Equal(t, []string{
+		"test_Outdoor_Run_20211224T080000+0800.geojson",
+		"test_Outdoor_Run_20211224T080000+0800.gpx",
+	}, listFiles(t, dir))
+	geojson, err = os.ReadFile(path.Join(dir, "test_Outdoor_Run_20211224T080000+0800.geojson"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(geojson), `"LineString"`)
+
+	// Empty payloads are skipped.
+	assert.NoError(t, backend.Write(nil, "test"))
+	assert.NoError(t, backend.Write(&healthautoexport.Payload{}, "test"))
+}
+
+func TestNewBackend(t *testing.T) {
+	assert.NoError(t, pflag.Set("routes.outputPath", t.TempDir()))
+	assert.NoError(t, pflag.Set("routes.formats", "kml"))
+	_, err := routes.NewBackend()
+	testutils.AssertErrorContains("invalid --routes.formats")(t, err)
+
+	assert.NoError(t, pflag.Set("routes.outputPath", ""))
+	_, err = routes.NewBackend()
+	testutils.AssertErrorContains("--routes.outputPath is not set")(t, err)
+}
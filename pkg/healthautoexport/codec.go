@@ -0,0 +1,257 @@
+package healthautoexport
+
+import (
+	"io"
+	"reflect"
+	"sync"
+	"unsafe" // nolint:gosec
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+var (
+	// jsonAPI is the default jsoniter.API used when no options are specified.
+	jsonAPI = jsoniter.ConfigDefault
+
+	timeType        = reflect.TypeOf(Time{})
+	payloadType     = reflect.TypeOf(Payload{})
+	payloadDataType = reflect.TypeOf(PayloadData{})
+	metricType      = reflect.TypeOf(Metric{})
+	workoutType     = reflect.TypeOf(Workout{})
+	datapointType   = reflect.TypeOf(Datapoint{})
+
+	sleepAnalysisType               = reflect.TypeOf(SleepAnalysis{})
+	aggregatedSleepAnalysisType     = reflect.TypeOf(AggregatedSleepAnalysis{})
+	datapointWithUnitType           = reflect.TypeOf(DatapointWithUnit{})
+	routeDatapointType              = reflect.TypeOf(RouteDatapoint{})
+	elevationType                   = reflect.TypeOf(Elevation{})
+	symptomType                     = reflect.TypeOf(Symptom{})
+	medicationType                  = reflect.TypeOf(Medication{})
+	ecgType                         = reflect.TypeOf(ECG{})
+	voltageMeasurementType          = reflect.TypeOf(VoltageMeasurement{})
+	heartRateNotificationType       = reflect.TypeOf(HeartRateNotification{})
+	heartRateNotificationSampleType = reflect.TypeOf(HeartRateNotificationSample{})
+	timeIntervalType                = reflect.TypeOf(TimeInterval{})
+	stateOfMindType                 = reflect.TypeOf(StateOfMind{})
+	cycleTrackingType               = reflect.TypeOf(CycleTracking{})
+
+	// apiTypes is the set of types which implement apiMarshaler and apiUnmarshaler.
+	apiTypes = map[reflect.Type]struct{}{
+		payloadType:                     {},
+		payloadDataType:                 {},
+		metricType:                      {},
+		workoutType:                     {},
+		datapointType:                   {},
+		sleepAnalysisType:               {},
+		aggregatedSleepAnalysisType:     {},
+		datapointWithUnitType:           {},
+		routeDatapointType:              {},
+		elevationType:                   {},
+		symptomType:                     {},
+		medicationType:                  {},
+		ecgType:                         {},
+		voltageMeasurementType:          {},
+		heartRateNotificationType:       {},
+		heartRateNotificationSampleType: {},
+		timeIntervalType:                {},
+		stateOfMindType:                 {},
+		cycleTrackingType:               {},
+	}
+
+	// decodeAPI is the jsoniter.API used to unmarshal payloads. It is shared
+	// across all payloads, since the TimeParser for each payload is passed to
+	// the decoders through parserAPI instead.
+	decodeAPI = newJSONAPI(jsoniter.Config{EscapeHTML: true, SortMapKeys: true, ValidateJsonRawMessage: true}, DefaultTimeFormat)
+
+	// encodeAPIs caches the jsoniter.API used to marshal payloads for each time format.
+	encodeAPIs sync.Map
+)
+
+// apiUnmarshaler is implemented by types with custom unmarshaling using a jsoniter.API.
+type apiUnmarshaler interface {
+	unmarshalJSON(api jsoniter.API, data []byte) error
+}
+
+// apiMarshaler is implemented by types with custom marshaling using a jsoniter.API.
+type apiMarshaler interface {
+	marshalJSON(api jsoniter.API) ([]byte, error)
+}
+
+// newJSONAPI returns a new jsoniter.API which uses timeFormat to format
+// timestamps. Timestamps are parsed with the TimeParser of the parserAPI that
+// is used to unmarshal, or a new TimeParser with the default layouts otherwise.
+//
+// Types which implement custom json.Marshaler and json.Unmarshaler methods
+// cannot receive any options, and use the package defaults. The returned API
+// overrides these methods with the given options, which allows the options to
+// be scoped to a single payload instead of the entire package.
+//
+// Freezing a jsoniter.Config discards all cached encoders and decoders, so the
+// returned API should be reused whenever possible.
+func newJSONAPI(config jsoniter.Config, timeFormat string) jsoniter.API {
+	api := config.Froze()
+	api.RegisterExtension(&codecExtension{
+		api:        api,
+		timeFormat: timeFormat,
+	})
+	return api
+}
+
+// encodeAPI returns the cached jsoniter.API used to marshal payloads with timeFormat.
+func encodeAPI(timeFormat string) jsoniter.API {
+	if api, ok := encodeAPIs.Load(timeFormat); ok {
+		return api.(jsoniter.API)
+	}
+	api, _ := encodeAPIs.LoadOrStore(timeFormat, newJSONAPI(jsoniter.Config{EscapeHTML: true, SortMapKeys: true}, timeFormat))
+	return api.(jsoniter.API)
+}
+
+// parserAPI is a jsoniter.API which unmarshals timestamps using parser.
+//
+// The parser is attached to the jsoniter.Iterator, which is passed down to the
+// decoders of all nested values, so that format detection is scoped to a single
+// payload even though the underlying jsoniter.API is shared.
+type parserAPI struct {
+	jsoniter.API
+	parser *TimeParser
+}
+
+func (a parserAPI) Unmarshal(data []byte, v interface{}) error {
+	iter := a.BorrowIterator(data)
+	defer a.ReturnIterator(iter)
+	iter.Attachment = a.parser
+	iter.ReadVal(v)
+	if iter.Error != nil && iter.Error != io.EOF {
+		return iter.Error
+	}
+	return nil
+}
+
+// iteratorParser returns the TimeParser attached to iter, or a new TimeParser
+// with the default layouts if there is none.
+func iteratorParser(iter *jsoniter.Iterator) *TimeParser {
+	if parser, ok := iter.Attachment.(*TimeParser); ok {
+		return parser
+	}
+	parser := NewTimeParser()
+	iter.Attachment = parser
+	return parser
+}
+
+// codecExtension is a jsoniter.Extension that overrides encoding and decoding of custom types.
+type codecExtension struct {
+	jsoniter.DummyExtension
+	api        jsoniter.API
+	timeFormat string
+}
+
+func (e *codecExtension) CreateDecoder(typ reflect2.Type) jsoniter.ValDecoder {
+	if typ.Type1() == timeType {
+		return &timeCodec{typ: typ}
+	}
+	if _, ok := apiTypes[typ.Type1()]; ok {
+		return &apiCodec{typ: typ, api: e.api}
+	}
+	return nil
+}
+
+func (e *codecExtension) CreateEncoder(typ reflect2.Type) jsoniter.ValEncoder {
+	elemType := typ.Type1()
+	isPtr := typ.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType == timeType {
+		return &timeCodec{typ: typ, isPtr: isPtr, timeFormat: e.timeFormat}
+	}
+	if _, ok := apiTypes[elemType]; ok {
+		return &apiCodec{typ: typ, isPtr: isPtr, api: e.api}
+	}
+	return nil
+}
+
+// apiCodec encodes and decodes types that implement apiMarshaler and apiUnmarshaler.
+type apiCodec struct {
+	typ   reflect2.Type
+	isPtr bool
+	api   jsoniter.API
+}
+
+func (c *apiCodec) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+	data := iter.SkipAndReturnBytes()
+	if iter.Error != nil {
+		return
+	}
+	obj := c.typ.PackEFace(ptr).(apiUnmarshaler)
+	api := parserAPI{API: c.api, parser: iteratorParser(iter)}
+	if err := obj.unmarshalJSON(api, data); err != nil {
+		iter.ReportError("decode "+c.typ.String(), err.Error())
+	}
+}
+
+func (c *apiCodec) IsEmpty(ptr unsafe.Pointer) bool {
+	return c.isPtr && *(*unsafe.Pointer)(ptr) == nil
+}
+
+func (c *apiCodec) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	if c.IsEmpty(ptr) {
+		stream.WriteNil()
+		return
+	}
+	obj := c.value(ptr).(apiMarshaler)
+	data, err := obj.marshalJSON(c.api)
+	if err != nil {
+		stream.Error = err
+		return
+	}
+	_, _ = stream.Write(data)
+}
+
+// value returns a pointer to the value referenced by ptr.
+func (c *apiCodec) value(ptr unsafe.Pointer) interface{} {
+	if c.isPtr {
+		return c.typ.UnsafeIndirect(ptr)
+	}
+	return c.typ.PackEFace(ptr)
+}
+
+// timeCodec encodes and decodes Time using the given options.
+type timeCodec struct {
+	typ        reflect2.Type
+	isPtr      bool
+	timeFormat string
+}
+
+func (c *timeCodec) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+	data := iter.SkipAndReturnBytes()
+	if iter.Error != nil {
+		return
+	}
+	if err := (*Time)(ptr).unmarshalJSON(iteratorParser(iter), data); err != nil {
+		iter.ReportError("decode time", err.Error())
+	}
+}
+
+func (c *timeCodec) IsEmpty(ptr unsafe.Pointer) bool {
+	return c.isPtr && *(*unsafe.Pointer)(ptr) == nil
+}
+
+func (c *timeCodec) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	if c.IsEmpty(ptr) {
+		stream.WriteNil()
+		return
+	}
+	var t *Time
+	if c.isPtr {
+		t = *(**Time)(ptr)
+	} else {
+		t = (*Time)(ptr)
+	}
+	data, err := t.marshalJSON(c.timeFormat)
+	if err != nil {
+		stream.Error = err
+		return
+	}
+	_, _ = stream.Write(data)
+}
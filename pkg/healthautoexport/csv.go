@@ -0,0 +1,350 @@
+package healthautoexport
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	csvColumnDateTime    = "Date/Time"
+	csvColumnWorkoutType = "Workout Type"
+	csvColumnStart       = "Start"
+	csvColumnEnd         = "End"
+	csvColumnDuration    = "Duration"
+
+	csvColumnElevationAscended  = "elevationAscended"
+	csvColumnElevationDescended = "elevationDescended"
+)
+
+var (
+	// csvHeaderRegexp matches a CSV column header in the form of "Name [Field] (Units)",
+	// where both the field and units are optional.
+	csvHeaderRegexp = regexp.MustCompile(`^(.+?)(?:\s*\[(.+)\])?(?:\s*\((.+)\))?$`)
+
+	// csvLocalTimeFormats contains time formats without a zone offset which may
+	// be used in CSV exports. Timestamps are normalized before parsing.
+	csvLocalTimeFormats = []string{
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02 3:04:05 PM",
+		"2006-01-02 PM 3:04:05",
+		"2006-01-02T15:04:05",
+	}
+
+	// csvFieldNames maps bracketed field names in CSV headers to their
+	// equivalent keys in the JSON format.
+	csvFieldNames = map[string]string{
+		"Asleep": "asleep",
+		"In Bed": "inBed",
+		"Awake":  "awake",
+		"Core":   "core",
+		"Deep":   "deep",
+		"REM":    "rem",
+	}
+)
+
+// csvColumn describes a single parsed CSV column header.
+type csvColumn struct {
+	// Name of the metric or workout field, converted to the equivalent name in the JSON format.
+	Name string
+	// Field is an optional key for metrics which have multiple fields (e.g. Min/Avg/Max).
+	Field string
+	// Units of the column.
+	Units Units
+}
+
+// CSVDecoder decodes CSV files exported by Health Auto Export into a Payload.
+//
+// Both the metrics format (a "Date/Time" column followed by one column per
+// metric, with units in the header) and the workouts format (one row per
+// workout) are supported. The format is detected from the header row.
+type CSVDecoder struct {
+	r *csv.Reader
+
+	// Location is used to interpret timestamps without a zone offset.
+	// Defaults to time.Local.
+	Location *time.Location
+
+	// TimeParser is used to parse timestamps with a zone offset.
+	TimeParser *TimeParser
+}
+
+// NewCSVDecoder returns a new CSVDecoder that reads from r.
+func NewCSVDecoder(r io.Reader) *CSVDecoder {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	return &CSVDecoder{
+		r:          reader,
+		Location:   time.Local,
+		TimeParser: NewTimeParser(),
+	}
+}
+
+// Decode reads the entire CSV input and returns it as a Payload.
+func (d *CSVDecoder) Decode() (*Payload, error) {
+	header, err := d.r.Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read csv header")
+	}
+	for i, column := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+	}
+
+	switch {
+	case indexOf(header, csvColumnDateTime) >= 0:
+		metrics, err := d.decodeMetrics(header)
+		if err != nil {
+			return nil, err
+		}
+		return &Payload{Data: &PayloadData{Metrics: metrics}}, nil
+	case indexOf(header, csvColumnStart) >= 0:
+		workouts, err := d.decodeWorkouts(header)
+		if err != nil {
+			return nil, err
+		}
+		return &Payload{Data: &PayloadData{Workouts: workouts}}, nil
+	}
+
+	return nil, fmt.Errorf("unknown csv format, expected a %q or %q column", csvColumnDateTime, csvColumnStart)
+}
+
+func (d *CSVDecoder) decodeMetrics(header []string) ([]*Metric, error) {
+	dateIndex := indexOf(header, csvColumnDateTime)
+
+	// Group columns by metric, preserving the order of the header.
+	metrics := make([]*Metric, 0, len(header))
+	metricIndex := make(map[string]int, len(header))
+	columns := make([]csvColumn, len(header))
+	for i, name := range header {
+		if i == dateIndex {
+			continue
+		}
+		column := parseCSVColumn(name, toSnakeCase)
+		columns[i] = column
+		key := column.Name + "_" + string(column.Units)
+		if _, ok := metricIndex[key]; !ok {
+			metricIndex[key] = len(metrics)
+			metrics = append(metrics, &Metric{
+				Name:  column.Name,
+				Units: column.Units,
+			})
+		}
+	}
+
+	for line := 2; ; line++ {
+		record, err := d.r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read csv line %v", line)
+		}
+		if dateIndex >= len(record) {
+			return nil, fmt.Errorf("line %v: missing %q column", line, csvColumnDateTime)
+		}
+		date, err := d.parseTime(record[dateIndex])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %v", line)
+		}
+
+		datapoints := make(map[int]*Datapoint)
+		for i, value := range record {
+			if i == dateIndex || i >= len(columns) || strings.TrimSpace(value) == "" {
+				continue
+			}
+			column := columns[i]
+			qty, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %v: cannot parse value for %q", line, header[i])
+			}
+
+			idx := metricIndex[column.Name+"_"+string(column.Units)]
+			datum, ok := datapoints[idx]
+			if !ok {
+				datum = &Datapoint{
+					Date:   &Time{Time: date.Time},
+					Fields: make(DatapointFields),
+				}
+				datapoints[idx] = datum
+			}
+			if column.Field == "" {
+				datum.Qty = Qty(qty)
+			} else {
+				datum.Fields[column.Field] = qty
+			}
+		}
+
+		for idx := range metrics {
+			if datum, ok := datapoints[idx]; ok {
+				metrics[idx].Datapoints = append(metrics[idx].Datapoints, datum)
+			}
+		}
+	}
+
+	return metrics, nil
+}
+
+func (d *CSVDecoder) decodeWorkouts(header []string) ([]*Workout, error) {
+	columns := make([]csvColumn, len(header))
+	for i, name := range header {
+		columns[i] = parseCSVColumn(name, toLowerCamelCase)
+	}
+
+	workouts := make([]*Workout, 0)
+	for line := 2; ; line++ {
+		record, err := d.r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read csv line %v", line)
+		}
+
+		workout := &Workout{
+			Fields: make(WorkoutFields, 0, len(record)),
+		}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if i >= len(header) || value == "" {
+				continue
+			}
+
+			switch header[i] {
+			case csvColumnWorkoutType:
+				workout.Name = value
+				continue
+			case csvColumnStart, csvColumnEnd:
+				t, err := d.parseTime(value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "line %v", line)
+				}
+				if header[i] == csvColumnStart {
+					workout.Start = &t
+				} else {
+					workout.End = &t
+				}
+				continue
+			case csvColumnDuration:
+				// Duration is derived from Start and End.
+				continue
+			}
+
+			column := columns[i]
+			qty, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %v: cannot parse value for %q", line, header[i])
+			}
+
+			switch column.Name {
+			case csvColumnElevationAscended, csvColumnElevationDescended:
+				if workout.Elevation == nil {
+					workout.Elevation = &Elevation{Units: column.Units}
+				}
+				if column.Name == csvColumnElevationAscended {
+					workout.Elevation.Ascent = Qty(qty)
+				} else {
+					workout.Elevation.Descent = Qty(qty)
+				}
+			default:
+				workout.Fields = append(workout.Fields, Field{
+					Key: column.Name,
+					Value: &QtyWithUnit{
+						Qty:   Qty(qty),
+						Units: column.Units,
+					},
+				})
+			}
+		}
+
+		workouts = append(workouts, workout)
+	}
+
+	return workouts, nil
+}
+
+// parseTime parses a CSV timestamp, which may or may not contain a zone offset.
+func (d *CSVDecoder) parseTime(s string) (Time, error) {
+	s = strings.TrimSpace(s)
+	parser := d.TimeParser
+	if parser == nil {
+		parser = NewTimeParser()
+	}
+	if t, err := parser.Parse(s); err == nil {
+		return t, nil
+	}
+	loc := d.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	for _, layout := range csvLocalTimeFormats {
+		if parsed, err := time.ParseInLocation(layout, normalizeTime(s), loc); err == nil {
+			return NewTime(parsed), nil
+		}
+	}
+	return Time{}, fmt.Errorf("failed to parse time %q across all known formats", s)
+}
+
+// UnmarshalCSV unmarshals a Health Auto Export CSV file from io.Reader.
+func UnmarshalCSV(r io.Reader) (*Payload, error) {
+	return NewCSVDecoder(r).Decode()
+}
+
+// parseCSVColumn parses a single CSV header, converting the name using convertName.
+func parseCSVColumn(header string, convertName func(string) string) csvColumn {
+	var column csvColumn
+	matches := csvHeaderRegexp.FindStringSubmatch(header)
+	if matches == nil {
+		column.Name = convertName(header)
+		return column
+	}
+	column.Name = convertName(matches[1])
+	column.Field = matches[2]
+	if name, ok := csvFieldNames[column.Field]; ok {
+		column.Field = name
+	}
+	column.Units = Units(matches[3])
+	return column
+}
+
+// splitWords splits s into words on any non-alphanumeric characters.
+func splitWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// toSnakeCase converts a human-readable name (e.g. "Active Energy") to snake case (e.g. "active_energy").
+func toSnakeCase(s string) string {
+	return strings.ToLower(strings.Join(splitWords(s), "_"))
+}
+
+// toLowerCamelCase converts a human-readable name (e.g. "Active Energy") to lower camel case (e.g. "activeEnergy").
+func toLowerCamelCase(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if i > 0 && len(word) > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		words[i] = word
+	}
+	return strings.Join(words, "")
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,159 @@
+package healthautoexport_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+func TestCSVDecoder_Decode(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      *healthautoexport.Payload
+		wantError assert.ErrorAssertionFunc
+	}{
+		{
+			name:      "empty input",
+			input:     "",
+			wantError: testutils.AssertErrorContains("cannot read csv header"),
+		},
+		{
+			name:      "unknown format",
+			input:     "Foo,Bar\n1,2\n",
+			wantError: testutils.AssertErrorContains("unknown csv format"),
+		},
+		{
+			name: "metrics",
+			input: "Date/Time,Active Energy (kJ),Heart Rate [Min] (count/min),Heart Rate [Max] (count/min),Heart Rate [Avg] (count/min)\n" +
+				"2021-12-24 00:04:00,0.7685677437484512,,,\n" +
+				"2021-12-24 00:05:00,0.377848256251549,60,72,66\n",
+			want: &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Metrics: []*healthautoexport.Metric{
+						{
+							Name:  "active_energy",
+							Units: "kJ",
+							Datapoints: []*healthautoexport.Datapoint{
+								{
+									Date:   mktimeptr("2021-12-24 00:04:00 +0800"),
+									Qty:    0.7685677437484512,
+									Fields: healthautoexport.DatapointFields{},
+								},
+								{
+									Date:   mktimeptr("2021-12-24 00:05:00 +0800"),
+									Qty:    0.377848256251549,
+									Fields: healthautoexport.DatapointFields{},
+								},
+							},
+						},
+						{
+							Name:  "heart_rate",
+							Units: "count/min",
+							Datapoints: []*healthautoexport.Datapoint{
+								{
+									Date: mktimeptr("2021-12-24 00:05:00 +0800"),
+									Fields: healthautoexport.DatapointFields{
+										"Min": 60.0,
+										"Max": 72.0,
+										"Avg": 66.0,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "metrics with zone offset and sleep fields",
+			input: "Date/Time,Sleep Analysis [In Bed] (hr),Sleep Analysis [Asleep] (hr)\n" +
+				"2021-12-18 09:03:36 +0800,6.8,6.1\n",
+			want: &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Metrics: []*healthautoexport.Metric{
+						{
+							Name:  "sleep_analysis",
+							Units: "hr",
+							Datapoints: []*healthautoexport.Datapoint{
+								{
+									Date: mktimeptr("2021-12-18 09:03:36 +0800"),
+									Fields: healthautoexport.DatapointFields{
+										"inBed":  6.8,
+										"asleep": 6.1,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "metrics with invalid value",
+			input:     "Date/Time,Active Energy (kJ)\n2021-12-24 00:04:00,abc\n",
+			wantError: testutils.AssertErrorContains(`line 2: cannot parse value for "Active Energy (kJ)"`),
+		},
+		{
+			name:      "metrics with invalid time",
+			input:     "Date/Time,Active Energy (kJ)\nyesterday,1\n",
+			wantError: testutils.AssertErrorContains("line 2"),
+		},
+		{
+			name: "workouts",
+			input: "Workout Type,Start,End,Duration,Active Energy (kJ),Step Count (count),Elevation Ascended (m),Elevation Descended (m)\n" +
+				"Walking,2021-12-24 08:02:43,2021-12-24 08:21:53,00:19:10,226.21122641832523,908,16.36,0\n",
+			want: &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Workouts: []*healthautoexport.Workout{
+						{
+							Name:  "Walking",
+							Start: mktimeptr("2021-12-24 08:02:43 +0800"),
+							End:   mktimeptr("2021-12-24 08:21:53 +0800"),
+							Elevation: &healthautoexport.Elevation{
+								Units:   "m",
+								Ascent:  16.36,
+								Descent: 0,
+							},
+							Fields: healthautoexport.WorkoutFields{
+								{
+									Key:   "activeEnergy",
+									Value: &healthautoexport.QtyWithUnit{Qty: 226.21122641832523, Units: "kJ"},
+								},
+								{
+									Key:   "stepCount",
+									Value: &healthautoexport.QtyWithUnit{Qty: 908, Units: "count"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dec := healthautoexport.NewCSVDecoder(strings.NewReader(tt.input))
+			dec.Location = time.FixedZone("", 8*60*60)
+			got, err := dec.Decode()
+			if testutils.WantError(t, tt.wantError, err) {
+				return
+			}
+			if !cmp.Equal(tt.want, got, cmpOptions...) {
+				t.Errorf("Decode() not equal\ndiff = %v", cmp.Diff(tt.want, got, cmpOptions...))
+			}
+		})
+	}
+}
+
+func mktimeptr(ts string) *healthautoexport.Time {
+	t := mktime(ts)
+	return &t
+}
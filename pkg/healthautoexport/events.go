@@ -0,0 +1,420 @@
+package healthautoexport
+
+import (
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Symptom is a symptom that was logged in the Health app.
+type Symptom struct {
+	Start *Time `json:"start"`
+	End   *Time `json:"end,omitempty"`
+
+	// Name of the symptom (e.g. "Headache").
+	Name string `json:"name"`
+
+	// Severity of the symptom (e.g. "Mild").
+	Severity string `json:"severity,omitempty"`
+
+	// UserEntered is true if the symptom was manually logged.
+	UserEntered bool `json:"userEntered,omitempty"`
+
+	// Data source of the symptom.
+	Source string `json:"source,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// symptomCopy avoids reflection stack overflow by creating type alias of Symptom.
+// https://stackoverflow.com/a/43178272/2037090
+type symptomCopy Symptom
+
+func (s *Symptom) MarshalJSON() ([]byte, error) {
+	return s.marshalJSON(jsonAPI)
+}
+
+func (s *Symptom) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*symptomCopy)(s), s.Unknown)
+}
+
+func (s *Symptom) UnmarshalJSON(bytes []byte) error {
+	return s.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (s *Symptom) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*symptomCopy)(s), symptomType)
+	if err != nil {
+		return err
+	}
+	s.Unknown = unknown
+	return nil
+}
+
+// Medication is a single logged dose of a medication.
+type Medication struct {
+	Start *Time `json:"start"`
+	End   *Time `json:"end,omitempty"`
+
+	// DisplayText is the name of the medication.
+	DisplayText string `json:"displayText"`
+
+	// Nickname is an optional user-specified name for the medication.
+	Nickname string `json:"nickname,omitempty"`
+
+	// Form of the medication (e.g. "Tablet").
+	Form string `json:"form,omitempty"`
+
+	// Status of the dose (e.g. "Taken", "Skipped").
+	Status string `json:"status,omitempty"`
+
+	// Dosage is the number of units taken.
+	Dosage Qty `json:"dosage,omitempty"`
+
+	// ScheduledDate is the time that the dose was scheduled for, if any.
+	ScheduledDate *Time `json:"scheduledDate,omitempty"`
+
+	// IsArchived is true if the medication was archived.
+	IsArchived bool `json:"isArchived,omitempty"`
+
+	// Data source of the medication.
+	Source string `json:"source,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// medicationCopy avoids reflection stack overflow by creating type alias of Medication.
+// https://stackoverflow.com/a/43178272/2037090
+type medicationCopy Medication
+
+func (m *Medication) MarshalJSON() ([]byte, error) {
+	return m.marshalJSON(jsonAPI)
+}
+
+func (m *Medication) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*medicationCopy)(m), m.Unknown)
+}
+
+func (m *Medication) UnmarshalJSON(bytes []byte) error {
+	return m.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (m *Medication) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*medicationCopy)(m), medicationType)
+	if err != nil {
+		return err
+	}
+	m.Unknown = unknown
+	return nil
+}
+
+// ECG is a single electrocardiogram recording.
+type ECG struct {
+	Start *Time `json:"start"`
+	End   *Time `json:"end,omitempty"`
+
+	// Classification of the recording (e.g. "Sinus Rhythm").
+	Classification string `json:"classification"`
+
+	// Severity of the recording, if any.
+	Severity string `json:"severity,omitempty"`
+
+	// AverageHeartRate in bpm during the recording.
+	AverageHeartRate Qty `json:"averageHeartRate,omitempty"`
+
+	// SamplingFrequency of the voltage measurements in Hz.
+	SamplingFrequency Qty `json:"samplingFrequency,omitempty"`
+
+	// NumberOfVoltageMeasurements in the recording.
+	NumberOfVoltageMeasurements int `json:"numberOfVoltageMeasurements,omitempty"`
+
+	// VoltageMeasurements contains all voltage samples in the recording.
+	VoltageMeasurements []*VoltageMeasurement `json:"voltageMeasurements,omitempty"`
+
+	// Data source of the recording.
+	Source string `json:"source,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// ecgCopy avoids reflection stack overflow by creating type alias of ECG.
+// https://stackoverflow.com/a/43178272/2037090
+type ecgCopy ECG
+
+func (e *ECG) MarshalJSON() ([]byte, error) {
+	return e.marshalJSON(jsonAPI)
+}
+
+func (e *ECG) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*ecgCopy)(e), e.Unknown)
+}
+
+func (e *ECG) UnmarshalJSON(bytes []byte) error {
+	return e.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (e *ECG) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*ecgCopy)(e), ecgType)
+	if err != nil {
+		return err
+	}
+	e.Unknown = unknown
+	return nil
+}
+
+// VoltageMeasurement is a single voltage sample in an ECG recording.
+type VoltageMeasurement struct {
+	Date    *Time `json:"date"`
+	Voltage Qty   `json:"voltage"`
+	Units   Units `json:"units"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// voltageMeasurementCopy avoids reflection stack overflow by creating type alias of VoltageMeasurement.
+// https://stackoverflow.com/a/43178272/2037090
+type voltageMeasurementCopy VoltageMeasurement
+
+func (v *VoltageMeasurement) MarshalJSON() ([]byte, error) {
+	return v.marshalJSON(jsonAPI)
+}
+
+func (v *VoltageMeasurement) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*voltageMeasurementCopy)(v), v.Unknown)
+}
+
+func (v *VoltageMeasurement) UnmarshalJSON(bytes []byte) error {
+	return v.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (v *VoltageMeasurement) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*voltageMeasurementCopy)(v), voltageMeasurementType)
+	if err != nil {
+		return err
+	}
+	v.Unknown = unknown
+	return nil
+}
+
+func (v VoltageMeasurement) GetUnits() Units {
+	return v.Units
+}
+
+// HeartRateNotification is a high, low or irregular heart rate notification.
+type HeartRateNotification struct {
+	Start *Time `json:"start"`
+	End   *Time `json:"end,omitempty"`
+
+	// Threshold in bpm which triggered the notification, if any.
+	Threshold Qty `json:"threshold,omitempty"`
+
+	// HeartRate contains the heart rate samples that triggered the notification.
+	HeartRate []*HeartRateNotificationSample `json:"heartRate,omitempty"`
+
+	// HeartRateVariation contains the heart rate variability samples that triggered the notification.
+	HeartRateVariation []*HeartRateNotificationSample `json:"heartRateVariation,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// heartRateNotificationCopy avoids reflection stack overflow by creating type alias of HeartRateNotification.
+// https://stackoverflow.com/a/43178272/2037090
+type heartRateNotificationCopy HeartRateNotification
+
+func (h *HeartRateNotification) MarshalJSON() ([]byte, error) {
+	return h.marshalJSON(jsonAPI)
+}
+
+func (h *HeartRateNotification) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*heartRateNotificationCopy)(h), h.Unknown)
+}
+
+func (h *HeartRateNotification) UnmarshalJSON(bytes []byte) error {
+	return h.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (h *HeartRateNotification) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*heartRateNotificationCopy)(h), heartRateNotificationType)
+	if err != nil {
+		return err
+	}
+	h.Unknown = unknown
+	return nil
+}
+
+// HeartRateNotificationSample is a single sample for a HeartRateNotification.
+type HeartRateNotificationSample struct {
+	// HR is the heart rate for heart rate samples.
+	HR Qty `json:"hr,omitempty"`
+
+	// HRV is the heart rate variability for heart rate variation samples.
+	HRV Qty `json:"hrv,omitempty"`
+
+	Units     Units         `json:"units"`
+	Timestamp *TimeInterval `json:"timestamp"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// heartRateNotificationSampleCopy avoids reflection stack overflow by creating type alias of HeartRateNotificationSample.
+// https://stackoverflow.com/a/43178272/2037090
+type heartRateNotificationSampleCopy HeartRateNotificationSample
+
+func (h *HeartRateNotificationSample) MarshalJSON() ([]byte, error) {
+	return h.marshalJSON(jsonAPI)
+}
+
+func (h *HeartRateNotificationSample) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*heartRateNotificationSampleCopy)(h), h.Unknown)
+}
+
+func (h *HeartRateNotificationSample) UnmarshalJSON(bytes []byte) error {
+	return h.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (h *HeartRateNotificationSample) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*heartRateNotificationSampleCopy)(h), heartRateNotificationSampleType)
+	if err != nil {
+		return err
+	}
+	h.Unknown = unknown
+	return nil
+}
+
+func (h HeartRateNotificationSample) GetUnits() Units {
+	return h.Units
+}
+
+// TimeInterval defines a period of time.
+type TimeInterval struct {
+	Start *Time `json:"start"`
+	End   *Time `json:"end,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// timeIntervalCopy avoids reflection stack overflow by creating type alias of TimeInterval.
+// https://stackoverflow.com/a/43178272/2037090
+type timeIntervalCopy TimeInterval
+
+func (t *TimeInterval) MarshalJSON() ([]byte, error) {
+	return t.marshalJSON(jsonAPI)
+}
+
+func (t *TimeInterval) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*timeIntervalCopy)(t), t.Unknown)
+}
+
+func (t *TimeInterval) UnmarshalJSON(bytes []byte) error {
+	return t.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (t *TimeInterval) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*timeIntervalCopy)(t), timeIntervalType)
+	if err != nil {
+		return err
+	}
+	t.Unknown = unknown
+	return nil
+}
+
+// StateOfMind is a logged emotion or mood.
+type StateOfMind struct {
+	ID    string `json:"id,omitempty"`
+	Start *Time  `json:"start"`
+	End   *Time  `json:"end,omitempty"`
+
+	// Kind is either "momentaryEmotion" or "dailyMood".
+	Kind string `json:"kind"`
+
+	// Labels describing the state of mind (e.g. "Happy").
+	Labels []string `json:"labels,omitempty"`
+
+	// Associations that contributed to the state of mind (e.g. "Family").
+	Associations []string `json:"associations,omitempty"`
+
+	// Valence ranges from -1 (very unpleasant) to 1 (very pleasant).
+	Valence Qty `json:"valence"`
+
+	// ValenceClassification is a human-readable description of Valence.
+	ValenceClassification string `json:"valenceClassification,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// stateOfMindCopy avoids reflection stack overflow by creating type alias of StateOfMind.
+// https://stackoverflow.com/a/43178272/2037090
+type stateOfMindCopy StateOfMind
+
+func (s *StateOfMind) MarshalJSON() ([]byte, error) {
+	return s.marshalJSON(jsonAPI)
+}
+
+func (s *StateOfMind) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*stateOfMindCopy)(s), s.Unknown)
+}
+
+func (s *StateOfMind) UnmarshalJSON(bytes []byte) error {
+	return s.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (s *StateOfMind) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*stateOfMindCopy)(s), stateOfMindType)
+	if err != nil {
+		return err
+	}
+	s.Unknown = unknown
+	return nil
+}
+
+// CycleTracking is a cycle tracking event (e.g. menstrual flow, ovulation test result).
+type CycleTracking struct {
+	Start *Time `json:"start"`
+	End   *Time `json:"end,omitempty"`
+
+	// Name of the cycle tracking event (e.g. "menstrual_flow").
+	Name string `json:"name"`
+
+	// Value of the event (e.g. "medium").
+	Value string `json:"value,omitempty"`
+
+	// Qty is an optional quantity for the event.
+	Qty Qty `json:"qty,omitempty"`
+
+	// Data source of the event.
+	Source string `json:"source,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// cycleTrackingCopy avoids reflection stack overflow by creating type alias of CycleTracking.
+// https://stackoverflow.com/a/43178272/2037090
+type cycleTrackingCopy CycleTracking
+
+func (c *CycleTracking) MarshalJSON() ([]byte, error) {
+	return c.marshalJSON(jsonAPI)
+}
+
+func (c *CycleTracking) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*cycleTrackingCopy)(c), c.Unknown)
+}
+
+func (c *CycleTracking) UnmarshalJSON(bytes []byte) error {
+	return c.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (c *CycleTracking) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*cycleTrackingCopy)(c), cycleTrackingType)
+	if err != nil {
+		return err
+	}
+	c.Unknown = unknown
+	return nil
+}
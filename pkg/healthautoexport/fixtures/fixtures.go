@@ -1,6 +1,8 @@
 package fixtures
 
 import (
+	"encoding/json"
+
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 )
 
@@ -54,6 +56,9 @@ var (
 							InBedStart:  mktime("2021-12-18 02:12:50 +0800"),
 							InBedEnd:    mktime("2021-12-18 09:04:45 +0800"),
 							InBedSource: "iPhone",
+							Unknown: healthautoexport.UnknownFields{
+								"date": json.RawMessage(`"2021-12-18 09:03:36 +0800"`),
+							},
 						},
 					},
 				},
@@ -112,6 +117,96 @@ var (
 		},
 	}
 
+	// PayloadWithHealthEvents is an example Payload with symptoms, medications, ECG,
+	// heart rate notifications, state of mind and cycle tracking data.
+	PayloadWithHealthEvents = &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{
+			Symptoms: []*healthautoexport.Symptom{
+				{
+					Start:       mktime("2024-10-01 09:00:00 +0800"),
+					End:         mktime("2024-10-01 11:30:00 +0800"),
+					Name:        "Headache",
+					Severity:    "Mild",
+					UserEntered: true,
+					Source:      "Irvin's iPhone",
+				},
+			},
+			Medications: []*healthautoexport.Medication{
+				{
+					Start:         mktime("2024-10-01 08:05:00 +0800"),
+					End:           mktime("2024-10-01 08:05:00 +0800"),
+					DisplayText:   "Vitamin D",
+					Form:          "Capsule",
+					Status:        "Taken",
+					Dosage:        1,
+					ScheduledDate: mktime("2024-10-01 08:00:00 +0800"),
+					Source:        "Irvin's iPhone",
+				},
+			},
+			ECG: []*healthautoexport.ECG{
+				{
+					Start:                       mktime("2024-10-01 10:00:00 +0800"),
+					End:                         mktime("2024-10-01 10:00:30 +0800"),
+					Classification:              "Sinus Rhythm",
+					AverageHeartRate:            72,
+					SamplingFrequency:           512,
+					NumberOfVoltageMeasurements: 2,
+					VoltageMeasurements: []*healthautoexport.VoltageMeasurement{
+						{
+							Date:    mktime("2024-10-01 10:00:00 +0800"),
+							Voltage: -12.5,
+							Units:   "uV",
+						},
+						{
+							Date:    mktime("2024-10-01 10:00:01 +0800"),
+							Voltage: 30.25,
+							Units:   "uV",
+						},
+					},
+					Source: "Irvin’s Apple Watch",
+				},
+			},
+			HeartRateNotifications: []*healthautoexport.HeartRateNotification{
+				{
+					Start:     mktime("2024-10-01 14:00:00 +0800"),
+					End:       mktime("2024-10-01 14:10:00 +0800"),
+					Threshold: 120,
+					HeartRate: []*healthautoexport.HeartRateNotificationSample{
+						{
+							HR:    125,
+							Units: "bpm",
+							Timestamp: &healthautoexport.TimeInterval{
+								Start: mktime("2024-10-01 14:00:00 +0800"),
+								End:   mktime("2024-10-01 14:05:00 +0800"),
+							},
+						},
+					},
+				},
+			},
+			StateOfMind: []*healthautoexport.StateOfMind{
+				{
+					ID:                    "B2D5A1C4-0000-4000-8000-000000000001",
+					Start:                 mktime("2024-10-01 20:00:00 +0800"),
+					End:                   mktime("2024-10-01 20:00:00 +0800"),
+					Kind:                  "momentaryEmotion",
+					Labels:                []string{"Happy", "Calm"},
+					Associations:          []string{"Family"},
+					Valence:               0.5,
+					ValenceClassification: "pleasant",
+				},
+			},
+			CycleTracking: []*healthautoexport.CycleTracking{
+				{
+					Start:  mktime("2024-10-01 00:00:00 +0800"),
+					End:    mktime("2024-10-02 00:00:00 +0800"),
+					Name:   "menstrual_flow",
+					Value:  "medium",
+					Source: "Irvin's iPhone",
+				},
+			},
+		},
+	}
+
 	PayloadMetricsSleepAnalysisNonAggregated = &healthautoexport.Payload{
 		Data: &healthautoexport.PayloadData{
 			Metrics: []*healthautoexport.Metric{
@@ -125,6 +220,9 @@ var (
 							Qty:       6.108333333333333,
 							Source:    "Irvin's Apple Watch",
 							Value:     "Core",
+							Unknown: healthautoexport.UnknownFields{
+								"date": json.RawMessage(`null`),
+							},
 						},
 					},
 				},
@@ -149,6 +247,9 @@ var (
 							Awake:      0.11666666666666667,
 							Deep:       0.85833333333333339,
 							REM:        1.2583333333333333,
+							Unknown: healthautoexport.UnknownFields{
+								"date": json.RawMessage(`"2023-01-31 02:44:02 +0800"`),
+							},
 						},
 					},
 				},
@@ -157,6 +258,11 @@ var (
 	}
 )
 
+// PayloadJSONWithUnknownFields is an example JSON payload with unknown fields
+// at every level. Keys are ordered in the same way that they are marshaled, so
+// that a round trip produces identical output.
+const PayloadJSONWithUnknownFields = `{"data":{"metrics":[{"name":"active_energy","units":"kJ","data":[{"date":"2021-12-24 00:04:00 +0800","qty":1,"source":"Apple Watch"}],"aggregation":{"interval":"minutes"}},{"name":"sleep_analysis","units":"hr","data":[{"sleepStart":"2023-01-31 00:23:47 +0800","sleepEnd":"2023-01-31 08:39:12 +0800","inBed":8.5,"asleep":7.5,"awake":0.25,"core":3.25,"deep":1,"rem":1.5,"source":"Irvin's iPhone","date":"2023-01-31 02:44:02 +0800","extra":true}]},{"name":"sleep_analysis","units":"hr","data":[{"startDate":"2021-12-18 02:21:06 +0800","endDate":"2021-12-18 08:57:06 +0800","qty":1.5,"source":"Irvin's Apple Watch","value":"Core","date":null}]}],"workouts":[{"elevation":{"ascent":16.5,"descent":0,"gain":1,"units":"m"},"end":"2021-12-24 08:21:53 +0800","heartRateData":[{"date":"2021-12-24 08:02:47 +0800","qty":108,"source":"Apple Watch","units":"bpm"}],"id":"ABC-123","name":"Walking","route":[{"altitude":8.25,"course":90.5,"lat":38.8951,"lon":-77.0364,"speed":1.25,"timestamp":"2021-12-24 08:04:45 +0800"}],"start":"2021-12-24 08:02:43 +0800","stepCount":{"qty":908,"units":"steps"},"isIndoor":false}],"symptoms":[{"start":"2024-10-01 09:00:00 +0800","end":"2024-10-01 11:30:00 +0800","name":"Headache","severity":"Mild","userEntered":true,"source":"Irvin's iPhone","bodyPart":"head"}],"medications":[{"start":"2024-10-01 08:05:00 +0800","end":"2024-10-01 08:05:00 +0800","displayText":"Vitamin D","form":"Capsule","status":"Taken","dosage":1,"scheduledDate":"2024-10-01 08:00:00 +0800","source":"Irvin's iPhone","codings":[{"code":"123"}]}],"ecg":[{"start":"2024-10-01 10:00:00 +0800","end":"2024-10-01 10:00:30 +0800","classification":"Sinus Rhythm","averageHeartRate":72,"samplingFrequency":512,"numberOfVoltageMeasurements":1,"voltageMeasurements":[{"date":"2024-10-01 10:00:00 +0800","voltage":-12.5,"units":"uV","lead":"I"}],"source":"Irvin's Apple Watch","algorithmVersion":2}],"heartRateNotifications":[{"start":"2024-10-01 14:00:00 +0800","end":"2024-10-01 14:10:00 +0800","threshold":120,"heartRate":[{"hr":125,"units":"bpm","timestamp":{"start":"2024-10-01 14:00:00 +0800","end":"2024-10-01 14:05:00 +0800","duration":300},"context":"rest"}],"kind":"high"}],"stateOfMind":[{"id":"B2D5A1C4-0000-4000-8000-000000000001","start":"2024-10-01 20:00:00 +0800","end":"2024-10-01 20:00:00 +0800","kind":"momentaryEmotion","labels":["Happy"],"associations":["Family"],"valence":0.5,"valenceClassification":"pleasant","metadata":{}}],"cycleTracking":[{"start":"2024-10-01 00:00:00 +0800","end":"2024-10-02 00:00:00 +0800","name":"menstrual_flow","value":"medium","source":"Irvin's iPhone","isCycleStart":true}],"newDataType":[{"a":1}]},"version":"7.0.1"}`
+
 func mktime(ts string) *healthautoexport.Time {
 	t, err := healthautoexport.ParseTime(ts)
 	if err != nil {
@@ -2,15 +2,60 @@ package healthautoexport
 
 import (
 	"bytes"
-	"encoding/json"
+	"fmt"
 	"io"
+	"strings"
+	"time"
+)
+
+// Format is the export format of a payload.
+type Format string
 
-	jsoniter "github.com/json-iterator/go"
+const (
+	// FormatJSON is the JSON export format.
+	FormatJSON Format = "json"
+	// FormatCSV is the CSV export format.
+	FormatCSV Format = "csv"
 )
 
+// ParseFormat parses a case-insensitive format name.
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(strings.ToLower(s)); format {
+	case FormatJSON, FormatCSV:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %q", s)
+}
+
+// EncoderOptions configures how a payload is marshaled.
+type EncoderOptions struct {
+	// TimeFormat is the layout used to format timestamps. Defaults to DefaultTimeFormat.
+	TimeFormat string
+}
+
+// DecoderOptions configures how a payload is unmarshaled.
+type DecoderOptions struct {
+	// TimeLayouts are the layouts used to parse timestamps, in order of
+	// preference. Defaults to DefaultTimeLayouts().
+	TimeLayouts []string
+
+	// Location is used to interpret timestamps without a zone offset, which
+	// are only found in the CSV format. Defaults to time.Local.
+	Location *time.Location
+}
+
 // Marshal payload to io.Writer.
 func Marshal(payload *Payload, w io.Writer) error {
-	enc := jsoniter.NewEncoder(w)
+	return MarshalWithOptions(payload, w, EncoderOptions{})
+}
+
+// MarshalWithOptions marshals payload to io.Writer with the given options.
+func MarshalWithOptions(payload *Payload, w io.Writer, opts EncoderOptions) error {
+	timeFormat := opts.TimeFormat
+	if timeFormat == "" {
+		timeFormat = DefaultTimeFormat
+	}
+	enc := encodeAPI(timeFormat).NewEncoder(w)
 	if err := enc.Encode(payload); err != nil {
 		return err
 	}
@@ -28,12 +73,42 @@ func MarshalToString(payload *Payload) (string, error) {
 
 // Unmarshal payload from io.Reader.
 func Unmarshal(r io.Reader) (*Payload, error) {
-	var payload Payload
-	dec := json.NewDecoder(r)
-	if err := dec.Decode(&payload); err != nil {
-		return nil, err
+	return UnmarshalWithOptions(r, FormatJSON, DecoderOptions{})
+}
+
+// UnmarshalWithFormat unmarshals payload from io.Reader in the given format.
+func UnmarshalWithFormat(r io.Reader, format Format) (*Payload, error) {
+	return UnmarshalWithOptions(r, format, DecoderOptions{})
+}
+
+// UnmarshalWithOptions unmarshals payload from io.Reader in the given format
+// with the given options. The time format is detected independently for each
+// payload.
+func UnmarshalWithOptions(r io.Reader, format Format, opts DecoderOptions) (*Payload, error) {
+	parser := NewTimeParser(opts.TimeLayouts...)
+	switch format {
+	case FormatJSON, "":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil, io.EOF
+		}
+		var payload Payload
+		if err := (parserAPI{API: decodeAPI, parser: parser}).Unmarshal(data, &payload); err != nil {
+			return nil, err
+		}
+		return &payload, nil
+	case FormatCSV:
+		dec := NewCSVDecoder(r)
+		dec.TimeParser = parser
+		if opts.Location != nil {
+			dec.Location = opts.Location
+		}
+		return dec.Decode()
 	}
-	return &payload, nil
+	return nil, fmt.Errorf("unknown format %q", format)
 }
 
 // UnmarshalFromString unmarshals payload from a string.
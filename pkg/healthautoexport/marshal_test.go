@@ -1,11 +1,16 @@
 package healthautoexport_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport/fixtures"
@@ -61,6 +66,10 @@ func TestMarshalToString(t *testing.T) {
   }
 }`,
 		},
+		{
+			name:    "marshal health events",
+			payload: fixtures.PayloadWithHealthEvents,
+		},
 		{
 			name:    "marshal non-aggregated sleep analysis",
 			payload: fixtures.PayloadMetricsSleepAnalysisNonAggregated,
@@ -136,7 +145,12 @@ func TestUnmarshalFromString(t *testing.T) {
 		},
 		{
 			name: "unmarshal metrics",
-			want: fixtures.PayloadWithMetrics,
+			want: &healthautoexport.Payload{
+				Data: fixtures.PayloadWithMetrics.Data,
+				Unknown: healthautoexport.UnknownFields{
+					"workouts": json.RawMessage("[\n  ]"),
+				},
+			},
 			input: `{
   "data" : {
     "metrics" : [
@@ -259,6 +273,89 @@ func TestUnmarshalFromString(t *testing.T) {
 			}
 		]
 	}
+}`,
+		},
+		{
+			name: "unmarshal health events",
+			want: fixtures.PayloadWithHealthEvents,
+			input: `{
+  "data": {
+    "symptoms": [
+      {
+        "start": "2024-10-01 09:00:00 +0800",
+        "end": "2024-10-01 11:30:00 +0800",
+        "name": "Headache",
+        "severity": "Mild",
+        "userEntered": true,
+        "source": "Irvin's iPhone"
+      }
+    ],
+    "medications": [
+      {
+        "start": "2024-10-01 08:05:00 +0800",
+        "end": "2024-10-01 08:05:00 +0800",
+        "displayText": "Vitamin D",
+        "form": "Capsule",
+        "status": "Taken",
+        "dosage": 1,
+        "scheduledDate": "2024-10-01 08:00:00 +0800",
+        "source": "Irvin's iPhone"
+      }
+    ],
+    "ecg": [
+      {
+        "start": "2024-10-01 10:00:00 +0800",
+        "end": "2024-10-01 10:00:30 +0800",
+        "classification": "Sinus Rhythm",
+        "averageHeartRate": 72,
+        "samplingFrequency": 512,
+        "numberOfVoltageMeasurements": 2,
+        "voltageMeasurements": [
+          {"date": "2024-10-01 10:00:00 +0800", "voltage": -12.5, "units": "uV"},
+          {"date": "2024-10-01 10:00:01 +0800", "voltage": 30.25, "units": "uV"}
+        ],
+        "source": "Irvin’s Apple Watch"
+      }
+    ],
+    "heartRateNotifications": [
+      {
+        "start": "2024-10-01 14:00:00 +0800",
+        "end": "2024-10-01 14:10:00 +0800",
+        "threshold": 120,
+        "heartRate": [
+          {
+            "hr": 125,
+            "units": "bpm",
+            "timestamp": {
+              "start": "2024-10-01 14:00:00 +0800",
+              "end": "2024-10-01 14:05:00 +0800"
+            }
+          }
+        ]
+      }
+    ],
+    "stateOfMind": [
+      {
+        "id": "B2D5A1C4-0000-4000-8000-000000000001",
+        "start": "2024-10-01 20:00:00 +0800",
+        "end": "2024-10-01 20:00:00 +0800",
+        "kind": "momentaryEmotion",
+        "labels": ["Happy", "Calm"],
+        "associations": ["Family"],
+        "valence": 0.5,
+        "valenceClassification": "pleasant"
+      }
+    ],
+    "cycleTracking": [
+      {
+        "start": "2024-10-01 00:00:00 +0800",
+        "end": "2024-10-02 00:00:00 +0800",
+        "name": "menstrual_flow",
+        "value": "medium",
+        "source": "Irvin's iPhone"
+      }
+    ]
+  }
 }`,
 		},
 		{
@@ -309,6 +406,179 @@ func TestUnmarshalFromString(t *testing.T) {
 	}
 }
 
+func TestUnknownFieldsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  *healthautoexport.Payload
+	}{
+		{
+			name:  "unknown fields at every level",
+			input: `{"data":{"metrics":[{"name":"active_energy","units":"kJ","data":[{"qty":1,"date":"2021-12-24 00:04:00 +0800"}],"aggregation":{"interval":"minutes"}}],"workouts":[{"name":"Walking","start":"2021-12-24 08:02:43 +0800","end":"2021-12-24 08:21:53 +0800","id":"ABC-123","isIndoor":false,"stepCount":{"qty":908,"units":"steps"}}],"newDataType":[{"a":1}]},"version":"7.0.1"}`,
+			want: &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Metrics: []*healthautoexport.Metric{
+						{
+							Name:  "active_energy",
+							Units: "kJ",
+							Datapoints: []*healthautoexport.Datapoint{
+								{Qty: 1, Date: mktimeptr("2021-12-24 00:04:00 +0800")},
+							},
+							Unknown: healthautoexport.UnknownFields{
+								"aggregation": json.RawMessage(`{"interval":"minutes"}`),
+							},
+						},
+					},
+					Workouts: []*healthautoexport.Workout{
+						{
+							ID:    "ABC-123",
+							Name:  "Walking",
+							Start: mktimeptr("2021-12-24 08:02:43 +0800"),
+							End:   mktimeptr("2021-12-24 08:21:53 +0800"),
+							Fields: healthautoexport.WorkoutFields{
+								{Key: "stepCount", Value: &healthautoexport.QtyWithUnit{Qty: 908, Units: "steps"}},
+							},
+							Unknown: healthautoexport.UnknownFields{
+								"isIndoor": json.RawMessage(`false`),
+							},
+						},
+					},
+					Unknown: healthautoexport.UnknownFields{
+						"newDataType": json.RawMessage(`[{"a":1}]`),
+					},
+				},
+				Unknown: healthautoexport.UnknownFields{
+					"version": json.RawMessage(`"7.0.1"`),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := healthautoexport.UnmarshalFromString(tt.input)
+			if err != nil {
+				t.Fatalf("UnmarshalFromString() error = %v", err)
+			}
+			if !cmp.Equal(tt.want, got, cmpOptions...) {
+				t.Fatalf("UnmarshalFromString() not equal\ndiff = %v", cmp.Diff(tt.want, got, cmpOptions...))
+			}
+
+			// Marshal back and ensure that unknown fields are re-emitted.
+			output, err := healthautoexport.MarshalToString(got)
+			if err != nil {
+				t.Fatalf("MarshalToString() error = %v", err)
+			}
+			var want, result map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.input), &want); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(output), &result); err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(want, result) {
+				t.Errorf("round trip not equal\ndiff = %v", cmp.Diff(want, result))
+			}
+		})
+	}
+}
+
+func TestUnknownFieldsRoundTripFixture(t *testing.T) {
+	payload, err := healthautoexport.UnmarshalFromString(fixtures.PayloadJSONWithUnknownFields)
+	if err != nil {
+		t.Fatalf("UnmarshalFromString() error = %v", err)
+	}
+	got, err := healthautoexport.MarshalToString(payload)
+	if err != nil {
+		t.Fatalf("MarshalToString() error = %v", err)
+	}
+	assert.Equal(t, fixtures.PayloadJSONWithUnknownFields, strings.TrimSuffix(got, "\n"))
+}
+
+func TestMarshalWithOptions(t *testing.T) {
+	var buf bytes.Buffer
+	err := healthautoexport.MarshalWithOptions(fixtures.PayloadWithMetrics, &buf, healthautoexport.EncoderOptions{
+		TimeFormat: time.RFC3339,
+	})
+	if err != nil {
+		t.Fatalf("MarshalWithOptions() error = %v", err)
+	}
+	want := `{"data":{"metrics":[{"name":"active_energy","units":"kJ","data":[{"qty":0.7685677437484512,"date":"2021-12-24T00:04:00+08:00"},{"qty":0.377848256251549,"date":"2021-12-24T00:05:00+08:00"}]},{"name":"basal_body_temperature","units":"degC","data":null}]}}`
+	assert.JSONEq(t, want, buf.String())
+
+	// Package defaults are not affected.
+	got, err := healthautoexport.MarshalToString(fixtures.PayloadWithMetrics)
+	if err != nil {
+		t.Fatalf("MarshalToString() error = %v", err)
+	}
+	assert.Contains(t, got, `"2021-12-24 00:04:00 +0800"`)
+}
+
+func TestUnmarshalWithOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		opts    healthautoexport.DecoderOptions
+		want    *healthautoexport.Payload
+		wantErr bool
+	}{
+		{
+			name:  "custom time layouts",
+			input: `{"data":{"workouts":[{"name":"Walking","start":"24/12/2021 08:02:43 +0800","end":"24/12/2021 08:21:53 +0800"}]}}`,
+			opts: healthautoexport.DecoderOptions{
+				TimeLayouts: []string{"02/01/2006 15:04:05 -0700"},
+			},
+			want: &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Workouts: []*healthautoexport.Workout{
+						{
+							Name:  "Walking",
+							Start: mktimeptr("2021-12-24 08:02:43 +0800"),
+							End:   mktimeptr("2021-12-24 08:21:53 +0800"),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "custom time layouts replace defaults",
+			input:   `{"data":{"workouts":[{"name":"Walking","start":"2021-12-24 08:02:43 +0800","end":"2021-12-24 08:21:53 +0800"}]}}`,
+			opts:    healthautoexport.DecoderOptions{TimeLayouts: []string{"02/01/2006 15:04:05 -0700"}},
+			wantErr: true,
+		},
+		{
+			name:  "metric datapoints with 12 hour time",
+			input: `{"data":{"metrics":[{"name":"active_energy","units":"kJ","data":[{"qty":1,"date":"2021-12-24 12:04:00 a.m. +0800"}]}]}}`,
+			want: &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Metrics: []*healthautoexport.Metric{
+						{
+							Name:  "active_energy",
+							Units: "kJ",
+							Datapoints: []*healthautoexport.Datapoint{
+								{Qty: 1, Date: mktimeptr("2021-12-24 00:04:00 +0800")},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := healthautoexport.UnmarshalWithOptions(strings.NewReader(tt.input), healthautoexport.FormatJSON, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !cmp.Equal(tt.want, got, cmpOptions...) {
+				t.Errorf("UnmarshalWithOptions() not equal\ndiff = %v", cmp.Diff(tt.want, got, cmpOptions...))
+			}
+		})
+	}
+}
+
 func mktime(ts string) healthautoexport.Time {
 	t, err := healthautoexport.ParseTime(ts)
 	if err != nil {
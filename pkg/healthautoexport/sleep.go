@@ -0,0 +1,307 @@
+package healthautoexport
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSleepSessionMaxGap is the default maximum gap between sleep
+	// analysis samples that belong to the same sleep session.
+	DefaultSleepSessionMaxGap = 3 * time.Hour
+
+	// SleepSessionDateLayout is the layout of SleepSession.Date.
+	SleepSessionDateLayout = "2006-01-02"
+)
+
+// sleepStage is the normalized value of a SleepAnalysis sample.
+type sleepStage int
+
+// Sleep stages in increasing order of precedence. When samples from the same
+// source overlap, the time is attributed to the stage with higher precedence.
+const (
+	sleepStageNone sleepStage = iota
+	sleepStageInBed
+	sleepStageAsleep
+	sleepStageCore
+	sleepStageREM
+	sleepStageDeep
+	sleepStageAwake
+)
+
+// parseSleepStage normalizes the Value of a SleepAnalysis sample,
+// e.g. "In Bed", "inBed", "Asleep Unspecified" or "REM".
+func parseSleepStage(value string) sleepStage {
+	switch strings.ToLower(strings.ReplaceAll(value, " ", "")) {
+	case "inbed":
+		return sleepStageInBed
+	case "asleep", "unspecified", "asleepunspecified":
+		return sleepStageAsleep
+	case "core", "asleepcore":
+		return sleepStageCore
+	case "rem", "asleeprem":
+		return sleepStageREM
+	case "deep", "asleepdeep":
+		return sleepStageDeep
+	case "awake":
+		return sleepStageAwake
+	}
+	return sleepStageNone
+}
+
+func (s sleepStage) isAsleep() bool {
+	return s >= sleepStageAsleep && s <= sleepStageDeep
+}
+
+// SleepSession summarizes a single night of sleep from a single source,
+// reconstructed from non-aggregated SleepAnalysis samples.
+//
+// All durations are in hours, similar to AggregatedSleepAnalysis.
+type SleepSession struct {
+	// Date of the night, which is the calendar date of waking up.
+	Date string `json:"date"`
+
+	// Data source of the sleep session.
+	Source string `json:"source"`
+
+	// Start time of the first sample.
+	Start *Time `json:"start"`
+
+	// End time of the last sample.
+	End *Time `json:"end"`
+
+	// Start time of the first asleep sample. Not set if no sleep was recorded.
+	SleepStart *Time `json:"sleepStart,omitempty"`
+
+	// End time of the last asleep sample. Not set if no sleep was recorded.
+	SleepEnd *Time `json:"sleepEnd,omitempty"`
+
+	// InBed duration, which is the total time covered by any sample.
+	InBed Qty `json:"inBed"`
+
+	// Asleep duration, which is the sum of all sleep stages.
+	Asleep Qty `json:"asleep"`
+
+	// Awake duration.
+	Awake Qty `json:"awake"`
+
+	// Core sleep duration.
+	Core Qty `json:"core"`
+
+	// Deep sleep duration.
+	Deep Qty `json:"deep"`
+
+	// REM sleep duration.
+	REM Qty `json:"rem"`
+
+	// Efficiency is the ratio of Asleep to InBed duration.
+	Efficiency float64 `json:"efficiency"`
+
+	// Latency is the duration between Start and SleepStart.
+	Latency Qty `json:"latency"`
+
+	// WASO (wake after sleep onset) is the duration spent awake or in bed
+	// between SleepStart and SleepEnd.
+	WASO Qty `json:"waso"`
+}
+
+// SleepSessionBuilder reconstructs SleepSessions from non-aggregated
+// SleepAnalysis samples.
+//
+// Samples from all sources are first grouped into nights, such that samples
+// which are separated by no more than MaxGap belong to the same night. This
+// ensures that samples from different sources (e.g. Apple Watch and iPhone)
+// are attributed to the same night, even if their start and end times differ.
+// A SleepSession is then computed for each source in each night.
+type SleepSessionBuilder struct {
+	// MaxGap is the maximum gap between samples in the same night.
+	MaxGap time.Duration
+}
+
+// NewSleepSessionBuilder returns a SleepSessionBuilder with default options.
+func NewSleepSessionBuilder() *SleepSessionBuilder {
+	return &SleepSessionBuilder{
+		MaxGap: DefaultSleepSessionMaxGap,
+	}
+}
+
+// SleepSessions returns the SleepSessions for a sleep_analysis metric using
+// default options. Returns nil if the metric has no non-aggregated samples.
+func (m *Metric) SleepSessions() []*SleepSession {
+	return NewSleepSessionBuilder().Build(m.SleepAnalyses)
+}
+
+// Build returns SleepSessions sorted by date and source.
+func (b *SleepSessionBuilder) Build(samples []*SleepAnalysis) []*SleepSession {
+	// Drop samples without a valid interval.
+	valid := make([]*SleepAnalysis, 0, len(samples))
+	for _, sample := range samples {
+		if sample == nil || sample.StartDate.IsZero() || sample.EndDate.IsZero() {
+			continue
+		}
+		if !sample.EndDate.After(sample.StartDate.Time) {
+			continue
+		}
+		valid = append(valid, sample)
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	sort.SliceStable(valid, func(i, j int) bool {
+		return valid[i].StartDate.Before(valid[j].StartDate.Time)
+	})
+
+	// Group samples into nights.
+	var nights [][]*SleepAnalysis
+	var night []*SleepAnalysis
+	var nightEnd time.Time
+	for _, sample := range valid {
+		if len(night) > 0 && sample.StartDate.Sub(nightEnd) > b.MaxGap {
+			nights = append(nights, night)
+			night = nil
+		}
+		if len(night) == 0 || sample.EndDate.After(nightEnd) {
+			nightEnd = sample.EndDate.Time
+		}
+		night = append(night, sample)
+	}
+	nights = append(nights, night)
+
+	// Compute one session for each source in each night.
+	var sessions []*SleepSession
+	for _, night := range nights {
+		bySource := make(map[string][]*SleepAnalysis)
+		var sources []string
+		for _, sample := range night {
+			if _, ok := bySource[sample.Source]; !ok {
+				sources = append(sources, sample.Source)
+			}
+			bySource[sample.Source] = append(bySource[sample.Source], sample)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			sessions = append(sessions, buildSleepSession(source, bySource[source]))
+		}
+	}
+
+	return sessions
+}
+
+// buildSleepSession computes a SleepSession from samples of a single source,
+// which must be sorted by start time.
+func buildSleepSession(source string, samples []*SleepAnalysis) *SleepSession {
+	// Collect all boundaries of samples.
+	boundaries := make([]time.Time, 0, len(samples)*2)
+	for _, sample := range samples {
+		boundaries = append(boundaries, sample.StartDate.Time, sample.EndDate.Time)
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].Before(boundaries[j])
+	})
+
+	// Attribute each segment between consecutive boundaries to the stage with
+	// the highest precedence, so that overlapping samples are not counted twice.
+	type segment struct {
+		start, end time.Time
+		stage      sleepStage
+	}
+	segments := make([]segment, 0, len(boundaries))
+	for i := 0; i+1 < len(boundaries); i++ {
+		start, end := boundaries[i], boundaries[i+1]
+		if !end.After(start) {
+			continue
+		}
+		var stage sleepStage
+		covered := false
+		for _, sample := range samples {
+			if !sample.StartDate.Before(end) {
+				break
+			}
+			if sample.EndDate.After(start) {
+				covered = true
+				if s := parseSleepStage(sample.Value); s > stage {
+					stage = s
+				}
+			}
+		}
+		if !covered {
+			continue
+		}
+		// Treat unknown values as being in bed.
+		if stage == sleepStageNone {
+			stage = sleepStageInBed
+		}
+		segments = append(segments, segment{start: start, end: end, stage: stage})
+	}
+
+	var inBed, awake, core, deep, rem, asleep time.Duration
+	var sleepStart, sleepEnd time.Time
+	for _, seg := range segments {
+		d := seg.end.Sub(seg.start)
+		inBed += d
+		switch seg.stage {
+		case sleepStageAwake:
+			awake += d
+		case sleepStageCore:
+			core += d
+		case sleepStageDeep:
+			deep += d
+		case sleepStageREM:
+			rem += d
+		}
+		if seg.stage.isAsleep() {
+			asleep += d
+			if sleepStart.IsZero() {
+				sleepStart = seg.start
+			}
+			sleepEnd = seg.end
+		}
+	}
+
+	start := segments[0].start
+	end := segments[len(segments)-1].end
+	session := &SleepSession{
+		Source: source,
+		Start:  timePtr(start),
+		End:    timePtr(end),
+		InBed:  durationToHours(inBed),
+		Asleep: durationToHours(asleep),
+		Awake:  durationToHours(awake),
+		Core:   durationToHours(core),
+		Deep:   durationToHours(deep),
+		REM:    durationToHours(rem),
+	}
+	if inBed > 0 {
+		session.Efficiency = float64(asleep) / float64(inBed)
+	}
+
+	// The night is dated by the wake up time.
+	wake := end
+	if !sleepStart.IsZero() {
+		wake = sleepEnd
+		session.SleepStart = timePtr(sleepStart)
+		session.SleepEnd = timePtr(sleepEnd)
+		session.Latency = durationToHours(sleepStart.Sub(start))
+
+		var waso time.Duration
+		for _, seg := range segments {
+			if !seg.stage.isAsleep() && !seg.start.Before(sleepStart) && !seg.end.After(sleepEnd) {
+				waso += seg.end.Sub(seg.start)
+			}
+		}
+		session.WASO = durationToHours(waso)
+	}
+	session.Date = wake.Format(SleepSessionDateLayout)
+
+	return session
+}
+
+func timePtr(t time.Time) *Time {
+	result := NewTime(t)
+	return &result
+}
+
+func durationToHours(d time.Duration) Qty {
+	return Qty(d.Hours())
+}
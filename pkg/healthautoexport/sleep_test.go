@@ -0,0 +1,152 @@
+package healthautoexport_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+func TestSleepSessionBuilder_Build(t *testing.T) {
+	sample := func(start, end, source, value string) *healthautoexport.SleepAnalysis {
+		return &healthautoexport.SleepAnalysis{
+			StartDate: mktimeptr(start),
+			EndDate:   mktimeptr(end),
+			Source:    source,
+			Value:     value,
+		}
+	}
+	const (
+		watch  = "Apple Watch"
+		iphone = "iPhone"
+	)
+
+	tests := []struct {
+		name    string
+		samples []*healthautoexport.SleepAnalysis
+		want    []*healthautoexport.SleepSession
+	}{
+		{
+			name: "no samples",
+		},
+		{
+			name: "invalid samples are skipped",
+			samples: []*healthautoexport.SleepAnalysis{
+				{Source: watch, Value: "Core"},
+				sample("2024-01-02 01:00:00 +0800", "2024-01-02 00:00:00 +0800", watch, "Core"),
+			},
+		},
+		{
+			name: "overlapping stages and sources in a single night",
+			samples: []*healthautoexport.SleepAnalysis{
+				sample("2024-01-01 22:30:00 +0800", "2024-01-02 07:00:00 +0800", iphone, "In Bed"),
+				sample("2024-01-01 23:10:00 +0800", "2024-01-02 00:40:00 +0800", watch, "Core"),
+				sample("2024-01-02 00:40:00 +0800", "2024-01-02 01:40:00 +0800", watch, "Deep"),
+				// Overlaps with deep sleep, which takes precedence.
+				sample("2024-01-02 01:30:00 +0800", "2024-01-02 02:00:00 +0800", watch, "Core"),
+				sample("2024-01-02 02:00:00 +0800", "2024-01-02 02:15:00 +0800", watch, "Awake"),
+				sample("2024-01-02 02:15:00 +0800", "2024-01-02 03:15:00 +0800", watch, "REM"),
+				sample("2024-01-02 03:15:00 +0800", "2024-01-02 06:45:00 +0800", watch, "Core"),
+			},
+			want: []*healthautoexport.SleepSession{
+				{
+					Date:       "2024-01-02",
+					Source:     watch,
+					Start:      mktimeptr("2024-01-01 23:10:00 +0800"),
+					End:        mktimeptr("2024-01-02 06:45:00 +0800"),
+					SleepStart: mktimeptr("2024-01-01 23:10:00 +0800"),
+					SleepEnd:   mktimeptr("2024-01-02 06:45:00 +0800"),
+					InBed:      7.0 + 35.0/60,
+					Asleep:     7.0 + 20.0/60,
+					Awake:      0.25,
+					Core:       5.0 + 20.0/60,
+					Deep:       1,
+					REM:        1,
+					Efficiency: (7.0 + 20.0/60) / (7.0 + 35.0/60),
+					WASO:       0.25,
+				},
+				{
+					Date:   "2024-01-02",
+					Source: iphone,
+					Start:  mktimeptr("2024-01-01 22:30:00 +0800"),
+					End:    mktimeptr("2024-01-02 07:00:00 +0800"),
+					InBed:  8.5,
+				},
+			},
+		},
+		{
+			name: "latency, wake after sleep onset and separate nights",
+			samples: []*healthautoexport.SleepAnalysis{
+				sample("2024-01-01 23:00:00 +0800", "2024-01-01 23:30:00 +0800", watch, "Awake"),
+				sample("2024-01-01 23:30:00 +0800", "2024-01-02 03:00:00 +0800", watch, "Asleep"),
+				// Gap without any data is not counted.
+				sample("2024-01-02 03:30:00 +0800", "2024-01-02 04:00:00 +0800", watch, "In Bed"),
+				sample("2024-01-02 04:00:00 +0800", "2024-01-02 07:00:00 +0800", watch, "Asleep"),
+				// Afternoon nap.
+				sample("2024-01-02 14:00:00 +0800", "2024-01-02 14:30:00 +0800", watch, "Core"),
+			},
+			want: []*healthautoexport.SleepSession{
+				{
+					Date:       "2024-01-02",
+					Source:     watch,
+					Start:      mktimeptr("2024-01-01 23:00:00 +0800"),
+					End:        mktimeptr("2024-01-02 07:00:00 +0800"),
+					SleepStart: mktimeptr("2024-01-01 23:30:00 +0800"),
+					SleepEnd:   mktimeptr("2024-01-02 07:00:00 +0800"),
+					InBed:      7.5,
+					Asleep:     6.5,
+					Awake:      0.5,
+					Efficiency: 6.5 / 7.5,
+					Latency:    0.5,
+					WASO:       0.5,
+				},
+				{
+					Date:       "2024-01-02",
+					Source:     watch,
+					Start:      mktimeptr("2024-01-02 14:00:00 +0800"),
+					End:        mktimeptr("2024-01-02 14:30:00 +0800"),
+					SleepStart: mktimeptr("2024-01-02 14:00:00 +0800"),
+					SleepEnd:   mktimeptr("2024-01-02 14:30:00 +0800"),
+					InBed:      0.5,
+					Asleep:     0.5,
+					Core:       0.5,
+					Efficiency: 1,
+				},
+			},
+		},
+	}
+	opts := []cmp.Option{
+		cmpopts.EquateEmpty(),
+		cmpopts.EquateApprox(0, 1e-9),
+		cmp.Comparer(func(a, b healthautoexport.Qty) bool {
+			return cmp.Equal(float64(a), float64(b), cmpopts.EquateApprox(0, 1e-9))
+		}),
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			builder := healthautoexport.NewSleepSessionBuilder()
+			got := builder.Build(tt.samples)
+			if !cmp.Equal(tt.want, got, opts...) {
+				t.Errorf("Build() not equal\ndiff = %v", cmp.Diff(tt.want, got, opts...))
+			}
+		})
+	}
+}
+
+func TestSleepSessionBuilder_MaxGap(t *testing.T) {
+	samples := []*healthautoexport.SleepAnalysis{
+		{StartDate: mktimeptr("2024-01-01 23:00:00 +0800"), EndDate: mktimeptr("2024-01-02 01:00:00 +0800"), Value: "Core"},
+		{StartDate: mktimeptr("2024-01-02 02:00:00 +0800"), EndDate: mktimeptr("2024-01-02 03:00:00 +0800"), Value: "Core"},
+	}
+	builder := &healthautoexport.SleepSessionBuilder{MaxGap: 30 * time.Minute}
+	if got := builder.Build(samples); len(got) != 2 {
+		t.Errorf("expected 2 sessions, got %v", len(got))
+	}
+	if got := healthautoexport.NewSleepSessionBuilder().Build(samples); len(got) != 1 {
+		t.Errorf("expected 1 session, got %v", len(got))
+	}
+}
@@ -0,0 +1,209 @@
+package healthautoexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTimeFormat is the default time format to use for output.
+	DefaultTimeFormat = "2006-01-02 15:04:05 -0700"
+)
+
+var (
+	// defaultTimeLayouts contains all known time layouts to parse timestamps by.
+	//
+	// Timestamps are normalized before parsing (see TimeParser), so layouts
+	// only need to handle a single space character and uppercase AM/PM.
+	defaultTimeLayouts = []string{
+		// Using 24-Hour Time
+		DefaultTimeFormat,
+		// In case General > Date & Time > 24-Hour Time is set to false
+		"2006-01-02 3:04:05 PM -0700",
+		// In case of locales which place the AM/PM marker before the time (e.g. ja_JP, ko_KR, zh_CN)
+		"2006-01-02 PM 3:04:05 -0700",
+		"2006-01-02 PM3:04:05 -0700",
+		// ISO 8601 without spaces
+		"2006-01-02T15:04:05-0700",
+		time.RFC3339Nano,
+		"20060102T150405-0700",
+		"20060102T150405Z0700",
+	}
+
+	// timeReplacer normalizes whitespace and AM/PM markers before parsing.
+	timeReplacer = strings.NewReplacer(
+		// Newer iOS versions introduce narrow non-breaking space characters into the time format (ICU 72.1)
+		"\u202f", " ",
+		"\u00a0", " ",
+		// Dotted markers (e.g. en_AU, en_CA)
+		"a.m.", "AM",
+		"p.m.", "PM",
+		"A.M.", "AM",
+		"P.M.", "PM",
+		"am", "AM",
+		"pm", "PM",
+		// Localized markers
+		"午前", "AM",
+		"午後", "PM",
+		"上午", "AM",
+		"下午", "PM",
+		"오전", "AM",
+		"오후", "PM",
+		"vorm.", "AM",
+		"nachm.", "PM",
+		"π.μ.", "AM",
+		"μ.μ.", "PM",
+	)
+)
+
+// DefaultTimeLayouts returns a copy of all known time layouts to parse timestamps by.
+func DefaultTimeLayouts() []string {
+	return append([]string(nil), defaultTimeLayouts...)
+}
+
+// Time is a custom time type for this package.
+type Time struct {
+	time.Time
+}
+
+func NewTime(t time.Time) Time {
+	return Time{Time: t}
+}
+
+// TimeParser parses timestamps in any of the time formats that may be exported
+// by Health Auto Export.
+//
+// Since iOS may export timestamps using different time formats depending on the
+// Region setting, we need to handle all possible time formats that may be
+// available. As an optimization, the last known good layout will be cached to
+// speed up subsequent calls to Parse. Since different devices may use different
+// formats, a new TimeParser should be used for each payload.
+//
+// TimeParser is safe for concurrent use.
+type TimeParser struct {
+	layouts []string
+
+	// last is the index of the last successfully used layout.
+	last atomic.Int32
+}
+
+// NewTimeParser returns a new TimeParser for the given layouts, which are
+// attempted in order. If no layouts are specified, DefaultTimeLayouts is used.
+func NewTimeParser(layouts ...string) *TimeParser {
+	if len(layouts) == 0 {
+		layouts = defaultTimeLayouts
+	}
+	return &TimeParser{
+		layouts: append([]string(nil), layouts...),
+	}
+}
+
+// Parse attempts to parse the input string across all layouts.
+func (p *TimeParser) Parse(s string) (Time, error) {
+	normalized := normalizeTime(s)
+
+	// Attempt to parse using the last used layout first.
+	last := int(p.last.Load())
+	if t, err := parseTime(p.layouts[last], normalized); err == nil {
+		return t, nil
+	}
+
+	// Otherwise, try all layouts.
+	var multiErr error
+	for i, layout := range p.layouts {
+		if i == last {
+			continue
+		}
+		parsed, err := parseTime(layout, normalized)
+		if err != nil {
+			multiErr = multierror.Append(multiErr, err)
+			continue
+		}
+		// Cache the last used layout to speed up subsequent parses.
+		p.last.Store(int32(i)) // nolint:gosec
+		return parsed, nil
+	}
+	return Time{}, fmt.Errorf(`failed to parse time "%v" across all known formats: %w`, s, multiErr)
+}
+
+// ParseTime attempts to parses the input string in the time format expected by health auto export.
+// See TimeParser for more details.
+func ParseTime(s string) (Time, error) {
+	return NewTimeParser().Parse(s)
+}
+
+// normalizeTime normalizes whitespace and AM/PM markers in a timestamp.
+func normalizeTime(s string) string {
+	return strings.Join(strings.Fields(timeReplacer.Replace(s)), " ")
+}
+
+// parseTime attempts to parse the time string with the given time format.
+func parseTime(timeFormat string, s string) (Time, error) {
+	parsed, err := time.Parse(timeFormat, s)
+	if err != nil {
+		return Time{}, errors.Wrapf(err, `cannot parse with format: "%v"`, timeFormat)
+	}
+	return NewTime(parsed), nil
+}
+
+// String returns a RFC3339 formatted timestamp string.
+func (t Time) String() string {
+	return t.Format(time.RFC3339)
+}
+
+func (t *Time) IsZero() bool {
+	if t == nil {
+		return true
+	}
+	return t.Time.IsZero()
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+// This implementation overrides the default time.Time json.Marshaler
+// implementation, and marshals using DefaultTimeFormat.
+func (t Time) MarshalJSON() ([]byte, error) {
+	return t.marshalJSON(DefaultTimeFormat)
+}
+
+func (t Time) marshalJSON(timeFormat string) ([]byte, error) {
+	// Propagate marshal errors forward first.
+	if ret, err := t.Time.MarshalJSON(); err != nil {
+		return ret, err
+	}
+
+	// Otherwise, marshal using timeFormat.
+	b := make([]byte, 0, len(timeFormat)+2)
+	b = append(b, '"')
+	b = t.AppendFormat(b, timeFormat)
+	b = append(b, '"')
+	return b, nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+// This implementation overrides the default time.Time json.Unmarshaler
+// implementation, and parses timestamps using DefaultTimeLayouts.
+func (t *Time) UnmarshalJSON(data []byte) error {
+	return t.unmarshalJSON(NewTimeParser(), data)
+}
+
+func (t *Time) unmarshalJSON(parser *TimeParser, data []byte) error {
+	if string(data) == "null" {
+		return t.Time.UnmarshalJSON(data)
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := parser.Parse(s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed.Time
+	return nil
+}
@@ -4,51 +4,101 @@ package healthautoexport
 
 import (
 	"encoding/json"
-	"fmt"
 	"reflect"
 	"sort"
 	"strings"
-	"time"
 
-	"github.com/hashicorp/go-multierror"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/mitchellh/mapstructure"
-	"github.com/pkg/errors"
 )
 
 const (
 	SleepAnalysisName = "sleep_analysis"
 )
 
-var (
-	// TimeFormat is the default time format to use for output.
-	TimeFormat = TimeFormats[0]
-
-	// lastUsedTimeFormat is a global default time format for the package.
-	// Attempts to memoize the last used format to speed up unmarshaling.
-	// See ParseTime.
-	lastUsedTimeFormat = TimeFormat
-
-	// TimeFormats contains all known time formats to parse timestamp by.
-	TimeFormats = []string{
-		// Using 24-Hour Time
-		"2006-01-02 15:04:05 -0700",
-		// In case General > Date & Time > 24-Hour Time is set to false
-		"2006-01-02 3:04:05 PM -0700",
-		"2006-01-02 3:04:05 pm -0700",
-		// In case of newer iOS versions which introduce narrow non-breaking space characters into time format
-		"2006-01-02 3:04:05\xe2\x80\xafPM -0700",
-		"2006-01-02 3:04:05\xe2\x80\xafpm -0700",
-	}
-)
-
 type Payload struct {
 	Data *PayloadData `json:"data,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
 }
 
+// payloadCopy avoids reflection stack overflow by creating type alias of Payload.
+// https://stackoverflow.com/a/43178272/2037090
+type payloadCopy Payload
+
 type PayloadData struct {
-	Metrics  []*Metric  `json:"metrics,omitempty"`
-	Workouts []*Workout `json:"workouts,omitempty"`
+	Metrics                []*Metric                `json:"metrics,omitempty"`
+	Workouts               []*Workout               `json:"workouts,omitempty"`
+	Symptoms               []*Symptom               `json:"symptoms,omitempty"`
+	Medications            []*Medication            `json:"medications,omitempty"`
+	ECG                    []*ECG                   `json:"ecg,omitempty"`
+	HeartRateNotifications []*HeartRateNotification `json:"heartRateNotifications,omitempty"`
+	StateOfMind            []*StateOfMind           `json:"stateOfMind,omitempty"`
+	CycleTracking          []*CycleTracking         `json:"cycleTracking,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// payloadDataCopy avoids reflection stack overflow by creating type alias of PayloadData.
+// https://stackoverflow.com/a/43178272/2037090
+type payloadDataCopy PayloadData
+
+func (p *Payload) MarshalJSON() ([]byte, error) {
+	return p.marshalJSON(jsonAPI)
+}
+
+func (p *Payload) marshalJSON(api jsoniter.API) ([]byte, error) {
+	bytes, err := api.Marshal((*payloadCopy)(p))
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnknownFields(api, bytes, p.Unknown)
+}
+
+func (p *Payload) UnmarshalJSON(bytes []byte) error {
+	return p.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (p *Payload) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	if err := api.Unmarshal(bytes, (*payloadCopy)(p)); err != nil {
+		return err
+	}
+	unknown, err := unmarshalUnknownFields(api, bytes, payloadType)
+	if err != nil {
+		return err
+	}
+	p.Unknown = unknown
+	return nil
+}
+
+func (d *PayloadData) MarshalJSON() ([]byte, error) {
+	return d.marshalJSON(jsonAPI)
+}
+
+func (d *PayloadData) marshalJSON(api jsoniter.API) ([]byte, error) {
+	bytes, err := api.Marshal((*payloadDataCopy)(d))
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnknownFields(api, bytes, d.Unknown)
+}
+
+func (d *PayloadData) UnmarshalJSON(bytes []byte) error {
+	return d.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (d *PayloadData) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	if err := api.Unmarshal(bytes, (*payloadDataCopy)(d)); err != nil {
+		return err
+	}
+	unknown, err := unmarshalUnknownFields(api, bytes, payloadDataType)
+	if err != nil {
+		return err
+	}
+	d.Unknown = unknown
+	return nil
 }
 
 // Metric defines a single measurement with units, as well as time-series data points.
@@ -58,6 +108,9 @@ type Metric struct {
 	Datapoints              []*Datapoint               `json:"-"`
 	SleepAnalyses           []*SleepAnalysis           `json:"-"`
 	AggregatedSleepAnalyses []*AggregatedSleepAnalysis `json:"-"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
 }
 
 // metricCopy avoids reflection stack overflow by creating type alias of Metric.
@@ -78,12 +131,41 @@ type SleepAnalysis struct {
 	Qty       Qty    `json:"qty,omitempty"`
 	Source    string `json:"source"`
 	Value     string `json:"value"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// sleepAnalysisCopy avoids reflection stack overflow by creating type alias of SleepAnalysis.
+// https://stackoverflow.com/a/43178272/2037090
+type sleepAnalysisCopy SleepAnalysis
+
+func (s *SleepAnalysis) MarshalJSON() ([]byte, error) {
+	return s.marshalJSON(jsonAPI)
+}
+
+func (s *SleepAnalysis) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*sleepAnalysisCopy)(s), s.Unknown)
+}
+
+func (s *SleepAnalysis) UnmarshalJSON(bytes []byte) error {
+	return s.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (s *SleepAnalysis) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*sleepAnalysisCopy)(s), sleepAnalysisType)
+	if err != nil {
+		return err
+	}
+	s.Unknown = unknown
+	return nil
 }
 
 // AggregatedSleepAnalysis defines an aggregated period of an entire night of sleep.
 // It is only valid for aggregate sleep analysis data ("Aggregate Sleep Data" is enabled)
 type AggregatedSleepAnalysis struct {
-	// we don't parse "date" which doesn't seem to have useful interesting information
+	// we don't parse "date" which doesn't seem to have useful interesting information,
+	// but it is still preserved in Unknown.
 
 	// Start time of sleep.
 	SleepStart *Time `json:"sleepStart"`
@@ -133,6 +215,34 @@ type AggregatedSleepAnalysis struct {
 	// Data source of inBed phase.
 	// Only available prior to HAE v6.6.2.
 	InBedSource string `json:"inBedSource,omitempty"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// aggregatedSleepAnalysisCopy avoids reflection stack overflow by creating type alias of AggregatedSleepAnalysis.
+// https://stackoverflow.com/a/43178272/2037090
+type aggregatedSleepAnalysisCopy AggregatedSleepAnalysis
+
+func (a *AggregatedSleepAnalysis) MarshalJSON() ([]byte, error) {
+	return a.marshalJSON(jsonAPI)
+}
+
+func (a *AggregatedSleepAnalysis) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*aggregatedSleepAnalysisCopy)(a), a.Unknown)
+}
+
+func (a *AggregatedSleepAnalysis) UnmarshalJSON(bytes []byte) error {
+	return a.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (a *AggregatedSleepAnalysis) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*aggregatedSleepAnalysisCopy)(a), aggregatedSleepAnalysisType)
+	if err != nil {
+		return err
+	}
+	a.Unknown = unknown
+	return nil
 }
 
 func (m *Metric) GetUnits() Units {
@@ -141,6 +251,10 @@ func (m *Metric) GetUnits() Units {
 
 // Workout defines a single recorded Workout.
 type Workout struct {
+	// ID is a unique identifier of the workout, which is only exported by
+	// newer versions of Health Auto Export.
+	ID string `json:"id,omitempty"`
+
 	Name  string `json:"name"`
 	Start *Time  `json:"start"`
 	End   *Time  `json:"end"`
@@ -157,6 +271,9 @@ type Workout struct {
 
 	// Other workout fields.
 	Fields WorkoutFields `json:"-"`
+
+	// Unknown fields which do not match QtyWithUnit, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
 }
 
 // WorkoutFields is a map of generic QtyWithUnit fields in a Workout.
@@ -167,16 +284,26 @@ type WorkoutFields []Field
 type workoutCopy Workout
 
 func (m *Metric) UnmarshalJSON(bytes []byte) error {
+	return m.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (m *Metric) unmarshalJSON(api jsoniter.API, bytes []byte) error {
 	intermediate := jsonMetric{
 		metricCopy: (*metricCopy)(m),
 	}
-	if err := jsoniter.Unmarshal(bytes, &intermediate); err != nil {
+	if err := api.Unmarshal(bytes, &intermediate); err != nil {
 		return err
 	}
+	unknown, err := unmarshalUnknownFields(api, bytes, metricType, "data")
+	if err != nil {
+		return err
+	}
+	m.Unknown = unknown
+
 	switch m.Name {
 	case SleepAnalysisName:
 		// Try to unmarshal as sleep_analysis on best-effort basis.
-		if m.unmarshalSleepAnalysis(intermediate.Data) {
+		if m.unmarshalSleepAnalysis(api, intermediate.Data) {
 			break
 		}
 		fallthrough
@@ -185,7 +312,7 @@ func (m *Metric) UnmarshalJSON(bytes []byte) error {
 			return nil
 		}
 		var d []*Datapoint
-		if err := jsoniter.Unmarshal(intermediate.Data, &d); err != nil {
+		if err := api.Unmarshal(intermediate.Data, &d); err != nil {
 			return err
 		}
 		m.Datapoints = d
@@ -194,10 +321,10 @@ func (m *Metric) UnmarshalJSON(bytes []byte) error {
 	return nil
 }
 
-func (m *Metric) unmarshalSleepAnalysis(data []byte) bool {
+func (m *Metric) unmarshalSleepAnalysis(api jsoniter.API, data []byte) bool {
 	// Try to unmarshal as SleepAnalysis first.
 	var sa []*SleepAnalysis
-	if err := jsoniter.Unmarshal(data, &sa); err == nil {
+	if err := api.Unmarshal(data, &sa); err == nil {
 		// Non-aggregated sleep_analysis should always have StartDate and EndDate set.
 		if len(sa) > 0 && !sa[0].StartDate.IsZero() && !sa[0].EndDate.IsZero() {
 			m.SleepAnalyses = sa
@@ -207,7 +334,7 @@ func (m *Metric) unmarshalSleepAnalysis(data []byte) bool {
 
 	// Try to unmarshal as AggregatedSleepAnalysis.
 	var agg []*AggregatedSleepAnalysis
-	if err := jsoniter.Unmarshal(data, &agg); err == nil {
+	if err := api.Unmarshal(data, &agg); err == nil {
 		// Aggregated sleep_analysis should always have SleepStart and SleepEnd set.
 		if len(agg) > 0 && !agg[0].SleepStart.IsZero() && !agg[0].SleepEnd.IsZero() {
 			m.AggregatedSleepAnalyses = agg
@@ -219,6 +346,10 @@ func (m *Metric) unmarshalSleepAnalysis(data []byte) bool {
 }
 
 func (m *Metric) MarshalJSON() ([]byte, error) {
+	return m.marshalJSON(jsonAPI)
+}
+
+func (m *Metric) marshalJSON(api jsoniter.API) ([]byte, error) {
 	intermediate := jsonMetric{
 		metricCopy: (*metricCopy)(m),
 	}
@@ -238,44 +369,60 @@ func (m *Metric) MarshalJSON() ([]byte, error) {
 	default:
 		data = m.Datapoints
 	}
-	bytes, err := jsoniter.Marshal(data)
+	bytes, err := api.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
 	intermediate.Data = bytes
-	return jsoniter.Marshal(intermediate)
+	result, err := api.Marshal(intermediate)
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnknownFields(api, result, m.Unknown)
 }
 
 func (w *Workout) MarshalJSON() ([]byte, error) {
+	return w.marshalJSON(jsonAPI)
+}
+
+func (w *Workout) marshalJSON(api jsoniter.API) ([]byte, error) {
 	result := make(map[string]interface{})
 	for _, field := range w.Fields {
 		result[field.Key] = field.Value
 	}
 
 	// Marshal and unmarshal remaining fields onto the same map
-	outerBytes, err := jsoniter.Marshal((*workoutCopy)(w))
+	outerBytes, err := api.Marshal((*workoutCopy)(w))
 	if err != nil {
 		return nil, err
 	}
-	if err := jsoniter.Unmarshal(outerBytes, &result); err != nil {
+	if err := api.Unmarshal(outerBytes, &result); err != nil {
 		return nil, err
 	}
 
 	// Marshal result back
-	return jsoniter.Marshal(result)
+	bytes, err := api.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnknownFields(api, bytes, w.Unknown)
 }
 
 // UnmarshalJSON implements a custom json.Unmarshaler for Workout.
 // This is necessary to unmarshal arbitrary Fields that may match QtyWithUnit.
 func (w *Workout) UnmarshalJSON(bytes []byte) error {
+	return w.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (w *Workout) unmarshalJSON(api jsoniter.API, bytes []byte) error {
 	// First pass: Unmarshal into struct.
-	if err := jsoniter.Unmarshal(bytes, (*workoutCopy)(w)); err != nil {
+	if err := api.Unmarshal(bytes, (*workoutCopy)(w)); err != nil {
 		return err
 	}
 
 	// Second pass: Unmarshal into generic map.
 	fields := make(map[string]interface{})
-	if err := jsoniter.Unmarshal(bytes, &fields); err != nil {
+	if err := api.Unmarshal(bytes, &fields); err != nil {
 		return err
 	}
 
@@ -302,6 +449,17 @@ func (w *Workout) UnmarshalJSON(bytes []byte) error {
 		return w.Fields[i].Key < w.Fields[j].Key
 	})
 
+	// Preserve all remaining fields.
+	exclude := make([]string, 0, len(w.Fields))
+	for _, field := range w.Fields {
+		exclude = append(exclude, field.Key)
+	}
+	unknown, err := unmarshalUnknownFields(api, bytes, workoutType, exclude...)
+	if err != nil {
+		return err
+	}
+	w.Unknown = unknown
+
 	return nil
 }
 
@@ -345,40 +503,48 @@ type DatapointFields map[string]interface{}
 type datapointCopy Datapoint
 
 func (w *Datapoint) MarshalJSON() ([]byte, error) {
+	return w.marshalJSON(jsonAPI)
+}
+
+func (w *Datapoint) marshalJSON(api jsoniter.API) ([]byte, error) {
 	// Marshal and unmarshal Fields into a generic map
 	result := make(map[string]interface{})
-	bytes, err := jsoniter.Marshal(w.Fields)
+	bytes, err := api.Marshal(w.Fields)
 	if err != nil {
 		return nil, err
 	}
-	if err := jsoniter.Unmarshal(bytes, &result); err != nil {
+	if err := api.Unmarshal(bytes, &result); err != nil {
 		return nil, err
 	}
 
 	// Marshal and unmarshal remaining fields onto the same map
-	outerBytes, err := jsoniter.Marshal((*datapointCopy)(w))
+	outerBytes, err := api.Marshal((*datapointCopy)(w))
 	if err != nil {
 		return nil, err
 	}
-	if err := jsoniter.Unmarshal(outerBytes, &result); err != nil {
+	if err := api.Unmarshal(outerBytes, &result); err != nil {
 		return nil, err
 	}
 
 	// Marshal result back
-	return jsoniter.Marshal(result)
+	return api.Marshal(result)
 }
 
 // UnmarshalJSON implements a custom json.Unmarshaler for Datapoint.
 // This is necessary to unmarshal arbitrary DatapointFields.
 func (w *Datapoint) UnmarshalJSON(bytes []byte) error {
+	return w.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (w *Datapoint) unmarshalJSON(api jsoniter.API, bytes []byte) error {
 	// First pass: Unmarshal into struct.
-	if err := jsoniter.Unmarshal(bytes, (*datapointCopy)(w)); err != nil {
+	if err := api.Unmarshal(bytes, (*datapointCopy)(w)); err != nil {
 		return err
 	}
 
 	// Second pass: Unmarshal into generic map, dropping qty and date.
 	fields := make(map[string]interface{})
-	if err := jsoniter.Unmarshal(bytes, &fields); err != nil {
+	if err := api.Unmarshal(bytes, &fields); err != nil {
 		return err
 	}
 
@@ -396,7 +562,7 @@ func (w *Datapoint) UnmarshalJSON(bytes []byte) error {
 		switch value := v.(type) {
 		case string:
 			var t Time
-			if err := jsoniter.Unmarshal([]byte(`"`+value+`"`), &t); err == nil {
+			if err := api.Unmarshal([]byte(`"`+value+`"`), &t); err == nil {
 				result = &t
 				break
 			}
@@ -411,6 +577,34 @@ func (w *Datapoint) UnmarshalJSON(bytes []byte) error {
 type DatapointWithUnit struct {
 	Date *Time `json:"date"`
 	QtyWithUnit
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
+}
+
+// datapointWithUnitCopy avoids reflection stack overflow by creating type alias of DatapointWithUnit.
+// https://stackoverflow.com/a/43178272/2037090
+type datapointWithUnitCopy DatapointWithUnit
+
+func (d *DatapointWithUnit) MarshalJSON() ([]byte, error) {
+	return d.marshalJSON(jsonAPI)
+}
+
+func (d *DatapointWithUnit) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*datapointWithUnitCopy)(d), d.Unknown)
+}
+
+func (d *DatapointWithUnit) UnmarshalJSON(bytes []byte) error {
+	return d.unmarshalJSON(jsonAPI, bytes)
+}
+
+func (d *DatapointWithUnit) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*datapointWithUnitCopy)(d), datapointWithUnitType)
+	if err != nil {
+		return err
+	}
+	d.Unknown = unknown
+	return nil
 }
 
 // RouteDatapoint is a point-in-time location in 3D coordinates.
@@ -419,108 +613,72 @@ type RouteDatapoint struct {
 	Lon       float64 `json:"lon"`
 	Altitude  float64 `json:"altitude"`
 	Timestamp *Time   `json:"timestamp"`
-}
 
-// Elevation is a specify QtyWithUnit that specifies Ascent and Descent values.
-// It is only used for the Elevation field.
-type Elevation struct {
-	Units   Units `json:"units"`
-	Ascent  Qty   `json:"ascent"`
-	Descent Qty   `json:"descent"`
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
 }
 
-func (e Elevation) GetUnits() Units {
-	return e.Units
-}
+// routeDatapointCopy avoids reflection stack overflow by creating type alias of RouteDatapoint.
+// https://stackoverflow.com/a/43178272/2037090
+type routeDatapointCopy RouteDatapoint
 
-// Time is a custom time type for this package.
-type Time struct {
-	time.Time
+func (r *RouteDatapoint) MarshalJSON() ([]byte, error) {
+	return r.marshalJSON(jsonAPI)
 }
 
-func NewTime(t time.Time) Time {
-	return Time{Time: t}
+func (r *RouteDatapoint) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*routeDatapointCopy)(r), r.Unknown)
 }
 
-// ParseTime attempts to parses the input string in the time format expected by health auto export.
-//
-// Since iOS may export timestamps using different time formats depending on the Region setting,
-// we need to handle all possible time formats that may be available.
-//
-// As an optimization, the last known good format will be cached to speed up subsequent calls to ParseTime.
-func ParseTime(s string) (Time, error) {
-	var multiErr error
-	// Attempt to unmarshal using lastUsedTimeFormat first.
-	if t, err := parseTime(lastUsedTimeFormat, s); err == nil {
-		return t, nil
-	}
-	// Otherwise, try all formats.
-	for _, timeFormat := range TimeFormats {
-		parsed, err := parseTime(timeFormat, s)
-		if err != nil {
-			multiErr = multierror.Append(multiErr, err)
-			continue
-		}
-		// Cache the last used format to speed up subsequent parses.
-		lastUsedTimeFormat = timeFormat
-		return parsed, nil
-	}
-	return Time{}, fmt.Errorf(`failed to parse time "%v" across all known formats: %w`, s, multiErr)
+func (r *RouteDatapoint) UnmarshalJSON(bytes []byte) error {
+	return r.unmarshalJSON(jsonAPI, bytes)
 }
 
-// parseTime attempts to parse the time string with the given time format.
-func parseTime(timeFormat string, s string) (Time, error) {
-	parsed, err := time.Parse(timeFormat, s)
+func (r *RouteDatapoint) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*routeDatapointCopy)(r), routeDatapointType)
 	if err != nil {
-		return Time{}, errors.Wrapf(err, `cannot parse with format: "%v"`, timeFormat)
+		return err
 	}
-	return NewTime(parsed), nil
+	r.Unknown = unknown
+	return nil
 }
 
-// String returns a RFC3339 formatted timestamp string.
-func (t Time) String() string {
-	return t.Format(time.RFC3339)
+// Elevation is a specify QtyWithUnit that specifies Ascent and Descent values.
+// It is only used for the Elevation field.
+type Elevation struct {
+	Units   Units `json:"units"`
+	Ascent  Qty   `json:"ascent"`
+	Descent Qty   `json:"descent"`
+
+	// Unknown fields, which are preserved when marshaling.
+	Unknown UnknownFields `json:"-"`
 }
 
-func (t *Time) IsZero() bool {
-	if t == nil {
-		return true
-	}
-	return t.Time.IsZero()
+// elevationCopy avoids reflection stack overflow by creating type alias of Elevation.
+// https://stackoverflow.com/a/43178272/2037090
+type elevationCopy Elevation
+
+func (e *Elevation) MarshalJSON() ([]byte, error) {
+	return e.marshalJSON(jsonAPI)
 }
 
-// MarshalJSON implements the json.Marshaler interface.
-// This implementation overrides the default time.Time json.Marshaler
-// implementation, and marshals using TimeFormat.
-func (t Time) MarshalJSON() ([]byte, error) {
-	// Propagate marshal errors forward first.
-	if ret, err := t.Time.MarshalJSON(); err != nil {
-		return ret, err
-	}
+func (e *Elevation) marshalJSON(api jsoniter.API) ([]byte, error) {
+	return marshalWithUnknownFields(api, (*elevationCopy)(e), e.Unknown)
+}
 
-	// Otherwise, marshal using TimeFormat.
-	b := make([]byte, 0, len(TimeFormat)+2)
-	b = append(b, '"')
-	b = t.AppendFormat(b, TimeFormat)
-	b = append(b, '"')
-	return b, nil
+func (e *Elevation) UnmarshalJSON(bytes []byte) error {
+	return e.unmarshalJSON(jsonAPI, bytes)
 }
 
-// UnmarshalJSON implements the json.Unmarshaler interface.
-// This implementation overrides the default time.Time json.Unmarshaler
-// implementation, and parses timestamps using TimeFormat.
-func (t *Time) UnmarshalJSON(data []byte) error {
-	if string(data) == "null" {
-		return t.Time.UnmarshalJSON(data)
-	}
-	var s string
-	if err := json.Unmarshal(data, &s); err != nil {
-		return err
-	}
-	parsed, err := ParseTime(s)
+func (e *Elevation) unmarshalJSON(api jsoniter.API, bytes []byte) error {
+	unknown, err := unmarshalWithUnknownFields(api, bytes, (*elevationCopy)(e), elevationType)
 	if err != nil {
 		return err
 	}
-	t.Time = parsed.Time
+	e.Unknown = unknown
 	return nil
 }
+
+func (e Elevation) GetUnits() Units {
+	return e.Units
+}
@@ -3,6 +3,7 @@ package healthautoexport_test
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -68,6 +69,36 @@ func TestParseTime(t *testing.T) {
 			input: "2024-09-21 7:57:00\xe2\x80\xafAM +1000",
 			want:  healthautoexport.NewTime(mustParseTimeRFC3339("2024-09-21T07:57:00+10:00")),
 		},
+		{
+			name:  "12 hour time using dotted a.m./p.m.",
+			input: "2024-09-21 7:57:00 p.m. +1000",
+			want:  healthautoexport.NewTime(mustParseTimeRFC3339("2024-09-21T19:57:00+10:00")),
+		},
+		{
+			name:  "12 hour time with localized marker before time",
+			input: "2024-09-21 午後7:57:00 +0900",
+			want:  healthautoexport.NewTime(mustParseTimeRFC3339("2024-09-21T19:57:00+09:00")),
+		},
+		{
+			name:  "12 hour time with localized marker separated by space",
+			input: "2024-09-21 오전 7:57:00 +0900",
+			want:  healthautoexport.NewTime(mustParseTimeRFC3339("2024-09-21T07:57:00+09:00")),
+		},
+		{
+			name:  "ISO 8601 without spaces",
+			input: "2024-09-21T07:57:00+1000",
+			want:  healthautoexport.NewTime(mustParseTimeRFC3339("2024-09-21T07:57:00+10:00")),
+		},
+		{
+			name:  "ISO 8601 basic format",
+			input: "20240921T075700Z",
+			want:  healthautoexport.NewTime(mustParseTimeRFC3339("2024-09-21T07:57:00Z")),
+		},
+		{
+			name:      "unknown format",
+			input:     "21/09/2024 07:57:00",
+			wantError: testutils.AssertErrorContains("failed to parse time"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -82,6 +113,83 @@ func TestParseTime(t *testing.T) {
 	}
 }
 
+func TestTimeParser_Parse(t *testing.T) {
+	tests := []struct {
+		name      string
+		layouts   []string
+		input     string
+		want      healthautoexport.Time
+		wantError assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "custom layout",
+			layouts: []string{"02/01/2006 15:04:05 -0700"},
+			input:   "21/09/2024 07:57:00 +1000",
+			want:    healthautoexport.NewTime(mustParseTimeRFC3339("2024-09-21T07:57:00+10:00")),
+		},
+		{
+			name:      "custom layout does not include defaults",
+			layouts:   []string{"02/01/2006 15:04:05 -0700"},
+			input:     "2024-09-21 07:57:00 +1000",
+			wantError: testutils.AssertErrorContains("failed to parse time"),
+		},
+		{
+			name:  "default layouts",
+			input: "2024-09-21 07:57:00 +1000",
+			want:  healthautoexport.NewTime(mustParseTimeRFC3339("2024-09-21T07:57:00+10:00")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := healthautoexport.NewTimeParser(tt.layouts...).Parse(tt.input)
+			if testutils.WantError(t, tt.wantError, err) {
+				return
+			}
+			if !cmp.Equal(tt.want, parsed) {
+				t.Errorf("want %v, got %v", tt.want, parsed)
+			}
+		})
+	}
+}
+
+func TestDefaultTimeLayouts(t *testing.T) {
+	// Modifying the returned layouts does not affect the package defaults.
+	layouts := healthautoexport.DefaultTimeLayouts()
+	for i := range layouts {
+		layouts[i] = "02/01/2006"
+	}
+	parsed, err := healthautoexport.ParseTime("2024-09-21 07:57:00 +1000")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2024-09-21T07:57:00+10:00", parsed.String())
+	}
+}
+
+func TestTimeParser_ParseConcurrent(t *testing.T) {
+	parser := healthautoexport.NewTimeParser()
+	inputs := map[string]string{
+		"2024-09-21 07:57:00 +1000":     "2024-09-21T07:57:00+10:00",
+		"2024-09-21 7:57:00 PM +1000":   "2024-09-21T19:57:00+10:00",
+		"2024-09-21T07:57:00+1000":      "2024-09-21T07:57:00+10:00",
+		"2024-09-21 午前7:57:00 +0900":    "2024-09-21T07:57:00+09:00",
+		"2024-09-21 7:57:00 a.m. +1000": "2024-09-21T07:57:00+10:00",
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for input, want := range inputs {
+			input, want := input, want
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				parsed, err := parser.Parse(input)
+				if assert.NoError(t, err) {
+					assert.Equal(t, want, parsed.String())
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
 func TestTime_UnmarshalJSON(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -137,3 +245,61 @@ func mustParseTimeRFC3339(s string) time.Time {
 	}
 	return parsed
 }
+
+func TestWorkout_WorkoutID(t *testing.T) {
+	tests := []struct {
+		name    string
+		workout *healthautoexport.Workout
+		want    string
+	}{
+		{
+			name: "use exported id",
+			workout: &healthautoexport.Workout{
+				ID:    "ABC-123",
+				Name:  "Walking",
+				Start: mktimeptr("2021-12-24 08:02:43 +0800"),
+			},
+			want: "ABC-123",
+		},
+		{
+			name: "hash of name, start and end",
+			workout: &healthautoexport.Workout{
+				Name:  "Walking",
+				Start: mktimeptr("2021-12-24 08:02:43 +0800"),
+				End:   mktimeptr("2021-12-24 08:21:53 +0800"),
+			},
+			want: "0d440f6feecd8126",
+		},
+		{
+			name: "hash is independent of time zone",
+			workout: &healthautoexport.Workout{
+				Name:  "Walking",
+				Start: mktimeptr("2021-12-24 00:02:43 +0000"),
+				End:   mktimeptr("2021-12-24 00:21:53 +0000"),
+			},
+			want: "0d440f6feecd8126",
+		},
+		{
+			name: "different start time",
+			workout: &healthautoexport.Workout{
+				Name:  "Walking",
+				Start: mktimeptr("2021-12-24 18:02:43 +0800"),
+				End:   mktimeptr("2021-12-24 18:21:53 +0800"),
+			},
+			want: "febbc4518f539a38",
+		},
+		{
+			name: "missing times",
+			workout: &healthautoexport.Workout{
+				Name: "Walking",
+			},
+			want: "4c4a90d68336bbc6",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.workout.WorkoutID())
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package healthautoexport
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// UnknownFields contains JSON fields which are not modeled by a type.
+//
+// Unknown fields are preserved as raw JSON when unmarshaling, and re-emitted
+// as-is when marshaling, so that payloads can be archived or forwarded without
+// losing any data, even if they contain fields added in newer versions of
+// Health Auto Export.
+type UnknownFields map[string]json.RawMessage
+
+// knownFieldNames returns the set of JSON field names for all fields in the
+// struct type t.
+func knownFieldNames(t reflect.Type) map[string]struct{} {
+	names := make(map[string]struct{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			// Fields of embedded structs are promoted to the outer object.
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				for embedded := range knownFieldNames(field.Type) {
+					names[embedded] = struct{}{}
+				}
+				continue
+			}
+			name = field.Name
+		}
+		names[name] = struct{}{}
+	}
+	return names
+}
+
+// unmarshalUnknownFields returns all fields in data which are not known fields
+// of the struct type t, and are not otherwise excluded.
+func unmarshalUnknownFields(api jsoniter.API, data []byte, t reflect.Type, exclude ...string) (UnknownFields, error) {
+	var fields UnknownFields
+	if err := api.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name := range knownFieldNames(t) {
+		delete(fields, name)
+	}
+	for _, name := range exclude {
+		delete(fields, name)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	for name, value := range fields {
+		// Null values are decoded as a nil json.RawMessage, which must be
+		// preserved as an explicit null to be re-emitted as valid JSON.
+		if value == nil {
+			fields[name] = json.RawMessage("null")
+		}
+	}
+	return fields, nil
+}
+
+// unmarshalWithUnknownFields unmarshals data into v, and returns all fields in
+// data which are not known fields of the struct type t.
+func unmarshalWithUnknownFields(api jsoniter.API, data []byte, v interface{}, t reflect.Type) (UnknownFields, error) {
+	if err := api.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	return unmarshalUnknownFields(api, data, t)
+}
+
+// marshalWithUnknownFields marshals v, and appends unknown fields to the
+// marshaled JSON object.
+func marshalWithUnknownFields(api jsoniter.API, v interface{}, unknown UnknownFields) ([]byte, error) {
+	object, err := api.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnknownFields(api, object, unknown)
+}
+
+// marshalUnknownFields appends unknown fields to the marshaled JSON object,
+// sorted by key. Fields which are already present in the object take
+// precedence over unknown fields with the same key.
+func marshalUnknownFields(api jsoniter.API, object []byte, unknown UnknownFields) ([]byte, error) {
+	if len(unknown) == 0 {
+		return object, nil
+	}
+
+	var known map[string]json.RawMessage
+	if err := api.Unmarshal(object, &known); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(unknown))
+	for key := range unknown {
+		if _, ok := known[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return object, nil
+	}
+	sort.Strings(keys)
+
+	// Strip the closing brace, and append each unknown field.
+	result := bytes.TrimSuffix(bytes.TrimSpace(object), []byte("}"))
+	result = append([]byte(nil), result...)
+	for i, key := range keys {
+		if i > 0 || len(known) > 0 {
+			result = append(result, ',')
+		}
+		name, err := api.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, name...)
+		result = append(result, ':')
+		result = append(result, unknown[key]...)
+	}
+	return append(result, '}'), nil
+}
@@ -0,0 +1,375 @@
+package healthautoexport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Severity is the severity of a ValidationIssue.
+type Severity string
+
+const (
+	// SeverityError indicates that the data is invalid and cannot be ingested correctly.
+	SeverityError Severity = "error"
+	// SeverityWarning indicates that the data is suspicious, but can still be ingested.
+	SeverityWarning Severity = "warning"
+)
+
+var (
+	// KnownUnits contains all units of measurement that are known to be exported by Health Auto Export.
+	// Units which are not in this list are reported as a warning during validation.
+	KnownUnits = map[Units]struct{}{
+		"%": {}, "bpm": {}, "breaths/min": {}, "cal": {}, "cm": {}, "count": {}, "count/min": {},
+		"dBASPL": {}, "dBHL": {}, "degC": {}, "degF": {}, "fl_oz_us": {}, "ft": {}, "g": {}, "hr": {},
+		"in": {}, "IU": {}, "kcal": {}, "kcal/hr·kg": {}, "kg": {}, "kJ": {}, "km": {}, "km/hr": {},
+		"L": {}, "lb": {}, "lux": {}, "m": {}, "m/s": {}, "mcg": {}, "mg": {}, "mg/dL": {}, "mi": {},
+		"mi/hr": {}, "min": {}, "mL": {}, "ml/(kg·min)": {}, "mmHg": {}, "mmol/L": {}, "ms": {},
+		"mV": {}, "s": {}, "spm": {}, "steps": {}, "uV": {}, "µV": {}, "W": {}, "yd": {},
+	}
+
+	// timeKeys contains all JSON object keys whose values are timestamps.
+	timeKeys = map[string]struct{}{
+		"date": {}, "start": {}, "end": {}, "startDate": {}, "endDate": {}, "timestamp": {},
+		"sleepStart": {}, "sleepEnd": {}, "inBedStart": {}, "inBedEnd": {}, "scheduledDate": {},
+	}
+
+	// qtyKeys contains all JSON object keys whose values must be numbers.
+	qtyKeys = map[string]struct{}{
+		"qty": {}, "lat": {}, "lon": {}, "altitude": {}, "ascent": {}, "descent": {}, "voltage": {},
+	}
+)
+
+// ValidationIssue is a single problem found in a payload.
+type ValidationIssue struct {
+	// Path is the JSON path to the offending value (e.g. data.metrics[3].data[120].date).
+	Path     string   `json:"path"`
+	Severity Severity `json:"severity"`
+	Reason   string   `json:"reason"`
+}
+
+func (v *ValidationIssue) String() string {
+	if v.Path == "" {
+		return fmt.Sprintf("%v: %v", v.Severity, v.Reason)
+	}
+	return fmt.Sprintf("%v: %v: %v", v.Severity, v.Path, v.Reason)
+}
+
+// ValidationIssues is a list of problems found in a payload.
+type ValidationIssues []*ValidationIssue
+
+// HasErrors returns true if any issue has SeverityError.
+func (v ValidationIssues) HasErrors() bool {
+	for _, issue := range v {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (v ValidationIssues) Error() string {
+	strs := make([]string, 0, len(v))
+	for _, issue := range v {
+		strs = append(strs, issue.String())
+	}
+	return strings.Join(strs, "; ")
+}
+
+// validator accumulates ValidationIssues.
+type validator struct {
+	issues ValidationIssues
+	parser *TimeParser
+}
+
+func (v *validator) addf(path string, severity Severity, format string, args ...interface{}) {
+	v.issues = append(v.issues, &ValidationIssue{
+		Path:     path,
+		Severity: severity,
+		Reason:   fmt.Sprintf(format, args...),
+	})
+}
+
+// ValidateWithFormat reads the payload in the given format and validates it,
+// returning all issues found. The decoded payload is also returned if it could
+// be decoded.
+func ValidateWithFormat(r io.Reader, format Format) (*Payload, ValidationIssues) {
+	return ValidateWithOptions(r, format, DecoderOptions{})
+}
+
+// ValidateWithOptions reads the payload in the given format with the given
+// options and validates it. See ValidateWithFormat.
+func ValidateWithOptions(r io.Reader, format Format, opts DecoderOptions) (*Payload, ValidationIssues) {
+	if format == FormatJSON || format == "" {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, ValidationIssues{{Severity: SeverityError, Reason: err.Error()}}
+		}
+		return validateJSON(data, opts)
+	}
+
+	payload, err := UnmarshalWithOptions(r, format, opts)
+	if err != nil {
+		return nil, ValidationIssues{{Severity: SeverityError, Reason: err.Error()}}
+	}
+	return payload, Validate(payload)
+}
+
+// ValidateJSON validates the raw JSON payload. Unlike Unmarshal, all
+// unparseable values are reported together with their location. The decoded
+// payload is returned if the data could be successfully unmarshaled.
+func ValidateJSON(data []byte) (*Payload, ValidationIssues) {
+	return validateJSON(data, DecoderOptions{})
+}
+
+func validateJSON(data []byte, opts DecoderOptions) (*Payload, ValidationIssues) {
+	v := &validator{parser: NewTimeParser(opts.TimeLayouts...)}
+
+	// First pass: Walk the raw JSON to find values that cannot be unmarshaled.
+	var raw interface{}
+	if err := jsoniter.Unmarshal(data, &raw); err != nil {
+		v.addf("", SeverityError, "invalid json: %v", err)
+		return nil, v.issues
+	}
+	v.validateRaw("", "", raw)
+	if v.issues.HasErrors() {
+		return nil, v.issues
+	}
+
+	// Second pass: Validate the unmarshaled payload.
+	payload, err := UnmarshalWithOptions(bytes.NewReader(data), FormatJSON, opts)
+	if err != nil {
+		v.addf("", SeverityError, "unmarshal error: %v", err)
+		return nil, v.issues
+	}
+	v.validatePayload(payload)
+	return payload, v.issues
+}
+
+// Validate walks the payload and returns all issues found.
+func Validate(payload *Payload) ValidationIssues {
+	v := &validator{}
+	v.validatePayload(payload)
+	return v.issues
+}
+
+func (v *validator) validateRaw(path, key string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v.validateRaw(joinPath(path, k), k, value[k])
+		}
+	case []interface{}:
+		for i, item := range value {
+			v.validateRaw(fmt.Sprintf("%v[%v]", path, i), "", item)
+		}
+	case string:
+		if _, ok := timeKeys[key]; ok {
+			if _, err := v.parser.Parse(value); err != nil {
+				v.addf(path, SeverityError, "unparseable time %q", value)
+			}
+		}
+		if _, ok := qtyKeys[key]; ok {
+			v.addf(path, SeverityError, "expected number but got string %q", value)
+		}
+	}
+}
+
+func (v *validator) validatePayload(payload *Payload) {
+	if payload == nil || payload.Data == nil {
+		v.addf("data", SeverityWarning, "payload has no data")
+		return
+	}
+	data := payload.Data
+
+	for i, metric := range data.Metrics {
+		v.validateMetric(fmt.Sprintf("data.metrics[%v]", i), metric)
+	}
+	for i, workout := range data.Workouts {
+		v.validateWorkout(fmt.Sprintf("data.workouts[%v]", i), workout)
+	}
+	for i, symptom := range data.Symptoms {
+		v.validateInterval(fmt.Sprintf("data.symptoms[%v]", i), "start", "end", symptom.Start, symptom.End)
+	}
+	for i, medication := range data.Medications {
+		path := fmt.Sprintf("data.medications[%v]", i)
+		v.validateInterval(path, "start", "end", medication.Start, medication.End)
+		v.validateQty(path+".dosage", medication.Dosage)
+	}
+	for i, ecg := range data.ECG {
+		path := fmt.Sprintf("data.ecg[%v]", i)
+		v.validateInterval(path, "start", "end", ecg.Start, ecg.End)
+		for j, datum := range ecg.VoltageMeasurements {
+			path := fmt.Sprintf("%v.voltageMeasurements[%v]", path, j)
+			v.validateDate(path+".date", datum.Date)
+			v.validateQty(path+".voltage", datum.Voltage)
+			v.validateUnits(path+".units", datum.Units)
+		}
+	}
+	for i, notification := range data.HeartRateNotifications {
+		path := fmt.Sprintf("data.heartRateNotifications[%v]", i)
+		v.validateInterval(path, "start", "end", notification.Start, notification.End)
+	}
+	for i, stateOfMind := range data.StateOfMind {
+		path := fmt.Sprintf("data.stateOfMind[%v]", i)
+		v.validateInterval(path, "start", "end", stateOfMind.Start, stateOfMind.End)
+		v.validateQty(path+".valence", stateOfMind.Valence)
+	}
+	for i, cycle := range data.CycleTracking {
+		v.validateInterval(fmt.Sprintf("data.cycleTracking[%v]", i), "start", "end", cycle.Start, cycle.End)
+	}
+}
+
+func (v *validator) validateMetric(path string, metric *Metric) {
+	if metric == nil {
+		v.addf(path, SeverityError, "metric is null")
+		return
+	}
+	if metric.Name == "" {
+		v.addf(path+".name", SeverityError, "missing metric name")
+	}
+	v.validateUnits(path+".units", metric.Units)
+
+	// Sleep analysis data that cannot be unmarshaled into either shape will fall back to Datapoints.
+	if metric.Name == SleepAnalysisName && len(metric.Datapoints) > 0 {
+		v.addf(path+".data", SeverityError,
+			"sleep analysis data does not match either the aggregated or non-aggregated format")
+	}
+
+	for i, datum := range metric.Datapoints {
+		path := fmt.Sprintf("%v.data[%v]", path, i)
+		if datum == nil {
+			v.addf(path, SeverityError, "datapoint is null")
+			continue
+		}
+		v.validateDate(path+".date", datum.Date)
+		v.validateQty(path+".qty", datum.Qty)
+		keys := make([]string, 0, len(datum.Fields))
+		for k := range datum.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if f, ok := datum.Fields[k].(float64); ok {
+				v.validateQty(joinPath(path, k), Qty(f))
+			}
+		}
+	}
+	for i, s := range metric.SleepAnalyses {
+		path := fmt.Sprintf("%v.data[%v]", path, i)
+		v.validateInterval(path, "startDate", "endDate", s.StartDate, s.EndDate)
+		v.validateQty(path+".qty", s.Qty)
+	}
+	for i, a := range metric.AggregatedSleepAnalyses {
+		path := fmt.Sprintf("%v.data[%v]", path, i)
+		v.validateInterval(path, "sleepStart", "sleepEnd", a.SleepStart, a.SleepEnd)
+		if !a.InBedStart.IsZero() || !a.InBedEnd.IsZero() {
+			v.validateInterval(path, "inBedStart", "inBedEnd", a.InBedStart, a.InBedEnd)
+		}
+		for _, field := range []struct {
+			Key string
+			Qty Qty
+		}{
+			{Key: "inBed", Qty: a.InBed},
+			{Key: "asleep", Qty: a.Asleep},
+			{Key: "awake", Qty: a.Awake},
+			{Key: "core", Qty: a.Core},
+			{Key: "deep", Qty: a.Deep},
+			{Key: "rem", Qty: a.REM},
+		} {
+			v.validateQty(joinPath(path, field.Key), field.Qty)
+			if field.Qty < 0 {
+				v.addf(joinPath(path, field.Key), SeverityError, "negative duration %v", field.Qty)
+			}
+		}
+	}
+}
+
+func (v *validator) validateWorkout(path string, workout *Workout) {
+	if workout == nil {
+		v.addf(path, SeverityError, "workout is null")
+		return
+	}
+	if workout.Name == "" {
+		v.addf(path+".name", SeverityWarning, "missing workout name")
+	}
+	v.validateInterval(path, "start", "end", workout.Start, workout.End)
+	for i, datum := range workout.Route {
+		path := fmt.Sprintf("%v.route[%v]", path, i)
+		v.validateDate(path+".timestamp", datum.Timestamp)
+		if math.IsNaN(datum.Lat) || math.IsNaN(datum.Lon) || math.IsNaN(datum.Altitude) {
+			v.addf(path, SeverityError, "coordinates are not finite numbers")
+		}
+	}
+	for _, series := range []struct {
+		Key  string
+		Data []*DatapointWithUnit
+	}{
+		{Key: "heartRateData", Data: workout.HeartRateData},
+		{Key: "heartRateRecovery", Data: workout.HeartRateRecovery},
+	} {
+		for i, datum := range series.Data {
+			path := fmt.Sprintf("%v.%v[%v]", path, series.Key, i)
+			v.validateDate(path+".date", datum.Date)
+			v.validateQty(path+".qty", datum.Qty)
+			v.validateUnits(path+".units", datum.Units)
+		}
+	}
+	if workout.Elevation != nil {
+		v.validateQty(path+".elevation.ascent", workout.Elevation.Ascent)
+		v.validateQty(path+".elevation.descent", workout.Elevation.Descent)
+		v.validateUnits(path+".elevation.units", workout.Elevation.Units)
+	}
+	for _, field := range workout.Fields {
+		v.validateQty(joinPath(path, field.Key)+".qty", field.Value.Qty)
+		v.validateUnits(joinPath(path, field.Key)+".units", field.Value.Units)
+	}
+}
+
+func (v *validator) validateDate(path string, t *Time) {
+	if t.IsZero() {
+		v.addf(path, SeverityError, "missing date")
+	}
+}
+
+func (v *validator) validateInterval(path, startKey, endKey string, start, end *Time) {
+	v.validateDate(joinPath(path, startKey), start)
+	if start.IsZero() || end.IsZero() {
+		return
+	}
+	if end.Before(start.Time) {
+		v.addf(joinPath(path, endKey), SeverityError, "negative duration, %v is before %v", end, start)
+	}
+}
+
+func (v *validator) validateQty(path string, qty Qty) {
+	if math.IsNaN(float64(qty)) || math.IsInf(float64(qty), 0) {
+		v.addf(path, SeverityError, "quantity is not a finite number")
+	}
+}
+
+func (v *validator) validateUnits(path string, units Units) {
+	if units == "" {
+		return
+	}
+	if _, ok := KnownUnits[units]; !ok {
+		v.addf(path, SeverityWarning, "unknown units %q", units)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
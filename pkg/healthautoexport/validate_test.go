@@ -0,0 +1,147 @@
+package healthautoexport_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport/fixtures"
+)
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		want        healthautoexport.ValidationIssues
+		wantPayload bool
+	}{
+		{
+			name:  "invalid json",
+			input: `{"data": {}`,
+			want: healthautoexport.ValidationIssues{
+				{Severity: healthautoexport.SeverityError, Reason: "invalid json: "},
+			},
+		},
+		{
+			name:        "valid payload",
+			input:       `{"data":{"metrics":[{"name":"active_energy","units":"kJ","data":[{"qty":0.77,"date":"2021-12-24 00:04:00 +0800"}]}]}}`,
+			wantPayload: true,
+		},
+		{
+			name: "unparseable times and string quantities",
+			input: `{"data":{"metrics":[{"name":"active_energy","units":"kJ","data":[
+				{"qty":0.77,"date":"2021-12-24 00:04:00 +0800"},
+				{"qty":"abc","date":"yesterday"}
+			]}]}}`,
+			want: healthautoexport.ValidationIssues{
+				{Path: "data.metrics[0].data[1].date", Severity: healthautoexport.SeverityError, Reason: `unparseable time "yesterday"`},
+				{Path: "data.metrics[0].data[1].qty", Severity: healthautoexport.SeverityError, Reason: `expected number but got string "abc"`},
+			},
+		},
+		{
+			name:  "missing dates and unknown units",
+			input: `{"data":{"metrics":[{"name":"active_energy","units":"furlongs","data":[{"qty":1,"date":null}]}]}}`,
+			want: healthautoexport.ValidationIssues{
+				{Path: "data.metrics[0].units", Severity: healthautoexport.SeverityWarning, Reason: `unknown units "furlongs"`},
+				{Path: "data.metrics[0].data[0].date", Severity: healthautoexport.SeverityError, Reason: "missing date"},
+			},
+			wantPayload: true,
+		},
+		{
+			name:  "sleep analysis in unknown format",
+			input: `{"data":{"metrics":[{"name":"sleep_analysis","units":"hr","data":[{"qty":1,"date":"2021-12-24 00:04:00 +0800"}]}]}}`,
+			want: healthautoexport.ValidationIssues{
+				{Path: "data.metrics[0].data", Severity: healthautoexport.SeverityError, Reason: "sleep analysis data does not match either the aggregated or non-aggregated format"},
+			},
+			wantPayload: true,
+		},
+		{
+			name:  "workout with negative duration and no start",
+			input: `{"data":{"workouts":[{"name":"Walking","start":"2021-12-24 08:21:53 +0800","end":"2021-12-24 08:02:43 +0800"},{"name":"Running"}]}}`,
+			want: healthautoexport.ValidationIssues{
+				{Path: "data.workouts[0].end", Severity: healthautoexport.SeverityError, Reason: "negative duration, 2021-12-24T08:02:43+08:00 is before 2021-12-24T08:21:53+08:00"},
+				{Path: "data.workouts[1].start", Severity: healthautoexport.SeverityError, Reason: "missing date"},
+			},
+			wantPayload: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			payload, issues := healthautoexport.ValidateJSON([]byte(tt.input))
+			assert.Equal(t, tt.wantPayload, payload != nil, "unexpected payload: %v", payload)
+			if !assert.Equal(t, len(tt.want), len(issues), "issues: %v", issues) {
+				return
+			}
+			for i, want := range tt.want {
+				assert.Equal(t, want.Path, issues[i].Path)
+				assert.Equal(t, want.Severity, issues[i].Severity)
+				assert.Contains(t, issues[i].Reason, want.Reason)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload *healthautoexport.Payload
+		want    healthautoexport.ValidationIssues
+	}{
+		{
+			name:    "nil data",
+			payload: &healthautoexport.Payload{},
+			want: healthautoexport.ValidationIssues{
+				{Path: "data", Severity: healthautoexport.SeverityWarning, Reason: "payload has no data"},
+			},
+		},
+		{
+			name:    "valid metrics",
+			payload: fixtures.PayloadWithMetrics,
+		},
+		{
+			name:    "valid workouts",
+			payload: fixtures.PayloadWithWorkouts,
+		},
+		{
+			name:    "valid health events",
+			payload: fixtures.PayloadWithHealthEvents,
+		},
+		{
+			name: "NaN quantity",
+			payload: &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{
+					Metrics: []*healthautoexport.Metric{
+						{
+							Name:  "heart_rate",
+							Units: "count/min",
+							Datapoints: []*healthautoexport.Datapoint{
+								{
+									Date:   mktimeptr("2021-12-24 00:04:00 +0800"),
+									Qty:    healthautoexport.Qty(math.NaN()),
+									Fields: healthautoexport.DatapointFields{"Max": math.Inf(1)},
+								},
+							},
+						},
+					},
+				},
+			},
+			want: healthautoexport.ValidationIssues{
+				{Path: "data.metrics[0].data[0].qty", Severity: healthautoexport.SeverityError, Reason: "quantity is not a finite number"},
+				{Path: "data.metrics[0].data[0].Max", Severity: healthautoexport.SeverityError, Reason: "quantity is not a finite number"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			issues := healthautoexport.Validate(tt.payload)
+			assert.Equal(t, len(tt.want), len(issues), "issues: %v", issues)
+			for i := 0; i < len(tt.want) && i < len(issues); i++ {
+				assert.Equal(t, tt.want[i], issues[i])
+			}
+		})
+	}
+}
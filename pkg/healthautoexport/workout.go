@@ -0,0 +1,35 @@
+package healthautoexport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// workoutIDLength is the length of workout IDs derived from a hash.
+	workoutIDLength = 16
+)
+
+// WorkoutID returns a stable identifier for the workout, which can be used to
+// distinguish between multiple workouts with the same name.
+//
+// The ID exported by Health Auto Export is used if present. Otherwise, the ID
+// is derived from a hash of the name, start and end time of the workout, so
+// that the same workout always has the same ID when exported again, regardless
+// of the time zone of the export.
+func (w *Workout) WorkoutID() string {
+	if w.ID != "" {
+		return w.ID
+	}
+
+	h := sha256.New()
+	h.Write([]byte(w.Name))
+	for _, t := range []*Time{w.Start, w.End} {
+		h.Write([]byte{0})
+		if !t.IsZero() {
+			h.Write([]byte(t.UTC().Format(time.RFC3339Nano)))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:workoutIDLength]
+}
@@ -2,6 +2,7 @@ package ingester
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"runtime/debug"
@@ -16,8 +17,21 @@ import (
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 )
 
+// DefaultWaitRetries is the default value of Ingester.WaitRetries, which
+// retries for about 30 seconds with the default backoff.
+const DefaultWaitRetries = 15
+
 // Ingester is a generic ingester for Health Auto Export data.
 type Ingester struct {
+	// DecoderOptions are used to unmarshal ingested payloads.
+	DecoderOptions healthautoexport.DecoderOptions
+
+	// WaitRetries is the maximum number of times that a payload ingested with
+	// IngestPayloadAndWait is retried after a retryable error, after which the
+	// error is returned. If negative, the payload is retried until the context
+	// is cancelled.
+	WaitRetries int
+
 	backends    map[string]*backends.BackendQueue
 	started     bool
 	backendsMtx sync.RWMutex
@@ -26,8 +40,9 @@ type Ingester struct {
 
 func NewIngester() *Ingester {
 	return &Ingester{
-		backends: make(map[string]*backends.BackendQueue),
-		quit:     &sync.WaitGroup{},
+		WaitRetries: DefaultWaitRetries,
+		backends:    make(map[string]*backends.BackendQueue),
+		quit:        &sync.WaitGroup{},
 	}
 }
 
@@ -62,7 +77,8 @@ func (i *Ingester) Start() {
 }
 
 // Shutdown begins graceful quit of the ingester, and blocks until all
-// background ingestion work has been successfully completed.
+// background ingestion work has been successfully completed. Backends which
+// implement io.Closer are closed afterwards.
 func (i *Ingester) Shutdown() {
 	i.backendsMtx.Lock()
 	defer i.backendsMtx.Unlock()
@@ -82,11 +98,44 @@ func (i *Ingester) Shutdown() {
 
 	// Block until all queues have terminated.
 	i.quit.Wait()
+
+	// Release resources held by backends, such as background goroutines.
+	for _, backend := range i.backends {
+		closer, ok := backend.Backend.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			log.WithError(err).WithField("backend", backend.Name()).Error("could not close backend")
+		}
+	}
 }
 
-// Ingest ingests the payload from io.Reader into the named backend.
+// Ingest ingests the JSON payload from io.Reader into the named backend.
 // All processing is done asynchronously.
 func (i *Ingester) Ingest(r io.Reader, name string, target string) error {
+	return i.IngestWithFormat(r, healthautoexport.FormatJSON, name, target)
+}
+
+// IngestWithFormat ingests the payload from io.Reader in the given format into
+// the named backend. All processing is done asynchronously.
+func (i *Ingester) IngestWithFormat(r io.Reader, format healthautoexport.Format, name string, target string) error {
+	// Ensure that the backend exists before unmarshaling.
+	if err := i.checkBackend(name); err != nil {
+		return err
+	}
+
+	payload, err := healthautoexport.UnmarshalWithOptions(r, format, i.DecoderOptions)
+	if err != nil {
+		return errors.Wrapf(err, "unmarshal error")
+	}
+
+	return i.IngestPayload(payload, name, target)
+}
+
+// IngestPayload ingests an already unmarshaled payload into the named backend.
+// All processing is done asynchronously.
+func (i *Ingester) IngestPayload(payload *healthautoexport.Payload, name string, target string) error {
 	if !i.started {
 		return errors.New("ingester is not yet started")
 	}
@@ -99,11 +148,6 @@ func (i *Ingester) Ingest(r io.Reader, name string, target string) error {
 		return fmt.Errorf("invalid backend %v", name)
 	}
 
-	payload, err := healthautoexport.Unmarshal(r)
-	if err != nil {
-		return errors.Wrapf(err, "unmarshal error")
-	}
-
 	// Augment with target name.
 	payloadWithTarget := &PayloadWithTarget{
 		Payload:    payload,
@@ -114,6 +158,62 @@ func (i *Ingester) Ingest(r io.Reader, name string, target string) error {
 	return nil
 }
 
+// IngestPayloadAndWait ingests an already unmarshaled payload into the named
+// backend, and blocks until it has been written. Writes that fail with a
+// retryable error are retried up to WaitRetries times, after which the error is
+// returned. Returns the error of the context if it is cancelled before the
+// write completes, in which case the payload is no longer retried.
+func (i *Ingester) IngestPayloadAndWait(
+	ctx context.Context, payload *healthautoexport.Payload, name string, target string,
+) error {
+	if !i.started {
+		return errors.New("ingester is not yet started")
+	}
+
+	i.backendsMtx.RLock()
+	backend, ok := i.backends[name]
+	if !ok {
+		i.backendsMtx.RUnlock()
+		return fmt.Errorf("invalid backend %v", name)
+	}
+	// Items added to a queue which is shutting down are dropped, and would
+	// never be written.
+	if backend.Queue.ShuttingDown() {
+		i.backendsMtx.RUnlock()
+		return fmt.Errorf("backend %v is shutting down", name)
+	}
+	done := make(chan error, 1)
+	backend.Queue.Add(&PayloadWithTarget{
+		Payload:    payload,
+		TargetName: target,
+		done:       done,
+		ctx:        ctx,
+	})
+	i.backendsMtx.RUnlock()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkBackend returns an error if the ingester is not started, or the named backend does not exist.
+func (i *Ingester) checkBackend(name string) error {
+	if !i.started {
+		return errors.New("ingester is not yet started")
+	}
+
+	i.backendsMtx.RLock()
+	defer i.backendsMtx.RUnlock()
+
+	if _, ok := i.backends[name]; !ok {
+		return fmt.Errorf("invalid backend %v", name)
+	}
+	return nil
+}
+
 // IngestFromString ingests the payload from a string into the named backend.
 // All processing is done asynchronously.
 func (i *Ingester) IngestFromString(s string, name, target string) error {
@@ -139,20 +239,52 @@ func (i *Ingester) processQueue(backend *backends.BackendQueue) {
 		logger = logger.WithField("elapsed", time.Since(startTime))
 
 		if err != nil {
-			if apierrors.IsRetryableWrite(err) {
+			retry := apierrors.IsRetryableWrite(err) && i.canRetry(item, backend)
+			if retry {
 				backend.Queue.AddRateLimited(item)
 				logger = logger.WithField("retries", backend.Queue.NumRequeues(item))
 			}
 
 			logger.WithError(err).Error("write data error")
+			if !retry {
+				backend.Queue.Forget(item)
+				notifyDone(item, err)
+			}
 		} else {
 			logger.Info("write data success")
+			backend.Queue.Forget(item)
+			notifyDone(item, nil)
 		}
 
 		backend.Queue.Done(item)
 	}
 }
 
+// canRetry returns true if the item can be retried after a retryable error.
+// Items are not retried once the queue is shutting down, since they would be
+// dropped. Items which are waited on are also not retried once the context of
+// the waiter is cancelled, or after WaitRetries retries.
+func (i *Ingester) canRetry(item interface{}, backend *backends.BackendQueue) bool {
+	if backend.Queue.ShuttingDown() {
+		return false
+	}
+	payload, ok := item.(*PayloadWithTarget)
+	if !ok || payload.done == nil {
+		return true
+	}
+	if payload.ctx.Err() != nil {
+		return false
+	}
+	return i.WaitRetries < 0 || backend.Queue.NumRequeues(item) < i.WaitRetries
+}
+
+// notifyDone sends the result of a write to the item if it is being waited on.
+func notifyDone(item interface{}, err error) {
+	if payload, ok := item.(*PayloadWithTarget); ok && payload.done != nil {
+		payload.done <- err
+	}
+}
+
 func (i *Ingester) processWriteItem(item interface{}, backend backends.Backend) (err error) {
 	payload, ok := item.(*PayloadWithTarget)
 	if !ok {
@@ -170,6 +302,11 @@ func (i *Ingester) processWriteItem(item interface{}, backend backends.Backend)
 		}
 	}()
 
+	// Skip payloads which are no longer waited on.
+	if payload.ctx != nil && payload.ctx.Err() != nil {
+		return errors.Wrapf(payload.ctx.Err(), "cannot write payload")
+	}
+
 	if err := backend.Write(payload.Payload, payload.TargetName); err != nil {
 		return errors.Wrapf(err, "cannot write payload to database")
 	}
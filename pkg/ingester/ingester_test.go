@@ -8,6 +8,8 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/irvinlim/apple-health-ingester/pkg/backends/noop"
+	apierrors "github.com/irvinlim/apple-health-ingester/pkg/errors"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
 )
 
@@ -80,6 +82,7 @@ tickerLoop:
 	}
 	ingest.Shutdown()
 	assert.Equal(t, expectedWrites, len(backend.Writes))
+	assert.True(t, backend.Closed)
 }
 
 func TestIngester_BackendPanic(t *testing.T) {
@@ -103,3 +106,47 @@ func TestIngester_BackendPanic(t *testing.T) {
 	expectedWrites++
 	assert.Equal(t, expectedWrites, len(backend.Writes))
 }
+
+func TestIngester_IngestPayloadAndWait(t *testing.T) {
+	ingest := ingester.NewIngester()
+	ingest.WaitRetries = 2
+	backend := noop.NewBackend()
+	assert.NoError(t, ingest.AddBackend(backend))
+	ctx := context.Background()
+
+	// Cannot ingest before start
+	assert.Error(t, ingest.IngestPayloadAndWait(ctx, &healthautoexport.Payload{}, backend.Name(), ""))
+	ingest.Start()
+
+	// No named backend
+	assert.Error(t, ingest.IngestPayloadAndWait(ctx, &healthautoexport.Payload{}, "invalid", ""))
+
+	// Write is completed once returned
+	assert.NoError(t, ingest.IngestPayloadAndWait(ctx, &healthautoexport.Payload{}, backend.Name(), ""))
+	assert.Equal(t, 1, len(backend.Writes))
+
+	// Panics are not retried and are returned
+	backend.ShouldPanic = true
+	assert.Error(t, ingest.IngestPayloadAndWait(ctx, &healthautoexport.Payload{}, backend.Name(), ""))
+	assert.Equal(t, 1, len(backend.Writes))
+	backend.ShouldPanic = false
+
+	// Retryable errors are returned after WaitRetries retries
+	backend.ShouldError = true
+	err := ingest.IngestPayloadAndWait(ctx, &healthautoexport.Payload{}, backend.Name(), "")
+	assert.True(t, apierrors.IsRetryableWrite(err))
+
+	// Retries are stopped once the context is cancelled
+	ingest.WaitRetries = -1
+	cancelCtx, cancel := context.WithTimeout(ctx, processingDelay)
+	defer cancel()
+	err = ingest.IngestPayloadAndWait(cancelCtx, &healthautoexport.Payload{}, backend.Name(), "")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	backend.ShouldError = false
+	time.Sleep(processingDelay)
+	assert.Equal(t, 1, len(backend.Writes))
+
+	// Cannot ingest once shutting down
+	ingest.Shutdown()
+	assert.Error(t, ingest.IngestPayloadAndWait(ctx, &healthautoexport.Payload{}, backend.Name(), ""))
+}
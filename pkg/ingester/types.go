@@ -1,6 +1,8 @@
 package ingester
 
 import (
+	"context"
+
 	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
 )
 
@@ -10,4 +12,12 @@ import (
 type PayloadWithTarget struct {
 	*healthautoexport.Payload
 	TargetName string
+
+	// done receives the result of the write if non-nil, once the payload has
+	// been written or failed with an error that will not be retried.
+	done chan error
+
+	// ctx is the context of the caller waiting on done. The payload is no
+	// longer written or retried once it is cancelled.
+	ctx context.Context
 }
@@ -0,0 +1,127 @@
+// Package migrate copies data that was stored by one backend into another.
+package migrate
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
+)
+
+var (
+	chunkSize int
+)
+
+// Options configures a Migrator.
+type Options struct {
+	// ChunkSize is the maximum number of datapoints of a metric in each
+	// payload written to the destination. Payloads are not split if zero.
+	ChunkSize int
+}
+
+// Stats summarizes a migration.
+type Stats struct {
+	// Read is the number of payloads read from the source.
+	Read int
+	// Written is the number of payloads written to the destination, after
+	// splitting payloads into chunks.
+	Written int
+}
+
+// Migrator reads all data from a source backend, and ingests it into a
+// destination backend of an Ingester.
+type Migrator struct {
+	ingester    *ingester.Ingester
+	destination string
+	opts        Options
+}
+
+// NewMigrator returns a Migrator into the named backend of the ingester, which
+// is configured by command-line flags.
+func NewMigrator(ingester *ingester.Ingester, destination string) *Migrator {
+	return NewMigratorWithOptions(ingester, destination, Options{ChunkSize: chunkSize})
+}
+
+// NewMigratorWithOptions returns a Migrator with the given options.
+func NewMigratorWithOptions(ingester *ingester.Ingester, destination string, opts Options) *Migrator {
+	return &Migrator{ingester: ingester, destination: destination, opts: opts}
+}
+
+// Migrate streams all data from the source into the destination, waiting for
+// each payload to be written before reading the next one. Migration is stopped
+// if a payload cannot be written or the context is cancelled.
+func (m *Migrator) Migrate(ctx context.Context, source backends.Reader) (*Stats, error) {
+	stats := &Stats{}
+	err := source.Read(func(payload *healthautoexport.Payload, targetName string) error {
+		stats.Read++
+		for _, chunk := range splitPayload(payload, m.opts.ChunkSize) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := m.ingester.IngestPayloadAndWait(ctx, chunk, m.destination, targetName); err != nil {
+				return errors.Wrapf(err, "cannot write to %v", m.destination)
+			}
+			stats.Written++
+		}
+		log.WithFields(log.Fields{
+			"target":  targetName,
+			"read":    stats.Read,
+			"written": stats.Written,
+		}).Info("migrated payload")
+		return nil
+	})
+	return stats, err
+}
+
+// splitPayload splits the datapoints of each metric in the payload into chunks
+// of at most size datapoints. Other data is kept in the first chunk.
+func splitPayload(payload *healthautoexport.Payload, size int) []*healthautoexport.Payload {
+	if size <= 0 || payload.Data == nil {
+		return []*healthautoexport.Payload{payload}
+	}
+
+	first := *payload.Data
+	first.Metrics = nil
+	var chunks []*healthautoexport.Payload
+	for _, metric := range payload.Data.Metrics {
+		if len(metric.Datapoints) <= size {
+			first.Metrics = append(first.Metrics, metric)
+			continue
+		}
+		for start := 0; start < len(metric.Datapoints); start += size {
+			end := start + size
+			if end > len(metric.Datapoints) {
+				end = len(metric.Datapoints)
+			}
+			m := *metric
+			m.Datapoints = metric.Datapoints[start:end]
+			if start > 0 {
+				m.SleepAnalyses = nil
+				m.AggregatedSleepAnalyses = nil
+			}
+			chunks = append(chunks, &healthautoexport.Payload{
+				Data: &healthautoexport.PayloadData{Metrics: []*healthautoexport.Metric{&m}},
+			})
+		}
+	}
+
+	if len(chunks) == 0 {
+		return []*healthautoexport.Payload{payload}
+	}
+	if len(first.Metrics) > 0 || len(first.Workouts) > 0 || len(first.Symptoms) > 0 || len(first.Medications) > 0 ||
+		len(first.ECG) > 0 || len(first.HeartRateNotifications) > 0 || len(first.StateOfMind) > 0 ||
+		len(first.CycleTracking) > 0 {
+		chunks = append([]*healthautoexport.Payload{{Data: &first, Unknown: payload.Unknown}}, chunks...)
+	}
+	return chunks
+}
+
+func init() {
+	pflag.IntVar(&chunkSize, "migrate.chunkSize", 10000,
+		"Maximum number of datapoints of a metric in each payload written during migration. Use 0 to not split payloads.")
+}
@@ -0,0 +1,137 @@
+package migrate_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/noop"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
+	"github.com/irvinlim/apple-health-ingester/pkg/migrate"
+)
+
+// reader is a backends.Reader of a fixed list of payloads.
+type reader []*healthautoexport.Payload
+
+func (r reader) Read(fn func(payload *healthautoexport.Payload, targetName string) error) error {
+	for _, payload := range r {
+		if err := fn(payload, "test"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func makePayload(datapoints int, workouts int) *healthautoexport.Payload {
+	metric := &healthautoexport.Metric{Name: "heart_rate", Units: "count/min"}
+	for i := 0; i < datapoints; i++ {
+		date := healthautoexport.NewTime(time.Unix(int64(i), 0))
+		metric.Datapoints = append(metric.Datapoints, &healthautoexport.Datapoint{Date: &date, Qty: 60})
+	}
+	payload := &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{Metrics: []*healthautoexport.Metric{metric}},
+	}
+	for i := 0; i < workouts; i++ {
+		payload.Data.Workouts = append(payload.Data.Workouts, &healthautoexport.Workout{Name: "Walking"})
+	}
+	return payload
+}
+
+func TestMigrator_Migrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		chunkSize   int
+		payloads    []*healthautoexport.Payload
+		wantStats   *migrate.Stats
+		wantLengths []int
+	}{
+		{
+			name:        "no chunking",
+			payloads:    []*healthautoexport.Payload{makePayload(5, 1), makePayload(2, 0)},
+			wantStats:   &migrate.Stats{Read: 2, Written: 2},
+			wantLengths: []int{5, 2},
+		},
+		{
+			name:        "split into chunks",
+			chunkSize:   2,
+			payloads:    []*healthautoexport.Payload{makePayload(5, 0), makePayload(2, 0)},
+			wantStats:   &migrate.Stats{Read: 2, Written: 4},
+			wantLengths: []int{2, 2, 1, 2},
+		},
+		{
+			name:        "other data in first chunk",
+			chunkSize:   3,
+			payloads:    []*healthautoexport.Payload{makePayload(5, 1)},
+			wantStats:   &migrate.Stats{Read: 1, Written: 3},
+			wantLengths: []int{0, 3, 2},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ingest := ingester.NewIngester()
+			backend := noop.NewBackend()
+			require.NoError(t, ingest.AddBackend(backend))
+			ingest.Start()
+			defer ingest.Shutdown()
+
+			migrator := migrate.NewMigratorWithOptions(ingest, backend.Name(), migrate.Options{ChunkSize: tt.chunkSize})
+			stats, err := migrator.Migrate(context.Background(), reader(tt.payloads))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStats, stats)
+
+			var lengths []int
+			var workouts int
+			for _, write := range backend.Writes {
+				var datapoints int
+				for _, metric := range write.Data.Metrics {
+					datapoints += len(metric.Datapoints)
+				}
+				lengths = append(lengths, datapoints)
+				workouts += len(write.Data.Workouts)
+			}
+			assert.Equal(t, tt.wantLengths, lengths)
+			for _, payload := range tt.payloads {
+				workouts -= len(payload.Data.Workouts)
+			}
+			assert.Zero(t, workouts)
+		})
+	}
+}
+
+func TestMigrator_Cancel(t *testing.T) {
+	ingest := ingester.NewIngester()
+	backend := noop.NewBackend()
+	require.NoError(t, ingest.AddBackend(backend))
+	ingest.Start()
+	defer ingest.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	migrator := migrate.NewMigratorWithOptions(ingest, backend.Name(), migrate.Options{})
+	_, err := migrator.Migrate(ctx, reader{makePayload(1, 0)})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, backend.Writes)
+}
+
+func TestMigrator_CancelWhileRetrying(t *testing.T) {
+	ingest := ingester.NewIngester()
+	ingest.WaitRetries = -1
+	backend := noop.NewBackend()
+	backend.ShouldError = true
+	require.NoError(t, ingest.AddBackend(backend))
+	ingest.Start()
+	defer ingest.Shutdown()
+
+	// Writes which are retried indefinitely are stopped once cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	migrator := migrate.NewMigratorWithOptions(ingest, backend.Name(), migrate.Options{})
+	stats, err := migrator.Migrate(ctx, reader{makePayload(1, 0), makePayload(1, 0)})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, &migrate.Stats{Read: 1}, stats)
+}
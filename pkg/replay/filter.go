@@ -0,0 +1,158 @@
+package replay
+
+import (
+	"time"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+// Filter selects the data of payloads to replay. The zero value matches all data.
+type Filter struct {
+	// Targets are the targets to replay. All targets are replayed if empty.
+	Targets []string
+
+	// Metrics are the names of metrics to replay. If non-empty, only these
+	// metrics are replayed, and workouts and health events are excluded.
+	Metrics []string
+
+	// From and To are the inclusive start and exclusive end of the time range
+	// of data to replay. The range is unbounded on either side if zero.
+	From time.Time
+	To   time.Time
+}
+
+// MatchTarget returns true if data for the target should be replayed.
+func (f *Filter) MatchTarget(target string) bool {
+	if len(f.Targets) == 0 {
+		return true
+	}
+	for _, t := range f.Targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns a copy of the payload that only contains data matching the
+// Filter, or nil if no data matches.
+func (f *Filter) Apply(payload *healthautoexport.Payload) *healthautoexport.Payload {
+	if payload == nil || payload.Data == nil {
+		return nil
+	}
+	if len(f.Metrics) == 0 && f.From.IsZero() && f.To.IsZero() {
+		return payload
+	}
+
+	data := payload.Data
+	filtered := &healthautoexport.PayloadData{Unknown: data.Unknown}
+	var count int
+	for _, metric := range data.Metrics {
+		if !f.matchMetric(metric.Name) {
+			continue
+		}
+		m := *metric
+		m.Datapoints = nil
+		for _, datum := range metric.Datapoints {
+			if f.matchTime(datum.Date) {
+				m.Datapoints = append(m.Datapoints, datum)
+			}
+		}
+		m.SleepAnalyses = nil
+		for _, a := range metric.SleepAnalyses {
+			if f.matchTime(a.StartDate) {
+				m.SleepAnalyses = append(m.SleepAnalyses, a)
+			}
+		}
+		m.AggregatedSleepAnalyses = nil
+		for _, a := range metric.AggregatedSleepAnalyses {
+			start := a.SleepStart
+			if start.IsZero() {
+				start = a.InBedStart
+			}
+			if f.matchTime(start) {
+				m.AggregatedSleepAnalyses = append(m.AggregatedSleepAnalyses, a)
+			}
+		}
+		if n := len(m.Datapoints) + len(m.SleepAnalyses) + len(m.AggregatedSleepAnalyses); n > 0 {
+			filtered.Metrics = append(filtered.Metrics, &m)
+			count += n
+		}
+	}
+
+	// Only metrics are replayed if filtering by metric name.
+	if len(f.Metrics) == 0 {
+		for _, workout := range data.Workouts {
+			if f.matchTime(workout.Start) {
+				filtered.Workouts = append(filtered.Workouts, workout)
+			}
+		}
+		for _, event := range data.Symptoms {
+			if f.matchTime(event.Start) {
+				filtered.Symptoms = append(filtered.Symptoms, event)
+			}
+		}
+		for _, event := range data.Medications {
+			if f.matchTime(event.Start) {
+				filtered.Medications = append(filtered.Medications, event)
+			}
+		}
+		for _, event := range data.ECG {
+			if f.matchTime(event.Start) {
+				filtered.ECG = append(filtered.ECG, event)
+			}
+		}
+		for _, event := range data.HeartRateNotifications {
+			if f.matchTime(event.Start) {
+				filtered.HeartRateNotifications = append(filtered.HeartRateNotifications, event)
+			}
+		}
+		for _, event := range data.StateOfMind {
+			if f.matchTime(event.Start) {
+				filtered.StateOfMind = append(filtered.StateOfMind, event)
+			}
+		}
+		for _, event := range data.CycleTracking {
+			if f.matchTime(event.Start) {
+				filtered.CycleTracking = append(filtered.CycleTracking, event)
+			}
+		}
+		count += len(filtered.Workouts) + len(filtered.Symptoms) + len(filtered.Medications) + len(filtered.ECG) +
+			len(filtered.HeartRateNotifications) + len(filtered.StateOfMind) + len(filtered.CycleTracking)
+	}
+
+	if count == 0 {
+		return nil
+	}
+	return &healthautoexport.Payload{Data: filtered, Unknown: payload.Unknown}
+}
+
+func (f *Filter) matchMetric(name string) bool {
+	if len(f.Metrics) == 0 {
+		return true
+	}
+	for _, metric := range f.Metrics {
+		if metric == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTime returns true if t is within the time range. Data without a time
+// only matches if the time range is unbounded.
+func (f *Filter) matchTime(t *healthautoexport.Time) bool {
+	if f.From.IsZero() && f.To.IsZero() {
+		return true
+	}
+	if t.IsZero() {
+		return false
+	}
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !t.Before(f.To) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package replay_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/replay"
+)
+
+func newTime(s string) *healthautoexport.Time {
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	t := healthautoexport.NewTime(parsed)
+	return &t
+}
+
+func TestFilter_Apply(t *testing.T) {
+	payload := &healthautoexport.Payload{
+		Data: &healthautoexport.PayloadData{
+			Metrics: []*healthautoexport.Metric{
+				{
+					Name: "active_energy",
+					Datapoints: []*healthautoexport.Datapoint{
+						{Date: newTime("2021-12-23T12:00:00Z")},
+						{Date: newTime("2021-12-24T12:00:00Z")},
+					},
+				},
+				{
+					Name: "sleep_analysis",
+					AggregatedSleepAnalyses: []*healthautoexport.AggregatedSleepAnalysis{
+						{SleepStart: newTime("2021-12-24T01:00:00Z")},
+					},
+				},
+			},
+			Workouts: []*healthautoexport.Workout{
+				{Name: "Walking", Start: newTime("2021-12-24T08:00:00Z")},
+				{Name: "Running", Start: newTime("2021-12-25T08:00:00Z")},
+			},
+			Symptoms: []*healthautoexport.Symptom{
+				{Start: newTime("2021-12-23T08:00:00Z")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		filter       replay.Filter
+		wantNil      bool
+		wantMetrics  map[string]int
+		wantWorkouts []string
+		wantSymptoms int
+	}{
+		{
+			name:         "match all",
+			wantMetrics:  map[string]int{"active_energy": 2, "sleep_analysis": 1},
+			wantWorkouts: []string{"Walking", "Running"},
+			wantSymptoms: 1,
+		},
+		{
+			name:        "metrics only",
+			filter:      replay.Filter{Metrics: []string{"active_energy"}},
+			wantMetrics: map[string]int{"active_energy": 2},
+		},
+		{
+			name: "time range",
+			filter: replay.Filter{
+				From: time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2021, 12, 25, 0, 0, 0, 0, time.UTC),
+			},
+			wantMetrics:  map[string]int{"active_energy": 1, "sleep_analysis": 1},
+			wantWorkouts: []string{"Walking"},
+		},
+		{
+			name: "no matching data",
+			filter: replay.Filter{
+				Metrics: []string{"step_count"},
+			},
+			wantNil: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.filter.Apply(payload)
+			if tt.wantNil {
+				assert.Nil(t, got)
+				return
+			}
+			metrics := make(map[string]int)
+			for _, metric := range got.Data.Metrics {
+				metrics[metric.Name] = len(metric.Datapoints) + len(metric.SleepAnalyses) + len(metric.AggregatedSleepAnalyses)
+			}
+			assert.Equal(t, tt.wantMetrics, metrics)
+			var workouts []string
+			for _, workout := range got.Data.Workouts {
+				workouts = append(workouts, workout.Name)
+			}
+			assert.Equal(t, tt.wantWorkouts, workouts)
+			assert.Len(t, got.Data.Symptoms, tt.wantSymptoms)
+		})
+	}
+
+	// The payload is not modified.
+	assert.Len(t, payload.Data.Metrics[0].Datapoints, 2)
+}
@@ -0,0 +1,410 @@
+// Package replay re-ingests previously received payloads into backends, from
+// payloads archived by the ingester, raw payloads, or the output of the
+// LocalFile backend.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/archive"
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/localfile"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
+)
+
+const (
+	metadataExt = ".meta.json"
+
+	// storeIndexFile is the name of the index file of a LocalFile JSON Lines store.
+	storeIndexFile = "index.json"
+)
+
+var (
+	targets   []string
+	metrics   []string
+	from      string
+	to        string
+	rate      float64
+	stateFile string
+)
+
+// sourceKind is the type of file that a payload is read from.
+type sourceKind int
+
+const (
+	// sourceArchive is a payload archived by the ingester, with metadata.
+	sourceArchive sourceKind = iota
+	// sourceRaw is a raw payload in JSON or CSV format, which may be compressed.
+	sourceRaw
+	// sourceLocalFile is a file or JSON Lines store written by the LocalFile backend.
+	sourceLocalFile
+)
+
+// source is a file that one payload is read from.
+type source struct {
+	path string
+	kind sourceKind
+}
+
+// Options configures a Replayer.
+type Options struct {
+	// Filter selects the data to replay.
+	Filter Filter
+
+	// Backends are the names of the backends to replay into. All backends of
+	// the ingester are used if empty.
+	Backends []string
+
+	// Rate is the maximum number of payloads to replay per second. There is no
+	// limit if zero.
+	Rate float64
+
+	// StateFile records the files which were replayed, which are skipped when
+	// replaying again so that an interrupted replay can be resumed. Files are
+	// always replayed if empty.
+	StateFile string
+
+	// DecoderOptions are used to unmarshal raw payloads.
+	DecoderOptions healthautoexport.DecoderOptions
+}
+
+// Stats summarizes a replay.
+type Stats struct {
+	// Files is the number of files found.
+	Files int
+	// Replayed is the number of files which were replayed into backends.
+	Replayed int
+	// Skipped is the number of files which were already replayed, or did not
+	// contain any data matching the filter.
+	Skipped int
+	// Failed is the number of files which could not be read.
+	Failed int
+}
+
+// Replayer reads payloads from files and ingests them into backends of an
+// Ingester, waiting for each payload to be written before continuing.
+type Replayer struct {
+	ingester *ingester.Ingester
+	opts     Options
+}
+
+// NewReplayer returns a Replayer into all backends of the ingester, which is
+// configured by command-line flags.
+func NewReplayer(ingester *ingester.Ingester) (*Replayer, error) {
+	opts := Options{
+		Filter: Filter{
+			Targets: targets,
+			Metrics: metrics,
+		},
+		Rate:           rate,
+		StateFile:      stateFile,
+		DecoderOptions: ingester.DecoderOptions,
+	}
+	var err error
+	if opts.Filter.From, err = parseTime(from); err != nil {
+		return nil, errors.Wrapf(err, "invalid --replay.from")
+	}
+	if opts.Filter.To, err = parseTime(to); err != nil {
+		return nil, errors.Wrapf(err, "invalid --replay.to")
+	}
+	return NewReplayerWithOptions(ingester, opts), nil
+}
+
+// NewReplayerWithOptions returns a Replayer with the given options.
+func NewReplayerWithOptions(ingester *ingester.Ingester, opts Options) *Replayer {
+	if len(opts.Backends) == 0 {
+		for _, backend := range ingester.ListBackends() {
+			opts.Backends = append(opts.Backends, backend.Name())
+		}
+	}
+	return &Replayer{ingester: ingester, opts: opts}
+}
+
+// Replay replays all payloads found in paths, which may be files or
+// directories, in order of their path. Files which cannot be read are skipped,
+// while replay is stopped if a payload cannot be written to a backend or the
+// context is cancelled.
+func (r *Replayer) Replay(ctx context.Context, paths ...string) (*Stats, error) {
+	var sources []*source
+	for _, p := range paths {
+		found, err := findSources(p)
+		if err != nil {
+			return &Stats{}, err
+		}
+		sources = append(sources, found...)
+	}
+	stats := &Stats{Files: len(sources)}
+
+	// Load files which were already replayed.
+	replayed := make(map[string]bool)
+	var state *os.File
+	if r.opts.StateFile != "" {
+		var err error
+		if replayed, err = readState(r.opts.StateFile); err != nil {
+			return stats, err
+		}
+		state, err = os.OpenFile(r.opts.StateFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return stats, errors.Wrapf(err, "cannot open %v", r.opts.StateFile)
+		}
+		defer func() {
+			_ = state.Close()
+		}()
+	}
+	markReplayed := func(src *source) error {
+		if state == nil {
+			return nil
+		}
+		if _, err := fmt.Fprintln(state, src.path); err != nil {
+			return errors.Wrapf(err, "cannot write %v", r.opts.StateFile)
+		}
+		return nil
+	}
+
+	var limiter <-chan time.Time
+	if r.opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.opts.Rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	for i, src := range sources {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		logger := log.WithFields(log.Fields{
+			"path":     src.path,
+			"progress": fmt.Sprintf("%v/%v", i+1, len(sources)),
+		})
+		if replayed[src.path] {
+			stats.Skipped++
+			logger.Debug("skipping replayed file")
+			continue
+		}
+
+		payload, target, err := r.read(src)
+		if err != nil {
+			stats.Failed++
+			logger.WithError(err).Warn("could not read file, skipping")
+			continue
+		}
+		if payload != nil && r.opts.Filter.MatchTarget(target) {
+			payload = r.opts.Filter.Apply(payload)
+		} else {
+			payload = nil
+		}
+		if payload == nil {
+			stats.Skipped++
+			logger.Debug("no data to replay in file")
+			if err := markReplayed(src); err != nil {
+				return stats, err
+			}
+			continue
+		}
+
+		if limiter != nil {
+			select {
+			case <-ctx.Done():
+				return stats, ctx.Err()
+			case <-limiter:
+			}
+		}
+		for _, backend := range r.opts.Backends {
+			if err := r.ingester.IngestPayloadAndWait(ctx, payload, backend, target); err != nil {
+				return stats, errors.Wrapf(err, "cannot replay %v into %v", src.path, backend)
+			}
+		}
+		if err := markReplayed(src); err != nil {
+			return stats, err
+		}
+		stats.Replayed++
+		logger.WithField("target", target).Info("replayed file")
+	}
+
+	return stats, nil
+}
+
+// read reads the payload of a source, and the target that it was ingested with.
+func (r *Replayer) read(src *source) (*healthautoexport.Payload, string, error) {
+	switch src.kind {
+	case sourceArchive:
+		entry := &archive.Entry{Path: src.path, MetadataPath: archiveMetadataPath(src.path)}
+		metadata, err := entry.ReadMetadata()
+		if err != nil {
+			return nil, "", err
+		}
+		format, err := healthautoexport.ParseFormat(metadata.Format)
+		if err != nil {
+			return nil, "", err
+		}
+		reader, err := entry.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		payload, err := healthautoexport.UnmarshalWithOptions(reader, format, r.opts.DecoderOptions)
+		return payload, metadata.Target, err
+
+	case sourceRaw:
+		data, err := os.ReadFile(src.path)
+		if err != nil {
+			return nil, "", err
+		}
+		name := src.path
+		if strings.HasSuffix(name, ".gz") {
+			gz, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, "", errors.Wrapf(err, "cannot decompress %v", src.path)
+			}
+			if data, err = io.ReadAll(gz); err != nil {
+				return nil, "", errors.Wrapf(err, "cannot decompress %v", src.path)
+			}
+			name = strings.TrimSuffix(name, ".gz")
+		}
+		format := healthautoexport.FormatJSON
+		if path.Ext(name) == ".csv" {
+			format = healthautoexport.FormatCSV
+		}
+		payload, err := healthautoexport.UnmarshalWithOptions(bytes.NewReader(data), format, r.opts.DecoderOptions)
+		return payload, "", err
+
+	case sourceLocalFile:
+		return localfile.ReadPayload(src.path)
+	}
+
+	return nil, "", errors.Errorf("unknown source %v", src.path)
+}
+
+// findSources returns the sources in root in order of their path.
+func findSources(root string) ([]*source, error) {
+	var sources []*source
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if _, err := os.Stat(path.Join(name, storeIndexFile)); err == nil {
+				sources = append(sources, &source{path: name, kind: sourceLocalFile})
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case strings.HasSuffix(name, metadataExt):
+			return nil
+		case strings.HasSuffix(name, ".gz"):
+			kind := sourceRaw
+			if _, err := os.Stat(archiveMetadataPath(name)); err == nil {
+				kind = sourceArchive
+			}
+			sources = append(sources, &source{path: name, kind: kind})
+		case strings.HasSuffix(name, ".csv"):
+			sources = append(sources, &source{path: name, kind: sourceRaw})
+		case strings.HasSuffix(name, ".parquet"):
+			sources = append(sources, &source{path: name, kind: sourceLocalFile})
+		case strings.HasSuffix(name, ".json"):
+			kind, err := jsonSourceKind(name)
+			if err != nil {
+				log.WithError(err).Warnf("could not read %v, skipping", name)
+				return nil
+			}
+			sources = append(sources, &source{path: name, kind: kind})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot walk %v", root)
+	}
+	return sources, nil
+}
+
+// jsonSourceKind determines if a JSON file is a raw payload, which contains a
+// data object, or was written by the LocalFile backend.
+func jsonSourceKind(name string) (sourceKind, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	var fields map[string]jsoniter.RawMessage
+	if err := jsoniter.Unmarshal(data, &fields); err != nil {
+		return 0, err
+	}
+	if raw := bytes.TrimSpace(fields["data"]); len(raw) > 0 && raw[0] == '{' {
+		return sourceRaw, nil
+	}
+	return sourceLocalFile, nil
+}
+
+// archiveMetadataPath returns the path of the metadata of an archived payload.
+func archiveMetadataPath(name string) string {
+	base := strings.TrimSuffix(name, ".gz")
+	return strings.TrimSuffix(base, path.Ext(base)) + metadataExt
+}
+
+// readState reads the paths of files recorded in the state file.
+func readState(name string) (map[string]bool, error) {
+	replayed := make(map[string]bool)
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return replayed, nil
+		}
+		return nil, errors.Wrapf(err, "cannot open %v", name)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			replayed[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "cannot read %v", name)
+	}
+	return replayed, nil
+}
+
+// parseTime parses a time in RFC 3339 format, or a date in UTC.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func init() {
+	pflag.StringSliceVar(&targets, "replay.targets", nil, "Only replay payloads for these targets.")
+	pflag.StringSliceVar(&metrics, "replay.metrics", nil,
+		"Only replay metrics with these names. Workouts and health events are excluded if set.")
+	pflag.StringVar(&from, "replay.from", "",
+		"Only replay data from this time onwards, as an RFC 3339 time or a date (e.g. 2021-12-24).")
+	pflag.StringVar(&to, "replay.to", "",
+		"Only replay data before this time, as an RFC 3339 time or a date (e.g. 2021-12-25).")
+	pflag.Float64Var(&rate, "replay.rate", 0, "Maximum number of payloads to replay per second. Use 0 for no limit.")
+	pflag.StringVar(&stateFile, "replay.stateFile", "",
+		"File to record replayed files in, which are skipped when replaying again to resume an interrupted replay.")
+}
@@ -0,0 +1,120 @@
+package replay_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/archive"
+	"github.com/irvinlim/apple-health-ingester/pkg/backends/noop"
+	"github.com/irvinlim/apple-health-ingester/pkg/ingester"
+	"github.com/irvinlim/apple-health-ingester/pkg/replay"
+)
+
+const (
+	payload    = `{"data":{"metrics":[{"name":"active_energy","units":"kJ","data":[{"qty":0.77,"date":"2021-12-24 00:04:00 +0800"},{"qty":0.38,"date":"2021-12-25 00:05:00 +0800"}]}]}}`
+	metricFile = `{"name":"step_count","target":"phone","units":"count","data":[{"qty":12,"date":"2021-12-24 00:04:00 +0800"}]}`
+)
+
+func newIngester(t *testing.T) (*ingester.Ingester, *noop.Backend) {
+	ingest := ingester.NewIngester()
+	backend := noop.NewBackend()
+	require.NoError(t, ingest.AddBackend(backend))
+	ingest.Start()
+	t.Cleanup(ingest.Shutdown)
+	return ingest, backend
+}
+
+func writeTestFiles(t *testing.T) string {
+	dir := t.TempDir()
+
+	// Archived payload
+	archiver, err := archive.NewArchiverWithOptions(archive.Options{Path: path.Join(dir, "archive")})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/api/healthautoexport/v1/influxdb/ingest?target=watch", nil)
+	_, err = archiver.Store([]byte(payload), archive.NewMetadata(r, []byte(payload), "InfluxDB", "json"))
+	require.NoError(t, err)
+	require.NoError(t, archiver.Close())
+
+	// Raw payloads
+	require.NoError(t, os.MkdirAll(path.Join(dir, "raw"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(dir, "raw", "export.json"), []byte(payload), 0644))
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err = gz.Write([]byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.WriteFile(path.Join(dir, "raw", "export.json.gz"), buf.Bytes(), 0644))
+
+	// LocalFile output
+	require.NoError(t, os.MkdirAll(path.Join(dir, "localfile"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(dir, "localfile", "phone_step_count_count.json"), []byte(metricFile), 0644))
+	require.NoError(t, os.WriteFile(path.Join(dir, "localfile", "phone_step_count_count.json.bak.1"), []byte(metricFile), 0644))
+
+	return dir
+}
+
+func TestReplayer_Replay(t *testing.T) {
+	dir := writeTestFiles(t)
+	ingest, backend := newIngester(t)
+
+	replayer := replay.NewReplayerWithOptions(ingest, replay.Options{})
+	stats, err := replayer.Replay(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, &replay.Stats{Files: 4, Replayed: 4}, stats)
+	require.Len(t, backend.Writes, 4)
+
+	// Files are replayed in order of their path.
+	var names []string
+	for _, write := range backend.Writes {
+		require.Len(t, write.Data.Metrics, 1)
+		names = append(names, write.Data.Metrics[0].Name)
+	}
+	assert.Equal(t, []string{"active_energy", "step_count", "active_energy", "active_energy"}, names)
+}
+
+func TestReplayer_Filter(t *testing.T) {
+	dir := writeTestFiles(t)
+	ingest, backend := newIngester(t)
+
+	replayer := replay.NewReplayerWithOptions(ingest, replay.Options{
+		Filter: replay.Filter{Targets: []string{"phone"}},
+	})
+	stats, err := replayer.Replay(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, &replay.Stats{Files: 4, Replayed: 1, Skipped: 3}, stats)
+	require.Len(t, backend.Writes, 1)
+	assert.Equal(t, "step_count", backend.Writes[0].Data.Metrics[0].Name)
+}
+
+func TestReplayer_Resume(t *testing.T) {
+	dir := writeTestFiles(t)
+	stateFile := path.Join(t.TempDir(), "state")
+	ingest, backend := newIngester(t)
+
+	// Replay is interrupted before any file is replayed.
+	replayer := replay.NewReplayerWithOptions(ingest, replay.Options{StateFile: stateFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := replayer.Replay(ctx, dir)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, backend.Writes, 0)
+
+	stats, err := replayer.Replay(context.Background(), path.Join(dir, "raw"))
+	require.NoError(t, err)
+	assert.Equal(t, &replay.Stats{Files: 2, Replayed: 2}, stats)
+
+	// Files which were already replayed are skipped.
+	stats, err = replayer.Replay(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, &replay.Stats{Files: 4, Replayed: 2, Skipped: 2}, stats)
+	assert.Len(t, backend.Writes, 4)
+}
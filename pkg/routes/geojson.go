@@ -0,0 +1,82 @@
+package routes
+
+import (
+	"io"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONGeometry   `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type string `json:"type"`
+
+	// Coordinates is a single position for a Point, or a list of positions
+	// for a LineString.
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	Name  string `json:"name"`
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+
+	// CoordinateTimes contains the timestamp of each coordinate of a
+	// LineString, following the convention used by tools such as togeojson.
+	CoordinateTimes []string `json:"coordinateTimes,omitempty"`
+
+	// Time contains the timestamp of a Point.
+	Time string `json:"time,omitempty"`
+}
+
+// EncodeGeoJSON writes the route of the workout to w as a GeoJSON Feature with
+// a LineString geometry. Coordinates are in [longitude, latitude, altitude] order.
+//
+// A LineString must have at least two positions, so a route with a single
+// point is written with a Point geometry instead.
+func EncodeGeoJSON(w io.Writer, workout *healthautoexport.Workout) error {
+	route := analytics.SortedRoute(workout)
+	feature := geoJSONFeature{
+		Type: "Feature",
+		Properties: geoJSONProperties{
+			Name: workout.Name,
+		},
+	}
+	if !workout.Start.IsZero() {
+		feature.Properties.Start = formatTime(workout.Start.Time)
+	}
+	if !workout.End.IsZero() {
+		feature.Properties.End = formatTime(workout.End.Time)
+	}
+
+	if len(route) == 1 {
+		datum := route[0]
+		feature.Geometry = geoJSONGeometry{
+			Type:        "Point",
+			Coordinates: [3]float64{datum.Lon, datum.Lat, datum.Altitude},
+		}
+		feature.Properties.Time = formatTime(datum.Timestamp.Time)
+	} else {
+		coordinates := make([][3]float64, 0, len(route))
+		feature.Properties.CoordinateTimes = make([]string, 0, len(route))
+		for _, datum := range route {
+			coordinates = append(coordinates, [3]float64{datum.Lon, datum.Lat, datum.Altitude})
+			feature.Properties.CoordinateTimes = append(feature.Properties.CoordinateTimes, formatTime(datum.Timestamp.Time))
+		}
+		feature.Geometry = geoJSONGeometry{
+			Type:        "LineString",
+			Coordinates: coordinates,
+		}
+	}
+
+	enc := jsoniter.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(feature)
+}
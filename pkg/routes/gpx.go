@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	gpxNamespace = "http://www.topografix.com/GPX/1/1"
+)
+
+type gpx struct {
+	XMLName  xml.Name    `xml:"gpx"`
+	XMLNS    string      `xml:"xmlns,attr"`
+	Version  string      `xml:"version,attr"`
+	Creator  string      `xml:"creator,attr"`
+	Metadata gpxMetadata `xml:"metadata"`
+	Tracks   []gpxTrack  `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name,omitempty"`
+	Time string `xml:"time,omitempty"`
+}
+
+type gpxTrack struct {
+	Name     string            `xml:"name,omitempty"`
+	Type     string            `xml:"type,omitempty"`
+	Segments []gpxTrackSegment `xml:"trkseg"`
+}
+
+type gpxTrackSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat       float64 `xml:"lat,attr"`
+	Lon       float64 `xml:"lon,attr"`
+	Elevation float64 `xml:"ele"`
+	Time      string  `xml:"time"`
+}
+
+// EncodeGPX writes the route of the workout to w as a GPX 1.1 track.
+func EncodeGPX(w io.Writer, workout *healthautoexport.Workout) error {
+	route := analytics.SortedRoute(workout)
+	segment := gpxTrackSegment{
+		Points: make([]gpxPoint, 0, len(route)),
+	}
+	for _, datum := range route {
+		segment.Points = append(segment.Points, gpxPoint{
+			Lat:       datum.Lat,
+			Lon:       datum.Lon,
+			Elevation: datum.Altitude,
+			Time:      formatTime(datum.Timestamp.Time),
+		})
+	}
+
+	doc := gpx{
+		XMLNS:   gpxNamespace,
+		Version: "1.1",
+		Creator: creator,
+		Metadata: gpxMetadata{
+			Name: workout.Name,
+		},
+		Tracks: []gpxTrack{
+			{
+				Name:     workout.Name,
+				Type:     workout.Name,
+				Segments: []gpxTrackSegment{segment},
+			},
+		},
+	}
+	if !workout.Start.IsZero() {
+		doc.Metadata.Time = formatTime(workout.Start.Time)
+	}
+
+	return encodeXML(w, doc)
+}
+
+// encodeXML writes v to w as an indented XML document.
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
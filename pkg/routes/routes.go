@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	// creator is used to identify the application that created the files.
+	creator = "apple-health-ingester"
+
+	// maxHeartRateGap is the maximum time difference between a route point and
+	// a heart rate sample for them to be merged.
+	maxHeartRateGap = time.Minute
+)
+
+// Format is the file format of an exported route.
+type Format string
+
+const (
+	// FormatGPX is the GPS Exchange Format 1.1.
+	FormatGPX Format = "gpx"
+	// FormatTCX is the Garmin Training Center XML format.
+	FormatTCX Format = "tcx"
+	// FormatGeoJSON is a GeoJSON Feature with a LineString (or Point) geometry.
+	FormatGeoJSON Format = "geojson"
+)
+
+var (
+	// AllFormats contains all supported formats.
+	AllFormats = []Format{FormatGPX, FormatTCX, FormatGeoJSON}
+)
+
+// ParseFormat parses a case-insensitive format name.
+func ParseFormat(s string) (Format, error) {
+	format := Format(strings.ToLower(s))
+	for _, f := range AllFormats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown route format %q", s)
+}
+
+// Extension returns the file extension for the format, including the leading dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// Encode writes the route of the workout to w in the given format.
+func Encode(w io.Writer, workout *healthautoexport.Workout, format Format) error {
+	switch format {
+	case FormatGPX:
+		return EncodeGPX(w, workout)
+	case FormatTCX:
+		return EncodeTCX(w, workout)
+	case FormatGeoJSON:
+		return EncodeGeoJSON(w, workout)
+	}
+	return fmt.Errorf("unknown route format %q", format)
+}
+
+// HasRoute returns true if the workout has a route that can be exported.
+func HasRoute(workout *healthautoexport.Workout) bool {
+	return workout != nil && !workout.Start.IsZero() && len(analytics.SortedRoute(workout)) > 0
+}
+
+// FileName returns the file name for the exported route of a workout,
+// consisting of the target, workout name and start time.
+func FileName(target string, workout *healthautoexport.Workout, format Format) string {
+	filename := sanitizeFileName(workout.Name) + "_" + workout.Start.Format("20060102T150405Z0700")
+	if target != "" {
+		filename = sanitizeFileName(target) + "_" + filename
+	}
+	return filename + format.Extension()
+}
+
+// sanitizeFileName replaces any character that is not safe for use in file
+// names with an underscore.
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '+':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// heartRateMatcher finds the heart rate sample closest to a given time.
+type heartRateMatcher struct {
+	samples []*healthautoexport.DatapointWithUnit
+}
+
+func newHeartRateMatcher(data []*healthautoexport.DatapointWithUnit) *heartRateMatcher {
+	samples := make([]*healthautoexport.DatapointWithUnit, 0, len(data))
+	for _, datum := range data {
+		if datum != nil && !datum.Date.IsZero() {
+			samples = append(samples, datum)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Date.Before(samples[j].Date.Time)
+	})
+	return &heartRateMatcher{samples: samples}
+}
+
+// Match returns the heart rate closest to t, if there is any sample within maxHeartRateGap.
+func (m *heartRateMatcher) Match(t time.Time) (healthautoexport.Qty, bool) {
+	i := sort.Search(len(m.samples), func(i int) bool {
+		return !m.samples[i].Date.Before(t)
+	})
+	var best *healthautoexport.DatapointWithUnit
+	var bestGap time.Duration
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(m.samples) {
+			continue
+		}
+		gap := m.samples[j].Date.Sub(t)
+		if gap < 0 {
+			gap = -gap
+		}
+		if best == nil || gap < bestGap {
+			best, bestGap = m.samples[j], gap
+		}
+	}
+	if best == nil || bestGap > maxHeartRateGap {
+		return 0, false
+	}
+	return best.Qty, true
+}
+
+// formatTime formats a timestamp as an XML Schema dateTime in UTC.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
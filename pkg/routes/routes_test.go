@@ -0,0 +1,214 @@
+package routes_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+	"github.com/irvinlim/apple-health-ingester/pkg/routes"
+	"github.com/irvinlim/apple-health-ingester/pkg/util/testutils"
+)
+
+var (
+	workout = &healthautoexport.Workout{
+		Name:  "Outdoor Run",
+		Start: mktime("2021-12-24 08:00:00 +0800"),
+		End:   mktime("2021-12-24 08:10:00 +0800"),
+		Route: []*healthautoexport.RouteDatapoint{
+			// Out of order, will be sorted.
+			{Lat: 1.301, Lon: 103.8, Altitude: 12.5, Timestamp: mktime("2021-12-24 08:05:00 +0800")},
+			{Lat: 1.3, Lon: 103.8, Altitude: 10, Timestamp: mktime("2021-12-24 08:00:30 +0800")},
+		},
+		HeartRateData: []*healthautoexport.DatapointWithUnit{
+			{Date: mktime("2021-12-24 08:00:00 +0800"), QtyWithUnit: healthautoexport.QtyWithUnit{Qty: 120.4, Units: "bpm"}},
+			// More than 1 minute away from any route point.
+			{Date: mktime("2021-12-24 08:02:00 +0800"), QtyWithUnit: healthautoexport.QtyWithUnit{Qty: 150, Units: "bpm"}},
+		},
+		Fields: healthautoexport.WorkoutFields{
+			{Key: "activeEnergy", Value: &healthautoexport.QtyWithUnit{Qty: 418.4, Units: "kJ"}},
+		},
+	}
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    routes.Format
+		want      string
+		wantError assert.ErrorAssertionFunc
+	}{
+		{
+			name:   "gpx",
+			format: routes.FormatGPX,
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="apple-health-ingester">
+  <metadata>
+    <name>Outdoor Run</name>
+    <time>2021-12-24T00:00:00Z</time>
+  </metadata>
+  <trk>
+    <name>Outdoor Run</name>
+    <type>Outdoor Run</type>
+    <trkseg>
+      <trkpt lat="1.3" lon="103.8">
+        <ele>10</ele>
+        <time>2021-12-24T00:00:30Z</time>
+      </trkpt>
+      <trkpt lat="1.301" lon="103.8">
+        <ele>12.5</ele>
+        <time>2021-12-24T00:05:00Z</time>
+      </trkpt>
+    </trkseg>
+  </trk>
+</gpx>
+`,
+		},
+		{
+			name:   "tcx with merged heart rate",
+			format: routes.FormatTCX,
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+  <Activities>
+    <Activity Sport="Running">
+      <Id>2021-12-24T00:00:00Z</Id>
+      <Lap StartTime="2021-12-24T00:00:00Z">
+        <TotalTimeSeconds>600</TotalTimeSeconds>
+        <DistanceMeters>111.1950802335304</DistanceMeters>
+        <Calories>100</Calories>
+        <Intensity>Active</Intensity>
+        <TriggerMethod>Manual</TriggerMethod>
+        <Track>
+          <Trackpoint>
+            <Time>2021-12-24T00:00:30Z</Time>
+            <Position>
+              <LatitudeDegrees>1.3</LatitudeDegrees>
+              <LongitudeDegrees>103.8</LongitudeDegrees>
+            </Position>
+            <AltitudeMeters>10</AltitudeMeters>
+            <DistanceMeters>0</DistanceMeters>
+            <HeartRateBpm>
+              <Value>120</Value>
+            </HeartRateBpm>
+          </Trackpoint>
+          <Trackpoint>
+            <Time>2021-12-24T00:05:00Z</Time>
+            <Position>
+              <LatitudeDegrees>1.301</LatitudeDegrees>
+              <LongitudeDegrees>103.8</LongitudeDegrees>
+            </Position>
+            <AltitudeMeters>12.5</AltitudeMeters>
+            <DistanceMeters>111.1950802335304</DistanceMeters>
+          </Trackpoint>
+        </Track>
+      </Lap>
+      <Notes>Outdoor Run</Notes>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>
+`,
+		},
+		{
+			name:   "geojson",
+			format: routes.FormatGeoJSON,
+			want: `{
+  "type": "Feature",
+  "geometry": {
+    "type": "LineString",
+    "coordinates": [[103.8, 1.3, 10], [103.8, 1.301, 12.5]]
+  },
+  "properties": {
+    "name": "Outdoor Run",
+    "start": "2021-12-24T00:00:00Z",
+    "end": "2021-12-24T00:10:00Z",
+    "coordinateTimes": ["2021-12-24T00:00:30Z", "2021-12-24T00:05:00Z"]
+  }
+}`,
+		},
+		{
+			name:      "unknown format",
+			format:    "kml",
+			wantError: testutils.AssertErrorContains(`unknown route format "kml"`),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if testutils.WantError(t, tt.wantError, routes.Encode(&buf, workout, tt.format)) {
+				return
+			}
+			if tt.format == routes.FormatGeoJSON {
+				assert.JSONEq(t, tt.want, buf.String())
+				return
+			}
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestEncodeGeoJSON_SinglePoint(t *testing.T) {
+	single := &healthautoexport.Workout{
+		Name:  "Outdoor Run",
+		Start: mktime("2021-12-24 08:00:00 +0800"),
+		Route: []*healthautoexport.RouteDatapoint{
+			{Lat: 1.3, Lon: 103.8, Altitude: 10, Timestamp: mktime("2021-12-24 08:00:30 +0800")},
+		},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, routes.EncodeGeoJSON(&buf, single))
+	assert.JSONEq(t, `{
+  "type": "Feature",
+  "geometry": {
+    "type": "Point",
+    "coordinates": [103.8, 1.3, 10]
+  },
+  "properties": {
+    "name": "Outdoor Run",
+    "start": "2021-12-24T00:00:00Z",
+    "time": "2021-12-24T00:00:30Z"
+  }
+}`, buf.String())
+}
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		format routes.Format
+		want   string
+	}{
+		{
+			name:   "without target",
+			format: routes.FormatGPX,
+			want:   "Outdoor_Run_20211224T080000+0800.gpx",
+		},
+		{
+			name:   "with target",
+			target: "irvin/phone",
+			format: routes.FormatGeoJSON,
+			want:   "irvin_phone_Outdoor_Run_20211224T080000+0800.geojson",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, routes.FileName(tt.target, workout, tt.format))
+		})
+	}
+}
+
+func TestHasRoute(t *testing.T) {
+	assert.True(t, routes.HasRoute(workout))
+	assert.False(t, routes.HasRoute(&healthautoexport.Workout{Name: "Yoga", Start: mktime("2021-12-24 08:00:00 +0800")}))
+	assert.False(t, routes.HasRoute(&healthautoexport.Workout{Name: "Walking", Route: workout.Route}))
+}
+
+func mktime(ts string) *healthautoexport.Time {
+	t, err := healthautoexport.ParseTime(ts)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
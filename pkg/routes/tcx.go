@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/analytics"
+	"github.com/irvinlim/apple-health-ingester/pkg/healthautoexport"
+)
+
+const (
+	tcxNamespace = "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2"
+)
+
+type tcxDatabase struct {
+	XMLName    xml.Name      `xml:"TrainingCenterDatabase"`
+	XMLNS      string        `xml:"xmlns,attr"`
+	Activities []tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport string   `xml:"Sport,attr"`
+	ID    string   `xml:"Id"`
+	Laps  []tcxLap `xml:"Lap"`
+	Notes string   `xml:"Notes,omitempty"`
+}
+
+type tcxLap struct {
+	StartTime        string          `xml:"StartTime,attr"`
+	TotalTimeSeconds float64         `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64         `xml:"DistanceMeters"`
+	Calories         int             `xml:"Calories"`
+	Intensity        string          `xml:"Intensity"`
+	TriggerMethod    string          `xml:"TriggerMethod"`
+	Trackpoints      []tcxTrackpoint `xml:"Track>Trackpoint"`
+}
+
+type tcxTrackpoint struct {
+	Time           string        `xml:"Time"`
+	Position       tcxPosition   `xml:"Position"`
+	AltitudeMeters float64       `xml:"AltitudeMeters"`
+	DistanceMeters float64       `xml:"DistanceMeters"`
+	HeartRateBpm   *tcxHeartRate `xml:"HeartRateBpm,omitempty"`
+}
+
+type tcxPosition struct {
+	LatitudeDegrees  float64 `xml:"LatitudeDegrees"`
+	LongitudeDegrees float64 `xml:"LongitudeDegrees"`
+}
+
+type tcxHeartRate struct {
+	Value int `xml:"Value"`
+}
+
+// EncodeTCX writes the route of the workout to w as a TCX activity. Heart rate
+// samples from HeartRateData are merged into the trackpoint that is closest in
+// time.
+func EncodeTCX(w io.Writer, workout *healthautoexport.Workout) error {
+	route := analytics.SortedRoute(workout)
+	heartRates := newHeartRateMatcher(workout.HeartRateData)
+
+	lap := tcxLap{
+		Calories:      getCalories(workout),
+		Intensity:     "Active",
+		TriggerMethod: "Manual",
+		Trackpoints:   make([]tcxTrackpoint, 0, len(route)),
+	}
+	if !workout.Start.IsZero() {
+		lap.StartTime = formatTime(workout.Start.Time)
+		if !workout.End.IsZero() {
+			lap.TotalTimeSeconds = workout.End.Sub(workout.Start.Time).Seconds()
+		}
+	}
+
+	var distance float64
+	for i, datum := range route {
+		if i > 0 {
+			distance += analytics.Haversine(route[i-1], datum)
+		}
+		point := tcxTrackpoint{
+			Time: formatTime(datum.Timestamp.Time),
+			Position: tcxPosition{
+				LatitudeDegrees:  datum.Lat,
+				LongitudeDegrees: datum.Lon,
+			},
+			AltitudeMeters: datum.Altitude,
+			DistanceMeters: distance,
+		}
+		if hr, ok := heartRates.Match(datum.Timestamp.Time); ok && hr > 0 {
+			point.HeartRateBpm = &tcxHeartRate{Value: int(math.Round(float64(hr)))}
+		}
+		lap.Trackpoints = append(lap.Trackpoints, point)
+	}
+	lap.DistanceMeters = distance
+
+	doc := tcxDatabase{
+		XMLNS: tcxNamespace,
+		Activities: []tcxActivity{
+			{
+				Sport: getSport(workout.Name),
+				ID:    lap.StartTime,
+				Laps:  []tcxLap{lap},
+				Notes: workout.Name,
+			},
+		},
+	}
+
+	return encodeXML(w, doc)
+}
+
+// getSport returns the TCX sport for the workout name, which may only be one of
+// Running, Biking or Other.
+func getSport(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "run"):
+		return "Running"
+	case strings.Contains(name, "cycl"), strings.Contains(name, "bik"):
+		return "Biking"
+	}
+	return "Other"
+}
+
+// getCalories returns the active energy of the workout in kcal.
+func getCalories(workout *healthautoexport.Workout) int {
+	energy, _ := analytics.ActiveEnergy(workout)
+	return int(math.Round(energy))
+}
@@ -0,0 +1,124 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// backupExt is the extension of backup generations, followed by the
+	// generation number, e.g. heart_rate_count_min.json.bak.1.
+	backupExt = ".bak."
+
+	// tmpExt is the extension of temporary files, followed by a random suffix.
+	tmpExt = ".tmp-"
+)
+
+// WriteAtomic writes a file using write, such that the file is either fully
+// written or left unchanged if the process crashes. The data is written to a
+// temporary file in the same directory, which is synced to disk and renamed
+// over the file.
+//
+// If backups is positive, up to that many previous versions of the file are
+// kept as backup generations, where generation 1 is the most recent.
+func WriteAtomic(name string, backups int, write func(w io.Writer) error) error {
+	dirname := path.Dir(name)
+	if err := os.MkdirAll(dirname, 0755); err != nil {
+		return errors.Wrapf(err, "cannot makedirs for %v", dirname)
+	}
+
+	// Write to temporary file.
+	file, err := os.CreateTemp(dirname, path.Base(name)+tmpExt+"*")
+	if err != nil {
+		return errors.Wrapf(err, "cannot create temp file for %v", name)
+	}
+	tmpName := file.Name()
+	defer func() {
+		// Clean up the temporary file if it was not renamed.
+		_ = os.Remove(tmpName)
+	}()
+	if err := write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "cannot sync %v", tmpName)
+	}
+	if err := file.Chmod(0644); err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "cannot chmod %v", tmpName)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "cannot close %v", tmpName)
+	}
+
+	// Rotate backup generations, and move the current file to generation 1.
+	if backups > 0 {
+		if err := rotateBackups(name, backups); err != nil {
+			return err
+		}
+	}
+
+	// Replace the file.
+	if err := os.Rename(tmpName, name); err != nil {
+		return errors.Wrapf(err, "cannot rename %v", tmpName)
+	}
+	SyncDir(dirname)
+	return nil
+}
+
+// rotateBackups shifts each backup generation of the file by one, dropping the
+// oldest generation, and moves the file itself to generation 1.
+func rotateBackups(name string, backups int) error {
+	if _, err := os.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "cannot stat %v", name)
+	}
+	for gen := backups - 1; gen >= 1; gen-- {
+		if err := os.Rename(BackupName(name, gen), BackupName(name, gen+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "cannot rotate backup of %v", name)
+		}
+	}
+	if err := os.Rename(name, BackupName(name, 1)); err != nil {
+		return errors.Wrapf(err, "cannot back up %v", name)
+	}
+	return nil
+}
+
+// BackupName returns the name of a backup generation of the file.
+func BackupName(name string, gen int) string {
+	return fmt.Sprintf("%v%v%d", name, backupExt, gen)
+}
+
+// ParseBackupName returns the name of the file that a backup generation
+// belongs to.
+func ParseBackupName(name string) (string, bool) {
+	i := strings.LastIndex(name, backupExt)
+	if i < 0 {
+		return "", false
+	}
+	if _, err := strconv.Atoi(name[i+len(backupExt):]); err != nil {
+		return "", false
+	}
+	return name[:i], true
+}
+
+// SyncDir syncs the directory to persist renames, which is not supported on
+// all platforms.
+func SyncDir(dirname string) {
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return
+	}
+	_ = dir.Sync()
+	_ = dir.Close()
+}
@@ -0,0 +1,71 @@
+package file_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/irvinlim/apple-health-ingester/pkg/util/file"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := path.Join(dir, "nested", "file.txt")
+
+	// Directories are created.
+	assert.NoError(t, file.WriteAtomic(name, 0, func(w io.Writer) error {
+		_, err := io.WriteString(w, "hello")
+		return err
+	}))
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// The file is left unchanged if writing fails partway.
+	assert.Error(t, file.WriteAtomic(name, 0, func(w io.Writer) error {
+		_, _ = io.WriteString(w, "partial")
+		return errors.New("failed")
+	}))
+	data, err = os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// Temporary files are cleaned up.
+	entries, err := os.ReadDir(path.Dir(name))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteAtomic_Backups(t *testing.T) {
+	dir := t.TempDir()
+	name := path.Join(dir, "file.txt")
+	for _, content := range []string{"a", "b", "c", "d"} {
+		content := content
+		assert.NoError(t, file.WriteAtomic(name, 2, func(w io.Writer) error {
+			_, err := io.WriteString(w, content)
+			return err
+		}))
+	}
+
+	// Only the most recent generations are kept.
+	for name, want := range map[string]string{
+		name:                     "d",
+		file.BackupName(name, 1): "c",
+		file.BackupName(name, 2): "b",
+	} {
+		data, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+	assert.NoFileExists(t, file.BackupName(name, 3))
+
+	original, ok := file.ParseBackupName(file.BackupName(name, 2))
+	assert.True(t, ok)
+	assert.Equal(t, name, original)
+	_, ok = file.ParseBackupName(name)
+	assert.False(t, ok)
+}